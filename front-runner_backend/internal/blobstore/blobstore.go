@@ -0,0 +1,73 @@
+// Package blobstore stores uploaded files such as product images behind a common interface,
+// so they can live on the local disk or in an S3-compatible bucket shared by several replicas.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no object exists under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves, fetches and deletes objects by key. Keys are slash-separated relative paths.
+type Store interface {
+	// Put creates or replaces the object stored under key.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an open stored object, seekable so it can be served with http.ServeContent.
+type Object struct {
+	io.ReadSeekCloser
+	ContentType string // May be empty when the backend does not record it
+	ModTime     time.Time
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) error {
+	if key == "" || !fs.ValidPath(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// FromEnv builds the Store selected by the BLOB_STORE environment variable:
+// "local" (the default) stores files under BLOB_LOCAL_DIR (default "uploads"), and
+// "s3" uses the bucket described by the S3_* variables.
+func FromEnv() (Store, error) {
+	switch backend := strings.ToLower(strings.TrimSpace(os.Getenv("BLOB_STORE"))); backend {
+	case "", "local":
+		dir := os.Getenv("BLOB_LOCAL_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir)
+	case "s3":
+		endpoint := os.Getenv("S3_ENDPOINT")
+		pathStyle := endpoint != "" // Self-hosted S3-compatible servers usually expect path-style URLs
+		if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+			pathStyle = strings.EqualFold(v, "true")
+		}
+		return NewS3(S3Config{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			PathStyle:       pathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q (use local or s3)", backend)
+	}
+}
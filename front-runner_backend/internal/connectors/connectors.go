@@ -0,0 +1,158 @@
+// Package connectors talks to the marketplaces behind linked storefronts through a common interface,
+// so listings, orders and inventory can be synced the same way whichever platform a seller uses.
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by New for store types without a connector.
+var ErrUnsupported = errors.New("no connector for this store type")
+
+// ErrNotFound is returned when the marketplace has no listing or SKU with the given ID.
+var ErrNotFound = errors.New("not found on the marketplace")
+
+// Connector lists and pushes listings, pulls orders and updates inventory on one linked storefront.
+type Connector interface {
+	// ListListings returns every listing in the store.
+	ListListings(ctx context.Context) ([]Listing, error)
+	// PushListing creates the listing, or replaces it when ExternalID is set, and returns it as stored.
+	PushListing(ctx context.Context, listing Listing) (Listing, error)
+	// PullOrders returns the orders created or updated since the given time, oldest first.
+	PullOrders(ctx context.Context, since time.Time) ([]Order, error)
+	// UpdateInventory sets the available quantity of one SKU of a listing.
+	UpdateInventory(ctx context.Context, update InventoryUpdate) error
+}
+
+// Listing is a product as listed on a marketplace.
+type Listing struct {
+	ExternalID  string           `json:"externalId"` // Empty for listings not yet pushed
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Currency    string           `json:"currency"`
+	ImageURLs   []string         `json:"imageUrls"`
+	Variants    []ListingVariant `json:"variants"` // At least one; listings without options have a single variant
+}
+
+// ListingVariant is a purchasable SKU of a listing.
+type ListingVariant struct {
+	ExternalID string `json:"externalId"`
+	SKU        string `json:"sku"`
+	Title      string `json:"title"`
+	PriceMinor int64  `json:"priceMinor"` // Minor units of the listing's currency
+	Quantity   int    `json:"quantity"`   // Available stock; marketplaces may report oversold SKUs as negative
+}
+
+// Order is an order placed on a marketplace.
+type Order struct {
+	ExternalID    string      `json:"externalId"`
+	Number        string      `json:"number"` // Order number shown to the buyer, e.g. "#1001"
+	Currency      string      `json:"currency"`
+	TotalMinor    int64       `json:"totalMinor"`
+	CustomerName  string      `json:"customerName"`
+	CustomerEmail string      `json:"customerEmail"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+	CancelledAt   *time.Time  `json:"cancelledAt"`
+	Lines         []OrderLine `json:"lines"`
+}
+
+// OrderLine is one SKU of an order.
+type OrderLine struct {
+	ExternalID string `json:"externalId"`
+	SKU        string `json:"sku"`
+	Title      string `json:"title"`
+	Quantity   uint   `json:"quantity"`
+	PriceMinor int64  `json:"priceMinor"` // Unit price
+}
+
+// InventoryUpdate sets the available quantity of one SKU of a listing.
+type InventoryUpdate struct {
+	ListingID string
+	SKU       string
+	Quantity  int
+}
+
+// Config describes a linked storefront.
+type Config struct {
+	StoreURL    string
+	StoreID     string
+	Credentials map[string]string // Decrypted StorefrontLink credentials, e.g. "accessToken"
+}
+
+// Factory validates a storefront's config and returns its connector.
+type Factory func(cfg Config) (Connector, error)
+
+// factories holds the connectors by store type. Adapters register themselves from init.
+var factories = map[string]Factory{}
+
+// Register makes a connector available for a store type.
+func Register(storeType string, factory Factory) {
+	factories[strings.ToLower(storeType)] = factory
+}
+
+// Supported reports whether the store type has a connector.
+func Supported(storeType string) bool {
+	_, ok := factories[strings.ToLower(storeType)]
+	return ok
+}
+
+// StoreTypes lists the store types with a connector, sorted.
+func StoreTypes() []string {
+	types := make([]string, 0, len(factories))
+	for storeType := range factories {
+		types = append(types, storeType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New returns the connector for a storefront of the given type.
+func New(storeType string, cfg Config) (Connector, error) {
+	factory, ok := factories[strings.ToLower(storeType)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, storeType)
+	}
+	return factory(cfg)
+}
+
+// APIError is an unexpected response from a marketplace API.
+type APIError struct {
+	StatusCode int
+	Message    string // Start of the response body
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("marketplace API responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried later: the marketplace was
+// rate limiting or failing rather than rejecting the request.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsTemporary reports whether err may go away if the call is retried: network errors, rate
+// limiting and marketplace outages.
+func IsTemporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+}
+
+// statusError builds the APIError of an unexpected response.
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
@@ -0,0 +1,324 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeShopify is a minimal in-memory stand-in for the Shopify Admin REST API. It pages product
+// lists one product at a time so that pagination is exercised.
+type fakeShopify struct {
+	mu        sync.Mutex
+	nextID    int64
+	products  map[int64]*shopifyProduct
+	levels    map[int64]int // Inventory item ID -> available
+	orders    []map[string]interface{}
+	failNext  int // Status to fail the next request with
+	locations int // Requests for the store's locations
+}
+
+func newFakeShopify() *fakeShopify {
+	return &fakeShopify{nextID: 100, products: map[int64]*shopifyProduct{}, levels: map[int64]int{}}
+}
+
+func (f *fakeShopify) id() int64 {
+	f.nextID++
+	return f.nextID
+}
+
+// save assigns IDs to a pushed product and its variants, keeping those of variants it already has.
+func (f *fakeShopify) save(p *shopifyProduct) {
+	if p.ID == 0 {
+		p.ID = f.id()
+	}
+	for i := range p.Variants {
+		v := &p.Variants[i]
+		if v.ID == 0 {
+			v.ID = f.id()
+			v.InventoryItemID = f.id()
+		} else if old := f.products[p.ID]; old != nil {
+			for _, ov := range old.Variants {
+				if ov.ID == v.ID {
+					v.InventoryItemID = ov.InventoryItemID
+				}
+			}
+		}
+		if v.Option1 != "" {
+			v.Title = v.Option1
+		} else {
+			v.Title = "Default Title"
+		}
+	}
+	f.products[p.ID] = p
+}
+
+// withLevels returns a copy of the product reporting its current inventory levels.
+func (f *fakeShopify) withLevels(p *shopifyProduct) shopifyProduct {
+	out := *p
+	out.Variants = append([]shopifyVariant(nil), p.Variants...)
+	for i := range out.Variants {
+		out.Variants[i].InventoryQuantity = f.levels[out.Variants[i].InventoryItemID]
+	}
+	return out
+}
+
+func (f *fakeShopify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Shopify-Access-Token") != "test-token" {
+		http.Error(w, `{"errors":"Invalid API key or access token"}`, http.StatusUnauthorized)
+		return
+	}
+	if f.failNext != 0 {
+		http.Error(w, `{"errors":"Exceeded 2 calls per second"}`, f.failNext)
+		f.failNext = 0
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/admin/api/"+shopifyAPIVersion)
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	switch {
+	case path == "/shop.json":
+		reply(map[string]interface{}{"shop": map[string]string{"currency": "USD"}})
+	case path == "/locations.json":
+		f.locations++
+		reply(map[string]interface{}{"locations": []map[string]interface{}{{"id": 1, "active": false}, {"id": 2, "active": true}}})
+	case path == "/products.json" && r.Method == http.MethodGet:
+		ids := make([]int64, 0, len(f.products))
+		for id := range f.products {
+			ids = append(ids, id)
+		}
+		after, _ := strconv.ParseInt(r.URL.Query().Get("page_info"), 10, 64)
+		var page []shopifyProduct
+		for _, id := range ids {
+			if id > after && (len(page) == 0 || id < page[0].ID) {
+				page = []shopifyProduct{f.withLevels(f.products[id])}
+			}
+		}
+		for _, id := range ids {
+			if len(page) > 0 && id > page[0].ID {
+				w.Header().Set("Link", fmt.Sprintf(`<https://test.myshopify.com%s?limit=250&page_info=%d>; rel="next"`, r.URL.Path, page[0].ID))
+				break
+			}
+		}
+		if page == nil {
+			page = []shopifyProduct{}
+		}
+		reply(map[string]interface{}{"products": page})
+	case path == "/products.json" && r.Method == http.MethodPost:
+		var body struct{ Product shopifyProduct }
+		json.NewDecoder(r.Body).Decode(&body)
+		f.save(&body.Product)
+		w.WriteHeader(http.StatusCreated)
+		reply(map[string]interface{}{"product": f.withLevels(&body.Product)})
+	case strings.HasPrefix(path, "/products/"):
+		id, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(path, "/products/"), ".json"), 10, 64)
+		if f.products[id] == nil {
+			http.Error(w, `{"errors":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut {
+			var body struct{ Product shopifyProduct }
+			json.NewDecoder(r.Body).Decode(&body)
+			f.save(&body.Product)
+		}
+		reply(map[string]interface{}{"product": f.withLevels(f.products[id])})
+	case path == "/inventory_levels/set.json":
+		var body struct {
+			LocationID      int64 `json:"location_id"`
+			InventoryItemID int64 `json:"inventory_item_id"`
+			Available       int   `json:"available"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.LocationID != 2 {
+			http.Error(w, `{"errors":"Inventory item is not stocked at the location"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		f.levels[body.InventoryItemID] = body.Available
+		reply(map[string]interface{}{"inventory_level": body})
+	case path == "/orders.json":
+		since, _ := time.Parse(time.RFC3339, r.URL.Query().Get("updated_at_min"))
+		orders := []map[string]interface{}{}
+		for _, o := range f.orders {
+			updated, _ := time.Parse(time.RFC3339, o["updated_at"].(string))
+			if !updated.Before(since) {
+				orders = append(orders, o)
+			}
+		}
+		reply(map[string]interface{}{"orders": orders})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// testShopify returns a connector talking to a fake store.
+func testShopify(t *testing.T) (*Shopify, *fakeShopify) {
+	t.Helper()
+	fake := newFakeShopify()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	shop, err := NewShopify(Config{StoreURL: "https://test.myshopify.com", Credentials: map[string]string{"accessToken": "test-token"}})
+	require.NoError(t, err)
+	base, err := url.Parse(server.URL + "/admin/api/" + shopifyAPIVersion)
+	require.NoError(t, err)
+	shop.base = base
+	return shop, fake
+}
+
+func TestNew(t *testing.T) {
+	conn, err := New("Shopify", Config{StoreURL: "my-store.myshopify.com", Credentials: map[string]string{"accessToken": "token"}})
+	require.NoError(t, err)
+	assert.Equal(t, "my-store.myshopify.com", conn.(*Shopify).base.Host)
+	assert.Contains(t, StoreTypes(), StoreTypeShopify)
+
+	_, err = New("carrier-pigeon", Config{})
+	assert.True(t, errors.Is(err, ErrUnsupported), "got %v", err)
+}
+
+func TestNewShopifyValidation(t *testing.T) {
+	creds := map[string]string{"accessToken": "token"}
+	_, err := NewShopify(Config{StoreURL: "https://my-store.myshopify.com"})
+	assert.ErrorContains(t, err, "accessToken")
+	for _, storeURL := range []string{"", "https://example.com", "https://my-store.myshopify.com.evil.com", "https://my-store.myshopify.com:8443", "http://a.b.myshopify.com"} {
+		_, err := NewShopify(Config{StoreURL: storeURL, Credentials: creds})
+		assert.Error(t, err, storeURL)
+	}
+	_, err = NewShopify(Config{StoreURL: "https://my-store.myshopify.com", Credentials: map[string]string{"accessToken": "token", "locationId": "main"}})
+	assert.ErrorContains(t, err, "locationId")
+}
+
+func TestShopifyListings(t *testing.T) {
+	shop, fake := testShopify(t)
+	ctx := context.Background()
+
+	tee, err := shop.PushListing(ctx, Listing{
+		Title:       "Tee",
+		Description: "Cotton <b>soft</b>",
+		Currency:    "usd",
+		ImageURLs:   []string{"https://cdn.example.com/tee.png"},
+		Variants: []ListingVariant{
+			{SKU: "TEE-S", Title: "S", PriceMinor: 1500, Quantity: 4},
+			{SKU: "TEE-M", Title: "M", PriceMinor: 1650, Quantity: 0},
+		},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, tee.ExternalID)
+	assert.Equal(t, "Cotton &lt;b&gt;soft&lt;/b&gt;", tee.Description, "Descriptions are plain text")
+	require.Len(t, tee.Variants, 2)
+	assert.Equal(t, "S", tee.Variants[0].Title)
+	assert.Equal(t, int64(1650), tee.Variants[1].PriceMinor)
+	assert.Equal(t, 4, tee.Variants[0].Quantity)
+	assert.Equal(t, 1, fake.locations, "The first active location is looked up once")
+
+	mug, err := shop.PushListing(ctx, Listing{Title: "Mug", Variants: []ListingVariant{{SKU: "MUG", PriceMinor: 950, Quantity: 2}}})
+	require.NoError(t, err)
+
+	t.Run("UpdateKeepsVariants", func(t *testing.T) {
+		tee.Variants = tee.Variants[:1]
+		tee.Variants[0].PriceMinor = 1400
+		tee.Variants = append(tee.Variants, ListingVariant{SKU: "TEE-L", Title: "L", PriceMinor: 1700, Quantity: 1})
+		updated, err := shop.PushListing(ctx, tee)
+		require.NoError(t, err)
+		assert.Equal(t, tee.ExternalID, updated.ExternalID)
+		require.Len(t, updated.Variants, 2, "Variants left out of the listing are removed")
+		assert.Equal(t, tee.Variants[0].ExternalID, updated.Variants[0].ExternalID, "Variants are matched by SKU")
+		assert.Equal(t, int64(1400), updated.Variants[0].PriceMinor)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		listings, err := shop.ListListings(ctx)
+		require.NoError(t, err)
+		require.Len(t, listings, 2, "Every page is fetched")
+		assert.Equal(t, "Tee", listings[0].Title)
+		assert.Equal(t, "USD", listings[1].Currency)
+		assert.Equal(t, "MUG", listings[1].Variants[0].SKU)
+		assert.Equal(t, 2, listings[1].Variants[0].Quantity)
+	})
+
+	t.Run("UpdateInventory", func(t *testing.T) {
+		require.NoError(t, shop.UpdateInventory(ctx, InventoryUpdate{ListingID: mug.ExternalID, SKU: "MUG", Quantity: 9}))
+		listings, err := shop.ListListings(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 9, listings[1].Variants[0].Quantity)
+
+		err = shop.UpdateInventory(ctx, InventoryUpdate{ListingID: mug.ExternalID, SKU: "NOPE", Quantity: 1})
+		assert.True(t, errors.Is(err, ErrNotFound), "got %v", err)
+		err = shop.UpdateInventory(ctx, InventoryUpdate{ListingID: "999999", SKU: "MUG", Quantity: 1})
+		assert.True(t, errors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("CurrencyMismatch", func(t *testing.T) {
+		_, err := shop.PushListing(ctx, Listing{Title: "Euro mug", Currency: "EUR", Variants: []ListingVariant{{SKU: "EMUG", PriceMinor: 100}}})
+		assert.ErrorContains(t, err, "EUR")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		fake.failNext = http.StatusTooManyRequests
+		_, err := shop.ListListings(ctx)
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr), "got %v", err)
+		assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+		assert.True(t, IsTemporary(err))
+
+		shop.token = "wrong"
+		_, err = shop.ListListings(ctx)
+		assert.Error(t, err)
+		assert.False(t, IsTemporary(err), "Rejected credentials will not work on a retry")
+	})
+}
+
+func TestShopifyPullOrders(t *testing.T) {
+	shop, fake := testShopify(t)
+	fake.orders = []map[string]interface{}{
+		{
+			"id": 2002, "name": "#1002", "email": "b@example.com", "currency": "USD", "total_price": "30.00",
+			"created_at": "2024-05-02T10:00:00-04:00", "updated_at": "2024-05-03T10:00:00-04:00", "cancelled_at": "2024-05-03T10:00:00-04:00",
+			"line_items": []map[string]interface{}{{"id": 1, "sku": "MUG", "title": "Mug", "quantity": 2, "price": "15.00"}},
+		},
+		{
+			"id": 2001, "name": "#1001", "email": "a@example.com", "currency": "USD", "total_price": "9.50",
+			"created_at": "2024-05-01T10:00:00Z", "updated_at": "2024-05-01T10:00:00Z", "cancelled_at": nil,
+			"customer":   map[string]string{"first_name": "Ada", "last_name": "Lovelace"},
+			"line_items": []map[string]interface{}{{"id": 3, "sku": "TEE-S", "title": "Tee", "quantity": 1, "price": "9.50"}},
+		},
+		{
+			"id": 2000, "name": "#1000", "currency": "USD", "total_price": "1.00",
+			"created_at": "2024-04-01T10:00:00Z", "updated_at": "2024-04-01T10:00:00Z",
+		},
+	}
+
+	orders, err := shop.PullOrders(context.Background(), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, orders, 2, "Orders not updated since are skipped")
+	assert.Equal(t, "2001", orders[0].ExternalID, "Oldest first")
+	assert.Equal(t, "Ada Lovelace", orders[0].CustomerName)
+	assert.Equal(t, int64(950), orders[0].TotalMinor)
+	assert.Nil(t, orders[0].CancelledAt)
+	assert.Equal(t, []OrderLine{{ExternalID: "3", SKU: "TEE-S", Title: "Tee", Quantity: 1, PriceMinor: 950}}, orders[0].Lines)
+	assert.Equal(t, "#1002", orders[1].Number)
+	assert.NotNil(t, orders[1].CancelledAt)
+	assert.Equal(t, int64(1500), orders[1].Lines[0].PriceMinor)
+}
+
+func TestParseShopifyPrice(t *testing.T) {
+	for price, want := range map[string]int64{"9.50": 950, "10.00": 1000, "0.00": 0, "12": 1200} {
+		got, err := parseShopifyPrice(price, "USD")
+		require.NoError(t, err, price)
+		assert.Equal(t, want, got, price)
+	}
+	got, err := parseShopifyPrice("1000.00", "JPY")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), got, "Zero-decimal currencies still come with two decimal places")
+}
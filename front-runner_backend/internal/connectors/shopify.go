@@ -0,0 +1,532 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"front-runner/internal/money"
+)
+
+// StoreTypeShopify is the StoreType of Shopify storefront links.
+const StoreTypeShopify = "shopify"
+
+// shopifyAPIVersion is the Admin REST API version requests are made against.
+const shopifyAPIVersion = "2024-01"
+
+// shopifyPageSize is the most records Shopify returns per page.
+const shopifyPageSize = 250
+
+// shopifyShopName matches the store part of a "<store>.myshopify.com" address.
+var shopifyShopName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// shopifyNextPage extracts the page_info cursor of the next page from a Link header.
+var shopifyNextPage = regexp.MustCompile(`<[^>]*[?&]page_info=([^&>]+)[^>]*>;\s*rel="next"`)
+
+func init() {
+	Register(StoreTypeShopify, func(cfg Config) (Connector, error) { return NewShopify(cfg) })
+}
+
+// Shopify connects to a Shopify store through the Admin REST API, authenticating with the
+// Admin API access token of a custom app installed in the store.
+type Shopify struct {
+	base       *url.URL // https://<store>.myshopify.com/admin/api/<version>
+	token      string
+	locationID int64 // Location whose inventory is set; looked up when not configured
+	client     *http.Client
+
+	mu       sync.Mutex
+	currency string // Store currency, cached after the first lookup
+}
+
+// NewShopify validates the config and returns a Shopify connector. StoreURL must be the store's
+// myshopify.com address, and the credentials need an "accessToken" and optionally a "locationId".
+func NewShopify(cfg Config) (*Shopify, error) {
+	token := cfg.Credentials["accessToken"]
+	if token == "" {
+		return nil, errors.New("shopify links need an accessToken")
+	}
+	raw := strings.TrimSpace(cfg.StoreURL)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	host := ""
+	if err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	// Only talk to Shopify itself, so a link cannot point requests at other hosts
+	if shop, ok := strings.CutSuffix(host, ".myshopify.com"); !ok || !shopifyShopName.MatchString(shop) || u.Port() != "" {
+		return nil, fmt.Errorf("storeUrl must be the store's myshopify.com address, got %q", cfg.StoreURL)
+	}
+	s := &Shopify{
+		base:   &url.URL{Scheme: "https", Host: host, Path: "/admin/api/" + shopifyAPIVersion},
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if id := cfg.Credentials["locationId"]; id != "" {
+		if s.locationID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid shopify locationId %q", id)
+		}
+	}
+	return s, nil
+}
+
+// shopifyProduct is a product of the Admin API.
+type shopifyProduct struct {
+	ID       int64            `json:"id,omitempty"`
+	Title    string           `json:"title"`
+	BodyHTML string           `json:"body_html"`
+	Variants []shopifyVariant `json:"variants"`
+	Images   []shopifyImage   `json:"images"`
+}
+
+type shopifyVariant struct {
+	ID                  int64  `json:"id,omitempty"`
+	SKU                 string `json:"sku"`
+	Title               string `json:"title,omitempty"`
+	Option1             string `json:"option1,omitempty"`
+	Price               string `json:"price"`
+	InventoryManagement string `json:"inventory_management,omitempty"`
+	InventoryItemID     int64  `json:"inventory_item_id,omitempty"`
+	InventoryQuantity   int    `json:"inventory_quantity,omitempty"` // Read only
+}
+
+type shopifyImage struct {
+	Src string `json:"src"`
+}
+
+// shopifyOrder is an order of the Admin API.
+type shopifyOrder struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Email       string     `json:"email"`
+	Currency    string     `json:"currency"`
+	TotalPrice  string     `json:"total_price"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CancelledAt *time.Time `json:"cancelled_at"`
+	Customer    *struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	} `json:"customer"`
+	LineItems []struct {
+		ID       int64  `json:"id"`
+		SKU      string `json:"sku"`
+		Title    string `json:"title"`
+		Quantity uint   `json:"quantity"`
+		Price    string `json:"price"`
+	} `json:"line_items"`
+}
+
+// order converts the order, whose prices are in the store currency unless it says otherwise.
+func (o shopifyOrder) order(currency string) (Order, error) {
+	if o.Currency != "" {
+		currency = strings.ToUpper(o.Currency)
+	}
+	total, err := parseShopifyPrice(o.TotalPrice, currency)
+	if err != nil {
+		return Order{}, fmt.Errorf("shopify order %d: %w", o.ID, err)
+	}
+	order := Order{
+		ExternalID:    strconv.FormatInt(o.ID, 10),
+		Number:        o.Name,
+		Currency:      currency,
+		TotalMinor:    total,
+		CustomerEmail: o.Email,
+		CreatedAt:     o.CreatedAt,
+		UpdatedAt:     o.UpdatedAt,
+		CancelledAt:   o.CancelledAt,
+	}
+	if o.Customer != nil {
+		order.CustomerName = strings.TrimSpace(o.Customer.FirstName + " " + o.Customer.LastName)
+	}
+	for _, item := range o.LineItems {
+		price, err := parseShopifyPrice(item.Price, currency)
+		if err != nil {
+			return Order{}, fmt.Errorf("shopify order %d: line %d: %w", o.ID, item.ID, err)
+		}
+		order.Lines = append(order.Lines, OrderLine{
+			ExternalID: strconv.FormatInt(item.ID, 10),
+			SKU:        item.SKU,
+			Title:      item.Title,
+			Quantity:   item.Quantity,
+			PriceMinor: price,
+		})
+	}
+	return order, nil
+}
+
+// do sends an Admin API request for path, relative to the versioned API root, and decodes the
+// JSON response into out. It returns the response headers for pagination.
+func (s *Shopify) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (http.Header, error) {
+	u := *s.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Shopify-Access-Token", s.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("shopify %s: %w", path, ErrNotFound)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, statusError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("shopify %s: invalid response: %w", path, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// shopifyList fetches every page of a list endpoint whose response holds the records under key.
+func shopifyList[T any](ctx context.Context, s *Shopify, path, key string, query url.Values) ([]T, error) {
+	var all []T
+	query.Set("limit", strconv.Itoa(shopifyPageSize))
+	for {
+		var page map[string][]T
+		header, err := s.do(ctx, http.MethodGet, path, query, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page[key]...)
+		next := shopifyNextPage.FindStringSubmatch(header.Get("Link"))
+		if next == nil {
+			return all, nil
+		}
+		cursor, err := url.QueryUnescape(next[1])
+		if err != nil {
+			return nil, fmt.Errorf("shopify %s: invalid page cursor", path)
+		}
+		// Later pages take only the cursor and page size; the filters travel in the cursor
+		query = url.Values{"limit": {strconv.Itoa(shopifyPageSize)}, "page_info": {cursor}}
+	}
+}
+
+// shopCurrency returns the store's currency.
+func (s *Shopify) shopCurrency(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currency != "" {
+		return s.currency, nil
+	}
+	var resp struct {
+		Shop struct {
+			Currency string `json:"currency"`
+		} `json:"shop"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/shop.json", nil, nil, &resp); err != nil {
+		return "", err
+	}
+	currency, err := money.NormalizeCurrency(resp.Shop.Currency)
+	if err != nil {
+		return "", fmt.Errorf("shopify store currency: %w", err)
+	}
+	s.currency = currency
+	return currency, nil
+}
+
+// location returns the ID of the location whose inventory is set: the configured one, or else
+// the store's first active location.
+func (s *Shopify) location(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locationID != 0 {
+		return s.locationID, nil
+	}
+	var resp struct {
+		Locations []struct {
+			ID     int64 `json:"id"`
+			Active bool  `json:"active"`
+		} `json:"locations"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/locations.json", nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	for _, loc := range resp.Locations {
+		if loc.Active {
+			s.locationID = loc.ID
+			return loc.ID, nil
+		}
+	}
+	return 0, errors.New("shopify store has no active location")
+}
+
+// parseShopifyPrice converts a Shopify decimal price, which always has two decimal places, into
+// minor units of currency.
+func parseShopifyPrice(price, currency string) (int64, error) {
+	if strings.Contains(price, ".") {
+		price = strings.TrimSuffix(strings.TrimRight(price, "0"), ".")
+	}
+	return money.Parse(price, currency)
+}
+
+// listing converts a Shopify product.
+func (s *Shopify) listing(p shopifyProduct, currency string) (Listing, error) {
+	listing := Listing{
+		ExternalID:  strconv.FormatInt(p.ID, 10),
+		Title:       p.Title,
+		Description: p.BodyHTML,
+		Currency:    currency,
+		ImageURLs:   []string{},
+	}
+	for _, img := range p.Images {
+		listing.ImageURLs = append(listing.ImageURLs, img.Src)
+	}
+	for _, v := range p.Variants {
+		price, err := parseShopifyPrice(v.Price, currency)
+		if err != nil {
+			return Listing{}, fmt.Errorf("shopify product %d: variant %d: %w", p.ID, v.ID, err)
+		}
+		listing.Variants = append(listing.Variants, ListingVariant{
+			ExternalID: strconv.FormatInt(v.ID, 10),
+			SKU:        v.SKU,
+			Title:      v.Title,
+			PriceMinor: price,
+			Quantity:   v.InventoryQuantity,
+		})
+	}
+	return listing, nil
+}
+
+// ListListings returns every product in the store.
+func (s *Shopify) ListListings(ctx context.Context) ([]Listing, error) {
+	currency, err := s.shopCurrency(ctx)
+	if err != nil {
+		return nil, err
+	}
+	products, err := shopifyList[shopifyProduct](ctx, s, "/products.json", "products", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	listings := make([]Listing, 0, len(products))
+	for _, p := range products {
+		listing, err := s.listing(p, currency)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+// product fetches a product by ID.
+func (s *Shopify) product(ctx context.Context, id string) (shopifyProduct, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return shopifyProduct{}, fmt.Errorf("shopify product %q: %w", id, ErrNotFound)
+	}
+	var resp struct {
+		Product shopifyProduct `json:"product"`
+	}
+	_, err := s.do(ctx, http.MethodGet, "/products/"+id+".json", nil, nil, &resp)
+	return resp.Product, err
+}
+
+// setInventory sets the available quantity of an inventory item at the store's location.
+func (s *Shopify) setInventory(ctx context.Context, inventoryItemID int64, quantity int) error {
+	locationID, err := s.location(ctx)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{"location_id": locationID, "inventory_item_id": inventoryItemID, "available": quantity}
+	_, err = s.do(ctx, http.MethodPost, "/inventory_levels/set.json", nil, body, nil)
+	return err
+}
+
+// PushListing creates or replaces a product with the listing's variants, sets their stock, and
+// returns the product as stored. Descriptions are plain text and escaped into the product's HTML.
+// Variants of an existing product are matched by SKU; variants not in the listing are removed.
+func (s *Shopify) PushListing(ctx context.Context, listing Listing) (Listing, error) {
+	if len(listing.Variants) == 0 {
+		return Listing{}, errors.New("a listing needs at least one variant")
+	}
+	currency, err := s.shopCurrency(ctx)
+	if err != nil {
+		return Listing{}, err
+	}
+	if listing.Currency != "" && !strings.EqualFold(listing.Currency, currency) {
+		return Listing{}, fmt.Errorf("listing is priced in %s but the store sells in %s", listing.Currency, currency)
+	}
+
+	existing := map[string]int64{} // SKU -> variant ID
+	if listing.ExternalID != "" {
+		current, err := s.product(ctx, listing.ExternalID)
+		if err != nil {
+			return Listing{}, err
+		}
+		for _, v := range current.Variants {
+			existing[v.SKU] = v.ID
+		}
+	}
+
+	product := shopifyProduct{
+		Title:    listing.Title,
+		BodyHTML: html.EscapeString(listing.Description),
+		Images:   []shopifyImage{},
+	}
+	for _, src := range listing.ImageURLs {
+		product.Images = append(product.Images, shopifyImage{Src: src})
+	}
+	quantities := make(map[string]int, len(listing.Variants))
+	for _, v := range listing.Variants {
+		variant := shopifyVariant{
+			ID:                  existing[v.SKU],
+			SKU:                 v.SKU,
+			Price:               money.Format(v.PriceMinor, currency),
+			InventoryManagement: "shopify",
+		}
+		if len(listing.Variants) > 1 {
+			variant.Option1 = v.Title // Shopify needs distinct option values to tell variants apart
+		}
+		product.Variants = append(product.Variants, variant)
+		quantities[v.SKU] = v.Quantity
+	}
+
+	var resp struct {
+		Product shopifyProduct `json:"product"`
+	}
+	if listing.ExternalID == "" {
+		_, err = s.do(ctx, http.MethodPost, "/products.json", nil, map[string]interface{}{"product": product}, &resp)
+	} else {
+		product.ID, _ = strconv.ParseInt(listing.ExternalID, 10, 64)
+		_, err = s.do(ctx, http.MethodPut, "/products/"+listing.ExternalID+".json", nil, map[string]interface{}{"product": product}, &resp)
+	}
+	if err != nil {
+		return Listing{}, err
+	}
+
+	// Stock is held per location rather than on the variant
+	for i, v := range resp.Product.Variants {
+		quantity, ok := quantities[v.SKU]
+		if !ok {
+			continue
+		}
+		if err := s.setInventory(ctx, v.InventoryItemID, quantity); err != nil {
+			return Listing{}, fmt.Errorf("setting stock of SKU %q: %w", v.SKU, err)
+		}
+		resp.Product.Variants[i].InventoryQuantity = quantity
+	}
+	return s.listing(resp.Product, currency)
+}
+
+// PullOrders returns the orders created or updated since the given time, including cancelled ones.
+func (s *Shopify) PullOrders(ctx context.Context, since time.Time) ([]Order, error) {
+	currency, err := s.shopCurrency(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"status": {"any"}, "updated_at_min": {since.UTC().Format(time.RFC3339)}}
+	raw, err := shopifyList[shopifyOrder](ctx, s, "/orders.json", "orders", query)
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]Order, 0, len(raw))
+	for _, o := range raw {
+		order, err := o.order(currency)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].UpdatedAt.Before(orders[j].UpdatedAt) })
+	return orders, nil
+}
+
+// UpdateInventory sets the available stock of a product's variant, found by SKU.
+func (s *Shopify) UpdateInventory(ctx context.Context, update InventoryUpdate) error {
+	product, err := s.product(ctx, update.ListingID)
+	if err != nil {
+		return err
+	}
+	for _, v := range product.Variants {
+		if v.SKU == update.SKU {
+			return s.setInventory(ctx, v.InventoryItemID, update.Quantity)
+		}
+	}
+	return fmt.Errorf("shopify product %s has no SKU %q: %w", update.ListingID, update.SKU, ErrNotFound)
+}
+
+// Headers of Shopify webhook deliveries.
+const (
+	shopifyHmacHeader      = "X-Shopify-Hmac-Sha256" // Base64 HMAC-SHA256 of the body, keyed by the app's client secret
+	shopifyTopicHeader     = "X-Shopify-Topic"
+	shopifyWebhookIDHeader = "X-Shopify-Webhook-Id" // The same when a delivery is retried
+)
+
+// shopifyOrderEvents maps the order webhook topics to event types.
+var shopifyOrderEvents = map[string]string{
+	"orders/create":    EventOrderCreated,
+	"orders/cancelled": EventOrderCancelled,
+}
+
+var _ WebhookReceiver = (*Shopify)(nil)
+
+// VerifyWebhook checks the X-Shopify-Hmac-Sha256 signature of a delivery. The secret is the
+// client secret of the app the webhooks are registered with.
+func (s *Shopify) VerifyWebhook(header http.Header, body []byte, secret string) error {
+	signature, err := base64.StdEncoding.DecodeString(header.Get(shopifyHmacHeader))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: bad %s", ErrInvalidWebhookSignature, shopifyHmacHeader)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrInvalidWebhookSignature
+	}
+	return nil
+}
+
+// ParseWebhook decodes a delivery. The orders/create and orders/cancelled topics carry the order;
+// other topics are returned without a type.
+func (s *Shopify) ParseWebhook(header http.Header, body []byte) (WebhookEvent, error) {
+	event := WebhookEvent{ID: header.Get(shopifyWebhookIDHeader), Topic: header.Get(shopifyTopicHeader)}
+	eventType, ok := shopifyOrderEvents[event.Topic]
+	if !ok {
+		return event, nil
+	}
+	var raw shopifyOrder
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return event, fmt.Errorf("invalid shopify %s webhook: %w", event.Topic, err)
+	}
+	order, err := raw.order("")
+	if err != nil {
+		return event, err
+	}
+	if eventType == EventOrderCancelled && order.CancelledAt == nil {
+		order.CancelledAt = &order.UpdatedAt
+	}
+	event.Type, event.Order = eventType, &order
+	return event, nil
+}
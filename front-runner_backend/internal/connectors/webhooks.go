@@ -0,0 +1,32 @@
+package connectors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Webhook event types, the same whichever marketplace sent the event.
+const (
+	EventOrderCreated   = "order.created"
+	EventOrderCancelled = "order.cancelled"
+)
+
+// ErrInvalidWebhookSignature is returned by VerifyWebhook when a delivery was not signed with the webhook secret.
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// WebhookEvent is an event a marketplace pushed to a webhook.
+type WebhookEvent struct {
+	ID    string // The marketplace's ID of the delivery, the same when it is redelivered; may be empty
+	Topic string // The marketplace's name for the event, e.g. "orders/create"
+	Type  string // EventOrderCreated, EventOrderCancelled, or "" for events that are not handled
+	Order *Order // Set for order events
+}
+
+// WebhookReceiver is implemented by the connectors of marketplaces that push events to webhooks.
+type WebhookReceiver interface {
+	// VerifyWebhook checks a delivery's signature against the storefront's webhook secret,
+	// returning ErrInvalidWebhookSignature if it does not match.
+	VerifyWebhook(header http.Header, body []byte, secret string) error
+	// ParseWebhook decodes a verified delivery.
+	ParseWebhook(header http.Header, body []byte) (WebhookEvent, error)
+}
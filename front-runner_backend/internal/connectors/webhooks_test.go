@@ -0,0 +1,72 @@
+package connectors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shopifyWebhookHeader(topic string, body []byte, secret string) http.Header {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	header := http.Header{}
+	header.Set("X-Shopify-Topic", topic)
+	header.Set("X-Shopify-Webhook-Id", "delivery-1")
+	header.Set("X-Shopify-Hmac-Sha256", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+func TestShopifyWebhooks(t *testing.T) {
+	shop, err := NewShopify(Config{StoreURL: "test.myshopify.com", Credentials: map[string]string{"accessToken": "token"}})
+	require.NoError(t, err)
+	body := []byte(`{"id": 450789469, "name": "#1001", "email": "buyer@example.com", "currency": "EUR", "total_price": "19.90",
+		"created_at": "2024-03-01T10:00:00Z", "updated_at": "2024-03-01T10:05:00Z", "cancelled_at": null,
+		"customer": {"first_name": "Ana", "last_name": "Ruiz"},
+		"line_items": [{"id": 1, "sku": "MUG", "title": "Mug", "quantity": 2, "price": "9.95"}]}`)
+
+	t.Run("VerifyWebhook", func(t *testing.T) {
+		header := shopifyWebhookHeader("orders/create", body, "app-secret")
+		assert.NoError(t, shop.VerifyWebhook(header, body, "app-secret"))
+		assert.ErrorIs(t, shop.VerifyWebhook(header, body, "other-secret"), ErrInvalidWebhookSignature)
+		assert.ErrorIs(t, shop.VerifyWebhook(header, append(body, ' '), "app-secret"), ErrInvalidWebhookSignature, "The body was changed")
+		header.Del("X-Shopify-Hmac-Sha256")
+		assert.ErrorIs(t, shop.VerifyWebhook(header, body, "app-secret"), ErrInvalidWebhookSignature)
+	})
+
+	t.Run("OrderCreated", func(t *testing.T) {
+		event, err := shop.ParseWebhook(shopifyWebhookHeader("orders/create", body, "app-secret"), body)
+		require.NoError(t, err)
+		assert.Equal(t, "delivery-1", event.ID)
+		assert.Equal(t, EventOrderCreated, event.Type)
+		require.NotNil(t, event.Order)
+		assert.Equal(t, "450789469", event.Order.ExternalID)
+		assert.Equal(t, "EUR", event.Order.Currency)
+		assert.Equal(t, "Ana Ruiz", event.Order.CustomerName)
+		require.Len(t, event.Order.Lines, 1)
+		assert.Equal(t, int64(995), event.Order.Lines[0].PriceMinor)
+		assert.Nil(t, event.Order.CancelledAt)
+	})
+
+	t.Run("OrderCancelled", func(t *testing.T) {
+		event, err := shop.ParseWebhook(shopifyWebhookHeader("orders/cancelled", body, "app-secret"), body)
+		require.NoError(t, err)
+		assert.Equal(t, EventOrderCancelled, event.Type)
+		require.NotNil(t, event.Order.CancelledAt, "Cancelled orders always have a cancellation time")
+	})
+
+	t.Run("OtherTopics", func(t *testing.T) {
+		event, err := shop.ParseWebhook(shopifyWebhookHeader("products/update", []byte(`{}`), "app-secret"), []byte(`{}`))
+		require.NoError(t, err)
+		assert.Equal(t, "products/update", event.Topic)
+		assert.Empty(t, event.Type)
+		assert.Nil(t, event.Order)
+
+		_, err = shop.ParseWebhook(shopifyWebhookHeader("orders/create", []byte(`not json`), "app-secret"), []byte(`not json`))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,179 @@
+// Package imagepipeline validates uploaded product images and prepares them for storage.
+//
+// Uploads are identified by their content rather than their filename, checked against
+// size limits, re-encoded (which drops EXIF and other metadata after applying the EXIF
+// orientation) and resized into the smaller variants served to product grids.
+package imagepipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Upload and decode limits.
+const (
+	MaxUploadBytes = 10 << 20   // Largest accepted upload
+	MaxDimension   = 8000       // Largest accepted width or height in pixels
+	MaxPixels      = 40_000_000 // Largest accepted width*height, guarding against decompression bombs
+	jpegQuality    = 85
+)
+
+// ErrInvalidImage is wrapped by every error caused by the upload itself rather than the server.
+var ErrInvalidImage = errors.New("invalid image")
+
+// Size names a stored rendition of an image.
+type Size string
+
+const (
+	Original Size = "original"
+	Medium   Size = "medium"
+	Thumb    Size = "thumb"
+)
+
+// variantBounds is the largest width and height of each resized variant.
+var variantBounds = map[Size]int{
+	Medium: 800,
+	Thumb:  200,
+}
+
+// Variants lists the resized sizes generated for every image.
+var Variants = []Size{Medium, Thumb}
+
+// outputFormats maps each accepted input MIME type to the format it is stored in.
+// GIFs are flattened to their first frame and stored as PNG.
+var outputFormats = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/gif":  "image/png",
+}
+
+// extensions maps the stored formats to their file extensions.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Processed holds a validated image re-encoded at each size.
+type Processed struct {
+	ContentType string // MIME type of every rendition
+	Ext         string // File extension matching ContentType, including the dot
+	Width       int    // Dimensions of the original after orientation is applied
+	Height      int
+	Renditions  map[Size][]byte // Encoded bytes of the original and each variant
+}
+
+// ParseSize parses a size query parameter; "" means Original.
+func ParseSize(s string) (Size, error) {
+	switch size := Size(strings.ToLower(s)); size {
+	case "", Original:
+		return Original, nil
+	case Medium, Thumb:
+		return size, nil
+	default:
+		return "", fmt.Errorf("invalid size %q (use original, medium or thumb)", s)
+	}
+}
+
+// FileName returns the filename of the given rendition of a stored image.
+// Variants live next to the original with the size appended, e.g. "abc_thumb.jpg".
+func FileName(original string, size Size) string {
+	if size == Original || size == "" {
+		return original
+	}
+	ext := filepath.Ext(original)
+	return strings.TrimSuffix(original, ext) + "_" + string(size) + ext
+}
+
+// FileNames returns the filenames of every rendition of a stored image, original first.
+func FileNames(original string) []string {
+	names := []string{original}
+	for _, size := range Variants {
+		names = append(names, FileName(original, size))
+	}
+	return names
+}
+
+// Read reads an upload, rejecting it if it exceeds MaxUploadBytes.
+func Read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxUploadBytes {
+		return nil, fmt.Errorf("%w: file is larger than %d MB", ErrInvalidImage, MaxUploadBytes>>20)
+	}
+	return data, nil
+}
+
+// Process validates an uploaded image and renders the original and every variant.
+func Process(data []byte) (*Processed, error) {
+	inputType := http.DetectContentType(data)
+	contentType, ok := outputFormats[inputType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported type %s (use JPEG, PNG or GIF)", ErrInvalidImage, inputType)
+	}
+
+	// Check the dimensions from the header before decoding the pixels
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%w: image has no pixels", ErrInvalidImage)
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds the %dpx / %d megapixel limit",
+			ErrInvalidImage, cfg.Width, cfg.Height, MaxDimension, MaxPixels/1_000_000)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	src := toRGBA(img)
+	if inputType == "image/jpeg" {
+		src = applyOrientation(src, exifOrientation(data))
+	}
+
+	out := &Processed{
+		ContentType: contentType,
+		Ext:         extensions[contentType],
+		Width:       src.Bounds().Dx(),
+		Height:      src.Bounds().Dy(),
+		Renditions:  make(map[Size][]byte, len(Variants)+1),
+	}
+	if out.Renditions[Original], err = encode(src, contentType); err != nil {
+		return nil, err
+	}
+	for _, size := range Variants {
+		if out.Renditions[size], err = encode(fit(src, variantBounds[size]), contentType); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// encode writes img in the given format. The standard encoders write no metadata.
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s: %w", contentType, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,160 @@
+package imagepipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage returns a w x h image that is red on the left half and blue on the right.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// withOrientation inserts an EXIF APP1 segment carrying the orientation tag after the SOI marker.
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08") // Big-endian header, IFD at offset 8
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // Orientation tag
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)      // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // Value padding and next-IFD offset
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("PNGVariants", func(t *testing.T) {
+		out, err := Process(encodePNG(t, testImage(1600, 400)))
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", out.ContentType)
+		assert.Equal(t, ".png", out.Ext)
+
+		for size, want := range map[Size]image.Point{Original: {1600, 400}, Medium: {800, 200}, Thumb: {200, 50}} {
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(out.Renditions[size]))
+			require.NoError(t, err, size)
+			assert.Equal(t, "png", format)
+			assert.Equal(t, want, image.Pt(cfg.Width, cfg.Height), size)
+		}
+	})
+
+	t.Run("SmallImageNotUpscaled", func(t *testing.T) {
+		out, err := Process(encodePNG(t, testImage(100, 60)))
+		require.NoError(t, err)
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(out.Renditions[Medium]))
+		require.NoError(t, err)
+		assert.Equal(t, 100, cfg.Width)
+	})
+
+	t.Run("GIFStoredAsPNG", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, gif.Encode(&buf, testImage(40, 40), nil))
+		out, err := Process(buf.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", out.ContentType)
+		assert.Equal(t, "image/png", http.DetectContentType(out.Renditions[Original]))
+	})
+
+	t.Run("JPEGOrientationAppliedAndEXIFStripped", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, testImage(64, 32), nil))
+		data := withOrientation(buf.Bytes(), 6)
+		require.Equal(t, 6, exifOrientation(data))
+
+		out, err := Process(data)
+		require.NoError(t, err)
+		assert.Equal(t, ".jpg", out.Ext)
+		assert.Equal(t, 32, out.Width, "Orientation 6 should turn the image upright")
+		assert.Equal(t, 64, out.Height)
+		assert.NotContains(t, string(out.Renditions[Original]), "Exif", "Metadata should be stripped")
+		assert.Equal(t, 1, exifOrientation(out.Renditions[Original]))
+
+		// The red left half ends up on top after a clockwise turn
+		img, err := jpeg.Decode(bytes.NewReader(out.Renditions[Original]))
+		require.NoError(t, err)
+		r, _, b, _ := img.At(16, 4).RGBA()
+		assert.Greater(t, r, b)
+	})
+
+	for name, data := range map[string][]byte{
+		"Text":      []byte("definitely not an image"),
+		"Truncated": encodePNG(t, testImage(10, 10))[:40],
+		"TooWide":   encodePNG(t, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))),
+	} {
+		t.Run("Rejects"+name, func(t *testing.T) {
+			_, err := Process(data)
+			assert.True(t, errors.Is(err, ErrInvalidImage), "got %v", err)
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	data, err := Read(strings.NewReader("small"))
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+
+	_, err = Read(bytes.NewReader(make([]byte, MaxUploadBytes+1)))
+	assert.True(t, errors.Is(err, ErrInvalidImage))
+}
+
+func TestApplyOrientation(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{G: 255, A: 255})
+
+	red := color.RGBA{R: 255, A: 255}
+	assert.Equal(t, red, applyOrientation(src, 1).At(0, 0))
+	assert.Equal(t, red, applyOrientation(src, 2).At(1, 0))
+	assert.Equal(t, red, applyOrientation(src, 6).At(0, 0))
+	assert.Equal(t, red, applyOrientation(src, 8).At(0, 1))
+	assert.Equal(t, image.Pt(1, 2), applyOrientation(src, 5).Bounds().Size())
+}
+
+func TestFileNames(t *testing.T) {
+	assert.Equal(t, "abc.jpg", FileName("abc.jpg", Original))
+	assert.Equal(t, "abc_thumb.jpg", FileName("abc.jpg", Thumb))
+	assert.Equal(t, []string{"abc.png", "abc_medium.png", "abc_thumb.png"}, FileNames("abc.png"))
+
+	size, err := ParseSize("")
+	require.NoError(t, err)
+	assert.Equal(t, Original, size)
+	size, err = ParseSize("Thumb")
+	require.NoError(t, err)
+	assert.Equal(t, Thumb, size)
+	_, err = ParseSize("huge")
+	assert.Error(t, err)
+}
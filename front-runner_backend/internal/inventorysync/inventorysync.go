@@ -0,0 +1,429 @@
+// Package inventorysync pushes stock levels to the marketplaces of sellers' linked storefronts, so
+// stock sold in one channel is not oversold in the others.
+//
+// Every stock change queues a prodtable.StockOutboxEntry in its own transaction. The sync turns
+// each entry into an InventoryPush per storefront link of the seller whose marketplace has a
+// connector, then pushes the current stock to the marketplace, retrying temporary failures with
+// exponential backoff. Products linked to a listing on a storefront (see prodtable.ProductListing)
+// are pushed to that listing, and the others to whichever listing has their SKU.
+package inventorysync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"front-runner/internal/connectors"
+	"front-runner/internal/coredbutils"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/storefronttable"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	db        *gorm.DB
+	setupOnce sync.Once
+	// syncInterval is how often pushes are made; read from INVENTORY_SYNC_SECONDS in Setup
+	syncInterval = defaultSyncInterval
+)
+
+// Push statuses.
+const (
+	PushPending   = "pending"    // Waiting to be pushed, or to be retried
+	PushSynced    = "synced"     // The marketplace has the stock
+	PushNotListed = "not_listed" // The product has no SKU, or the marketplace has no listing with it
+	PushFailed    = "failed"     // The marketplace rejected the push, or it failed too many times
+)
+
+const (
+	defaultSyncInterval = 30 * time.Second
+	batchSize           = 100              // Outbox entries, and pushes, handled per pass
+	pushLease           = 5 * time.Minute  // How long a push being made is left to its worker
+	maxPushAttempts     = 8                // Attempts before a push that keeps failing is given up
+	retryBaseDelay      = 30 * time.Second // Delay after the first failed attempt, doubled after each
+	retryMaxDelay       = 2 * time.Hour
+	maxPushErrorLength  = 1000
+)
+
+// inventoryPushIndex keeps one push per product or variant and storefront link.
+const inventoryPushIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_pushes_target ON inventory_pushes (link_id, product_id, variant_id)`
+
+var (
+	errNoSKU      = errors.New("the product has no SKU to find its listing by")
+	errStockGone  = errors.New("the product or variant was deleted")
+	errNoOwnStock = errors.New("the product's stock is managed per variant")
+	errLinkGone   = errors.New("the storefront link was deleted")
+	errNotListed  = errors.New("no listing on the marketplace has this SKU")
+	errPushFailed = errors.New("gave up after too many failed attempts")
+)
+
+// InventoryPush is the stock of a product, or of one of its variants, to be pushed to the
+// marketplace of one storefront link, and how pushing it went.
+type InventoryPush struct {
+	ID            uint       `gorm:"primaryKey"`
+	LinkID        uint       `gorm:"not null"`
+	UserID        uint       `gorm:"not null;index"` // Seller
+	ProductID     uint       `gorm:"not null;index"`
+	VariantID     uint       `gorm:"not null;default:0"` // 0 for the product's own stock
+	Status        string     `gorm:"not null;index"`
+	Generation    uint       `gorm:"not null;default:0"` // Incremented by every stock change, so a push in flight cannot mark a later change synced
+	SKU           string     // SKU last pushed
+	Quantity      int        // Quantity last pushed
+	Attempts      int        `gorm:"not null;default:0"` // Failed attempts since the last stock change
+	NextAttemptAt time.Time  `gorm:"not null;index"`
+	LeaseUntil    *time.Time // Set while a worker is pushing
+	LastError     string
+	SyncedAt      *time.Time
+	UpdatedAt     time.Time
+}
+
+// Setup initializes the database connection for the inventorysync package.
+func Setup() {
+	setupOnce.Do(func() {
+		coredbutils.LoadEnv() // Ensure env vars are loaded if needed by GetDB
+		var err error
+		db, err = coredbutils.GetDB()
+		if err != nil {
+			log.Fatalf("inventorysync Setup: Failed to get database connection: %v", err)
+		}
+		if db == nil {
+			log.Fatal("inventorysync Setup: Database connection is nil after GetDB.")
+		}
+		syncInterval = syncIntervalFromEnv()
+		log.Println("inventorysync package setup complete (DB connection obtained).")
+	})
+}
+
+// MigrateInventorySyncDB runs GORM auto-migration for the inventory pushes table.
+func MigrateInventorySyncDB() {
+	if db == nil {
+		log.Fatal("Database connection is not initialized for inventory sync migration")
+	}
+	log.Println("Running inventory sync database migrations...")
+	if err := db.AutoMigrate(&InventoryPush{}); err != nil {
+		log.Fatalf("Inventory sync migration failed: %v", err)
+	}
+	if err := db.Exec(inventoryPushIndex).Error; err != nil {
+		log.Fatalf("Inventory sync migration failed creating index: %v", err)
+	}
+	log.Println("Inventory sync database migration complete")
+}
+
+// ClearInventoryPushTable removes all inventory pushes. USE WITH CAUTION.
+func ClearInventoryPushTable(db *gorm.DB) error {
+	return db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&InventoryPush{}).Error
+}
+
+// syncIntervalFromEnv reads INVENTORY_SYNC_SECONDS, falling back to defaultSyncInterval.
+func syncIntervalFromEnv() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("INVENTORY_SYNC_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultSyncInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// retryDelay is how long to wait before retrying a push that has failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// queueOutbox turns stock outbox entries into pending pushes to each of the seller's storefront
+// links with a connector, and reports how many entries it handled. Pushes already queued are
+// reset to be made again with the new stock.
+func queueOutbox(ctx context.Context, now time.Time) (int, error) {
+	var entries []prodtable.StockOutboxEntry
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Entries are locked until removed. A stock change made meanwhile updates its entry, which
+		// waits for the lock and then queues the entry again (see prodtable's queueStockPush)
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Order("id").Limit(batchSize).Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			var product prodtable.Product
+			if err := tx.Select("id", "user_id").First(&product, entry.ProductID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				return err
+			}
+			var links []storefronttable.StorefrontLink
+			if err := tx.Where("user_id = ? AND LOWER(store_type) IN ?", product.UserID, connectors.StoreTypes()).Find(&links).Error; err != nil {
+				return err
+			}
+			for _, link := range links {
+				push := InventoryPush{LinkID: link.ID, UserID: product.UserID, ProductID: entry.ProductID, VariantID: entry.VariantID,
+					Status: PushPending, Generation: 1, NextAttemptAt: now}
+				if err := tx.Clauses(clause.OnConflict{
+					Columns: []clause.Column{{Name: "link_id"}, {Name: "product_id"}, {Name: "variant_id"}},
+					DoUpdates: clause.Assignments(map[string]interface{}{
+						"status":          PushPending,
+						"generation":      gorm.Expr("inventory_pushes.generation + 1"),
+						"attempts":        0,
+						"next_attempt_at": now,
+						"last_error":      "",
+						"updated_at":      now,
+					}),
+				}).Create(&push).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return tx.Delete(&entries).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("queueing stock changes: %w", err)
+	}
+	return len(entries), nil
+}
+
+// claimPushes leases the pending pushes that are due, so other workers leave them alone.
+func claimPushes(ctx context.Context, now time.Time) ([]InventoryPush, error) {
+	var pushes []InventoryPush
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ? AND (lease_until IS NULL OR lease_until < ?)", PushPending, now, now).
+			Order("link_id, id").Limit(batchSize).Find(&pushes).Error; err != nil {
+			return err
+		}
+		if len(pushes) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(pushes))
+		for i, p := range pushes {
+			ids[i] = p.ID
+		}
+		return tx.Model(&InventoryPush{}).Where("id IN ?", ids).Update("lease_until", now.Add(pushLease)).Error
+	})
+	return pushes, err
+}
+
+// storefront is a storefront link's connection to its marketplace during one pass.
+type storefront struct {
+	conn     connectors.Connector
+	err      error             // Why the link cannot be pushed to, if it cannot
+	listings map[string]string // SKU -> ID of the listing selling it; loaded when first needed
+}
+
+// openStorefront connects to the marketplace of a storefront link.
+func openStorefront(ctx context.Context, linkID uint) *storefront {
+	var link storefronttable.StorefrontLink
+	if err := db.WithContext(ctx).First(&link, linkID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = errLinkGone
+		}
+		return &storefront{err: err}
+	}
+	conn, err := link.Connector()
+	return &storefront{conn: conn, err: err}
+}
+
+// listingID finds the listing selling a SKU on the marketplace.
+func (s *storefront) listingID(ctx context.Context, sku string) (string, error) {
+	if s.listings == nil {
+		listings, err := s.conn.ListListings(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.listings = map[string]string{}
+		for _, l := range listings {
+			for _, v := range l.Variants {
+				if v.SKU != "" {
+					s.listings[v.SKU] = l.ExternalID
+				}
+			}
+		}
+	}
+	id, ok := s.listings[sku]
+	if !ok {
+		return "", errNotListed
+	}
+	return id, nil
+}
+
+// currentStock returns the SKU and stock of a product, or of one of its variants. The product's
+// own stock is not pushed once it has variants, since each variant is pushed.
+func currentStock(ctx context.Context, productID, variantID uint) (string, int, error) {
+	if variantID != 0 {
+		var variant prodtable.ProductVariant
+		err := db.WithContext(ctx).Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error
+		return variant.SKU, int(variant.Count), err
+	}
+	var product prodtable.Product
+	if err := db.WithContext(ctx).First(&product, productID).Error; err != nil {
+		return "", 0, err
+	}
+	var variants int64
+	if err := db.WithContext(ctx).Model(&prodtable.ProductVariant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+		return "", 0, err
+	}
+	if variants > 0 {
+		return "", 0, errNoOwnStock
+	}
+	return product.SKU, int(product.ProdCount), nil
+}
+
+// linkedListing returns the listing a product is linked to on a storefront link, if any.
+func linkedListing(ctx context.Context, productID, linkID uint) (*prodtable.ProductListing, error) {
+	var listing prodtable.ProductListing
+	err := db.WithContext(ctx).Where("product_id = ? AND link_id = ?", productID, linkID).First(&listing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// push sets the stock of a push's product or variant on the storefront's marketplace, returning
+// the SKU and quantity pushed.
+func (s *storefront) push(ctx context.Context, p *InventoryPush) (string, int, error) {
+	if s.err != nil {
+		return "", 0, s.err
+	}
+	sku, quantity, err := currentStock(ctx, p.ProductID, p.VariantID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = errStockGone
+		}
+		return "", 0, err
+	}
+	linked, err := linkedListing(ctx, p.ProductID, p.LinkID)
+	if err != nil {
+		return "", 0, err
+	}
+	if linked != nil && p.VariantID == 0 && linked.ExternalSKU != "" {
+		sku = linked.ExternalSKU
+	}
+	if sku == "" {
+		return "", quantity, errNoSKU
+	}
+	var listingID string
+	if linked != nil {
+		listingID = linked.ExternalListingID
+	} else if listingID, err = s.listingID(ctx, sku); err != nil {
+		return sku, quantity, err
+	}
+	return sku, quantity, s.conn.UpdateInventory(ctx, connectors.InventoryUpdate{ListingID: listingID, SKU: sku, Quantity: quantity})
+}
+
+// finishPush records how a push went. Pushes whose stock changed while they were made are left
+// pending, to be made again with the new stock.
+func finishPush(ctx context.Context, p *InventoryPush, sku string, quantity int, pushErr error, now time.Time) error {
+	if errors.Is(pushErr, errStockGone) || errors.Is(pushErr, errLinkGone) || errors.Is(pushErr, errNoOwnStock) {
+		return db.WithContext(ctx).Delete(&InventoryPush{}, p.ID).Error
+	}
+
+	updates := map[string]interface{}{"lease_until": nil, "last_error": ""}
+	switch {
+	case pushErr == nil:
+		updates["status"], updates["sku"], updates["quantity"], updates["synced_at"] = PushSynced, sku, quantity, now
+		updates["attempts"] = 0
+	case errors.Is(pushErr, errNoSKU), errors.Is(pushErr, errNotListed), errors.Is(pushErr, connectors.ErrNotFound):
+		updates["status"], updates["sku"] = PushNotListed, sku
+		updates["last_error"] = pushErr.Error()
+	default:
+		message := pushErr.Error()
+		if p.Attempts+1 >= maxPushAttempts && connectors.IsTemporary(pushErr) {
+			message = fmt.Sprintf("%v: %s", errPushFailed, message)
+		}
+		if len(message) > maxPushErrorLength {
+			message = message[:maxPushErrorLength]
+		}
+		updates["last_error"], updates["attempts"] = message, p.Attempts+1
+		if connectors.IsTemporary(pushErr) && p.Attempts+1 < maxPushAttempts {
+			updates["next_attempt_at"] = now.Add(retryDelay(p.Attempts + 1))
+		} else {
+			updates["status"] = PushFailed
+		}
+	}
+
+	res := db.WithContext(ctx).Model(&InventoryPush{}).Where("id = ? AND generation = ?", p.ID, p.Generation).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return db.WithContext(ctx).Model(&InventoryPush{}).Where("id = ?", p.ID).Update("lease_until", nil).Error
+	}
+
+	// The product's linked listing, if any, shows how its latest push went
+	listing := map[string]interface{}{"sync_error": updates["last_error"]}
+	switch status, _ := updates["status"].(string); status {
+	case PushSynced:
+		listing["sync_status"], listing["last_synced_at"] = prodtable.ListingSynced, now
+	case PushNotListed, PushFailed:
+		listing["sync_status"] = prodtable.ListingSyncError
+	default:
+		listing["sync_status"] = prodtable.ListingSyncPending
+	}
+	return db.WithContext(ctx).Model(&prodtable.ProductListing{}).Where("product_id = ? AND link_id = ?", p.ProductID, p.LinkID).Updates(listing).Error
+}
+
+// Sync queues the stock changes in the outbox, then pushes the stock of the pending pushes that
+// are due to the marketplaces. It reports how many pushes succeeded. A push failing does not stop
+// the others; its error is recorded in its InventoryPush.
+func Sync(ctx context.Context, now time.Time) (int, error) {
+	if _, err := queueOutbox(ctx, now); err != nil {
+		return 0, err
+	}
+	pushes, err := claimPushes(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("claiming pushes: %w", err)
+	}
+
+	storefronts := map[uint]*storefront{}
+	synced := 0
+	for i := range pushes {
+		p := &pushes[i]
+		if err := ctx.Err(); err != nil {
+			return synced, err
+		}
+		s, ok := storefronts[p.LinkID]
+		if !ok {
+			s = openStorefront(ctx, p.LinkID)
+			storefronts[p.LinkID] = s
+		}
+		sku, quantity, pushErr := s.push(ctx, p)
+		if pushErr == nil {
+			synced++
+		} else if !errors.Is(pushErr, errNoSKU) && !errors.Is(pushErr, errNotListed) && !errors.Is(pushErr, errNoOwnStock) {
+			log.Printf("Inventory sync: push %d to storefront link %d: %v", p.ID, p.LinkID, pushErr)
+		}
+		if err := finishPush(ctx, p, sku, quantity, pushErr, now); err != nil {
+			log.Printf("Inventory sync: recording push %d: %v", p.ID, err)
+		}
+	}
+	return synced, nil
+}
+
+// StartInventorySync pushes stock changes to linked marketplaces in the background every INVENTORY_SYNC_SECONDS.
+func StartInventorySync() {
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			synced, err := Sync(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("Inventory sync: %v", err)
+			}
+			if synced > 0 {
+				log.Printf("Inventory sync: pushed the stock of %d products and variants", synced)
+			}
+		}
+	}()
+}
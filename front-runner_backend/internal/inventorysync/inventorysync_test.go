@@ -0,0 +1,374 @@
+// internal/inventorysync/inventorysync_test.go
+package inventorysync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"front-runner/internal/connectors"
+	"front-runner/internal/coredbutils"
+	"front-runner/internal/oauth"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/storefronttable"
+	"front-runner/internal/usertable"
+)
+
+const projectDirName = "front-runner_backend"
+
+// Global test variables
+var (
+	testDB           *gorm.DB
+	testSessionStore *sessions.CookieStore
+	setupEnvOnce     sync.Once
+)
+
+// setupTestEnvironment loads environment variables, initializes DB and session store for tests.
+// It also clears relevant tables before each test run.
+func setupTestEnvironment(t *testing.T) {
+	t.Helper()
+
+	setupEnvOnce.Do(func() {
+		// Find project root
+		re := regexp.MustCompile(`^(.*` + projectDirName + `)`)
+		cwd, _ := os.Getwd()
+		rootPath := re.Find([]byte(cwd))
+		if rootPath == nil {
+			t.Fatalf("Could not find project root directory '%s' from '%s'", projectDirName, cwd)
+		}
+
+		// Load .env file
+		envPath := string(rootPath) + `/.env`
+		err := godotenv.Load(envPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Problem loading .env file from %s: %v", envPath, err)
+		}
+
+		// Initialize DB connection
+		coredbutils.ResetDBStateForTests()
+		require.NoError(t, coredbutils.LoadEnv(), "Failed to load core DB environment")
+		var dbErr error
+		testDB, dbErr = coredbutils.GetDB()
+		require.NoError(t, dbErr, "Failed to get DB connection for tests")
+
+		// Initialize Session Store for tests
+		testSessionStore = sessions.NewCookieStore([]byte("test-auth-key-32-bytes-long-000"), []byte("test-enc-key-needs-to-be-32-byte"))
+		testSessionStore.Options = &sessions.Options{Path: "/", MaxAge: 86400, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+
+		// Setup dependent packages
+		usertable.Setup()
+		prodtable.Setup()
+		storefronttable.Setup()
+		oauth.Setup(testSessionStore)
+		Setup()
+
+		// Run migrations once after setup
+		usertable.MigrateUserDB()
+		prodtable.MigrateProdDB()
+		storefronttable.MigrateStorefrontDB()
+		MigrateInventorySyncDB()
+	})
+
+	require.NoError(t, ClearInventoryPushTable(testDB), "Failed to clear inventory_pushes table")
+	require.NoError(t, storefronttable.ClearStorefrontTable(testDB), "Failed to clear storefront_links table")
+	require.NoError(t, prodtable.ClearProdTable(testDB), "Failed to clear product tables")
+	require.NoError(t, usertable.ClearUserTable(testDB), "Failed to clear user table")
+}
+
+// Helper to create a test user directly in the DB
+func createTestUser(t *testing.T, email string) *usertable.User {
+	t.Helper()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	require.NoError(t, usertable.CreateUser(&usertable.User{Email: email, PasswordHash: string(hashedPassword), Name: "Test User " + email, Provider: "local"}))
+	user, err := usertable.GetUserByEmail(email)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	return user
+}
+
+// Helper to create an authenticated request
+func createAuthenticatedRequest(t *testing.T, user *usertable.User, method, url string, body io.Reader) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(method, url, body)
+	session, err := testSessionStore.New(req, "front-runner-session")
+	require.NoError(t, err)
+	session.Values["userID"] = user.ID
+	rr := httptest.NewRecorder()
+	require.NoError(t, testSessionStore.Save(req, rr, session))
+	req.Header.Set("Cookie", rr.Header().Get("Set-Cookie"))
+	return req
+}
+
+// Helper to create a test product directly in the DB
+func createTestProduct(t *testing.T, owner *usertable.User, name, sku string, count uint) *prodtable.Product {
+	t.Helper()
+	image := prodtable.Image{URL: fmt.Sprintf("dummy_%s.jpg", uuid.NewString()), UserID: owner.ID}
+	require.NoError(t, testDB.Create(&image).Error)
+	product := &prodtable.Product{UserID: owner.ID, ProdName: name, SKU: sku, ImgID: image.ID, PriceMinor: 500, ProdCount: count}
+	require.NoError(t, testDB.Create(product).Error)
+	return product
+}
+
+// fakeShelf is what links of the "fakeshelf" store type list, the updates pushed to them, and the
+// error pushes fail with.
+var (
+	fakeShelfListings = []connectors.Listing{{ExternalID: "L1", Variants: []connectors.ListingVariant{{SKU: "MUG"}}}}
+	fakeShelfUpdates  []connectors.InventoryUpdate
+	fakeShelfErr      error
+)
+
+// fakeShelf is a connector recording inventory updates in fakeShelfUpdates.
+type fakeShelf struct{}
+
+func (fakeShelf) ListListings(context.Context) ([]connectors.Listing, error) {
+	return fakeShelfListings, nil
+}
+
+func (fakeShelf) PushListing(_ context.Context, l connectors.Listing) (connectors.Listing, error) {
+	return l, nil
+}
+
+func (fakeShelf) PullOrders(context.Context, time.Time) ([]connectors.Order, error) { return nil, nil }
+
+func (fakeShelf) UpdateInventory(_ context.Context, update connectors.InventoryUpdate) error {
+	if fakeShelfErr != nil {
+		return fakeShelfErr
+	}
+	fakeShelfUpdates = append(fakeShelfUpdates, update)
+	return nil
+}
+
+func init() {
+	connectors.Register("fakeshelf", func(connectors.Config) (connectors.Connector, error) { return fakeShelf{}, nil })
+}
+
+func TestSyncIntervalFromEnv(t *testing.T) {
+	t.Setenv("INVENTORY_SYNC_SECONDS", "")
+	assert.Equal(t, defaultSyncInterval, syncIntervalFromEnv())
+	t.Setenv("INVENTORY_SYNC_SECONDS", "90")
+	assert.Equal(t, 90*time.Second, syncIntervalFromEnv())
+	t.Setenv("INVENTORY_SYNC_SECONDS", "0")
+	assert.Equal(t, defaultSyncInterval, syncIntervalFromEnv())
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, retryBaseDelay, retryDelay(1))
+	assert.Equal(t, 2*retryBaseDelay, retryDelay(2))
+	assert.Equal(t, 8*retryBaseDelay, retryDelay(4))
+	assert.Equal(t, retryMaxDelay, retryDelay(maxPushAttempts*10), "Delays are capped")
+}
+
+// TestInventorySync tests queueing stock changes, pushing them to linked marketplaces, and retrying failed pushes.
+func TestInventorySync(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "inventoryseller@example.com")
+	other := createTestUser(t, "inventoryother@example.com")
+	mug := createTestProduct(t, seller, "Sync Mug", "MUG", 10)
+	plate := createTestProduct(t, seller, "Sync Plate", "", 10)
+	link := storefronttable.StorefrontLink{UserID: seller.ID, StoreType: "fakeshelf", StoreName: "Shelf"}
+	require.NoError(t, testDB.Create(&link).Error)
+	// Stores without a connector are not pushed to
+	require.NoError(t, testDB.Create(&storefronttable.StorefrontLink{UserID: seller.ID, StoreType: "elsewhere", StoreName: "Elsewhere"}).Error)
+	defer func() { fakeShelfUpdates, fakeShelfErr = nil, nil }()
+
+	adjust := func(t *testing.T, product *prodtable.Product, delta int64) {
+		t.Helper()
+		require.NoError(t, testDB.Transaction(func(tx *gorm.DB) error {
+			return prodtable.AdjustStock(tx, product.ID, 0, delta, prodtable.StockChange{Reason: prodtable.StockReasonAdjustment})
+		}))
+	}
+	syncAt := func(t *testing.T, now time.Time) int {
+		t.Helper()
+		synced, err := Sync(context.Background(), now)
+		require.NoError(t, err)
+		return synced
+	}
+	pushOf := func(t *testing.T, product *prodtable.Product) InventoryPush {
+		t.Helper()
+		var push InventoryPush
+		require.NoError(t, testDB.Where("link_id = ? AND product_id = ?", link.ID, product.ID).First(&push).Error)
+		return push
+	}
+	now := time.Now()
+
+	t.Run("QueuesStockChanges", func(t *testing.T) {
+		adjust(t, mug, -3)
+		adjust(t, mug, -1)
+		var entries int64
+		require.NoError(t, testDB.Model(&prodtable.StockOutboxEntry{}).Where("product_id = ?", mug.ID).Count(&entries).Error)
+		assert.Equal(t, int64(1), entries, "Changes not yet pushed share an outbox entry")
+	})
+
+	t.Run("PushesCurrentStock", func(t *testing.T) {
+		assert.Equal(t, 1, syncAt(t, now))
+		assert.Equal(t, []connectors.InventoryUpdate{{ListingID: "L1", SKU: "MUG", Quantity: 6}}, fakeShelfUpdates)
+		push := pushOf(t, mug)
+		assert.Equal(t, PushSynced, push.Status)
+		assert.Equal(t, 6, push.Quantity)
+
+		var pushes, entries int64
+		require.NoError(t, testDB.Model(&InventoryPush{}).Count(&pushes).Error)
+		assert.Equal(t, int64(1), pushes, "Only links with a connector are pushed to")
+		require.NoError(t, testDB.Model(&prodtable.StockOutboxEntry{}).Count(&entries).Error)
+		assert.Zero(t, entries)
+		assert.Equal(t, 0, syncAt(t, now), "Nothing is pushed again until the stock changes")
+	})
+
+	t.Run("NotListed", func(t *testing.T) {
+		adjust(t, plate, -1)
+		assert.Equal(t, 0, syncAt(t, now))
+		assert.Equal(t, PushNotListed, pushOf(t, plate).Status, "Products without a SKU cannot be found on the marketplace")
+
+		require.NoError(t, testDB.Model(plate).Update("sku", "PLATE").Error)
+		adjust(t, plate, -1)
+		assert.Equal(t, 0, syncAt(t, now))
+		push := pushOf(t, plate)
+		assert.Equal(t, PushNotListed, push.Status)
+		assert.Equal(t, "PLATE", push.SKU)
+	})
+
+	t.Run("RetriesTemporaryFailures", func(t *testing.T) {
+		fakeShelfUpdates = nil
+		fakeShelfErr = &connectors.APIError{StatusCode: http.StatusServiceUnavailable, Message: "down"}
+		adjust(t, mug, 4)
+		assert.Equal(t, 0, syncAt(t, now))
+		push := pushOf(t, mug)
+		assert.Equal(t, PushPending, push.Status)
+		assert.Equal(t, 1, push.Attempts)
+		assert.Contains(t, push.LastError, "503")
+		assert.WithinDuration(t, now.Add(retryBaseDelay), push.NextAttemptAt, time.Second)
+
+		fakeShelfErr = nil
+		assert.Equal(t, 0, syncAt(t, now.Add(retryBaseDelay/2)), "The retry waits for its delay")
+		assert.Equal(t, 1, syncAt(t, now.Add(retryBaseDelay)))
+		assert.Equal(t, []connectors.InventoryUpdate{{ListingID: "L1", SKU: "MUG", Quantity: 10}}, fakeShelfUpdates)
+		push = pushOf(t, mug)
+		assert.Equal(t, PushSynced, push.Status)
+		assert.Zero(t, push.Attempts)
+		assert.Empty(t, push.LastError)
+	})
+
+	t.Run("GivesUpOnRejectedPushes", func(t *testing.T) {
+		fakeShelfErr = &connectors.APIError{StatusCode: http.StatusUnprocessableEntity, Message: "bad quantity"}
+		adjust(t, mug, 1)
+		assert.Equal(t, 0, syncAt(t, now))
+		assert.Equal(t, PushFailed, pushOf(t, mug).Status)
+
+		// The next stock change is pushed again
+		fakeShelfErr = nil
+		adjust(t, mug, 1)
+		assert.Equal(t, 1, syncAt(t, now))
+		push := pushOf(t, mug)
+		assert.Equal(t, PushSynced, push.Status)
+		assert.Equal(t, 12, push.Quantity)
+	})
+
+	t.Run("ChangedWhilePushing", func(t *testing.T) {
+		adjust(t, mug, -2)
+		_, err := queueOutbox(context.Background(), now)
+		require.NoError(t, err)
+		pushes, err := claimPushes(context.Background(), now)
+		require.NoError(t, err)
+		require.Len(t, pushes, 1)
+		adjust(t, mug, -1)
+		_, err = queueOutbox(context.Background(), now)
+		require.NoError(t, err)
+
+		require.NoError(t, finishPush(context.Background(), &pushes[0], "MUG", 10, nil, now))
+		assert.Equal(t, PushPending, pushOf(t, mug).Status, "A later change is not marked synced by an earlier push")
+		assert.Equal(t, 1, syncAt(t, now))
+		assert.Equal(t, 9, pushOf(t, mug).Quantity)
+	})
+
+	t.Run("ChangedWhileQueueing", func(t *testing.T) {
+		adjust(t, mug, -1)
+		// Hold the entry locked, as queueOutbox does until it removes it
+		tx := testDB.Begin()
+		defer tx.Rollback()
+		var entries []prodtable.StockOutboxEntry
+		require.NoError(t, tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", mug.ID).Find(&entries).Error)
+		require.Len(t, entries, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- testDB.Transaction(func(tx *gorm.DB) error {
+				return prodtable.AdjustStock(tx, mug.ID, 0, -1, prodtable.StockChange{Reason: prodtable.StockReasonAdjustment})
+			})
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("The stock change did not wait for the entry being queued: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		require.NoError(t, tx.Delete(&entries).Error)
+		require.NoError(t, tx.Commit().Error)
+		require.NoError(t, <-done)
+
+		var count int64
+		require.NoError(t, testDB.Model(&prodtable.StockOutboxEntry{}).Where("product_id = ?", mug.ID).Count(&count).Error)
+		assert.Equal(t, int64(1), count, "The change is queued again once the entry is removed")
+		assert.Equal(t, 1, syncAt(t, now))
+		assert.Equal(t, 7, pushOf(t, mug).Quantity)
+	})
+
+	t.Run("GetInventoryPushes", func(t *testing.T) {
+		list := func(t *testing.T, user *usertable.User, url string) []InventoryPushReturn {
+			t.Helper()
+			rr := httptest.NewRecorder()
+			GetInventoryPushes(rr, createAuthenticatedRequest(t, user, "GET", url, nil))
+			require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+			var ret []InventoryPushReturn
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ret))
+			return ret
+		}
+		assert.Len(t, list(t, seller, "/api/get_inventory_pushes"), 2)
+		notListed := list(t, seller, "/api/get_inventory_pushes?status=not_listed")
+		require.Len(t, notListed, 1)
+		assert.Equal(t, plate.ID, notListed[0].ProductID)
+		assert.Len(t, list(t, seller, fmt.Sprintf("/api/get_inventory_pushes?productID=%d", mug.ID)), 1)
+		assert.Empty(t, list(t, other, "/api/get_inventory_pushes"))
+
+		rr := httptest.NewRecorder()
+		GetInventoryPushes(rr, createAuthenticatedRequest(t, seller, "GET", "/api/get_inventory_pushes?status=lost", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("LinkedListing", func(t *testing.T) {
+		fakeShelfUpdates = nil
+		listing := prodtable.ProductListing{ProductID: plate.ID, LinkID: link.ID, ExternalListingID: "L2", ExternalSKU: "PLATE-ALT",
+			SyncStatus: prodtable.ListingSyncPending}
+		require.NoError(t, testDB.Omit("Link").Create(&listing).Error)
+		adjust(t, plate, 1)
+		assert.Equal(t, 1, syncAt(t, now))
+		assert.Equal(t, []connectors.InventoryUpdate{{ListingID: "L2", SKU: "PLATE-ALT", Quantity: 9}}, fakeShelfUpdates,
+			"Linked products are pushed to their listing rather than found by SKU")
+		require.NoError(t, testDB.First(&listing, listing.ID).Error)
+		assert.Equal(t, prodtable.ListingSynced, listing.SyncStatus)
+		assert.NotNil(t, listing.LastSyncedAt)
+
+		fakeShelfErr = connectors.ErrNotFound
+		adjust(t, plate, 1)
+		assert.Equal(t, 0, syncAt(t, now))
+		require.NoError(t, testDB.First(&listing, listing.ID).Error)
+		assert.Equal(t, prodtable.ListingSyncError, listing.SyncStatus)
+		assert.NotEmpty(t, listing.SyncError)
+	})
+}
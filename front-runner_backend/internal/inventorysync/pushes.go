@@ -0,0 +1,118 @@
+package inventorysync
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"front-runner/internal/oauth"
+)
+
+// maxListedPushes is how many pushes GetInventoryPushes returns.
+const maxListedPushes = 100
+
+// InventoryPushReturn describes the stock of a product or variant pushed to a storefront link.
+type InventoryPushReturn struct {
+	PushID        uint   `json:"pushID"`
+	LinkID        uint   `json:"linkID"`
+	ProductID     uint   `json:"productID"`
+	VariantID     uint   `json:"variantID,omitempty"`
+	Status        string `json:"status"` // pending, synced, not_listed or failed
+	SKU           string `json:"sku,omitempty"`
+	Quantity      int    `json:"quantity"` // Stock last pushed
+	Attempts      int    `json:"attempts"` // Failed attempts since the stock last changed
+	LastError     string `json:"lastError,omitempty"`
+	NextAttemptAt string `json:"nextAttemptAt,omitempty"` // Formatted date string; set while pending
+	SyncedAt      string `json:"syncedAt,omitempty"`      // Formatted date string
+	UpdatedAt     string `json:"updatedAt"`               // Formatted date string
+}
+
+func (p *InventoryPush) toReturn() InventoryPushReturn {
+	ret := InventoryPushReturn{
+		PushID:    p.ID,
+		LinkID:    p.LinkID,
+		ProductID: p.ProductID,
+		VariantID: p.VariantID,
+		Status:    p.Status,
+		SKU:       p.SKU,
+		Quantity:  p.Quantity,
+		Attempts:  p.Attempts,
+		LastError: p.LastError,
+		UpdatedAt: p.UpdatedAt.Format(time.RFC3339),
+	}
+	if p.Status == PushPending {
+		ret.NextAttemptAt = p.NextAttemptAt.Format(time.RFC3339)
+	}
+	if p.SyncedAt != nil {
+		ret.SyncedAt = p.SyncedAt.Format(time.RFC3339)
+	}
+	return ret
+}
+
+// GetInventoryPushes lists how pushing stock to the user's storefront links is going.
+//
+// @Summary      List inventory pushes
+// @Description  Stock changes, from orders, product updates, imports or adjustments, are pushed to the marketplace of every storefront link with a connector (such as "shopify"), matched to its listings by SKU. Failed pushes are retried with increasing delays. Lists the most recently changed pushes, at most 100, with their status.
+// @Tags         stock
+// @Produce      json
+// @Param        linkID    query integer false "Only pushes to this Storefront Link"
+// @Param        productID query integer false "Only pushes of this product"
+// @Param        status    query string  false "Only pushes with this status" Enums(pending, synced, not_listed, failed)
+// @Success      200  {array}   InventoryPushReturn "The pushes"
+// @Failure      400  {string}  string "Invalid linkID, productID or status"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_inventory_pushes [get]
+func GetInventoryPushes(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetInventoryPushes: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	query := db.Where("user_id = ?", user.ID)
+	for _, filter := range []struct{ param, column string }{{"linkID", "link_id"}, {"productID", "product_id"}} {
+		s := q.Get(filter.param)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid "+filter.param, http.StatusBadRequest)
+			return
+		}
+		query = query.Where(filter.column+" = ?", id)
+	}
+	switch status := q.Get("status"); status {
+	case "":
+	case PushPending, PushSynced, PushNotListed, PushFailed:
+		query = query.Where("status = ?", status)
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	var pushes []InventoryPush
+	if err := query.Order("updated_at DESC, id DESC").Limit(maxListedPushes).Find(&pushes).Error; err != nil {
+		log.Printf("Error fetching inventory pushes of user %d: %v", user.ID, err)
+		http.Error(w, "Internal server error while fetching inventory pushes", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]InventoryPushReturn, 0, len(pushes))
+	for i := range pushes {
+		ret = append(ret, pushes[i].toReturn())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
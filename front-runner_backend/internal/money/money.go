@@ -0,0 +1,138 @@
+// Package money represents prices exactly as integer minor units (e.g. cents) of an ISO 4217 currency,
+// so totals can be summed without floating point rounding errors.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidAmount is returned by Parse for malformed, negative or too precise amounts.
+	ErrInvalidAmount = errors.New("invalid amount")
+	// ErrInvalidCurrency is returned by NormalizeCurrency for codes that are not three letters.
+	ErrInvalidCurrency = errors.New("invalid currency code")
+)
+
+// FallbackCurrency is used when DEFAULT_CURRENCY is unset or invalid.
+const FallbackCurrency = "USD"
+
+// MaxMinor bounds parsed amounts and order subtotals, so that totals with tax and shipping
+// added stay within int64.
+const MaxMinor = 1_000_000_000_000_000
+
+// exponents lists the currencies whose minor unit is not a hundredth of the major unit.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Exponent returns the number of decimal places of the currency's minor unit.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// NormalizeCurrency upper-cases a three-letter currency code and checks its form.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w %q", ErrInvalidCurrency, code)
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", fmt.Errorf("%w %q", ErrInvalidCurrency, code)
+		}
+	}
+	return code, nil
+}
+
+// DefaultCurrency returns the currency configured by DEFAULT_CURRENCY, or FallbackCurrency.
+// It applies to new products that do not name a currency and to rows migrated from float prices.
+func DefaultCurrency() string {
+	if code, err := NormalizeCurrency(os.Getenv("DEFAULT_CURRENCY")); err == nil {
+		return code
+	}
+	return FallbackCurrency
+}
+
+// Parse converts a non-negative decimal string such as "12.5" into minor units of currency.
+// It is exact: more decimal places than the currency allows are rejected rather than rounded.
+func Parse(s, currency string) (int64, error) {
+	minor, err := ParseDecimal(s, Exponent(currency))
+	if err != nil {
+		return 0, fmt.Errorf("%w for %s", err, currency)
+	}
+	return minor, nil
+}
+
+// ParseDecimal converts a non-negative decimal string with at most places decimal places
+// into an integer scaled by 10^places, e.g. "8.25" with 2 places is 825.
+func ParseDecimal(s string, places int) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > places || !digits(whole) || !digits(frac) {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+	var v int64
+	for _, c := range whole + frac + strings.Repeat("0", places-len(frac)) {
+		v = v*10 + int64(c-'0')
+		if v > MaxMinor {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+		}
+	}
+	return v, nil
+}
+
+// digits reports whether s consists only of ASCII digits; the empty string qualifies.
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Format renders minor units of currency as a decimal string with the currency's
+// number of decimal places, e.g. 1050 USD is "10.50" and 1050 JPY is "1050".
+func Format(minor int64, currency string) string {
+	return FormatDecimal(minor, Exponent(currency))
+}
+
+// FormatDecimal renders an integer scaled by 10^places as a decimal string, e.g. 825 with 2 places is "8.25".
+func FormatDecimal(v int64, places int) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	s := strconv.FormatInt(v, 10)
+	if places == 0 {
+		return sign + s
+	}
+	if len(s) <= places {
+		s = strings.Repeat("0", places-len(s)+1) + s
+	}
+	return sign + s[:len(s)-places] + "." + s[len(s)-places:]
+}
+
+// Number returns the amount as a JSON number carrying its exact decimal digits.
+func Number(minor int64, currency string) json.Number {
+	return json.Number(Format(minor, currency))
+}
+
+// Scale returns the number of minor units in one major unit of the currency, e.g. 100 for USD.
+func Scale(currency string) int64 {
+	scale := int64(1)
+	for i := 0; i < Exponent(currency); i++ {
+		scale *= 10
+	}
+	return scale
+}
@@ -0,0 +1,83 @@
+package money
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	valid := []struct {
+		in       string
+		currency string
+		want     int64
+	}{
+		{"10.50", "USD", 1050},
+		{"10.5", "USD", 1050},
+		{"0.1", "USD", 10},
+		{" 19.99 ", "EUR", 1999},
+		{"7", "USD", 700},
+		{".25", "USD", 25},
+		{"5.", "USD", 500},
+		{"1500", "JPY", 1500},
+		{"1.234", "KWD", 1234},
+	}
+	for _, tc := range valid {
+		got, err := Parse(tc.in, tc.currency)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.want, got, tc.in)
+	}
+
+	for _, in := range []string{"", ".", "-1", "1.005", "1e3", "abc", "1,50", "10000000000000000000"} {
+		_, err := Parse(in, "USD")
+		assert.True(t, errors.Is(err, ErrInvalidAmount), "%q should be rejected", in)
+	}
+	_, err := Parse("1.5", "JPY")
+	assert.Error(t, err, "JPY has no minor unit")
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "10.50", Format(1050, "USD"))
+	assert.Equal(t, "0.05", Format(5, "USD"))
+	assert.Equal(t, "0.00", Format(0, "EUR"))
+	assert.Equal(t, "-1.20", Format(-120, "USD"))
+	assert.Equal(t, "1500", Format(1500, "JPY"))
+	assert.Equal(t, "1.234", Format(1234, "KWD"))
+	assert.Equal(t, "10.50", Number(1050, "USD").String())
+
+	// Summing minor units is exact where float64 is not: 0.1 + 0.2
+	a, _ := Parse("0.1", "USD")
+	b, _ := Parse("0.2", "USD")
+	assert.Equal(t, "0.30", Format(a+b, "USD"))
+}
+
+func TestCurrency(t *testing.T) {
+	code, err := NormalizeCurrency(" eur ")
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", code)
+	for _, bad := range []string{"", "EURO", "E1R", "€"} {
+		_, err := NormalizeCurrency(bad)
+		assert.True(t, errors.Is(err, ErrInvalidCurrency), "%q should be rejected", bad)
+	}
+
+	t.Setenv("DEFAULT_CURRENCY", "gbp")
+	assert.Equal(t, "GBP", DefaultCurrency())
+	t.Setenv("DEFAULT_CURRENCY", "")
+	assert.Equal(t, FallbackCurrency, DefaultCurrency())
+	assert.Equal(t, 0, Exponent("JPY"))
+	assert.Equal(t, 2, Exponent("USD"))
+	assert.Equal(t, int64(100), Scale("USD"))
+	assert.Equal(t, int64(1), Scale("JPY"))
+}
+
+func TestDecimal(t *testing.T) {
+	v, err := ParseDecimal("8.25", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(825), v)
+	assert.Equal(t, "8.25", FormatDecimal(v, 2))
+	assert.Equal(t, "0.5", FormatDecimal(5, 1))
+	_, err = ParseDecimal("8.255", 2)
+	assert.True(t, errors.Is(err, ErrInvalidAmount))
+}
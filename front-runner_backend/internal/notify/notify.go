@@ -0,0 +1,113 @@
+// Package notify sends notifications, such as stock alerts, to sellers behind a common interface,
+// so they can be emailed over SMTP or just logged during development.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is a notification to one recipient.
+type Message struct {
+	To      string // Email address
+	Subject string
+	Body    string // Plain text
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to the log instead of delivering them.
+type LogNotifier struct{}
+
+// Notify logs the message.
+func (LogNotifier) Notify(_ context.Context, msg Message) error {
+	log.Printf("Notification to %s: %s: %s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// SMTPConfig describes the mail server an SMTPNotifier sends through.
+type SMTPConfig struct {
+	Host     string
+	Port     string // Defaults to 587
+	Username string // Optional; PLAIN authentication is used when set
+	Password string
+	From     string // Sender address
+}
+
+// SMTPNotifier emails messages through an SMTP server.
+type SMTPNotifier struct {
+	cfg  SMTPConfig
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTP returns a notifier sending through the configured server.
+func NewSMTP(cfg SMTPConfig) (*SMTPNotifier, error) {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil, fmt.Errorf("SMTP host and sender address are required")
+	}
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	return &SMTPNotifier{cfg: cfg, send: smtp.SendMail}, nil
+}
+
+// Notify emails the message.
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)
+	}
+	addr := net.JoinHostPort(n.cfg.Host, n.cfg.Port)
+	if err := n.send(addr, auth, n.cfg.From, []string{msg.To}, formatEmail(n.cfg.From, msg, time.Now())); err != nil {
+		return fmt.Errorf("sending email to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// formatEmail builds a plain text RFC 5322 message.
+func formatEmail(from string, msg Message, date time.Time) []byte {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", " ", "\n", " ")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// FromEnv builds the Notifier selected by the NOTIFIER environment variable:
+// "log" (the default) only logs messages, and "smtp" emails them through the server
+// described by the SMTP_* variables.
+func FromEnv() (Notifier, error) {
+	switch backend := strings.ToLower(strings.TrimSpace(os.Getenv("NOTIFIER"))); backend {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "smtp":
+		return NewSMTP(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q (use log or smtp)", backend)
+	}
+}
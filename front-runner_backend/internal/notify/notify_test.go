@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPNotifier(t *testing.T) {
+	n, err := NewSMTP(SMTPConfig{Host: "mail.example.com", Username: "user", Password: "secret", From: "alerts@example.com"})
+	require.NoError(t, err)
+
+	var addr, from string
+	var to []string
+	var sent []byte
+	n.send = func(a string, auth smtp.Auth, f string, t []string, msg []byte) error {
+		addr, from, to, sent = a, f, t, msg
+		return nil
+	}
+	require.NoError(t, n.Notify(context.Background(), Message{To: "seller@example.com", Subject: "Low stock:\r\nBcc: x@example.com", Body: "Line one\nLine two"}))
+
+	assert.Equal(t, "mail.example.com:587", addr, "Port defaults to 587")
+	assert.Equal(t, "alerts@example.com", from)
+	assert.Equal(t, []string{"seller@example.com"}, to)
+	msg := string(sent)
+	assert.Contains(t, msg, "Subject: Low stock:  Bcc: x@example.com\r\n", "Line breaks cannot inject headers")
+	assert.NotContains(t, msg, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nLine one\r\nLine two\r\n"))
+
+	_, err = NewSMTP(SMTPConfig{Host: "mail.example.com"})
+	assert.Error(t, err, "A sender address is required")
+}
+
+func TestFormatEmail(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(formatEmail("a@example.com", Message{To: "b@example.com", Subject: "Hi", Body: "Hello"}, date))
+	assert.Equal(t, "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\nDate: Fri, 01 Mar 2024 12:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nHello\r\n", msg)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("NOTIFIER", "")
+	n, err := FromEnv()
+	require.NoError(t, err)
+	assert.IsType(t, LogNotifier{}, n)
+
+	t.Setenv("NOTIFIER", "smtp")
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_FROM", "alerts@example.com")
+	n, err = FromEnv()
+	require.NoError(t, err)
+	assert.IsType(t, &SMTPNotifier{}, n)
+
+	t.Setenv("NOTIFIER", "pigeon")
+	_, err = FromEnv()
+	assert.Error(t, err)
+}
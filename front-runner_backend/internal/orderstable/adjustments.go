@@ -0,0 +1,94 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"fmt"
+	"front-runner/internal/money"
+	"front-runner/internal/pricing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrderAdjustment is a tax, shipping or discount line on one seller's share of an order.
+// Rates are stored with the line so that cancellations can recompute it exactly.
+type OrderAdjustment struct {
+	ID          uint      `gorm:"primaryKey"`
+	OrderID     uint      `gorm:"not null;index"`
+	UserID      uint      `gorm:"not null;index"` // Seller whose items the line applies to
+	Kind        string    `gorm:"not null"`       // pricing.KindDiscount, KindTax or KindShipping
+	Label       string    `gorm:"not null"`       // e.g. the tax name or discount code
+	RateBP      int64     `gorm:"not null;default:0"`
+	FixedMinor  int64     `gorm:"not null;default:0"`
+	AmountMinor int64     `gorm:"not null;default:0"` // Signed amount in the order's currency, negative for discounts
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// OrderAdjustmentReturn is an adjustment line as returned to the frontend.
+type OrderAdjustmentReturn struct {
+	Kind   string      `json:"kind"`   // "discount", "tax" or "shipping"
+	Label  string      `json:"label"`  // e.g. "CA sales tax" or the discount code
+	Amount json.Number `json:"amount"` // Signed amount, negative for discounts
+}
+
+// adjustmentLines converts stored adjustments to pricing lines.
+func adjustmentLines(adjustments []OrderAdjustment) []pricing.Line {
+	lines := make([]pricing.Line, len(adjustments))
+	for i, a := range adjustments {
+		lines[i] = pricing.Line{Kind: a.Kind, Label: a.Label, RateBP: a.RateBP, FixedMinor: a.FixedMinor, AmountMinor: a.AmountMinor}
+	}
+	return lines
+}
+
+// createAdjustments stores the priced lines of a seller's share of an order.
+func createAdjustments(tx *gorm.DB, orderID, sellerID uint, lines []pricing.Line) error {
+	for _, l := range lines {
+		adjustment := OrderAdjustment{
+			OrderID:     orderID,
+			UserID:      sellerID,
+			Kind:        l.Kind,
+			Label:       l.Label,
+			RateBP:      l.RateBP,
+			FixedMinor:  l.FixedMinor,
+			AmountMinor: l.AmountMinor,
+		}
+		if err := tx.Create(&adjustment).Error; err != nil {
+			return fmt.Errorf("failed to record %s for seller %d: %w", l.Kind, sellerID, err)
+		}
+	}
+	return nil
+}
+
+// recomputeAdjustments reprices a seller's adjustment lines for their current subtotal,
+// e.g. after some of their items were cancelled. It must run inside a transaction.
+func recomputeAdjustments(tx *gorm.DB, orderID, sellerID uint, subtotal int64) error {
+	var adjustments []OrderAdjustment
+	if err := tx.Where("order_id = ? AND user_id = ?", orderID, sellerID).Order("id asc").Find(&adjustments).Error; err != nil {
+		return fmt.Errorf("failed to fetch order adjustments: %w", err)
+	}
+	lines := adjustmentLines(adjustments)
+	pricing.Compute(subtotal, lines)
+	for i := range adjustments {
+		if adjustments[i].AmountMinor == lines[i].AmountMinor {
+			continue
+		}
+		if err := tx.Model(&adjustments[i]).Update("amount_minor", lines[i].AmountMinor).Error; err != nil {
+			return fmt.Errorf("failed to update order adjustment %d: %w", adjustments[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// applyTotals fills in the seller's subtotal, adjustment lines and grand total.
+func applyTotals(ret *OrderReturn, subtotal int64, adjustments []OrderAdjustment) {
+	totals := pricing.Summarize(subtotal, adjustmentLines(adjustments))
+	ret.Subtotal = money.Number(totals.Subtotal, ret.Currency)
+	ret.Discount = money.Number(totals.Discount, ret.Currency)
+	ret.Tax = money.Number(totals.Tax, ret.Currency)
+	ret.Shipping = money.Number(totals.Shipping, ret.Currency)
+	ret.Total = money.Number(totals.Total, ret.Currency)
+	ret.Adjustments = make([]OrderAdjustmentReturn, len(adjustments))
+	for i, a := range adjustments {
+		ret.Adjustments[i] = OrderAdjustmentReturn{Kind: a.Kind, Label: a.Label, Amount: money.Number(a.AmountMinor, ret.Currency)}
+	}
+}
@@ -0,0 +1,131 @@
+// internal/orderstable/adjustments_test.go
+package orderstable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/pricing"
+)
+
+// TestCreateOrder_Adjustments tests that tax, shipping and discount lines are recorded per seller
+// and repriced when items are cancelled.
+func TestCreateOrder_Adjustments(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "pricingseller@example.com", "password")
+	product := createTestProduct(t, seller, "Priced Prod", 2000, 10)
+
+	require.NoError(t, testDB.Create(&[]pricing.TaxRate{
+		{UserID: seller.ID, Region: "US", Name: "US tax", RateBP: 500},
+		{UserID: seller.ID, Region: "US-CA", Name: "CA sales tax", RateBP: 825},
+	}).Error)
+	require.NoError(t, testDB.Create(&pricing.ShippingRate{UserID: seller.ID, Region: "", Currency: "USD", AmountMinor: 500, FreeOverMinor: 10000}).Error)
+	require.NoError(t, testDB.Create(&pricing.DiscountCode{UserID: seller.ID, Code: "SAVE10", PercentBP: 1000, MaxUses: 1}).Error)
+
+	createOrder := func(t *testing.T, payload OrderCreatePayload) *httptest.ResponseRecorder {
+		t.Helper()
+		payload.CustomerName, payload.CustomerEmail = "Pricing Customer", "pricing@test.com"
+		body, _ := json.Marshal(payload)
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, req)
+		return rr
+	}
+	getOrder := func(t *testing.T, orderID uint) OrderReturn {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetOrder(rr, createAuthenticatedRequest(t, seller, "GET", fmt.Sprintf("/api/get_order?id=%d", orderID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp OrderReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("UnknownDiscountCode", func(t *testing.T) {
+		rr := createOrder(t, OrderCreatePayload{
+			OrderedProducts: []OrderProductPayload{{ProdID: product.ID, Count: 1}},
+			DiscountCodes:   []string{"NOPE"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	var orderID uint
+	t.Run("TaxShippingAndDiscount", func(t *testing.T) {
+		rr := createOrder(t, OrderCreatePayload{
+			OrderedProducts: []OrderProductPayload{{ProdID: product.ID, Count: 3}},
+			Region:          "us-ca",
+			DiscountCodes:   []string{"save10"},
+		})
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var created map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		orderID = created["orderID"]
+
+		resp := getOrder(t, orderID)
+		assert.Equal(t, json.Number("60.00"), resp.Subtotal)
+		assert.Equal(t, json.Number("6.00"), resp.Discount)
+		assert.Equal(t, json.Number("4.46"), resp.Tax, "8.25% of 54.00, rounded half up")
+		assert.Equal(t, json.Number("5.00"), resp.Shipping)
+		assert.Equal(t, json.Number("63.46"), resp.Total)
+		require.Len(t, resp.Adjustments, 3)
+		assert.Equal(t, OrderAdjustmentReturn{Kind: pricing.KindDiscount, Label: "SAVE10", Amount: "-6.00"}, resp.Adjustments[0])
+		assert.Equal(t, "CA sales tax", resp.Adjustments[1].Label, "The most specific region's rate applies")
+	})
+
+	t.Run("NoRatesConfigured", func(t *testing.T) {
+		other := createTestUser(t, "pricingother@example.com", "password")
+		otherProduct := createTestProduct(t, other, "Untaxed Prod", 1000, 10)
+		body, _ := json.Marshal(OrderCreatePayload{CustomerName: "Pricing Customer", CustomerEmail: "pricing@test.com",
+			OrderedProducts: []OrderProductPayload{{ProdID: otherProduct.ID, Count: 1}}, Region: "US-CA"})
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, createAuthenticatedRequest(t, other, "POST", "/api/create_order", bytes.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var created map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+
+		rr = httptest.NewRecorder()
+		GetOrder(rr, createAuthenticatedRequest(t, other, "GET", fmt.Sprintf("/api/get_order?id=%d", created["orderID"]), nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp OrderReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, json.Number("10.00"), resp.Total)
+		assert.Empty(t, resp.Adjustments)
+	})
+
+	t.Run("DiscountCodeUsedUp", func(t *testing.T) {
+		rr := createOrder(t, OrderCreatePayload{
+			OrderedProducts: []OrderProductPayload{{ProdID: product.ID, Count: 1}},
+			DiscountCodes:   []string{"SAVE10"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	t.Run("CancellationReprices", func(t *testing.T) {
+		tx := testDB.Begin()
+		_, err := cancelOrderItems(tx, orderID, seller.ID, []OrderCancelItem{{ProdID: product.ID, Count: 1}}, "Out of stock")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit().Error)
+
+		resp := getOrder(t, orderID)
+		assert.Equal(t, json.Number("40.00"), resp.Subtotal)
+		assert.Equal(t, json.Number("4.00"), resp.Discount)
+		assert.Equal(t, json.Number("2.97"), resp.Tax)
+		assert.Equal(t, json.Number("43.97"), resp.Total)
+
+		tx = testDB.Begin()
+		_, err = cancelOrderItems(tx, orderID, seller.ID, nil, "Out of stock")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit().Error)
+
+		resp = getOrder(t, orderID)
+		assert.Equal(t, json.Number("0.00"), resp.Total, "Nothing is charged once every item is cancelled")
+	})
+}
@@ -0,0 +1,261 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/oauth"
+	"front-runner/internal/prodtable"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errOrderNotCancellable = errors.New("order can no longer be cancelled")
+	errNotSellerItem       = errors.New("you can only cancel your own line items")
+	errItemNotInOrder      = errors.New("product is not part of this order")
+	errInvalidCancelCount  = errors.New("invalid cancel count")
+)
+
+// OrderCancellation records a quantity of a line item that was cancelled and returned to stock.
+type OrderCancellation struct {
+	ID          uint      `gorm:"primaryKey"`
+	OrderID     uint      `gorm:"not null;index"`
+	ProdID      uint      `gorm:"not null;index"`
+	VariantID   uint      `gorm:"not null;default:0"` // Variant of the product, 0 for none
+	Count       uint      `gorm:"not null"`           // Quantity cancelled and restocked
+	CancelledBy uint      `gorm:"not null"`           // User ID of the seller who cancelled
+	Reason      string    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// OrderCancelItem selects a line item (and optionally a partial quantity) to cancel.
+type OrderCancelItem struct {
+	ProdID    uint `json:"productID"`
+	VariantID uint `json:"variantID,omitempty"` // Identifies the line when the product was ordered by variant
+	Count     uint `json:"count"`               // Quantity to cancel; 0 cancels everything still outstanding on the line
+}
+
+// OrderCancelPayload is used to decode the JSON body when cancelling an order.
+type OrderCancelPayload struct {
+	Reason string            `json:"reason"` // Why the items are being cancelled
+	Items  []OrderCancelItem `json:"items"`  // Line items to cancel; empty cancels all of the seller's items
+}
+
+// OrderCancelReturn is returned to the frontend after a successful cancellation.
+type OrderCancelReturn struct {
+	OrderID        uint              `json:"orderID"`
+	OrderStatus    string            `json:"status"`         // The seller's own fulfilment status
+	OverallStatus  string            `json:"overallStatus"`  // Status of the order across all sellers
+	CancelledItems []OrderCancelItem `json:"cancelledItems"` // Quantities restocked by this request
+}
+
+// cancelOrderItems cancels the seller's line items in an order and returns their
+// quantities to product stock. It must run inside a transaction. Once all of the
+// seller's lines are cancelled their shipment moves to StatusCancelled, and the
+// order follows when every seller's shipment is cancelled.
+func cancelOrderItems(tx *gorm.DB, orderID, sellerID uint, items []OrderCancelItem, reason string) (*OrderCancelReturn, error) {
+	// Lock the order so concurrent cancellations and status changes serialise
+	var order Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+	var owner OrderOwner
+	if err := tx.Where("order_id = ? AND user_id = ?", orderID, sellerID).First(&owner).Error; err != nil {
+		return nil, err
+	}
+	shipment, err := findOrCreateShipment(tx, &owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateStatusTransition(shipment.Status, StatusCancelled); err != nil {
+		return nil, fmt.Errorf("%w (status: %s)", errOrderNotCancellable, shipment.Status)
+	}
+
+	// Fetch every line in the order along with its product's owner.
+	// The order row lock above serialises changes to these lines.
+	var lines []OrderProd
+	if err := tx.Preload("Prod").Where("order_id = ?", orderID).Order("id asc").Find(&lines).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch order lines: %w", err)
+	}
+	linesByKey := make(map[orderLineKey]*OrderProd, len(lines))
+	for i := range lines {
+		linesByKey[orderLineKey{ProdID: lines[i].ProdID, VariantID: lines[i].VariantID}] = &lines[i]
+	}
+
+	// An empty request cancels everything the seller still has outstanding
+	if len(items) == 0 {
+		for _, line := range lines {
+			if line.Prod.UserID == sellerID && line.Count > line.CancelledCount {
+				items = append(items, OrderCancelItem{ProdID: line.ProdID, VariantID: line.VariantID})
+			}
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("%w: no outstanding items to cancel", errInvalidCancelCount)
+		}
+	}
+
+	// Restock products in ID order, like every other stock change, so concurrent transactions cannot deadlock
+	items = append([]OrderCancelItem(nil), items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].ProdID < items[j].ProdID })
+
+	cancelled := make([]OrderCancelItem, 0, len(items))
+	for _, item := range items {
+		line, ok := linesByKey[orderLineKey{ProdID: item.ProdID, VariantID: item.VariantID}]
+		if !ok {
+			return nil, fmt.Errorf("%w (product ID %d)", errItemNotInOrder, item.ProdID)
+		}
+		if line.Prod.UserID != sellerID {
+			return nil, fmt.Errorf("%w (product ID %d)", errNotSellerItem, item.ProdID)
+		}
+		remaining := line.Count - line.CancelledCount
+		count := item.Count
+		if count == 0 {
+			count = remaining
+		}
+		if count == 0 || count > remaining {
+			return nil, fmt.Errorf("%w for product ID %d (requested: %d, outstanding: %d)", errInvalidCancelCount, item.ProdID, count, remaining)
+		}
+
+		// Return the quantity to stock; variant stock rolls up into the product's
+		change := prodtable.StockChange{Reason: prodtable.StockReasonCancellation, Reference: fmt.Sprintf("order:%d", orderID), UserID: sellerID, Note: reason}
+		if err := prodtable.ReturnStock(tx, item.ProdID, item.VariantID, count, change); err != nil {
+			return nil, fmt.Errorf("failed to restock product %d: %w", item.ProdID, err)
+		}
+		line.CancelledCount += count
+		if err := tx.Model(line).Update("cancelled_count", line.CancelledCount).Error; err != nil {
+			return nil, fmt.Errorf("failed to update order line for product %d: %w", item.ProdID, err)
+		}
+		record := OrderCancellation{
+			OrderID:     orderID,
+			ProdID:      item.ProdID,
+			VariantID:   item.VariantID,
+			Count:       count,
+			CancelledBy: sellerID,
+			Reason:      reason,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, fmt.Errorf("failed to record cancellation for product %d: %w", item.ProdID, err)
+		}
+		cancelled = append(cancelled, OrderCancelItem{ProdID: item.ProdID, VariantID: item.VariantID, Count: count})
+	}
+
+	// Reprice the seller's discount, tax and shipping for what is left, and cancel
+	// their shipment once nothing is left outstanding on any of their lines
+	fullyCancelled := true
+	var subtotal int64
+	for _, line := range lines {
+		if line.Prod.UserID == sellerID && line.CancelledCount < line.Count {
+			fullyCancelled = false
+			subtotal += line.CostMinor * int64(line.Count-line.CancelledCount)
+		}
+	}
+	if err := recomputeAdjustments(tx, orderID, sellerID, subtotal); err != nil {
+		return nil, err
+	}
+	if fullyCancelled {
+		if err := transitionShipmentStatus(tx, &order, shipment, StatusCancelled, sellerID, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	return &OrderCancelReturn{
+		OrderID:        order.ID,
+		OrderStatus:    shipment.Status,
+		OverallStatus:  order.OrderStatus,
+		CancelledItems: cancelled,
+	}, nil
+}
+
+// CancelOrder cancels some or all of the authenticated seller's line items in an order
+// and returns their quantities to stock in the same transaction.
+//
+// @Summary      Cancel an order (or some of its items)
+// @Description  Cancels the authenticated seller's line items in an order, restocking the cancelled quantities. With no items listed, all of the seller's outstanding items are cancelled. The seller's shipment moves to Cancelled once all of their lines are cancelled, and the order once every seller's shipment is.
+// @Tags         order
+// @Accept       json
+// @Param        id   query integer true "Order ID"
+// @Param        cancellation body OrderCancelPayload true "Reason and optional line items to cancel"
+// @Success      200  {object}  OrderCancelReturn "Items cancelled and the resulting seller and order statuses"
+// @Failure      400  {string}  string "Invalid Order ID, request body, missing reason, or invalid item quantities"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (not linked to the order, or item belongs to another seller)"
+// @Failure      404  {string}  string "Order not found or product not in order"
+// @Failure      409  {string}  string "Order can no longer be cancelled"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/cancel_order [post]
+func CancelOrder(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("CancelOrder: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	userID := user.ID
+
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+	orderID := uint(orderID64)
+
+	var payload OrderCancelPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	reason := strings.TrimSpace(payload.Reason)
+	if reason == "" {
+		http.Error(w, "A cancellation reason is required", http.StatusBadRequest)
+		return
+	}
+
+	if !checkOrderOwnership(w, orderID, userID) {
+		return
+	}
+
+	var result *OrderCancelReturn
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var errCancel error
+		result, errCancel = cancelOrderItems(tx, orderID, userID, payload.Items, reason)
+		return errCancel
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, fmt.Sprintf("Order with ID %d not found", orderID), http.StatusNotFound)
+		case errors.Is(err, errItemNotInOrder):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errNotSellerItem):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, errInvalidCancelCount):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errOrderNotCancellable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error cancelling order %d for user %d: %v", orderID, userID, err)
+			http.Error(w, "Internal server error during order cancellation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
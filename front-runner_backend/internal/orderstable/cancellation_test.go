@@ -0,0 +1,132 @@
+// internal/orderstable/cancellation_test.go
+package orderstable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/prodtable"
+	"front-runner/internal/usertable"
+)
+
+func TestCancelOrder(t *testing.T) {
+	setupTestEnvironment(t)
+	seller1 := createTestUser(t, "cancelseller1@example.com", "password")
+	seller2 := createTestUser(t, "cancelseller2@example.com", "password")
+	productS1 := createTestProduct(t, seller1, "Cancel S1 Prod", 1000, 5)
+	productS2 := createTestProduct(t, seller2, "Cancel S2 Prod", 2000, 5)
+
+	order := createTestOrder(t, "Cancel Cust", "cancel@test.com", map[*prodtable.Product]uint{
+		productS1: 3,
+		productS2: 2,
+	})
+
+	cancel := func(t *testing.T, user *usertable.User, payload OrderCancelPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		url := fmt.Sprintf("/api/cancel_order?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, user, "POST", url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		CancelOrder(rr, req)
+		return rr
+	}
+	stockOf := func(t *testing.T, product *prodtable.Product) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, product.ID).Error)
+		return p.ProdCount
+	}
+
+	t.Run("MissingReason", func(t *testing.T) {
+		rr := cancel(t, seller1, OrderCancelPayload{})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("PartialLineItem", func(t *testing.T) {
+		rr := cancel(t, seller1, OrderCancelPayload{
+			Reason: "Customer changed mind",
+			Items:  []OrderCancelItem{{ProdID: productS1.ID, Count: 1}},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		var resp OrderCancelReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, StatusPending, resp.OrderStatus, "Order should stay open while items remain")
+		require.Len(t, resp.CancelledItems, 1)
+		assert.Equal(t, uint(1), resp.CancelledItems[0].Count)
+
+		assert.Equal(t, uint(6), stockOf(t, productS1), "Cancelled quantity should be restocked")
+
+		var line OrderProd
+		require.NoError(t, testDB.Where("order_id = ? AND prod_id = ?", order.ID, productS1.ID).First(&line).Error)
+		assert.Equal(t, uint(1), line.CancelledCount)
+
+		var record OrderCancellation
+		require.NoError(t, testDB.Where("order_id = ? AND prod_id = ?", order.ID, productS1.ID).First(&record).Error)
+		assert.Equal(t, "Customer changed mind", record.Reason)
+		assert.Equal(t, seller1.ID, record.CancelledBy)
+	})
+
+	t.Run("OtherSellersItem", func(t *testing.T) {
+		rr := cancel(t, seller1, OrderCancelPayload{
+			Reason: "Not mine",
+			Items:  []OrderCancelItem{{ProdID: productS2.ID}},
+		})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, uint(5), stockOf(t, productS2), "Other seller's stock must not change")
+	})
+
+	t.Run("TooMany", func(t *testing.T) {
+		rr := cancel(t, seller1, OrderCancelPayload{
+			Reason: "Too many",
+			Items:  []OrderCancelItem{{ProdID: productS1.ID, Count: 5}},
+		})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("FullCancellationAcrossSellers", func(t *testing.T) {
+		rr := cancel(t, seller1, OrderCancelPayload{Reason: "Out of business"})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(8), stockOf(t, productS1))
+
+		var resp OrderCancelReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, StatusCancelled, resp.OrderStatus, "Seller 1's shipment should be cancelled")
+		assert.Equal(t, StatusPending, resp.OverallStatus, "Seller 2 still has items outstanding")
+
+		rr = cancel(t, seller2, OrderCancelPayload{Reason: "Customer request"})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, StatusCancelled, resp.OrderStatus)
+		assert.Equal(t, StatusCancelled, resp.OverallStatus)
+		assert.Equal(t, uint(7), stockOf(t, productS2))
+
+		var updated Order
+		require.NoError(t, testDB.First(&updated, order.ID).Error)
+		assert.Equal(t, StatusCancelled, updated.OrderStatus)
+
+		var history OrderStatusHistory
+		require.NoError(t, testDB.Where("order_id = ? AND changed_by = ?", order.ID, seller2.ID).First(&history).Error)
+		assert.Equal(t, StatusCancelled, history.ToStatus)
+		assert.Equal(t, "Customer request", history.Note)
+	})
+
+	t.Run("NotCancellableOnceShipped", func(t *testing.T) {
+		shipped := createTestOrder(t, "Shipped Cust", "shipped@test.com", map[*prodtable.Product]uint{productS1: 1})
+		require.NoError(t, testDB.Model(&Order{}).Where("id = ?", shipped.ID).Update("order_status", StatusShipped).Error)
+
+		body, _ := json.Marshal(OrderCancelPayload{Reason: "Too late"})
+		url := fmt.Sprintf("/api/cancel_order?id=%d", shipped.ID)
+		req := createAuthenticatedRequest(t, seller1, "POST", url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		CancelOrder(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+}
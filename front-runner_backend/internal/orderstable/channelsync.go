@@ -0,0 +1,460 @@
+package orderstable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/connectors"
+	"front-runner/internal/oauth"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/storefronttable"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultChannelSyncInterval = 5 * time.Minute  // Overridden by CHANNEL_SYNC_MINUTES
+	channelSyncLease           = 10 * time.Minute // How long a sync may hold a link before another may take over
+	maxSyncErrorLength         = 1000
+	maxListedOrderFailures     = 50 // Failed orders listed per link by GetChannelSyncStatus
+)
+
+// Channel sync statuses reported by GetChannelSyncStatus.
+const (
+	SyncStatusNever = "never" // Not attempted yet
+	SyncStatusOK    = "ok"
+	SyncStatusError = "error" // The last attempt failed, see LastError
+)
+
+// externalOrderIndex keeps a marketplace order from being imported twice from one storefront link.
+const externalOrderIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_channel_external ON orders (channel_id, external_id) WHERE external_id <> ''`
+
+// channelOrderFailureIndex keeps one failure per marketplace order of a storefront link.
+const channelOrderFailureIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_channel_order_failures_order ON channel_order_failures (link_id, external_id)`
+
+// channelSyncInterval is how often StartChannelSync pulls marketplace orders.
+var channelSyncInterval = defaultChannelSyncInterval
+
+var (
+	errCurrencyMismatch = errors.New("order currency does not match the products' currency")
+	errOrderNoID        = errors.New("marketplace order has no ID")
+)
+
+// ChannelSync tracks importing the orders of one storefront link's marketplace.
+type ChannelSync struct {
+	LinkID        uint       `gorm:"primaryKey;autoIncrement:false"` // StorefrontLink ID
+	UserID        uint       `gorm:"not null;index"`                 // Seller owning the link
+	Cursor        time.Time  `gorm:"not null"`                       // Orders updated on the marketplace before this are imported
+	LastAttemptAt *time.Time // When the last sync ran
+	LastSuccessAt *time.Time // When a sync last ran without errors
+	LastError     string     `gorm:"not null;default:''"` // Why the last sync failed, "" if it succeeded
+	Imported      int64      `gorm:"not null;default:0"`  // Orders imported since the link was created
+	LeaseUntil    *time.Time // Set while a sync runs, so the link is not synced twice at once
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`
+}
+
+// ChannelOrderFailure is a marketplace order a sync could not import because of the order itself,
+// such as selling stock the seller no longer has. The sync moves past it, and it is removed once
+// the order is imported or cancelled on the marketplace.
+type ChannelOrderFailure struct {
+	ID         uint      `gorm:"primaryKey"`
+	LinkID     uint      `gorm:"not null"`       // StorefrontLink ID
+	UserID     uint      `gorm:"not null;index"` // Seller owning the link
+	ExternalID string    `gorm:"not null"`       // The marketplace's ID of the order
+	Number     string    // The marketplace's order number, as the seller knows it
+	Error      string    `gorm:"not null"`
+	FailedAt   time.Time `gorm:"not null"` // When the order last failed to import
+}
+
+// ChannelOrderFailureReturn is a marketplace order that could not be imported, returned to the frontend.
+type ChannelOrderFailureReturn struct {
+	ExternalID string `json:"externalID"`
+	Number     string `json:"number"`
+	Error      string `json:"error"`
+	FailedAt   string `json:"failedAt"` // Formatted date string
+}
+
+// ChannelSyncStatusReturn is returned to the frontend for each storefront link whose orders are imported.
+type ChannelSyncStatusReturn struct {
+	LinkID         uint                        `json:"linkID"`
+	StoreType      string                      `json:"storeType"`
+	StoreName      string                      `json:"storeName"`
+	Status         string                      `json:"status"`                  // "never", "ok" or "error"
+	LastAttemptAt  string                      `json:"lastAttemptAt,omitempty"` // Formatted date string
+	LastSuccessAt  string                      `json:"lastSuccessAt,omitempty"` // Formatted date string
+	LastError      string                      `json:"lastError,omitempty"`     // Why the last sync failed
+	SyncedThrough  string                      `json:"syncedThrough"`           // Orders updated on the marketplace before this time are imported
+	ImportedOrders int64                       `json:"importedOrders"`
+	FailedOrders   []ChannelOrderFailureReturn `json:"failedOrders"` // Orders that could not be imported, most recent first
+}
+
+// channelSyncIntervalFromEnv reads how often to pull marketplace orders from CHANNEL_SYNC_MINUTES.
+func channelSyncIntervalFromEnv() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("CHANNEL_SYNC_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return defaultChannelSyncInterval
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// importMarketplaceOrder records an order pulled from a storefront link's marketplace, taking its
+// stock, and reports whether it created one. It must run inside a transaction. Lines are matched to
+// the seller's products and variants by SKU; lines with other SKUs are left to the marketplace, and
+// orders with none of the seller's SKUs are skipped. An order already imported is not imported again,
+// but is cancelled if it was cancelled on the marketplace since.
+func importMarketplaceOrder(tx *gorm.DB, link *storefronttable.StorefrontLink, external connectors.Order) (bool, error) {
+	if external.ExternalID == "" {
+		return false, errOrderNoID
+	}
+	var existing Order
+	err := tx.Where("channel_id = ? AND external_id = ?", link.ID, external.ExternalID).Take(&existing).Error
+	if err == nil {
+		if external.CancelledAt != nil && existing.OrderStatus != StatusCancelled {
+			return false, cancelImportedOrder(tx, link, &existing)
+		}
+		return false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	// Orders placed before the storefront was linked, or cancelled before they were pulled, are not imported
+	if external.CreatedAt.Before(link.CreatedAt) || external.CancelledAt != nil {
+		return false, clearOrderFailure(tx, link.ID, external.ExternalID)
+	}
+
+	items := make([]OrderProductPayload, 0, len(external.Lines))
+	prices := make(map[orderLineKey]int64, len(external.Lines))
+	for _, line := range external.Lines {
+		if line.Quantity == 0 {
+			continue
+		}
+		productID, variantID, err := prodtable.FindSKU(tx, link.UserID, line.SKU)
+		if errors.Is(err, prodtable.ErrSKUNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		items = append(items, OrderProductPayload{ProdID: productID, VariantID: variantID, Count: line.Quantity})
+		key := orderLineKey{ProdID: productID, VariantID: variantID}
+		if _, ok := prices[key]; !ok {
+			prices[key] = line.PriceMinor
+		}
+	}
+	if len(items) == 0 {
+		return false, nil
+	}
+	ordered, err := resolveOrderLines(tx, items, link.UserID)
+	if err != nil {
+		return false, err
+	}
+	if external.Currency != "" && !strings.EqualFold(external.Currency, ordered.Currency) {
+		return false, fmt.Errorf("%w (%s, not %s)", errCurrencyMismatch, external.Currency, ordered.Currency)
+	}
+
+	// Tax, shipping and discounts were charged by the marketplace, so the order has no adjustments
+	order := Order{
+		CustomerName:  external.CustomerName,
+		CustomerEmail: external.CustomerEmail,
+		OrderDate:     external.CreatedAt,
+		OrderStatus:   StatusPending,
+		Currency:      ordered.Currency,
+		Source:        OrderSourceMarketplace,
+		ChannelID:     link.ID,
+		ExternalID:    external.ExternalID,
+		CreatedBy:     link.UserID,
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		log.Printf("Error creating order record for %s order %s: %v", link.StoreType, external.ExternalID, err)
+		return false, errors.New("failed to create order record")
+	}
+	change := prodtable.StockChange{
+		Reason:    prodtable.StockReasonSale,
+		Reference: fmt.Sprintf("order:%d", order.ID),
+		UserID:    link.UserID,
+		Note:      fmt.Sprintf("%s order %s", link.StoreType, external.Number),
+	}
+	if err := ordered.takeStock(tx, change); err != nil {
+		return false, err
+	}
+	if err := linkSeller(tx, order.ID, link.UserID); err != nil {
+		return false, err
+	}
+	if err := ordered.createOrderProds(tx, order.ID, prices); err != nil {
+		return false, err
+	}
+	return true, clearOrderFailure(tx, link.ID, external.ExternalID)
+}
+
+// orderRejected reports whether importing a marketplace order failed because of the order itself,
+// such as selling stock the seller no longer has or a currency their products are not priced in.
+// Importing it again would fail the same way. Other failures, such as database errors, may not.
+func orderRejected(err error) bool {
+	return errors.Is(err, errCurrencyMismatch) || errors.Is(err, errOrderNoID) || errors.Is(err, errInvalidOrderLine) ||
+		errors.Is(err, errNotClientProduct) || errors.Is(err, prodtable.ErrInsufficientStock)
+}
+
+// recordOrderFailure records that a marketplace order could not be imported, replacing the error
+// of an earlier attempt.
+func recordOrderFailure(link *storefronttable.StorefrontLink, external connectors.Order, importErr error, now time.Time) error {
+	message := importErr.Error()
+	if len(message) > maxSyncErrorLength {
+		message = message[:maxSyncErrorLength]
+	}
+	failure := ChannelOrderFailure{LinkID: link.ID, UserID: link.UserID, ExternalID: external.ExternalID, Number: external.Number,
+		Error: message, FailedAt: now}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "link_id"}, {Name: "external_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"number", "error", "failed_at"}),
+	}).Create(&failure).Error
+}
+
+// clearOrderFailure removes the recorded failure of a marketplace order, if there is one.
+func clearOrderFailure(tx *gorm.DB, linkID uint, externalID string) error {
+	return tx.Where("link_id = ? AND external_id = ?", linkID, externalID).Delete(&ChannelOrderFailure{}).Error
+}
+
+// cancelImportedOrder cancels the outstanding items of an imported order that was cancelled on
+// its marketplace, returning them to stock. Orders the seller already shipped are left alone.
+func cancelImportedOrder(tx *gorm.DB, link *storefronttable.StorefrontLink, order *Order) error {
+	_, err := cancelOrderItems(tx, order.ID, link.UserID, nil, "Cancelled on "+link.StoreType)
+	if errors.Is(err, errOrderNotCancellable) || errors.Is(err, errInvalidCancelCount) {
+		log.Printf("Channel sync: order %d was cancelled on %s but cannot be cancelled here: %v", order.ID, link.StoreType, err)
+		return nil
+	}
+	return err
+}
+
+// pullChannelOrders imports the link's marketplace orders updated since the given time. It returns
+// the number of orders imported, how far the link's cursor may advance, and the first error.
+// Orders rejected for themselves (see orderRejected) are recorded as ChannelOrderFailures and
+// passed over. Any other failure stops the cursor at its order, so it is retried by the next
+// sync; orders after it that were imported are skipped as duplicates when pulled again.
+func pullChannelOrders(ctx context.Context, link *storefronttable.StorefrontLink, since, now time.Time) (int, time.Time, error) {
+	cursor := since
+	conn, err := link.Connector()
+	if err != nil {
+		return 0, cursor, err
+	}
+	orders, err := conn.PullOrders(ctx, since)
+	if err != nil {
+		return 0, cursor, err
+	}
+
+	imported := 0
+	var firstErr error
+	held := false // Whether an order failed in a way the next sync may not
+	for _, external := range orders {
+		if err := ctx.Err(); err != nil {
+			return imported, cursor, err
+		}
+		// Each order is imported in its own transaction, so one bad order does not hold up the rest
+		created := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			created, err = importMarketplaceOrder(tx, link, external)
+			return err
+		})
+		if err != nil {
+			err = fmt.Errorf("order %s: %w", external.Number, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			if !orderRejected(err) {
+				held = true
+				continue
+			}
+			if errRecord := recordOrderFailure(link, external, err, now); errRecord != nil {
+				log.Printf("Channel sync: failed to record the failure of %s order %s: %v", link.StoreType, external.ExternalID, errRecord)
+				held = true
+				continue
+			}
+		} else if created {
+			imported++
+		}
+		if !held && external.UpdatedAt.After(cursor) {
+			cursor = external.UpdatedAt
+		}
+	}
+	return imported, cursor, firstErr
+}
+
+// syncChannel imports the new marketplace orders of a storefront link and records the outcome
+// in its ChannelSync. It reports how many orders were imported, and does nothing if the link
+// is already being synced.
+func syncChannel(ctx context.Context, link *storefronttable.StorefrontLink, now time.Time) (int, error) {
+	// New links are synced from when they were created
+	state := ChannelSync{LinkID: link.ID, UserID: link.UserID, Cursor: link.CreatedAt}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return 0, err
+	}
+	claim := db.Model(&ChannelSync{}).Where("link_id = ? AND (lease_until IS NULL OR lease_until < ?)", link.ID, now).
+		Update("lease_until", now.Add(channelSyncLease))
+	if claim.Error != nil {
+		return 0, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return 0, nil
+	}
+	if err := db.First(&state, link.ID).Error; err != nil {
+		return 0, err
+	}
+
+	imported, cursor, syncErr := pullChannelOrders(ctx, link, state.Cursor, now)
+	updates := map[string]interface{}{
+		"cursor":          cursor,
+		"last_attempt_at": now,
+		"last_error":      "",
+		"imported":        gorm.Expr("imported + ?", imported),
+		"lease_until":     nil,
+	}
+	if syncErr != nil {
+		message := syncErr.Error()
+		if len(message) > maxSyncErrorLength {
+			message = message[:maxSyncErrorLength]
+		}
+		updates["last_error"] = message
+	} else {
+		updates["last_success_at"] = now
+	}
+	if err := db.Model(&ChannelSync{}).Where("link_id = ?", link.ID).Updates(updates).Error; err != nil {
+		return imported, err
+	}
+	return imported, syncErr
+}
+
+// SyncChannels imports new orders from every storefront link whose marketplace has a connector,
+// and reports how many were imported. A link failing to sync does not stop the others; its
+// error is recorded in its ChannelSync.
+func SyncChannels(ctx context.Context, now time.Time) (int, error) {
+	var links []storefronttable.StorefrontLink
+	if err := db.Where("LOWER(store_type) IN ?", connectors.StoreTypes()).Order("id").Find(&links).Error; err != nil {
+		return 0, err
+	}
+	imported := 0
+	for i := range links {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+		n, err := syncChannel(ctx, &links[i], now)
+		if err != nil {
+			log.Printf("Channel sync: storefront link %d: %v", links[i].ID, err)
+		}
+		imported += n
+	}
+	return imported, nil
+}
+
+// StartChannelSync imports marketplace orders in the background every CHANNEL_SYNC_MINUTES.
+func StartChannelSync() {
+	go func() {
+		ticker := time.NewTicker(channelSyncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			imported, err := SyncChannels(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("Channel sync: %v", err)
+			}
+			if imported > 0 {
+				log.Printf("Channel sync: imported %d marketplace orders", imported)
+			}
+		}
+	}()
+}
+
+// GetChannelSyncStatus reports how importing orders from each of the user's storefront links is going.
+//
+// @Summary      Get marketplace order sync status
+// @Description  Orders placed on the marketplaces of linked storefronts with a connector (such as "shopify") are imported every few minutes. Their lines are matched to the seller's products and variants by SKU, and imported orders have source "marketplace" and the marketplace's externalID. Orders that cannot be imported because of the order itself, such as selling more than the stock left, are listed as failedOrders and passed over; they are imported if the marketplace updates them later. Other failures are retried by the next sync. Returns, for each such storefront link of the user, when it last synced, the last error, how many orders have been imported, and the most recent failed orders (at most 50).
+// @Tags         order
+// @Produce      json
+// @Success      200  {array}   ChannelSyncStatusReturn "Sync status of each storefront link with a connector"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_channel_sync_status [get]
+func GetChannelSyncStatus(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetChannelSyncStatus: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var links []storefronttable.StorefrontLink
+	if err := db.Where("user_id = ? AND LOWER(store_type) IN ?", user.ID, connectors.StoreTypes()).Order("id").Find(&links).Error; err != nil {
+		log.Printf("Error fetching storefront links of user %d: %v", user.ID, err)
+		http.Error(w, "Database error fetching storefront links", http.StatusInternalServerError)
+		return
+	}
+	var syncs []ChannelSync
+	if err := db.Where("user_id = ?", user.ID).Find(&syncs).Error; err != nil {
+		log.Printf("Error fetching channel syncs of user %d: %v", user.ID, err)
+		http.Error(w, "Database error fetching sync status", http.StatusInternalServerError)
+		return
+	}
+	syncByLink := make(map[uint]ChannelSync, len(syncs))
+	for _, s := range syncs {
+		syncByLink[s.LinkID] = s
+	}
+	var failures []ChannelOrderFailure
+	if err := db.Where("user_id = ?", user.ID).Order("failed_at DESC, id DESC").Find(&failures).Error; err != nil {
+		log.Printf("Error fetching channel order failures of user %d: %v", user.ID, err)
+		http.Error(w, "Database error fetching sync status", http.StatusInternalServerError)
+		return
+	}
+	failuresByLink := make(map[uint][]ChannelOrderFailureReturn)
+	for _, f := range failures {
+		if len(failuresByLink[f.LinkID]) < maxListedOrderFailures {
+			failuresByLink[f.LinkID] = append(failuresByLink[f.LinkID], ChannelOrderFailureReturn{
+				ExternalID: f.ExternalID,
+				Number:     f.Number,
+				Error:      f.Error,
+				FailedAt:   f.FailedAt.Format(time.RFC3339),
+			})
+		}
+	}
+
+	statuses := make([]ChannelSyncStatusReturn, 0, len(links))
+	for _, link := range links {
+		status := ChannelSyncStatusReturn{
+			LinkID:        link.ID,
+			StoreType:     link.StoreType,
+			StoreName:     link.StoreName,
+			Status:        SyncStatusNever,
+			SyncedThrough: link.CreatedAt.Format(time.RFC3339),
+			FailedOrders:  failuresByLink[link.ID],
+		}
+		if status.FailedOrders == nil {
+			status.FailedOrders = []ChannelOrderFailureReturn{}
+		}
+		if s, ok := syncByLink[link.ID]; ok && s.LastAttemptAt != nil {
+			status.Status = SyncStatusOK
+			if s.LastError != "" {
+				status.Status = SyncStatusError
+			}
+			status.LastAttemptAt = formatOptionalTime(s.LastAttemptAt)
+			status.LastSuccessAt = formatOptionalTime(s.LastSuccessAt)
+			status.LastError = s.LastError
+			status.SyncedThrough = s.Cursor.Format(time.RFC3339)
+			status.ImportedOrders = s.Imported
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statuses)
+}
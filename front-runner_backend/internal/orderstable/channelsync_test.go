@@ -0,0 +1,225 @@
+// internal/orderstable/channelsync_test.go
+package orderstable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/connectors"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/storefronttable"
+)
+
+// fakeMarketOrders and fakeMarketErr are what links of the "fakemarket" store type pull.
+var (
+	fakeMarketOrders []connectors.Order
+	fakeMarketErr    error
+)
+
+// fakeMarket is a connector serving fakeMarketOrders.
+type fakeMarket struct{}
+
+func (fakeMarket) ListListings(context.Context) ([]connectors.Listing, error) { return nil, nil }
+
+func (fakeMarket) PushListing(_ context.Context, l connectors.Listing) (connectors.Listing, error) {
+	return l, nil
+}
+
+func (fakeMarket) PullOrders(_ context.Context, since time.Time) ([]connectors.Order, error) {
+	if fakeMarketErr != nil {
+		return nil, fakeMarketErr
+	}
+	var orders []connectors.Order
+	for _, o := range fakeMarketOrders {
+		if !o.UpdatedAt.Before(since) {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+func (fakeMarket) UpdateInventory(context.Context, connectors.InventoryUpdate) error { return nil }
+
+func init() {
+	connectors.Register("fakemarket", func(connectors.Config) (connectors.Connector, error) { return fakeMarket{}, nil })
+}
+
+func TestChannelSyncIntervalFromEnv(t *testing.T) {
+	t.Setenv("CHANNEL_SYNC_MINUTES", "")
+	assert.Equal(t, defaultChannelSyncInterval, channelSyncIntervalFromEnv())
+	t.Setenv("CHANNEL_SYNC_MINUTES", "15")
+	assert.Equal(t, 15*time.Minute, channelSyncIntervalFromEnv())
+	t.Setenv("CHANNEL_SYNC_MINUTES", "-1")
+	assert.Equal(t, defaultChannelSyncInterval, channelSyncIntervalFromEnv())
+}
+
+func TestOrderRejected(t *testing.T) {
+	assert.True(t, orderRejected(fmt.Errorf("order #1: %w", errCurrencyMismatch)))
+	assert.True(t, orderRejected(fmt.Errorf("order #1: %w", prodtable.ErrInsufficientStock)))
+	assert.True(t, orderRejected(fmt.Errorf("%w: invalid variant: product ID 3 requires a variantID", errInvalidOrderLine)))
+	assert.False(t, orderRejected(errors.New("failed to create order record")), "Database errors are retried")
+}
+
+// TestChannelSync tests importing marketplace orders, deduplicating and cancelling them, and the sync status.
+func TestChannelSync(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "syncseller@example.com", "password")
+	other := createTestUser(t, "syncother@example.com", "password")
+	mug := createTestProduct(t, seller, "Sync Mug", 950, 10)
+	require.NoError(t, testDB.Model(mug).Update("sku", "MUG").Error)
+	tee := createTestProduct(t, seller, "Sync Tee", 1500, 0)
+	require.NoError(t, testDB.Create(&prodtable.ProductOption{ProductID: tee.ID, Name: "Size", Values: []string{"M"}}).Error)
+	medium := prodtable.ProductVariant{ProductID: tee.ID, UserID: seller.ID, SKU: "TEE-M", Options: map[string]string{"Size": "M"},
+		OptionsKey: "size=m", Title: "M", PriceMinor: 1600, Count: 2}
+	require.NoError(t, testDB.Create(&medium).Error)
+	require.NoError(t, prodtable.SyncVariantStock(testDB, tee.ID))
+
+	link := storefronttable.StorefrontLink{UserID: seller.ID, StoreType: "fakemarket", StoreName: "Market"}
+	require.NoError(t, testDB.Create(&link).Error)
+	start := link.CreatedAt
+	defer func() { fakeMarketOrders, fakeMarketErr = nil, nil }()
+
+	stock := func(t *testing.T) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, mug.ID).Error)
+		return p.ProdCount
+	}
+	sync := func(t *testing.T) int {
+		t.Helper()
+		imported, err := SyncChannels(context.Background(), time.Now())
+		require.NoError(t, err)
+		return imported
+	}
+	syncStatus := func(t *testing.T) ChannelSyncStatusReturn {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetChannelSyncStatus(rr, createAuthenticatedRequest(t, seller, "GET", "/api/get_channel_sync_status", nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var statuses []ChannelSyncStatusReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+		require.Len(t, statuses, 1)
+		return statuses[0]
+	}
+	marketOrder := func(id string, at time.Time, lines ...connectors.OrderLine) connectors.Order {
+		return connectors.Order{ExternalID: id, Number: "#" + id, Currency: "USD", CustomerName: "Market Cust",
+			CustomerEmail: "market@test.com", CreatedAt: at, UpdatedAt: at, Lines: lines}
+	}
+
+	assert.Equal(t, SyncStatusNever, syncStatus(t).Status)
+
+	fakeMarketOrders = []connectors.Order{
+		marketOrder("1001", start.Add(time.Minute),
+			connectors.OrderLine{SKU: "MUG", Quantity: 2, PriceMinor: 900},
+			connectors.OrderLine{SKU: "GIFT-WRAP", Quantity: 1, PriceMinor: 200}),
+		marketOrder("1002", start.Add(2*time.Minute), connectors.OrderLine{SKU: "TEE-M", Quantity: 1, PriceMinor: 1600}),
+		marketOrder("1003", start.Add(-time.Hour), connectors.OrderLine{SKU: "MUG", Quantity: 1, PriceMinor: 950}),
+		marketOrder("1004", start.Add(3*time.Minute), connectors.OrderLine{SKU: "ELSEWHERE", Quantity: 1, PriceMinor: 500}),
+	}
+	fakeMarketOrders[2].UpdatedAt = start.Add(3 * time.Minute)
+
+	t.Run("ImportsNewOrders", func(t *testing.T) {
+		assert.Equal(t, 2, sync(t), "Orders from before the link, and without the seller's SKUs, are skipped")
+		assert.Equal(t, uint(8), stock(t))
+
+		var order Order
+		require.NoError(t, testDB.Preload("OrderProds").Where("channel_id = ? AND external_id = ?", link.ID, "1001").First(&order).Error)
+		assert.Equal(t, OrderSourceMarketplace, order.Source)
+		assert.Equal(t, seller.ID, order.CreatedBy)
+		assert.True(t, order.OrderDate.Equal(start.Add(time.Minute)), "The order is dated when it was placed")
+		require.Len(t, order.OrderProds, 1, "Lines the seller does not sell here are left out")
+		assert.Equal(t, uint(2), order.OrderProds[0].Count)
+		assert.Equal(t, int64(900), order.OrderProds[0].CostMinor, "Lines cost what they sold for on the marketplace")
+
+		require.NoError(t, testDB.Preload("OrderProds").Where("channel_id = ? AND external_id = ?", link.ID, "1002").First(&order).Error)
+		require.Len(t, order.OrderProds, 1)
+		assert.Equal(t, medium.ID, order.OrderProds[0].VariantID)
+
+		var owners int64
+		require.NoError(t, testDB.Model(&OrderOwner{}).Where("order_id = ? AND user_id = ?", order.ID, seller.ID).Count(&owners).Error)
+		assert.Equal(t, int64(1), owners)
+
+		status := syncStatus(t)
+		assert.Equal(t, SyncStatusOK, status.Status)
+		assert.Equal(t, int64(2), status.ImportedOrders)
+		assert.Empty(t, status.LastError)
+	})
+
+	t.Run("Deduplicates", func(t *testing.T) {
+		require.NoError(t, testDB.Model(&ChannelSync{}).Where("link_id = ?", link.ID).Update("cursor", start).Error)
+		assert.Equal(t, 0, sync(t))
+		assert.Equal(t, uint(8), stock(t))
+	})
+
+	t.Run("CancelledOnMarketplace", func(t *testing.T) {
+		cancelledAt := start.Add(5 * time.Minute)
+		fakeMarketOrders[0].CancelledAt = &cancelledAt
+		fakeMarketOrders[0].UpdatedAt = cancelledAt
+		assert.Equal(t, 0, sync(t))
+		assert.Equal(t, uint(10), stock(t), "The cancelled order's stock is returned")
+
+		var order Order
+		require.NoError(t, testDB.Where("channel_id = ? AND external_id = ?", link.ID, "1001").First(&order).Error)
+		assert.Equal(t, StatusCancelled, order.OrderStatus)
+	})
+
+	t.Run("RejectedOrdersAreRecorded", func(t *testing.T) {
+		fakeMarketOrders = append(fakeMarketOrders,
+			marketOrder("1005", start.Add(6*time.Minute), connectors.OrderLine{SKU: "MUG", Quantity: 11, PriceMinor: 950}),
+			marketOrder("1006", start.Add(7*time.Minute), connectors.OrderLine{SKU: "MUG", Quantity: 1, PriceMinor: 950}))
+		assert.Equal(t, 1, sync(t), "A failed order does not hold up the orders after it")
+		assert.Equal(t, uint(9), stock(t))
+		status := syncStatus(t)
+		assert.Equal(t, SyncStatusError, status.Status)
+		assert.Contains(t, status.LastError, "#1005")
+		syncedThrough, err := time.Parse(time.RFC3339, status.SyncedThrough)
+		require.NoError(t, err)
+		assert.True(t, syncedThrough.Equal(start.Add(7*time.Minute).Truncate(time.Second)), "The cursor moves past an order that cannot be imported")
+		require.Len(t, status.FailedOrders, 1)
+		assert.Equal(t, "1005", status.FailedOrders[0].ExternalID)
+		assert.Equal(t, "#1005", status.FailedOrders[0].Number)
+		assert.Contains(t, status.FailedOrders[0].Error, "insufficient stock")
+
+		// The next sync succeeds, and the failed order stays listed
+		assert.Equal(t, 0, sync(t))
+		status = syncStatus(t)
+		assert.Equal(t, SyncStatusOK, status.Status)
+		assert.Empty(t, status.LastError)
+		assert.Len(t, status.FailedOrders, 1)
+
+		// Once the marketplace updates the order it is pulled and imported again
+		fakeMarketOrders[4].Lines[0].Quantity = 1
+		fakeMarketOrders[4].UpdatedAt = start.Add(8 * time.Minute)
+		assert.Equal(t, 1, sync(t))
+		assert.Equal(t, uint(8), stock(t))
+		status = syncStatus(t)
+		assert.Equal(t, SyncStatusOK, status.Status)
+		assert.Equal(t, int64(4), status.ImportedOrders)
+		assert.Empty(t, status.FailedOrders)
+	})
+
+	t.Run("MarketplaceErrors", func(t *testing.T) {
+		fakeMarketErr = errors.New("marketplace unavailable")
+		defer func() { fakeMarketErr = nil }()
+		assert.Equal(t, 0, sync(t))
+		status := syncStatus(t)
+		assert.Equal(t, SyncStatusError, status.Status)
+		assert.Equal(t, "marketplace unavailable", status.LastError)
+	})
+
+	t.Run("OnlyOwnLinks", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GetChannelSyncStatus(rr, createAuthenticatedRequest(t, other, "GET", "/api/get_channel_sync_status", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `[]`, rr.Body.String())
+	})
+}
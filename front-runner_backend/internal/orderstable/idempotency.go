@@ -0,0 +1,83 @@
+package orderstable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyHeader names the request header that makes CreateOrder safe to retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the keys clients may send.
+const maxIdempotencyKeyLen = 255
+
+var (
+	errInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	errIdempotencyKeyReused  = errors.New("idempotency key was already used with a different request")
+)
+
+// OrderIdempotencyKey records the outcome of a CreateOrder request sent with an Idempotency-Key,
+// so that a retry of the same request returns the original response instead of a second order.
+// Only successful requests are recorded: the row is written in the order's transaction.
+// Keys are scoped to the client that sent them.
+type OrderIdempotencyKey struct {
+	ID             uint   `gorm:"primaryKey"`
+	ClientKey      string `gorm:"size:64;not null;default:'';index:idx_idempotency_client_key,unique"` // See orderClient.key
+	IdempotencyKey string `gorm:"size:255;not null;index:idx_idempotency_client_key,unique"`
+	RequestHash    string `gorm:"size:64;not null"` // SHA-256 of the canonical request payload
+	OrderID        uint   `gorm:"not null;default:0;index"`
+	ResponseCode   int    `gorm:"not null;default:0"`
+	ResponseBody   string `gorm:"type:text;not null;default:''"`
+	CreatedAt      time.Time
+}
+
+// hashOrderPayload hashes the decoded payload, so retries that only differ in whitespace or
+// field order are recognised as the same request.
+func hashOrderPayload(payload OrderCreatePayload) (string, error) {
+	canonical, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey reserves key for this request inside the order's transaction. If the key
+// was already used it returns the earlier record instead, or errIdempotencyKeyReused when that
+// record belongs to a different request. A concurrent request with the same key waits on the
+// unique index until the first one commits or rolls back.
+func claimIdempotencyKey(tx *gorm.DB, clientKey, key, requestHash string) (*OrderIdempotencyKey, error) {
+	record := OrderIdempotencyKey{ClientKey: clientKey, IdempotencyKey: key, RequestHash: requestHash}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to record idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing OrderIdempotencyKey
+	if err := tx.Where("client_key = ? AND idempotency_key = ?", clientKey, key).First(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch idempotency key: %w", err)
+	}
+	if existing.RequestHash != requestHash {
+		return nil, errIdempotencyKeyReused
+	}
+	return &existing, nil
+}
+
+// completeIdempotencyKey stores the response to replay for the client's key.
+func completeIdempotencyKey(tx *gorm.DB, clientKey, key string, orderID uint, code int, body []byte) error {
+	return tx.Model(&OrderIdempotencyKey{}).Where("client_key = ? AND idempotency_key = ?", clientKey, key).Updates(map[string]interface{}{
+		"order_id":      orderID,
+		"response_code": code,
+		"response_body": string(body),
+	}).Error
+}
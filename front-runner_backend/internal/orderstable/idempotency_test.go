@@ -0,0 +1,88 @@
+// internal/orderstable/idempotency_test.go
+package orderstable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/prodtable"
+)
+
+// TestCreateOrder_Idempotency tests that retries with the same Idempotency-Key do not create duplicate orders.
+func TestCreateOrder_Idempotency(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "idemseller@example.com", "password")
+	product := createTestProduct(t, seller, "Idempotent Prod", 1000, 10)
+
+	createOrder := func(t *testing.T, key string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, req)
+		return rr
+	}
+	stock := func(t *testing.T) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, product.ID).Error)
+		return p.ProdCount
+	}
+	body := fmt.Sprintf(`{"customerName": "Retry Cust", "customerEmail": "retry@test.com", "orderedProducts": [{"productID": %d, "count": 2}]}`, product.ID)
+
+	rr := createOrder(t, "webhook-123", body)
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	var first map[string]uint
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &first))
+	assert.Equal(t, uint(8), stock(t))
+
+	t.Run("ReplayReturnsOriginalOrder", func(t *testing.T) {
+		// Same payload, different formatting
+		var compact bytes.Buffer
+		require.NoError(t, json.Compact(&compact, []byte(body)))
+		rr := createOrder(t, "webhook-123", compact.String())
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+		var replayed map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &replayed))
+		assert.Equal(t, first["orderID"], replayed["orderID"])
+		assert.Equal(t, uint(8), stock(t), "Stock is only decremented once")
+
+		var orders int64
+		require.NoError(t, testDB.Model(&Order{}).Count(&orders).Error)
+		assert.Equal(t, int64(1), orders)
+	})
+
+	t.Run("DifferentBodyConflicts", func(t *testing.T) {
+		rr := createOrder(t, "webhook-123", strings.Replace(body, `"count": 2`, `"count": 3`, 1))
+		assert.Equal(t, http.StatusConflict, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(8), stock(t))
+	})
+
+	t.Run("FailedRequestCanBeRetried", func(t *testing.T) {
+		tooMany := strings.Replace(body, `"count": 2`, `"count": 50`, 1)
+		rr := createOrder(t, "webhook-456", tooMany)
+		require.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+
+		// The failed attempt left no record, so the key is free for a corrected request
+		rr = createOrder(t, "webhook-456", body)
+		assert.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("KeyTooLong", func(t *testing.T) {
+		rr := createOrder(t, strings.Repeat("k", maxIdempotencyKeyLen+1), body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
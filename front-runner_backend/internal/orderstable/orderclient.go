@@ -0,0 +1,129 @@
+package orderstable
+
+import (
+	"errors"
+	"fmt"
+	"front-runner/internal/oauth"
+	"front-runner/internal/ratelimit"
+	"front-runner/internal/storefronttable"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// How an order was placed, recorded as Order.Source.
+const (
+	OrderSourceChannel     = "channel"     // A linked storefront signing its requests
+	OrderSourceSession     = "session"     // A seller signed in to the dashboard
+	OrderSourceMarketplace = "marketplace" // Imported from a linked storefront's marketplace; see channelsync.go
+)
+
+// Default CreateOrder rate limits, overridden by ORDER_RATE_LIMIT and ORDER_RATE_BURST.
+const (
+	defaultOrderRatePerMinute = 60
+	defaultOrderRateBurst     = 20
+)
+
+// maxOrderBodyBytes bounds the CreateOrder request body, which is read whole to verify its signature.
+const maxOrderBodyBytes = 1 << 20
+
+var (
+	errOrderUnauthenticated = errors.New("order client not authenticated")
+	errNotClientProduct     = errors.New("product is not sold by this seller")
+)
+
+// orderLimiter limits CreateOrder and ReserveStock requests per client, and failed authentications per IP address.
+var orderLimiter = ratelimit.New(defaultOrderRatePerMinute, defaultOrderRateBurst)
+
+// orderClient is who is placing an order. Either way it acts for one seller, whose
+// products are the only ones it may order.
+type orderClient struct {
+	Source    string // OrderSourceChannel or OrderSourceSession
+	ChannelID uint   // StorefrontLink ID for channels
+	SellerID  uint
+	IP        string
+}
+
+// key identifies the client for rate limiting and idempotency keys.
+func (c orderClient) key() string {
+	if c.Source == OrderSourceChannel {
+		return fmt.Sprintf("channel:%d", c.ChannelID)
+	}
+	return fmt.Sprintf("user:%d", c.SellerID)
+}
+
+// clientIP returns the address the request came from.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// authenticateOrderClient identifies the client from a storefront signature, if the request
+// carries one, or else from the session. body is the raw request body.
+func authenticateOrderClient(r *http.Request, body []byte) (*orderClient, error) {
+	client := &orderClient{IP: clientIP(r)}
+	if storefronttable.IsSignedRequest(r) {
+		link, err := storefronttable.VerifySignedRequest(r, body)
+		if errors.Is(err, storefronttable.ErrInvalidSignature) {
+			return nil, fmt.Errorf("%w: %v", errOrderUnauthenticated, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		client.Source, client.ChannelID, client.SellerID = OrderSourceChannel, link.ID, link.UserID
+		return client, nil
+	}
+
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errOrderUnauthenticated
+	}
+	client.Source, client.SellerID = OrderSourceSession, user.ID
+	return client, nil
+}
+
+// admitOrderClient authenticates and rate limits a request to create an order or reserve stock,
+// returning the client and the raw request body. If ok is false a response has been written.
+func admitOrderClient(w http.ResponseWriter, r *http.Request) (client *orderClient, body []byte, ok bool) {
+	// Addresses with too many failed authentications are turned away before any work is done
+	ipKey := "ip:" + clientIP(r)
+	if ok, wait := orderLimiter.Check(ipKey); !ok {
+		writeTooManyRequests(w, ratelimit.RetryAfter(wait))
+		return nil, nil, false
+	}
+	// The raw body is needed to verify storefront signatures
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOrderBodyBytes))
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	client, err = authenticateOrderClient(r, body)
+	if errors.Is(err, errOrderUnauthenticated) {
+		orderLimiter.Allow(ipKey)
+		http.Error(w, "Order client not authenticated", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Error authenticating order client: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if ok, wait := orderLimiter.Allow(client.key()); !ok {
+		writeTooManyRequests(w, ratelimit.RetryAfter(wait))
+		return nil, nil, false
+	}
+	return client, body, true
+}
+
+// writeTooManyRequests responds with 429 and when to retry.
+func writeTooManyRequests(w http.ResponseWriter, wait string) {
+	w.Header().Set("Retry-After", wait)
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,120 @@
+// internal/orderstable/orderclient_test.go
+package orderstable
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/ratelimit"
+	"front-runner/internal/storefronttable"
+)
+
+// TestCreateOrder_Authentication tests storefront signatures, session authentication and rate limiting of CreateOrder.
+func TestCreateOrder_Authentication(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "channelseller@example.com", "password")
+	other := createTestUser(t, "channelother@example.com", "password")
+	product := createTestProduct(t, seller, "Channel Prod", 1000, 20)
+	otherProduct := createTestProduct(t, other, "Other Channel Prod", 1000, 20)
+
+	// Link a storefront and give it a signing secret
+	link := storefronttable.StorefrontLink{UserID: seller.ID, StoreType: "shopify", StoreName: "Main shop"}
+	require.NoError(t, testDB.Create(&link).Error)
+	rr := httptest.NewRecorder()
+	storefronttable.RotateSigningSecret(rr, createAuthenticatedRequest(t, seller, "POST", fmt.Sprintf("/api/rotate_storefront_secret?id=%d", link.ID), nil))
+	require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+	var secret storefronttable.SigningSecretReturn
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &secret))
+
+	orderBody := func(productID uint) string {
+		return fmt.Sprintf(`{"customerName": "Channel Cust", "customerEmail": "channel@test.com", "orderedProducts": [{"productID": %d, "count": 1}]}`, productID)
+	}
+	signedRequest := func(body string, timestamp time.Time, key string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/create_order", strings.NewReader(body))
+		ts := timestamp.Unix()
+		req.Header.Set(storefronttable.ChannelIDHeader, strconv.FormatUint(uint64(link.ID), 10))
+		req.Header.Set(storefronttable.ChannelTimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(storefronttable.ChannelSignatureHeader, storefronttable.SignRequest(key, ts, "POST", "/api/create_order", []byte(body)))
+		return req
+	}
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, httptest.NewRequest("POST", "/api/create_order", strings.NewReader(orderBody(product.ID))))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("SignedByChannel", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, signedRequest(orderBody(product.ID), time.Now(), secret.SigningSecret))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var created map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+
+		var order Order
+		require.NoError(t, testDB.First(&order, created["orderID"]).Error)
+		assert.Equal(t, OrderSourceChannel, order.Source)
+		assert.Equal(t, link.ID, order.ChannelID)
+		assert.Equal(t, seller.ID, order.CreatedBy)
+		assert.NotEmpty(t, order.ClientIP)
+	})
+
+	t.Run("BadSignatures", func(t *testing.T) {
+		for name, req := range map[string]*http.Request{
+			"WrongSecret":   signedRequest(orderBody(product.ID), time.Now(), "not-the-secret"),
+			"Stale":         signedRequest(orderBody(product.ID), time.Now().Add(-10*time.Minute), secret.SigningSecret),
+			"TamperedBody":  signedRequest(orderBody(product.ID), time.Now(), secret.SigningSecret),
+			"TamperedQuery": signedRequest(orderBody(product.ID), time.Now(), secret.SigningSecret),
+		} {
+			switch name {
+			case "TamperedBody":
+				req.Body = http.NoBody
+			case "TamperedQuery":
+				req.URL.RawQuery = "id=1"
+			}
+			rr := httptest.NewRecorder()
+			CreateOrder(rr, req)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, name)
+		}
+	})
+
+	t.Run("OnlyTheSellersProducts", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, signedRequest(orderBody(otherProduct.ID), time.Now(), secret.SigningSecret))
+		assert.Equal(t, http.StatusForbidden, rr.Code, "body: %s", rr.Body.String())
+
+		rr = httptest.NewRecorder()
+		CreateOrder(rr, createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(orderBody(otherProduct.ID))))
+		assert.Equal(t, http.StatusForbidden, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		previous := orderLimiter
+		orderLimiter = ratelimit.New(1, 2)
+		defer func() { orderLimiter = previous }()
+
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			CreateOrder(rr, signedRequest(orderBody(product.ID), time.Now(), secret.SigningSecret))
+			require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		}
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, signedRequest(orderBody(product.ID), time.Now(), secret.SigningSecret))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+		// Other clients have their own allowance
+		rr = httptest.NewRecorder()
+		CreateOrder(rr, createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(orderBody(product.ID))))
+		assert.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	})
+}
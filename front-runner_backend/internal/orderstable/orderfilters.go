@@ -0,0 +1,177 @@
+package orderstable
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Page size limits for GetOrders.
+const (
+	defaultOrdersPageSize = 50
+	maxOrdersPageSize     = 200
+)
+
+// sellerTotalSQL computes the requesting seller's share of an order (alias o), net of cancellations
+// and including their adjustment lines. It takes the seller's user ID twice.
+const sellerTotalSQL = `((SELECT COALESCE(SUM(op.cost_minor * (op.count - op.cancelled_count)), 0)
+	FROM order_prods op JOIN products p ON p.id = op.prod_id
+	WHERE op.order_id = o.id AND op.deleted_at IS NULL AND p.user_id = ?) +
+	(SELECT COALESCE(SUM(oa.amount_minor), 0) FROM order_adjustments oa WHERE oa.order_id = o.id AND oa.user_id = ?))`
+
+// orderListParams holds the parsed filter, sort and pagination options for GetOrders.
+type orderListParams struct {
+	Status        string     // Seller's shipment status; "" for any
+	From          *time.Time // Inclusive lower bound on the order date
+	To            *time.Time // Upper bound on the order date
+	ToInclusive   bool       // Whether To itself is included: true for timestamps, false for the day after a plain date
+	CustomerEmail string     // Case-insensitive exact match; "" for any
+	ProdID        uint       // Only orders containing this product; 0 for any
+	SortBy        string     // "date" or "total"
+	Desc          bool
+	Page          int // 1-based
+	PageSize      int
+}
+
+// parseOrderDate accepts either an RFC 3339 timestamp or a plain YYYY-MM-DD date, and reports
+// whether it was a timestamp. For plain dates used as an upper bound, the start of the next day
+// is returned, so that excluding it includes the whole day.
+func parseOrderDate(value string, upperBound bool) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC 3339)", value)
+	}
+	if upperBound {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, false, nil
+}
+
+// parseOrderListParams reads GetOrders' query parameters, applying defaults.
+func parseOrderListParams(q url.Values) (orderListParams, error) {
+	params := orderListParams{
+		SortBy:   "date",
+		Desc:     true,
+		Page:     1,
+		PageSize: defaultOrdersPageSize,
+	}
+
+	if s := q.Get("status"); s != "" {
+		params.Status = normalizeStatus(s)
+		if params.Status == "" {
+			return params, fmt.Errorf("unknown order status %q", s)
+		}
+	}
+	if s := q.Get("from"); s != "" {
+		from, _, err := parseOrderDate(s, false)
+		if err != nil {
+			return params, err
+		}
+		params.From = &from
+	}
+	if s := q.Get("to"); s != "" {
+		to, exact, err := parseOrderDate(s, true)
+		if err != nil {
+			return params, err
+		}
+		params.To, params.ToInclusive = &to, exact
+	}
+	params.CustomerEmail = strings.TrimSpace(q.Get("customerEmail"))
+	if s := q.Get("productID"); s != "" {
+		prodID, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return params, fmt.Errorf("invalid productID %q", s)
+		}
+		params.ProdID = uint(prodID)
+	}
+
+	switch sortBy := strings.ToLower(q.Get("sort")); sortBy {
+	case "", "date":
+	case "total":
+		params.SortBy = sortBy
+	default:
+		return params, fmt.Errorf("invalid sort %q (use date or total)", sortBy)
+	}
+	switch order := strings.ToLower(q.Get("order")); order {
+	case "", "desc":
+	case "asc":
+		params.Desc = false
+	default:
+		return params, fmt.Errorf("invalid order %q (use asc or desc)", order)
+	}
+
+	if s := q.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 {
+			return params, fmt.Errorf("invalid page %q", s)
+		}
+		params.Page = page
+	}
+	if s := q.Get("pageSize"); s != "" {
+		pageSize, err := strconv.Atoi(s)
+		if err != nil || pageSize < 1 {
+			return params, fmt.Errorf("invalid pageSize %q", s)
+		}
+		params.PageSize = min(pageSize, maxOrdersPageSize)
+	}
+	return params, nil
+}
+
+// filterQuery builds the query selecting the seller's orders that match the filters.
+// Orders are aliased o, OrderOwner rows oo, and the seller's shipment s.
+func (p orderListParams) filterQuery(tx *gorm.DB, userID uint) *gorm.DB {
+	query := tx.Table("order_owners oo").
+		Joins("JOIN orders o ON o.id = oo.order_id").
+		Joins("LEFT JOIN order_shipments s ON s.order_owner_id = oo.id").
+		Where("oo.user_id = ? AND oo.deleted_at IS NULL", userID).
+		// Only orders in which the seller still has products
+		Where(`EXISTS (SELECT 1 FROM order_prods op JOIN products p ON p.id = op.prod_id
+			WHERE op.order_id = o.id AND op.deleted_at IS NULL AND p.user_id = ?)`, userID)
+
+	if p.Status != "" {
+		query = query.Where("COALESCE(s.status, o.order_status) = ?", p.Status)
+	}
+	if p.From != nil {
+		query = query.Where("o.order_date >= ?", *p.From)
+	}
+	if p.To != nil && p.ToInclusive {
+		query = query.Where("o.order_date <= ?", *p.To)
+	} else if p.To != nil {
+		query = query.Where("o.order_date < ?", *p.To)
+	}
+	if p.CustomerEmail != "" {
+		query = query.Where("LOWER(o.customer_email) = LOWER(?)", p.CustomerEmail)
+	}
+	if p.ProdID != 0 {
+		query = query.Where(`EXISTS (SELECT 1 FROM order_prods op
+			WHERE op.order_id = o.id AND op.deleted_at IS NULL AND op.prod_id = ?)`, p.ProdID)
+	}
+	return query
+}
+
+// orderBy returns the ORDER BY clause for the requested sort, breaking ties on order ID.
+func (p orderListParams) orderBy(userID uint) clause.OrderBy {
+	dir := "ASC"
+	if p.Desc {
+		dir = "DESC"
+	}
+	if p.SortBy == "total" {
+		return clause.OrderBy{Expression: clause.Expr{
+			SQL:                sellerTotalSQL + " " + dir + ", o.id " + dir,
+			Vars:               []interface{}{userID, userID},
+			WithoutParentheses: true,
+		}}
+	}
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:                "o.order_date " + dir + ", o.id " + dir,
+		WithoutParentheses: true,
+	}}
+}
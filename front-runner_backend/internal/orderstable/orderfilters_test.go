@@ -0,0 +1,168 @@
+// internal/orderstable/orderfilters_test.go
+package orderstable
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/prodtable"
+)
+
+func TestParseOrderListParams(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		params, err := parseOrderListParams(url.Values{})
+		require.NoError(t, err)
+		assert.Equal(t, "date", params.SortBy)
+		assert.True(t, params.Desc)
+		assert.Equal(t, 1, params.Page)
+		assert.Equal(t, defaultOrdersPageSize, params.PageSize)
+		assert.Nil(t, params.From)
+		assert.Nil(t, params.To)
+	})
+
+	t.Run("AllFilters", func(t *testing.T) {
+		q := url.Values{
+			"status":        {"shipped"},
+			"from":          {"2025-01-01"},
+			"to":            {"2025-01-31"},
+			"customerEmail": {" Buyer@Test.com "},
+			"productID":     {"42"},
+			"sort":          {"total"},
+			"order":         {"asc"},
+			"page":          {"3"},
+			"pageSize":      {"1000"},
+		}
+		params, err := parseOrderListParams(q)
+		require.NoError(t, err)
+		assert.Equal(t, StatusShipped, params.Status)
+		assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), *params.From)
+		assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), *params.To, "Date-only upper bound should include the whole day")
+		assert.False(t, params.ToInclusive, "The start of the next day is excluded")
+		assert.Equal(t, "Buyer@Test.com", params.CustomerEmail)
+		assert.Equal(t, uint(42), params.ProdID)
+		assert.Equal(t, "total", params.SortBy)
+		assert.False(t, params.Desc)
+		assert.Equal(t, 3, params.Page)
+		assert.Equal(t, maxOrdersPageSize, params.PageSize, "Page size should be capped")
+	})
+
+	t.Run("TimestampUpperBound", func(t *testing.T) {
+		params, err := parseOrderListParams(url.Values{"to": {"2025-01-31T12:00:00Z"}})
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC), *params.To)
+		assert.True(t, params.ToInclusive, "Orders placed at the timestamp are included")
+	})
+
+	for _, q := range []url.Values{
+		{"status": {"Lost"}},
+		{"from": {"yesterday"}},
+		{"productID": {"abc"}},
+		{"sort": {"name"}},
+		{"order": {"sideways"}},
+		{"page": {"0"}},
+		{"pageSize": {"-5"}},
+	} {
+		t.Run("Invalid_"+q.Encode(), func(t *testing.T) {
+			_, err := parseOrderListParams(q)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetOrdersFilteringAndPagination(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "filterseller@example.com", "password")
+	other := createTestUser(t, "filterother@example.com", "password")
+	cheap := createTestProduct(t, seller, "Filter Cheap", 500, 100)
+	pricey := createTestProduct(t, seller, "Filter Pricey", 5000, 100)
+	otherProd := createTestProduct(t, other, "Filter Other", 100, 100)
+
+	order1 := createTestOrder(t, "Cust A", "a@test.com", map[*prodtable.Product]uint{cheap: 1})               // total 5
+	order2 := createTestOrder(t, "Cust B", "b@test.com", map[*prodtable.Product]uint{pricey: 1})              // total 50
+	order3 := createTestOrder(t, "Cust A", "A@test.com", map[*prodtable.Product]uint{cheap: 2, otherProd: 1}) // total 10
+	createTestOrder(t, "Cust C", "c@test.com", map[*prodtable.Product]uint{otherProd: 3})                     // not the seller's
+	require.NoError(t, testDB.Model(&Order{}).Where("id = ?", order1.ID).Update("order_date", time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)).Error)
+	require.NoError(t, testDB.Model(&Order{}).Where("id = ?", order2.ID).Update("order_date", time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)).Error)
+	require.NoError(t, testDB.Model(&Order{}).Where("id = ?", order3.ID).Update("order_date", time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)).Error)
+
+	getOrders := func(t *testing.T, query string) ([]OrderReturn, *httptest.ResponseRecorder) {
+		t.Helper()
+		req := createAuthenticatedRequest(t, seller, "GET", "/api/get_orders?"+query, nil)
+		rr := httptest.NewRecorder()
+		GetOrders(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp []OrderReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp, rr
+	}
+	ids := func(orders []OrderReturn) []uint {
+		out := make([]uint, len(orders))
+		for i, o := range orders {
+			out[i] = o.OrderID
+		}
+		return out
+	}
+
+	t.Run("DefaultSortNewestFirst", func(t *testing.T) {
+		resp, rr := getOrders(t, "")
+		assert.Equal(t, []uint{order3.ID, order2.ID, order1.ID}, ids(resp))
+		assert.Equal(t, "3", rr.Header().Get("X-Total-Count"))
+	})
+
+	t.Run("SortByTotal", func(t *testing.T) {
+		resp, _ := getOrders(t, "sort=total&order=asc")
+		assert.Equal(t, []uint{order1.ID, order3.ID, order2.ID}, ids(resp))
+		assert.Equal(t, json.Number("10.00"), resp[1].Total, "Total should only include the seller's items")
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		resp, rr := getOrders(t, "pageSize=2&page=2")
+		assert.Equal(t, []uint{order1.ID}, ids(resp))
+		assert.Equal(t, "3", rr.Header().Get("X-Total-Count"))
+		assert.Equal(t, "2", rr.Header().Get("X-Page"))
+		assert.Equal(t, "2", rr.Header().Get("X-Page-Size"))
+	})
+
+	t.Run("DateRange", func(t *testing.T) {
+		resp, rr := getOrders(t, "from=2025-02-01&to=2025-03-10")
+		assert.Equal(t, []uint{order3.ID, order2.ID}, ids(resp))
+		assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+
+		// Timestamps are inclusive bounds at both ends
+		resp, _ = getOrders(t, "from=2025-02-10T12:00:00Z&to=2025-02-10T12:00:00Z")
+		assert.Equal(t, []uint{order2.ID}, ids(resp))
+		resp, _ = getOrders(t, "to=2025-02-10T11:59:59Z")
+		assert.Equal(t, []uint{order1.ID}, ids(resp))
+	})
+
+	t.Run("CustomerEmail", func(t *testing.T) {
+		resp, _ := getOrders(t, "customerEmail=a@test.com")
+		assert.Equal(t, []uint{order3.ID, order1.ID}, ids(resp))
+	})
+
+	t.Run("ProductID", func(t *testing.T) {
+		resp, _ := getOrders(t, fmt.Sprintf("productID=%d", pricey.ID))
+		assert.Equal(t, []uint{order2.ID}, ids(resp))
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		require.NoError(t, testDB.Model(&Order{}).Where("id = ?", order2.ID).Update("order_status", StatusProcessing).Error)
+		resp, _ := getOrders(t, "status=processing")
+		assert.Equal(t, []uint{order2.ID}, ids(resp))
+	})
+
+	t.Run("InvalidParam", func(t *testing.T) {
+		req := createAuthenticatedRequest(t, seller, "GET", "/api/get_orders?sort=name", nil)
+		rr := httptest.NewRecorder()
+		GetOrders(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,173 @@
+package orderstable
+
+import (
+	"errors"
+	"fmt"
+	"front-runner/internal/money"
+	"front-runner/internal/prodtable"
+	"log"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// errInvalidOrderLine is returned for items with a zero count, a variant of another product
+	// or a missing variant, and for items priced in different currencies.
+	errInvalidOrderLine = errors.New("invalid order line")
+	// errOrderTooLarge is returned for orders whose subtotal exceeds money.MaxMinor.
+	errOrderTooLarge = errors.New("order total is too large")
+)
+
+// orderLines are the requested items of an order or reservation, consolidated into one line
+// per product or variant, with the products and variants they refer to.
+type orderLines struct {
+	Counts   map[orderLineKey]uint
+	Products map[uint]prodtable.Product
+	Variants map[uint]prodtable.ProductVariant
+	Currency string // Every product in an order must share one currency
+}
+
+// resolveOrderLines consolidates the requested items and checks they exist, are sold by the
+// seller and share a currency. Stock is not checked; see takeStock.
+func resolveOrderLines(tx *gorm.DB, items []OrderProductPayload, sellerID uint) (*orderLines, error) {
+	lines := &orderLines{
+		Counts:   make(map[orderLineKey]uint),
+		Products: make(map[uint]prodtable.Product),
+		Variants: make(map[uint]prodtable.ProductVariant),
+	}
+
+	for _, item := range items {
+		if item.Count <= 0 {
+			return nil, fmt.Errorf("%w: invalid count for product ID %d", errInvalidOrderLine, item.ProdID)
+		}
+		key := orderLineKey{ProdID: item.ProdID, VariantID: item.VariantID}
+		if item.VariantID != 0 {
+			// The variant determines the product
+			variant, ok := lines.Variants[item.VariantID]
+			if !ok {
+				if err := tx.First(&variant, item.VariantID).Error; err != nil {
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						return nil, fmt.Errorf("variant with ID %d not found", item.VariantID)
+					}
+					log.Printf("Error fetching variant %d: %v", item.VariantID, err)
+					return nil, fmt.Errorf("database error fetching variant %d", item.VariantID)
+				}
+				lines.Variants[item.VariantID] = variant
+			}
+			if item.ProdID != 0 && item.ProdID != variant.ProductID {
+				return nil, fmt.Errorf("%w: invalid variant: variant %d does not belong to product ID %d", errInvalidOrderLine, item.VariantID, item.ProdID)
+			}
+			key.ProdID = variant.ProductID
+		}
+		if lines.Counts[key]+item.Count < item.Count {
+			return nil, fmt.Errorf("%w: invalid count for product ID %d", errInvalidOrderLine, item.ProdID)
+		}
+		lines.Counts[key] += item.Count
+	}
+
+	for key := range lines.Counts {
+		prodID := key.ProdID
+		product, ok := lines.Products[prodID]
+		if !ok {
+			if err := tx.Preload("Variants").First(&product, prodID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, fmt.Errorf("product with ID %d not found", prodID)
+				}
+				log.Printf("Error fetching product %d: %v", prodID, err)
+				return nil, fmt.Errorf("database error fetching product %d", prodID)
+			}
+		}
+
+		if product.UserID != sellerID {
+			return nil, fmt.Errorf("%w (product ID %d)", errNotClientProduct, prodID)
+		}
+		if key.VariantID == 0 && len(product.Variants) > 0 {
+			return nil, fmt.Errorf("%w: invalid variant: product ID %d requires a variantID", errInvalidOrderLine, prodID)
+		}
+		if lines.Currency == "" {
+			lines.Currency = product.Currency
+		} else if product.Currency != lines.Currency {
+			return nil, fmt.Errorf("%w: mixed currencies: product ID %d is priced in %s, not %s", errInvalidOrderLine, prodID, product.Currency, lines.Currency)
+		}
+		lines.Products[prodID] = product
+	}
+	return lines, nil
+}
+
+// sortedKeys returns the lines in product then variant ID order, the order in which
+// stock rows are locked so concurrent transactions cannot deadlock.
+func (l *orderLines) sortedKeys() []orderLineKey {
+	keys := make([]orderLineKey, 0, len(l.Counts))
+	for key := range l.Counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ProdID != keys[j].ProdID {
+			return keys[i].ProdID < keys[j].ProdID
+		}
+		return keys[i].VariantID < keys[j].VariantID
+	})
+	return keys
+}
+
+// takeStock removes every line's count from stock, failing with prodtable.ErrInsufficientStock
+// if any product or variant has too few units. It must run inside a transaction.
+func (l *orderLines) takeStock(tx *gorm.DB, change prodtable.StockChange) error {
+	for _, key := range l.sortedKeys() {
+		if err := prodtable.TakeStock(tx, key.ProdID, key.VariantID, l.Counts[key], change); err != nil {
+			if !errors.Is(err, prodtable.ErrInsufficientStock) {
+				log.Printf("Error updating stock for product %d: %v", key.ProdID, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// unitPrice returns the current price of one unit of a line, in minor units of the order's currency.
+func (l *orderLines) unitPrice(key orderLineKey) int64 {
+	if key.VariantID != 0 {
+		return l.Variants[key.VariantID].PriceMinor
+	}
+	return l.Products[key.ProdID].PriceMinor
+}
+
+// subtotal returns the total of the lines at their unitPrice, failing with errOrderTooLarge
+// rather than overflowing.
+func (l *orderLines) subtotal() (int64, error) {
+	var subtotal int64
+	for key, count := range l.Counts {
+		price := l.unitPrice(key)
+		if price > 0 && uint64(count) > uint64(money.MaxMinor-subtotal)/uint64(price) {
+			return 0, errOrderTooLarge
+		}
+		subtotal += price * int64(count)
+	}
+	return subtotal, nil
+}
+
+// createOrderProds records every line in the order. Each line costs its unitPrice, unless
+// prices gives the price it was sold at elsewhere, such as on a marketplace.
+func (l *orderLines) createOrderProds(tx *gorm.DB, orderID uint, prices map[orderLineKey]int64) error {
+	for _, key := range l.sortedKeys() {
+		record := OrderProd{
+			OrderID:   orderID,
+			ProdID:    key.ProdID,
+			VariantID: key.VariantID,
+			Count:     l.Counts[key],
+			CostMinor: l.unitPrice(key),
+		}
+		if key.VariantID != 0 {
+			record.VariantTitle = l.Variants[key.VariantID].Title
+		}
+		if price, ok := prices[key]; ok {
+			record.CostMinor = price
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			log.Printf("Error creating order product record (Order: %d, Prod: %d): %v", orderID, key.ProdID, err)
+			return fmt.Errorf("failed to record product %d in order", key.ProdID)
+		}
+	}
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"errors" // Import errors package
 	"fmt"    // Import fmt for error formatting
+	"front-runner/internal/blobstore"
 	"front-runner/internal/coredbutils"
+	"front-runner/internal/money"
 	"front-runner/internal/oauth" // Use oauth for authentication
+	"front-runner/internal/pricing"
 	"front-runner/internal/prodtable"
+	"front-runner/internal/ratelimit"
+	"io"
 	"log"
 	"net/http"
 	"strconv" // Import strconv for ID parsing
@@ -20,10 +25,11 @@ import (
 var (
 	// db will hold the GORM DB instance
 	db        *gorm.DB
+	store     blobstore.Store // Where shipping labels live; selected by BLOB_STORE
 	setupOnce sync.Once
 )
 
-// Setup initializes the database connection for the orderstable package.
+// Setup initializes the database connection and the blob store that holds shipping labels.
 func Setup() {
 	setupOnce.Do(func() {
 		// Get DB connection from coredbutils
@@ -37,31 +43,49 @@ func Setup() {
 			log.Fatal("orderstable Setup: Database connection is nil after GetDB.")
 		}
 		// login.Setup() // Removed: Assume main.go handles setup order
+		// Label storage: the local uploads directory by default, or an S3-compatible bucket
+		store, err = blobstore.FromEnv()
+		if err != nil {
+			log.Fatalf("orderstable Setup: Failed to configure shipping label storage: %v", err)
+		}
+		orderLimiter = ratelimit.FromEnv("ORDER_RATE_LIMIT", "ORDER_RATE_BURST", defaultOrderRatePerMinute, defaultOrderRateBurst)
+		reservationTTL = reservationTTLFromEnv()
+		channelSyncInterval = channelSyncIntervalFromEnv()
 		log.Println("orderstable package setup complete (DB connection obtained).")
 	})
 }
 
 // Order represents the main order details.
 type Order struct {
-	ID             uint      `gorm:"primaryKey"`
-	CustomerName   string    // Name provided by the buyer (might not be a registered user)
-	CustomerEmail  string    // Email provided by the buyer
-	OrderDate      time.Time `gorm:"autoCreateTime"`
-	OrderStatus    string    // e.g., "Pending", "Processing", "Shipped", "Delivered", "Cancelled"
-	TrackingNumber string
-	TrackingImage  string      // URL or path to a tracking image/label if applicable
+	ID             uint        `gorm:"primaryKey"`
+	CustomerName   string      // Name provided by the buyer (might not be a registered user)
+	CustomerEmail  string      // Email provided by the buyer
+	OrderDate      time.Time   `gorm:"autoCreateTime"`
+	OrderStatus    string      // Aggregate of the sellers' OrderShipment statuses; see aggregateOrderStatus
+	Currency       string      `gorm:"size:3;not null;default:'USD'"` // ISO 4217 code shared by every line's price
+	Region         string      // Shipping region used for tax and shipping rates, e.g. "US-CA"
+	Source         string      // How the order was placed: OrderSourceChannel, OrderSourceSession or OrderSourceMarketplace; "" for legacy orders
+	ChannelID      uint        `gorm:"not null;default:0;index"` // StorefrontLink that created the order, 0 otherwise
+	ExternalID     string      `gorm:"not null;default:''"`      // The marketplace's ID of an order imported from ChannelID
+	CreatedBy      uint        `gorm:"not null;default:0"`       // Seller whose storefront or session created the order
+	ClientIP       string      // Address the order was submitted from
+	TrackingNumber string      // Legacy order-wide tracking; superseded by OrderShipment.TrackingNumber
+	TrackingImage  string      // Legacy order-wide label; superseded by OrderShipment.LabelFile
 	OrderProds     []OrderProd `gorm:"foreignKey:OrderID"` // <--- Add this line
 }
 
 // OrderProd links an Order with a Product, specifying quantity and cost at the time of order.
 type OrderProd struct {
-	gorm.Model                   // Includes ID, CreatedAt, UpdatedAt, DeletedAt
-	OrderID    uint              `gorm:"not null;index"`
-	Order      Order             `gorm:"foreignKey:OrderID"`
-	ProdID     uint              `gorm:"not null;index"`
-	Prod       prodtable.Product `gorm:"foreignKey:ProdID"` // Link to the actual product
-	Count      uint              `gorm:"not null"`
-	Cost       float64           `gorm:"not null"` // Price per item at the time of order
+	gorm.Model                       // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	OrderID        uint              `gorm:"not null;index"`
+	Order          Order             `gorm:"foreignKey:OrderID"`
+	ProdID         uint              `gorm:"not null;index"`
+	Prod           prodtable.Product `gorm:"foreignKey:ProdID"`        // Link to the actual product
+	VariantID      uint              `gorm:"not null;default:0;index"` // Ordered variant of the product, 0 for none
+	VariantTitle   string            // Variant title (e.g. "M / Red") at the time of order
+	Count          uint              `gorm:"not null"`
+	CancelledCount uint              `gorm:"not null;default:0"` // Quantity cancelled and returned to stock
+	CostMinor      int64             `gorm:"not null;default:0"` // Price per item at the time of order, in minor units of the order's currency
 }
 
 // OrderOwner links an Order to the User who *owns* the products being sold in that order.
@@ -75,8 +99,15 @@ type OrderOwner struct {
 
 // OrderProductPayload is used to decode the JSON body when creating an order.
 type OrderProductPayload struct {
-	ProdID uint `json:"productID"`
-	Count  uint `json:"count"`
+	ProdID    uint `json:"productID"`
+	VariantID uint `json:"variantID"` // Required for products with variants; productID may then be omitted
+	Count     uint `json:"count"`
+}
+
+// orderLineKey identifies an order line: a product, or one of its variants.
+type orderLineKey struct {
+	ProdID    uint
+	VariantID uint // 0 for products without variants
 }
 
 // OrderCreatePayload is used to decode the JSON body when creating an order.
@@ -84,27 +115,47 @@ type OrderCreatePayload struct {
 	CustomerName    string                `json:"customerName"`    // Name of the customer placing the order
 	CustomerEmail   string                `json:"customerEmail"`   // Email of the customer placing the order
 	OrderedProducts []OrderProductPayload `json:"orderedProducts"` // List of ordered products
+	ReservationID   uint                  `json:"reservationID"`   // Converts a stock reservation into the order, in place of orderedProducts
+	Region          string                `json:"region"`          // Shipping region such as "US-CA", selecting tax and shipping rates
+	DiscountCodes   []string              `json:"discountCodes"`   // At most one of the seller's codes
 }
 
 // OrderProductReturn is struct returned to the frontend containing information about an order's products.
 type OrderProductReturn struct {
-	ProdID   uint    `json:"productID"`
-	ProdName string  `json:"productName"`
-	Count    uint    `json:"count"`
-	Price    float64 `json:"price"` // Price per item at the time of order
+	ProdID         uint        `json:"productID"`
+	ProdName       string      `json:"productName"`
+	VariantID      uint        `json:"variantID,omitempty"`
+	VariantTitle   string      `json:"variantTitle,omitempty"`
+	Count          uint        `json:"count"`
+	CancelledCount uint        `json:"cancelledCount"` // Quantity of Count that has been cancelled
+	Price          json.Number `json:"price"`          // Price per item at the time of order, exact decimal in the order's currency
 }
 
 // OrderReturn is struct returned to the frontend containing relevant information about an order,
 // filtered for the requesting user (seller).
 type OrderReturn struct {
-	OrderID         uint                 `json:"orderID"`       // ID of the order requested
-	CustomerName    string               `json:"customerName"`  // Name of the customer that placed the order
-	CustomerEmail   string               `json:"customerEmail"` // Email of the customer that placed the order
-	OrderDate       string               `json:"orderDate"`     // Formatted date string
-	OrderStatus     string               `json:"status"`
-	TrackingNumber  string               `json:"trackingNumber"`
-	Total           float64              `json:"total"`           // Total cost *for the items owned by the requesting user* in this order
-	OrderedProducts []OrderProductReturn `json:"orderedProducts"` // List of ordered products *owned by the requesting user*
+	OrderID          uint                    `json:"orderID"`               // ID of the order requested
+	CustomerName     string                  `json:"customerName"`          // Name of the customer that placed the order
+	CustomerEmail    string                  `json:"customerEmail"`         // Email of the customer that placed the order
+	OrderDate        string                  `json:"orderDate"`             // Formatted date string
+	OrderStatus      string                  `json:"status"`                // The requesting seller's own fulfilment status
+	OverallStatus    string                  `json:"overallStatus"`         // Status of the order across all sellers
+	TrackingNumber   string                  `json:"trackingNumber"`        // The requesting seller's tracking number for this order
+	Carrier          string                  `json:"carrier"`               // The requesting seller's shipping carrier
+	HasShippingLabel bool                    `json:"hasShippingLabel"`      // Whether the requesting seller uploaded a shipping label
+	ShippedAt        string                  `json:"shippedAt,omitempty"`   // When the requesting seller shipped, if they have
+	DeliveredAt      string                  `json:"deliveredAt,omitempty"` // When the requesting seller's shipment was delivered
+	Source           string                  `json:"source,omitempty"`      // "channel", "session" or "marketplace": how the order was placed
+	ChannelID        uint                    `json:"channelID,omitempty"`   // Storefront link that placed the order
+	ExternalID       string                  `json:"externalID,omitempty"`  // The marketplace's ID of an imported order
+	Currency         string                  `json:"currency"`              // ISO 4217 code of every price in the order
+	Subtotal         json.Number             `json:"subtotal"`              // Cost of the requesting user's items, net of cancellations
+	Discount         json.Number             `json:"discount"`              // Discounts taken off the subtotal, as a positive amount
+	Tax              json.Number             `json:"tax"`                   // Tax charged on the discounted subtotal
+	Shipping         json.Number             `json:"shipping"`              // Shipping charged by the requesting user
+	Total            json.Number             `json:"total"`                 // Grand total *for the items owned by the requesting user* in this order, exact decimal
+	Adjustments      []OrderAdjustmentReturn `json:"adjustments"`           // The requesting user's discount, tax and shipping lines
+	OrderedProducts  []OrderProductReturn    `json:"orderedProducts"`       // List of ordered products *owned by the requesting user*
 }
 
 // MigrateOrdersDB runs the database migrations for the order-related tables.
@@ -113,86 +164,208 @@ func MigrateOrdersDB() {
 		log.Fatal("Database connection is not initialized for orders migration")
 	}
 	log.Println("Running orders database migrations...")
-	// AutoMigrate Order, OrderProd, OrderOwner
-	err := db.AutoMigrate(&Order{}, &OrderProd{}, &OrderOwner{})
+	// AutoMigrate Order, OrderProd, OrderOwner, OrderStatusHistory, OrderCancellation, OrderShipment, OrderAdjustment, OrderIdempotencyKey,
+	// StockReservation, StockReservationItem, ChannelSync, ChannelOrderFailure, WebhookDelivery
+	err := db.AutoMigrate(&Order{}, &OrderProd{}, &OrderOwner{}, &OrderStatusHistory{}, &OrderCancellation{}, &OrderShipment{}, &OrderAdjustment{},
+		&OrderIdempotencyKey{}, &StockReservation{}, &StockReservationItem{}, &ChannelSync{}, &ChannelOrderFailure{}, &WebhookDelivery{})
 	if err != nil {
 		log.Fatalf("Orders migration failed: %v", err)
 	}
+	if err := db.Exec(externalOrderIndex).Error; err != nil {
+		log.Fatalf("Orders migration failed creating the external order index: %v", err)
+	}
+	if err := db.Exec(channelOrderFailureIndex).Error; err != nil {
+		log.Fatalf("Orders migration failed creating the channel order failure index: %v", err)
+	}
+	if err := db.Exec(webhookDeliveryIndex).Error; err != nil {
+		log.Fatalf("Orders migration failed creating the webhook delivery index: %v", err)
+	}
+
+	// Idempotency keys used to be unique across all clients; they are now scoped to each client
+	if db.Migrator().HasIndex(&OrderIdempotencyKey{}, "idx_order_idempotency_keys_idempotency_key") {
+		if err := db.Migrator().DropIndex(&OrderIdempotencyKey{}, "idx_order_idempotency_keys_idempotency_key"); err != nil {
+			log.Fatalf("Orders migration failed dropping the old idempotency key index: %v", err)
+		}
+	}
+
+	// Convert float line costs from before money was stored in minor units.
+	// Existing orders are assumed to be in the default currency.
+	if db.Migrator().HasColumn(&OrderProd{}, "cost") {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			currency := money.DefaultCurrency()
+			if err := tx.Exec(`UPDATE orders SET currency = ?`, currency).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(`UPDATE order_prods SET cost_minor = ROUND(cost * ?)::bigint`, money.Scale(currency)).Error; err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&OrderProd{}, "cost")
+		})
+		if err != nil {
+			log.Fatalf("Orders migration failed converting costs: %v", err)
+		}
+	}
+
+	// Backfill a shipment for every seller linked to an order created before shipments
+	// were tracked per seller, copying the order's status and tracking number.
+	err = db.Exec(`
+		INSERT INTO order_shipments (order_owner_id, order_id, user_id, status, tracking_number, updated_at)
+		SELECT oo.id, oo.order_id, oo.user_id, COALESCE(NULLIF(o.order_status, ''), ?), o.tracking_number, NOW()
+		FROM order_owners oo
+		JOIN orders o ON o.id = oo.order_id
+		WHERE oo.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM order_shipments s WHERE s.order_owner_id = oo.id)`, StatusPending).Error
+	if err != nil {
+		log.Fatalf("Orders migration failed backfilling shipments: %v", err)
+	}
 	log.Println("Orders database migration complete")
 }
 
-// CreateOrder creates a new order. This endpoint is typically public or requires buyer authentication.
-// It processes the order, updates stock, and links the order to the sellers of the products.
+// linkSeller links a seller to an order with an OrderOwner, and creates the shipment they
+// fulfil their part of the order with. It must run inside a transaction.
+func linkSeller(tx *gorm.DB, orderID, sellerID uint) error {
+	orderOwner := OrderOwner{
+		UserID:  sellerID,
+		OrderID: orderID,
+	}
+	if err := tx.Create(&orderOwner).Error; err != nil {
+		// Check for unique constraint violation (shouldn't happen if logic is correct)
+		log.Printf("Error creating order owner link (User: %d, Order: %d): %v", sellerID, orderID, err)
+		return fmt.Errorf("failed to link seller %d to order", sellerID)
+	}
+	// Each seller fulfils their part of the order independently
+	shipment := OrderShipment{
+		OrderOwnerID: orderOwner.ID,
+		OrderID:      orderID,
+		UserID:       sellerID,
+		Status:       StatusPending,
+	}
+	if err := tx.Create(&shipment).Error; err != nil {
+		log.Printf("Error creating shipment (User: %d, Order: %d): %v", sellerID, orderID, err)
+		return fmt.Errorf("failed to create shipment for seller %d", sellerID)
+	}
+	return nil
+}
+
+// CreateOrder creates a new order on behalf of a seller, either from one of their linked storefronts
+// signing its requests (see storefronttable.VerifySignedRequest) or from their dashboard session.
+// Every order has that one seller: it processes the order, updates stock, and links the order to the seller.
 //
 // @Summary      Creates an order
-// @Description  Creates a new order entry with customer details and products. Updates product stock and links sellers.
+// @Description  Creates a new order entry with customer details and products. Requests are authenticated either as a linked storefront, with X-Channel-ID, X-Channel-Timestamp and X-Channel-Signature headers (hex HMAC-SHA256 with the storefront's signing secret of "<timestamp>.POST./api/create_order." followed by the raw body), or by the seller's session. An order is placed with that one seller: only their products can be ordered, so a cart spanning several sellers is placed as one order per seller. Orders placed before create_order was authenticated may have several sellers, each managing their own shipment and cancellations. Requests are rate limited per storefront or seller. Updates product stock and links the seller. Products with variants must be ordered by variantID, which decrements that variant's stock. Stock rows are locked while they are decremented, so concurrent orders cannot oversell. Alternatively, reservationID converts a stock reservation (see /api/reserve_stock) into the order, using the stock it holds. The seller's discount code, and their tax and shipping rates for the region, are recorded as adjustment lines.
 // @Tags         order
 // @Accept       json
+// @Param        X-Channel-ID header integer false "Storefront link ID, for requests signed by a storefront"
+// @Param        X-Channel-Timestamp header integer false "Unix time the request was signed at, within 5 minutes of the server's clock"
+// @Param        X-Channel-Signature header string false "Hex HMAC-SHA256 signature of the request"
+// @Param        Idempotency-Key header string false "Unique key making the request safe to retry; a replay returns the original response"
 // @Param        orderInfo body OrderCreatePayload true "Order Details"
 // @Success      201  {object} map[string]uint "Order created successfully, returns order ID" // Example success response
-// @Failure      400  {string}  string "Invalid request body, missing fields, or invalid product data"
-// @Failure      404  {string}  string "Product not found or insufficient stock"
+// @Failure      400  {string}  string "Invalid request body, missing fields, invalid product data, region or discount code, insufficient stock, products in different currencies, or invalid Idempotency-Key"
+// @Failure      401  {string}  string "Missing or invalid session or storefront signature"
+// @Failure      403  {string}  string "Product belongs to another seller"
+// @Failure      404  {string}  string "Product or reservation not found"
+// @Failure      409  {string}  string "Idempotency-Key was already used with a different request, or the reservation was released, expired or already converted"
+// @Failure      429  {string}  string "Too many requests from this storefront, seller or address"
 // @Failure      500  {string}  string "Internal server error during order processing"
+// @Security     ApiKeyAuth
 // @Router       /api/create_order [post]
 func CreateOrder(w http.ResponseWriter, r *http.Request) {
-	// Authentication: This endpoint might be public or require buyer auth.
-	// For now, we assume it's public, but seller info is derived from products.
+	// --- Authentication and Rate Limiting ---
+	client, body, ok := admitOrderClient(w, r)
+	if !ok {
+		return
+	}
 
 	var payload OrderCreatePayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	if err := json.Unmarshal(body, &payload); err != nil {
 		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer r.Body.Close()
 
 	// --- Basic Validation ---
 	if strings.TrimSpace(payload.CustomerName) == "" || strings.TrimSpace(payload.CustomerEmail) == "" {
 		http.Error(w, "Customer name and email are required", http.StatusBadRequest)
 		return
 	}
-	if len(payload.OrderedProducts) == 0 {
+	if payload.ReservationID != 0 && len(payload.OrderedProducts) > 0 {
+		http.Error(w, "Order cannot have both orderedProducts and a reservationID", http.StatusBadRequest)
+		return
+	}
+	if payload.ReservationID == 0 && len(payload.OrderedProducts) == 0 {
 		http.Error(w, "Order must contain at least one product", http.StatusBadRequest)
 		return
 	}
+	region, err := pricing.NormalizeRegion(payload.Region)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// --- Idempotency ---
+	// A retried request with the same key replays the original response instead of creating another order
+	idempotencyKey := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+	var requestHash string
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLen {
+			http.Error(w, fmt.Sprintf("%v: at most %d characters", errInvalidIdempotencyKey, maxIdempotencyKeyLen), http.StatusBadRequest)
+			return
+		}
+		if requestHash, err = hashOrderPayload(payload); err != nil {
+			log.Printf("Error hashing order payload: %v", err)
+			http.Error(w, "Internal server error during order processing", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// --- Consolidate Products and Check Stock within a Transaction ---
 	var createdOrderID uint
-	err := db.Transaction(func(tx *gorm.DB) error {
-		consolidatedCount := make(map[uint]uint)
-		productDetails := make(map[uint]prodtable.Product) // Store fetched product details
-		sellerIDs := make(map[uint]bool)
-
-		for _, item := range payload.OrderedProducts {
-			if item.Count <= 0 {
-				return fmt.Errorf("invalid count for product ID %d", item.ProdID) // Return error to rollback
+	var replay *OrderIdempotencyKey
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if idempotencyKey != "" {
+			var err error
+			if replay, err = claimIdempotencyKey(tx, client.key(), idempotencyKey, requestHash); err != nil || replay != nil {
+				return err
 			}
-			consolidatedCount[item.ProdID] += item.Count
 		}
 
-		// Fetch products, check stock, and gather seller IDs
-		for prodID, requestedCount := range consolidatedCount {
-			var product prodtable.Product
-			// Use the transaction tx here
-			if err := tx.First(&product, prodID).Error; err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					return fmt.Errorf("product with ID %d not found", prodID) // Return error to rollback
-				}
-				log.Printf("Error fetching product %d: %v", prodID, err)
-				return fmt.Errorf("database error fetching product %d", prodID) // Return error to rollback
+		items := payload.OrderedProducts
+		var reservation *StockReservation
+		if payload.ReservationID != 0 {
+			// The reservation already holds the stock for its items
+			var err error
+			if reservation, err = lockActiveReservation(tx, payload.ReservationID, client.SellerID); err != nil {
+				return err
 			}
+			items = reservation.orderItems()
+		}
+		ordered, err := resolveOrderLines(tx, items, client.SellerID)
+		if err != nil {
+			return err
+		}
+		currency := ordered.Currency
 
-			if requestedCount > product.ProdCount {
-				return fmt.Errorf("insufficient stock for product ID %d (requested: %d, available: %d)", prodID, requestedCount, product.ProdCount) // Return error to rollback
-			}
-			productDetails[prodID] = product
-			sellerIDs[product.UserID] = true // Track the seller (user) of this product
+		// The subtotal is what the seller's discount, tax and shipping are based on
+		subtotal, err := ordered.subtotal()
+		if err != nil {
+			return err
+		}
+		discountCodes, err := pricing.AssignDiscountCodes(tx, payload.DiscountCodes, []uint{client.SellerID})
+		if err != nil {
+			return err
 		}
 
 		// --- Create Order Record ---
 		order := Order{
 			CustomerName:  payload.CustomerName,
 			CustomerEmail: payload.CustomerEmail,
-			OrderStatus:   "Pending", // Initial status
+			OrderStatus:   StatusPending, // Initial status
+			Currency:      currency,
+			Region:        region,
+			Source:        client.Source,
+			ChannelID:     client.ChannelID,
+			CreatedBy:     client.SellerID,
+			ClientIP:      client.IP,
 			// TrackingNumber and TrackingImage are usually set later
 		}
 		// Use the transaction tx here
@@ -202,44 +375,56 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 		createdOrderID = order.ID // Store the ID for response
 
-		// --- Create OrderOwner Records (Link Sellers) ---
-		for sellerID := range sellerIDs {
-			orderOwner := OrderOwner{
-				UserID:  sellerID,
-				OrderID: order.ID,
-				// Order:   order, // GORM can handle this via OrderID
-			}
-			// Use the transaction tx here
-			if err := tx.Create(&orderOwner).Error; err != nil {
-				// Check for unique constraint violation (shouldn't happen if logic is correct)
-				log.Printf("Error creating order owner link (User: %d, Order: %d): %v", sellerID, order.ID, err)
-				return fmt.Errorf("failed to link seller %d to order", sellerID) // Return error to rollback
+		// Take the ordered stock, unless a reservation already holds it
+		if reservation == nil {
+			change := prodtable.StockChange{Reason: prodtable.StockReasonSale, Reference: fmt.Sprintf("order:%d", order.ID), UserID: client.SellerID}
+			if err := ordered.takeStock(tx, change); err != nil {
+				return err
 			}
 		}
 
-		// --- Create OrderProd Records and Update Product Stock ---
-		for prodID, count := range consolidatedCount {
-			product := productDetails[prodID] // Get pre-fetched product details
+		// --- Create OrderOwner and OrderShipment Records (Link the Seller) ---
+		if err := linkSeller(tx, order.ID, client.SellerID); err != nil {
+			return err // Return error to rollback
+		}
 
-			// Create OrderProd record
-			orderProductRecord := OrderProd{
-				OrderID: order.ID,
-				ProdID:  prodID,
-				Count:   count,
-				Cost:    product.ProdPrice, // Record price at time of order
-			}
-			// Use the transaction tx here
-			if err := tx.Create(&orderProductRecord).Error; err != nil {
-				log.Printf("Error creating order product record (Order: %d, Prod: %d): %v", order.ID, prodID, err)
-				return fmt.Errorf("failed to record product %d in order", prodID) // Return error to rollback
+		// Record the seller's discount, tax and shipping lines
+		lines, err := pricing.Quote(tx, pricing.Request{
+			SellerID:     client.SellerID,
+			Currency:     currency,
+			Region:       region,
+			Subtotal:     subtotal,
+			DiscountCode: discountCodes[client.SellerID],
+		})
+		if err != nil {
+			return err
+		}
+		if err := createAdjustments(tx, order.ID, client.SellerID, lines); err != nil {
+			log.Printf("Error creating adjustments (User: %d, Order: %d): %v", client.SellerID, order.ID, err)
+			return err
+		}
+
+		// --- Create OrderProd Records ---
+		// Record prices at the time of order
+		if err := ordered.createOrderProds(tx, order.ID, nil); err != nil {
+			return err // Return error to rollback
+		}
+
+		if reservation != nil {
+			if err := tx.Model(reservation).Updates(map[string]interface{}{"status": ReservationConverted, "order_id": order.ID}).Error; err != nil {
+				log.Printf("Error converting reservation %d into order %d: %v", reservation.ID, order.ID, err)
+				return errors.New("failed to convert reservation")
 			}
+		}
 
-			// Update product stock count
-			newCount := product.ProdCount - count
-			// Use the transaction tx here
-			if err := tx.Model(&prodtable.Product{}).Where("id = ?", prodID).Update("prod_count", newCount).Error; err != nil {
-				log.Printf("Error updating stock for product %d: %v", prodID, err)
-				return fmt.Errorf("failed to update stock for product %d", prodID) // Return error to rollback
+		if idempotencyKey != "" {
+			response, err := json.Marshal(map[string]uint{"orderID": order.ID})
+			if err != nil {
+				return err
+			}
+			if err := completeIdempotencyKey(tx, client.key(), idempotencyKey, order.ID, http.StatusCreated, response); err != nil {
+				log.Printf("Error recording idempotency key for order %d: %v", order.ID, err)
+				return errors.New("failed to record idempotency key")
 			}
 		}
 
@@ -249,9 +434,19 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 	// --- Handle Transaction Outcome ---
 	if err != nil {
 		// Determine appropriate HTTP status code based on the error
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, errNotClientProduct) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if errors.Is(err, errReservationNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if errors.Is(err, errReservationNotActive) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else if errors.Is(err, errIdempotencyKeyReused) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else if errors.Is(err, pricing.ErrInvalidDiscount) || errors.Is(err, errOrderTooLarge) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "not found") {
 			http.Error(w, err.Error(), http.StatusNotFound)
-		} else if strings.Contains(err.Error(), "insufficient stock") || strings.Contains(err.Error(), "invalid count") {
+		} else if errors.Is(err, prodtable.ErrInsufficientStock) || errors.Is(err, errInvalidOrderLine) {
 			http.Error(w, err.Error(), http.StatusBadRequest) // Or StatusConflict (409)? Bad Request seems ok.
 		} else {
 			// Generic internal server error for other DB issues
@@ -262,6 +457,12 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	// --- Success Response ---
 	w.Header().Set("Content-Type", "application/json")
+	if replay != nil {
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(replay.ResponseCode)
+		io.WriteString(w, replay.ResponseBody)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]uint{"orderID": createdOrderID})
 }
@@ -306,20 +507,8 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 	orderID := uint(orderID64)
 
 	// --- Verify User Ownership (Check OrderOwner) ---
-	var orderOwner OrderOwner
-	if err := db.Where("order_id = ? AND user_id = ?", orderID, userID).First(&orderOwner).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// Check if the order exists at all before returning 403
-			var orderExists Order
-			if errExists := db.First(&orderExists, orderID).Error; errors.Is(errExists, gorm.ErrRecordNotFound) {
-				http.Error(w, fmt.Sprintf("Order with ID %d not found", orderID), http.StatusNotFound)
-			} else {
-				http.Error(w, "Permission denied: You are not associated with this order", http.StatusForbidden)
-			}
-		} else {
-			log.Printf("Error checking order ownership (User: %d, Order: %d): %v", userID, orderID, err)
-			http.Error(w, "Database error checking order ownership", http.StatusInternalServerError)
-		}
+	owner, ok := findOrderOwner(w, orderID, userID)
+	if !ok {
 		return
 	}
 
@@ -339,21 +528,40 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 
 	// --- Filter Products for the Requesting User ---
 	var userProds []OrderProductReturn
-	var totalCost float64 = 0.0
+	var totalCost int64 // Minor units; summed exactly
 	for _, op := range order.OrderProds {
 		// Check if the product within the OrderProd belongs to the current user
 		if op.Prod.UserID == userID {
 			userProd := OrderProductReturn{
-				ProdID:   op.ProdID,
-				ProdName: op.Prod.ProdName,
-				Count:    op.Count,
-				Price:    op.Cost, // Use the cost stored at the time of order
+				ProdID:         op.ProdID,
+				ProdName:       op.Prod.ProdName,
+				VariantID:      op.VariantID,
+				VariantTitle:   op.VariantTitle,
+				Count:          op.Count,
+				CancelledCount: op.CancelledCount,
+				Price:          money.Number(op.CostMinor, order.Currency), // Use the cost stored at the time of order
 			}
-			totalCost += (op.Cost * float64(op.Count))
+			totalCost += op.CostMinor * int64(op.Count-op.CancelledCount) // Cancelled quantities are not charged
 			userProds = append(userProds, userProd)
 		}
 	}
 
+	// --- Fetch the User's Shipment (if any) ---
+	var shipment OrderShipment
+	if err := db.Where("order_owner_id = ?", owner.ID).First(&shipment).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error fetching shipment for order %d (User: %d): %v", orderID, userID, err)
+		http.Error(w, "Database error fetching shipment details", http.StatusInternalServerError)
+		return
+	}
+
+	// --- Fetch the User's Adjustment Lines ---
+	var adjustments []OrderAdjustment
+	if err := db.Where("order_id = ? AND user_id = ?", orderID, userID).Order("id asc").Find(&adjustments).Error; err != nil {
+		log.Printf("Error fetching adjustments for order %d (User: %d): %v", orderID, userID, err)
+		http.Error(w, "Database error fetching order adjustments", http.StatusInternalServerError)
+		return
+	}
+
 	// --- Construct and Return Response ---
 	// Even if userProds is empty, return the main order details
 	orderRet := OrderReturn{
@@ -362,10 +570,14 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 		CustomerEmail:   order.CustomerEmail,
 		OrderDate:       order.OrderDate.Format(time.RFC3339), // Standard format
 		OrderStatus:     order.OrderStatus,
-		TrackingNumber:  order.TrackingNumber,
-		Total:           totalCost, // Total for *user's items only*
+		Source:          order.Source,
+		ChannelID:       order.ChannelID,
+		ExternalID:      order.ExternalID,
+		Currency:        order.Currency,
 		OrderedProducts: userProds, // Will be [] if user owns no items in this order
 	}
+	applyTotals(&orderRet, totalCost, adjustments) // Totals for *user's items only*
+	applyShipment(&orderRet, order, shipment)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -375,12 +587,27 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetOrders retrieves all orders containing products sold by the logged-in user.
+// GetOrders retrieves a page of orders containing products sold by the logged-in user.
+// Filtering, sorting and pagination are all done in SQL; the total number of matching
+// orders is reported in the X-Total-Count header.
 //
 // @Summary      Retrieve user's sales orders
-// @Description  Retrieves orders containing products sold by the authenticated user, along with the relevant product details for each order.
+// @Description  Retrieves orders containing products sold by the authenticated user, along with the relevant product details for each order. Results are paginated; X-Total-Count, X-Page and X-Page-Size response headers describe the full result set.
 // @Tags         order
+// @Param        status        query string  false "Only orders where the seller's shipment has this status"
+// @Param        from          query string  false "Only orders placed on or after this date (YYYY-MM-DD or RFC 3339)"
+// @Param        to            query string  false "Only orders placed on or before this date (YYYY-MM-DD or RFC 3339)"
+// @Param        customerEmail query string  false "Only orders placed with this customer email (case-insensitive)"
+// @Param        productID     query integer false "Only orders containing this product"
+// @Param        sort          query string  false "Sort key: date (default) or total (the seller's share)"
+// @Param        order         query string  false "Sort direction: desc (default) or asc"
+// @Param        page          query integer false "Page number, starting at 1 (default 1)"
+// @Param        pageSize      query integer false "Orders per page (default 50, max 200)"
 // @Success      200  {array}  OrderReturn "JSON array of orders relevant to the user (empty array if none)"
+// @Header       200  {integer} X-Total-Count "Total number of orders matching the filters"
+// @Header       200  {integer} X-Page "Page returned"
+// @Header       200  {integer} X-Page-Size "Page size used"
+// @Failure      400  {string}  string "Invalid filter, sort or pagination parameter"
 // @Failure      401  {string}  string "User not authenticated"
 // @Failure      500  {string}  string "Internal server error"
 // @Security     ApiKeyAuth
@@ -400,82 +627,130 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 	userID := user.ID
 
-	// --- Fetch Order IDs associated with the User (Seller) ---
-	var userOrderOwners []OrderOwner
-	// Preload the main Order details along with the OrderOwner link
-	if err := db.Preload("Order").Where("user_id = ?", userID).Find(&userOrderOwners).Error; err != nil {
-		log.Printf("Error fetching order ownerships for user %d: %v", userID, err)
+	params, err := parseOrderListParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// --- Count and Fetch the Requested Page of Order IDs ---
+	var total int64
+	if err := params.filterQuery(db, userID).Count(&total).Error; err != nil {
+		log.Printf("Error counting orders for user %d: %v", userID, err)
+		http.Error(w, "Database error fetching user orders", http.StatusInternalServerError)
+		return
+	}
+
+	var orderIDs []uint
+	if err := params.filterQuery(db, userID).
+		Clauses(params.orderBy(userID)).
+		Limit(params.PageSize).
+		Offset((params.Page-1)*params.PageSize).
+		Pluck("o.id", &orderIDs).Error; err != nil {
+		log.Printf("Error fetching order page for user %d: %v", userID, err)
 		http.Error(w, "Database error fetching user orders", http.StatusInternalServerError)
 		return
 	}
 
 	// --- Process Each Order ---
-	// var orderReturns []OrderReturn
-	orderReturns := make([]OrderReturn, 0)
-	if len(userOrderOwners) > 0 {
-		orderIDs := make([]uint, len(userOrderOwners))
-		orderMap := make(map[uint]Order) // Map order ID to preloaded Order details
-		for i, owner := range userOrderOwners {
-			orderIDs[i] = owner.OrderID
-			orderMap[owner.OrderID] = owner.Order // Store the preloaded Order
+	orderReturns := make([]OrderReturn, 0, len(orderIDs))
+	if len(orderIDs) > 0 {
+		var orders []Order
+		if err := db.Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+			log.Printf("Error fetching orders for user %d: %v", userID, err)
+			http.Error(w, "Database error fetching user orders", http.StatusInternalServerError)
+			return
+		}
+		orderMap := make(map[uint]Order, len(orders))
+		for _, order := range orders {
+			orderMap[order.ID] = order
 		}
 
-		// Fetch all relevant OrderProd items in one go
+		// Fetch only the user's OrderProd items for this page
 		var allOrderProds []OrderProd
-		if err := db.Preload("Prod").Where("order_id IN ?", orderIDs).Find(&allOrderProds).Error; err != nil {
+		if err := db.Preload("Prod").
+			Joins("JOIN products ON products.id = order_prods.prod_id").
+			Where("order_prods.order_id IN ? AND products.user_id = ?", orderIDs, userID).
+			Find(&allOrderProds).Error; err != nil {
 			log.Printf("Error fetching order products for user %d orders: %v", userID, err)
 			http.Error(w, "Database error fetching order products", http.StatusInternalServerError)
 			return
 		}
 
+		// Fetch the user's shipments for these orders
+		var shipments []OrderShipment
+		if err := db.Where("user_id = ? AND order_id IN ?", userID, orderIDs).Find(&shipments).Error; err != nil {
+			log.Printf("Error fetching shipments for user %d orders: %v", userID, err)
+			http.Error(w, "Database error fetching shipment details", http.StatusInternalServerError)
+			return
+		}
+		shipmentByOrderID := make(map[uint]OrderShipment, len(shipments))
+		for _, s := range shipments {
+			shipmentByOrderID[s.OrderID] = s
+		}
+
+		// Fetch the user's adjustment lines for these orders
+		var adjustments []OrderAdjustment
+		if err := db.Where("user_id = ? AND order_id IN ?", userID, orderIDs).Order("id asc").Find(&adjustments).Error; err != nil {
+			log.Printf("Error fetching adjustments for user %d orders: %v", userID, err)
+			http.Error(w, "Database error fetching order adjustments", http.StatusInternalServerError)
+			return
+		}
+		adjustmentsByOrderID := make(map[uint][]OrderAdjustment)
+		for _, a := range adjustments {
+			adjustmentsByOrderID[a.OrderID] = append(adjustmentsByOrderID[a.OrderID], a)
+		}
+
 		// Group OrderProds by OrderID
 		prodsByOrderID := make(map[uint][]OrderProd)
 		for _, op := range allOrderProds {
 			prodsByOrderID[op.OrderID] = append(prodsByOrderID[op.OrderID], op)
 		}
 
-		// Construct OrderReturn for each order the user is linked to
-		for _, owner := range userOrderOwners {
-			order := orderMap[owner.OrderID] // Get the preloaded Order details
-			orderProds := prodsByOrderID[owner.OrderID]
-
-			var userProdsInOrder []OrderProductReturn
-			var totalCost float64 = 0.0
-
-			for _, op := range orderProds {
-				// Filter for products owned by the current user
-				if op.Prod.UserID == userID {
-					userProd := OrderProductReturn{
-						ProdID:   op.ProdID,
-						ProdName: op.Prod.ProdName,
-						Count:    op.Count,
-						Price:    op.Cost,
-					}
-					totalCost += (op.Cost * float64(op.Count))
-					userProdsInOrder = append(userProdsInOrder, userProd)
+		// Construct OrderReturn for each order, keeping the SQL sort order
+		for _, orderID := range orderIDs {
+			order := orderMap[orderID]
+
+			userProdsInOrder := make([]OrderProductReturn, 0, len(prodsByOrderID[orderID]))
+			var totalCost int64
+			for _, op := range prodsByOrderID[orderID] {
+				userProd := OrderProductReturn{
+					ProdID:         op.ProdID,
+					ProdName:       op.Prod.ProdName,
+					VariantID:      op.VariantID,
+					VariantTitle:   op.VariantTitle,
+					Count:          op.Count,
+					CancelledCount: op.CancelledCount,
+					Price:          money.Number(op.CostMinor, order.Currency),
 				}
+				totalCost += op.CostMinor * int64(op.Count-op.CancelledCount)
+				userProdsInOrder = append(userProdsInOrder, userProd)
 			}
 
-			// Only include the order in the response if the user sold items in it
-			if len(userProdsInOrder) > 0 {
-				orderInfo := OrderReturn{
-					OrderID:         order.ID,
-					CustomerName:    order.CustomerName,
-					CustomerEmail:   order.CustomerEmail,
-					OrderDate:       order.OrderDate.Format(time.RFC3339),
-					OrderStatus:     order.OrderStatus,
-					TrackingNumber:  order.TrackingNumber,
-					Total:           totalCost,
-					OrderedProducts: userProdsInOrder,
-				}
-				orderReturns = append(orderReturns, orderInfo)
+			orderInfo := OrderReturn{
+				OrderID:         order.ID,
+				CustomerName:    order.CustomerName,
+				CustomerEmail:   order.CustomerEmail,
+				OrderDate:       order.OrderDate.Format(time.RFC3339),
+				OrderStatus:     order.OrderStatus,
+				Source:          order.Source,
+				ChannelID:       order.ChannelID,
+				ExternalID:      order.ExternalID,
+				Currency:        order.Currency,
+				OrderedProducts: userProdsInOrder,
 			}
+			applyTotals(&orderInfo, totalCost, adjustmentsByOrderID[orderID])
+			applyShipment(&orderInfo, order, shipmentByOrderID[orderID])
+			orderReturns = append(orderReturns, orderInfo)
 		}
 	}
 
 	// --- Return Response ---
 	// Return empty array `[]` if no relevant orders found
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	w.Header().Set("X-Page", strconv.Itoa(params.Page))
+	w.Header().Set("X-Page-Size", strconv.Itoa(params.PageSize))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(orderReturns); err != nil {
 		log.Printf("Error encoding orders response for user %d: %v", userID, err)
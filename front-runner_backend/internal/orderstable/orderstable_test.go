@@ -26,8 +26,11 @@ import (
 
 	"front-runner/internal/coredbutils"
 	"front-runner/internal/login"
+	"front-runner/internal/money"
 	"front-runner/internal/oauth"
+	"front-runner/internal/pricing"
 	"front-runner/internal/prodtable" // Need product table structs and functions
+	"front-runner/internal/storefronttable"
 	"front-runner/internal/usertable"
 )
 
@@ -87,6 +90,8 @@ func setupTestEnvironment(t *testing.T) {
 		// Setup dependent packages
 		usertable.Setup()                     // Uses coredbutils.GetDB()
 		prodtable.Setup()                     // Uses coredbutils.GetDB()
+		pricing.Setup()                       // Uses coredbutils.GetDB()
+		storefronttable.Setup()               // Uses coredbutils.GetDB() and loads the storefront key
 		oauth.Setup(testSessionStore)         // Uses session store
 		login.Setup(testDB, testSessionStore) // Uses DB and session store
 		Setup()                               // Setup orderstable package (uses coredbutils.GetDB())
@@ -94,20 +99,39 @@ func setupTestEnvironment(t *testing.T) {
 		// Run migrations once after setup
 		usertable.MigrateUserDB()
 		prodtable.MigrateProdDB()
-		MigrateOrdersDB() // Migrates Order, OrderProd, OrderOwner tables
+		pricing.MigratePricingDB()
+		storefronttable.MigrateStorefrontDB()
+		MigrateOrdersDB() // Migrates Order, OrderProd, OrderOwner, OrderStatusHistory, OrderCancellation, OrderShipment, OrderAdjustment tables
 	})
 
-	// 1. Clear dependent tables first (OrderOwner, OrderProd)
+	// 1. Clear dependent tables first (WebhookDelivery, ChannelOrderFailure, ChannelSync, StockReservationItem, StockReservation, OrderAdjustment, OrderIdempotencyKey, OrderShipment, OrderStatusHistory, OrderCancellation, OrderOwner, OrderProd)
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&WebhookDelivery{}).Error, "Failed to clear webhook_deliveries table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ChannelOrderFailure{}).Error, "Failed to clear channel_order_failures table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ChannelSync{}).Error, "Failed to clear channel_syncs table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockReservationItem{}).Error, "Failed to clear stock_reservation_items table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockReservation{}).Error, "Failed to clear stock_reservations table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderAdjustment{}).Error, "Failed to clear order_adjustments table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderIdempotencyKey{}).Error, "Failed to clear order_idempotency_keys table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderStatusHistory{}).Error, "Failed to clear order_status_histories table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderShipment{}).Error, "Failed to clear order_shipments table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderCancellation{}).Error, "Failed to clear order_cancellations table")
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderOwner{}).Error, "Failed to clear order_owners table")
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&OrderProd{}).Error, "Failed to clear order_prods table")
 
+	// Clear the sellers' tax, shipping and discount settings
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&pricing.TaxRate{}).Error, "Failed to clear tax_rates table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&pricing.ShippingRate{}).Error, "Failed to clear shipping_rates table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&pricing.DiscountCode{}).Error, "Failed to clear discount_codes table")
+
 	// 2. Clear tables that depend on others (Order, Product)
 	// Order uses Unscoped() just in case, though it doesn't have gorm.Model by default
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Order{}).Error, "Failed to clear orders table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.StockMovement{}).Error, "Failed to clear stock_movements table")
 	// Product uses Unscoped() as it might have soft delete hooks or relations
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.Product{}).Error, "Failed to clear product table")
 
-	// 3. Clear tables that are depended upon (Image, User)
+	// 3. Clear tables that are depended upon (StorefrontLink, Image, User)
+	require.NoError(t, storefronttable.ClearStorefrontTable(testDB), "Failed to clear storefront table")
 	// Image uses Unscoped()
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.Image{}).Error, "Failed to clear image table")
 	// Assuming ClearUserTable handles its own potential soft delete logic if needed, or use Unscoped here too if necessary.
@@ -156,7 +180,7 @@ func createAuthenticatedRequest(t *testing.T, user *usertable.User, method, url
 }
 
 // Helper to create a test product directly in the DB
-func createTestProduct(t *testing.T, owner *usertable.User, name string, price float64, count uint) *prodtable.Product {
+func createTestProduct(t *testing.T, owner *usertable.User, name string, priceMinor int64, count uint) *prodtable.Product {
 	t.Helper()
 	// Create a dummy image record first (required by product schema)
 	// No need to create actual file for order tests unless testing image links later
@@ -172,9 +196,8 @@ func createTestProduct(t *testing.T, owner *usertable.User, name string, price f
 		ProdName:        name,
 		ProdDescription: fmt.Sprintf("Description for %s", name),
 		ImgID:           dummyImage.ID,
-		ProdPrice:       price,
+		PriceMinor:      priceMinor,
 		ProdCount:       count,
-		ProdTags:        "test",
 	}
 	err = testDB.Create(product).Error
 	require.NoError(t, err, "Failed to create test product %s", name)
@@ -198,10 +221,10 @@ func createTestOrder(t *testing.T, customerName, customerEmail string, products
 	sellerIDs := make(map[uint]bool)
 	for product, count := range products {
 		orderProd := &OrderProd{
-			OrderID: order.ID,
-			ProdID:  product.ID,
-			Count:   count,
-			Cost:    product.ProdPrice, // Record price at time of order
+			OrderID:   order.ID,
+			ProdID:    product.ID,
+			Count:     count,
+			CostMinor: product.PriceMinor, // Record price at time of order
 		}
 		err = testDB.Create(orderProd).Error
 		require.NoError(t, err, "Failed to create order_prod record for product %d", product.ID)
@@ -230,8 +253,8 @@ func createTestOrder(t *testing.T, customerName, customerEmail string, products
 func TestCreateOrder(t *testing.T) {
 	setupTestEnvironment(t)
 	seller := createTestUser(t, "seller@example.com", "password")
-	product1 := createTestProduct(t, seller, "Gadget", 10.50, 5)
-	product2 := createTestProduct(t, seller, "Widget", 5.25, 10)
+	product1 := createTestProduct(t, seller, "Gadget", 1050, 5)
+	product2 := createTestProduct(t, seller, "Widget", 525, 10)
 
 	t.Run("Success", func(t *testing.T) {
 		payload := OrderCreatePayload{
@@ -244,8 +267,8 @@ func TestCreateOrder(t *testing.T) {
 		}
 		bodyBytes, _ := json.Marshal(payload)
 
-		// Note: CreateOrder doesn't require authentication in the current implementation
-		req := httptest.NewRequest("POST", "/api/create_order", bytes.NewReader(bodyBytes))
+		// Orders are placed with the seller's session (or a signed storefront request)
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(bodyBytes))
 		req.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 
@@ -298,7 +321,7 @@ func TestCreateOrder(t *testing.T) {
 		}
 		bodyBytes, _ := json.Marshal(payload)
 
-		req := httptest.NewRequest("POST", "/api/create_order", bytes.NewReader(bodyBytes))
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(bodyBytes))
 		req.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 
@@ -333,7 +356,7 @@ func TestCreateOrder(t *testing.T) {
 		}
 		bodyBytes, _ := json.Marshal(payload)
 
-		req := httptest.NewRequest("POST", "/api/create_order", bytes.NewReader(bodyBytes))
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(bodyBytes))
 		req.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 
@@ -349,6 +372,48 @@ func TestCreateOrder(t *testing.T) {
 		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
 	})
 
+	t.Run("MixedCurrencies", func(t *testing.T) {
+		euroProduct := createTestProduct(t, seller, "Euro Gadget", 900, 5)
+		require.NoError(t, testDB.Model(euroProduct).Update("currency", "EUR").Error)
+		payload := OrderCreatePayload{
+			CustomerName:  "Currency Customer",
+			CustomerEmail: "currency@test.com",
+			OrderedProducts: []OrderProductPayload{
+				{ProdID: product2.ID, Count: 1},
+				{ProdID: euroProduct.ID, Count: 1},
+			},
+		}
+		bodyBytes, _ := json.Marshal(payload)
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		CreateOrder(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Products priced in different currencies cannot share an order")
+		assert.Contains(t, rr.Body.String(), "mixed currencies")
+	})
+
+	t.Run("TotalTooLarge", func(t *testing.T) {
+		pricey := createTestProduct(t, seller, "Pricey Gadget", money.MaxMinor, 5)
+		payload := OrderCreatePayload{
+			CustomerName:  "Large Customer",
+			CustomerEmail: "large@test.com",
+			OrderedProducts: []OrderProductPayload{
+				{ProdID: pricey.ID, Count: 2},
+			},
+		}
+		bodyBytes, _ := json.Marshal(payload)
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		CreateOrder(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Subtotals beyond money.MaxMinor are rejected rather than overflowing")
+		assert.Contains(t, rr.Body.String(), errOrderTooLarge.Error())
+	})
+
 	// Add more tests for:
 	// - Invalid JSON
 	// - Missing customer name/email
@@ -356,12 +421,102 @@ func TestCreateOrder(t *testing.T) {
 	// - Zero count for a product
 }
 
+func TestCreateOrder_Variants(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "variantseller@example.com", "password")
+	tee := createTestProduct(t, seller, "Variant Tee", 1500, 0)
+	require.NoError(t, testDB.Create(&prodtable.ProductOption{ProductID: tee.ID, Name: "Size", Values: []string{"S", "M"}}).Error)
+	small := prodtable.ProductVariant{ProductID: tee.ID, UserID: seller.ID, SKU: "VT-S", Options: map[string]string{"Size": "S"},
+		OptionsKey: "size=s", Title: "S", PriceMinor: 1400, Count: 3}
+	medium := prodtable.ProductVariant{ProductID: tee.ID, UserID: seller.ID, SKU: "VT-M", Options: map[string]string{"Size": "M"},
+		OptionsKey: "size=m", Title: "M", PriceMinor: 1600, Count: 2}
+	require.NoError(t, testDB.Create(&small).Error)
+	require.NoError(t, testDB.Create(&medium).Error)
+	require.NoError(t, prodtable.SyncVariantStock(testDB, tee.ID))
+
+	createOrder := func(t *testing.T, email string, items ...OrderProductPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(OrderCreatePayload{CustomerName: "Variant Customer", CustomerEmail: email, OrderedProducts: items})
+		req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, req)
+		return rr
+	}
+	variantCount := func(t *testing.T, id uint) uint {
+		t.Helper()
+		var v prodtable.ProductVariant
+		require.NoError(t, testDB.First(&v, id).Error)
+		return v.Count
+	}
+	productCount := func(t *testing.T) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, tee.ID).Error)
+		return p.ProdCount
+	}
+
+	t.Run("RequiresVariant", func(t *testing.T) {
+		rr := createOrder(t, "novariant@test.com", OrderProductPayload{ProdID: tee.ID, Count: 1})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	t.Run("VariantOfOtherProduct", func(t *testing.T) {
+		other := createTestProduct(t, seller, "Other Product", 500, 5)
+		rr := createOrder(t, "mismatch@test.com", OrderProductPayload{ProdID: other.ID, VariantID: small.ID, Count: 1})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	var orderID uint
+	t.Run("DecrementsVariantStock", func(t *testing.T) {
+		rr := createOrder(t, "variant@test.com",
+			OrderProductPayload{VariantID: small.ID, Count: 1},
+			OrderProductPayload{ProdID: tee.ID, VariantID: small.ID, Count: 1},
+			OrderProductPayload{VariantID: medium.ID, Count: 2},
+		)
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var resp map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		orderID = resp["orderID"]
+
+		assert.Equal(t, uint(1), variantCount(t, small.ID))
+		assert.Equal(t, uint(0), variantCount(t, medium.ID))
+		assert.Equal(t, uint(1), productCount(t), "Product stock is the variants' total")
+
+		var lines []OrderProd
+		require.NoError(t, testDB.Where("order_id = ?", orderID).Order("variant_id").Find(&lines).Error)
+		require.Len(t, lines, 2, "Lines are consolidated per variant")
+		assert.Equal(t, uint(2), lines[0].Count)
+		assert.Equal(t, int64(1400), lines[0].CostMinor, "The variant's price is charged")
+		assert.Equal(t, "M", lines[1].VariantTitle)
+	})
+
+	t.Run("InsufficientVariantStock", func(t *testing.T) {
+		rr := createOrder(t, "variantstock@test.com", OrderProductPayload{VariantID: medium.ID, Count: 1})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "insufficient stock")
+	})
+
+	t.Run("CancelRestocksVariant", func(t *testing.T) {
+		body, _ := json.Marshal(OrderCancelPayload{
+			Reason: "Wrong size",
+			Items:  []OrderCancelItem{{ProdID: tee.ID, VariantID: medium.ID, Count: 1}},
+		})
+		req := createAuthenticatedRequest(t, seller, "POST", fmt.Sprintf("/api/cancel_order?id=%d", orderID), bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		CancelOrder(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(1), variantCount(t, medium.ID))
+		assert.Equal(t, uint(2), productCount(t))
+	})
+}
+
 func TestGetOrder(t *testing.T) {
 	setupTestEnvironment(t)
 	seller1 := createTestUser(t, "seller1@example.com", "password")
 	seller2 := createTestUser(t, "seller2@example.com", "password")
-	productS1 := createTestProduct(t, seller1, "S1 Prod", 20.00, 10)
-	productS2 := createTestProduct(t, seller2, "S2 Prod", 30.00, 5)
+	productS1 := createTestProduct(t, seller1, "S1 Prod", 2000, 10)
+	productS2 := createTestProduct(t, seller2, "S2 Prod", 3000, 5)
 
 	// Create a test order with items from both sellers
 	testOrder := createTestOrder(t, "GetOrder Cust", "getorder@test.com", map[*prodtable.Product]uint{
@@ -391,8 +546,9 @@ func TestGetOrder(t *testing.T) {
 		assert.Equal(t, productS1.ID, resp.OrderedProducts[0].ProdID)
 		assert.Equal(t, productS1.ProdName, resp.OrderedProducts[0].ProdName)
 		assert.Equal(t, uint(2), resp.OrderedProducts[0].Count)
-		assert.Equal(t, productS1.ProdPrice, resp.OrderedProducts[0].Price) // Price at time of order
-		assert.Equal(t, 20.00*2, resp.Total, "Total should be for Seller 1's items only")
+		assert.Equal(t, json.Number("20.00"), resp.OrderedProducts[0].Price) // Price at time of order
+		assert.Equal(t, json.Number("40.00"), resp.Total, "Total should be for Seller 1's items only")
+		assert.Equal(t, "USD", resp.Currency)
 	})
 
 	t.Run("Success_Seller2_OwnsItems", func(t *testing.T) {
@@ -410,7 +566,7 @@ func TestGetOrder(t *testing.T) {
 		require.Len(t, resp.OrderedProducts, 1, "Seller 2 should see 1 product they own")
 		assert.Equal(t, productS2.ID, resp.OrderedProducts[0].ProdID)
 		assert.Equal(t, uint(1), resp.OrderedProducts[0].Count)
-		assert.Equal(t, 30.00*1, resp.Total, "Total should be for Seller 2's items only")
+		assert.Equal(t, json.Number("30.00"), resp.Total, "Total should be for Seller 2's items only")
 	})
 
 	t.Run("Forbidden_UserNotLinked", func(t *testing.T) {
@@ -456,9 +612,9 @@ func TestGetOrders(t *testing.T) {
 	setupTestEnvironment(t)
 	seller1 := createTestUser(t, "getseller1@example.com", "password")
 	seller2 := createTestUser(t, "getseller2@example.com", "password")
-	prodS1A := createTestProduct(t, seller1, "S1 Prod A", 10.00, 10)
-	prodS1B := createTestProduct(t, seller1, "S1 Prod B", 15.00, 5)
-	prodS2 := createTestProduct(t, seller2, "S2 Prod Only", 25.00, 8)
+	prodS1A := createTestProduct(t, seller1, "S1 Prod A", 1000, 10)
+	prodS1B := createTestProduct(t, seller1, "S1 Prod B", 1500, 5)
+	prodS2 := createTestProduct(t, seller2, "S2 Prod Only", 2500, 8)
 
 	// Order 1: Contains items from Seller 1 only
 	order1 := createTestOrder(t, "Cust 1", "cust1@test.com", map[*prodtable.Product]uint{
@@ -499,12 +655,12 @@ func TestGetOrders(t *testing.T) {
 			if orderResp.OrderID == order1.ID {
 				foundOrder1 = true
 				assert.Len(t, orderResp.OrderedProducts, 2, "Order 1 response should have 2 products for Seller 1")
-				assert.Equal(t, (10.00*1)+(15.00*2), orderResp.Total) // 10 + 30 = 40
+				assert.Equal(t, json.Number("40.00"), orderResp.Total) // 10 + 30 = 40
 			} else if orderResp.OrderID == order2.ID {
 				foundOrder2 = true
 				assert.Len(t, orderResp.OrderedProducts, 1, "Order 2 response should have 1 product for Seller 1")
 				assert.Equal(t, prodS1A.ID, orderResp.OrderedProducts[0].ProdID)
-				assert.Equal(t, 10.00*3, orderResp.Total) // 30
+				assert.Equal(t, json.Number("30.00"), orderResp.Total) // 30
 			}
 		}
 		assert.True(t, foundOrder1, "Response for Order 1 not found")
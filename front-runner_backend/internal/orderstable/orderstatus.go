@@ -0,0 +1,405 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/oauth"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Order status values. Each seller's shipment starts as StatusPending and moves
+// through the lifecycle defined by allowedTransitions. The Order's own status is
+// derived from its shipments by aggregateOrderStatus.
+const (
+	StatusPending    = "Pending"
+	StatusProcessing = "Processing"
+	StatusShipped    = "Shipped"
+	StatusDelivered  = "Delivered"
+	StatusCancelled  = "Cancelled"
+	StatusRefunded   = "Refunded"
+)
+
+// allowedTransitions maps each status to the statuses it may move to.
+// Refunded is terminal.
+var allowedTransitions = map[string][]string{
+	StatusPending:    {StatusProcessing, StatusCancelled},
+	StatusProcessing: {StatusShipped, StatusCancelled},
+	StatusShipped:    {StatusDelivered, StatusRefunded},
+	StatusDelivered:  {StatusRefunded},
+	StatusCancelled:  {StatusRefunded},
+	StatusRefunded:   {},
+}
+
+var (
+	errUnknownStatus     = errors.New("unknown order status")
+	errIllegalTransition = errors.New("illegal status transition")
+)
+
+// defaultStatusCancelReason is recorded when a seller cancels through UpdateOrderStatus without a note.
+const defaultStatusCancelReason = "Cancelled by status update"
+
+// statusRank orders the active statuses along the fulfilment lifecycle.
+// An order is only as far along as its least advanced shipment.
+var statusRank = map[string]int{
+	StatusPending:    0,
+	StatusProcessing: 1,
+	StatusShipped:    2,
+	StatusDelivered:  3,
+}
+
+// OrderStatusHistory records a single status change made to a seller's shipment.
+type OrderStatusHistory struct {
+	ID              uint      `gorm:"primaryKey"`
+	OrderID         uint      `gorm:"not null;index"`
+	OrderShipmentID uint      `gorm:"index"` // Shipment that changed; 0 for changes recorded before shipments were per seller
+	FromStatus      string    `gorm:"not null"`
+	ToStatus        string    `gorm:"not null"`
+	ChangedBy       uint      `gorm:"not null;index"` // User ID of the seller who made the change
+	Note            string    // Optional free-text reason supplied with the change
+	ChangedAt       time.Time `gorm:"autoCreateTime"`
+}
+
+// OrderStatusUpdatePayload is used to decode the JSON body when updating an order's status.
+type OrderStatusUpdatePayload struct {
+	Status string `json:"status"` // Target status, e.g. "Processing"
+	Note   string `json:"note"`   // Optional reason for the change
+}
+
+// OrderStatusHistoryReturn is returned to the frontend for each recorded status change.
+type OrderStatusHistoryReturn struct {
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+	ChangedBy  uint   `json:"changedBy"`
+	Note       string `json:"note"`
+	ChangedAt  string `json:"changedAt"` // Formatted date string
+}
+
+// normalizeStatus maps a case-insensitive status name onto its canonical form.
+// It returns an empty string if the status is not recognised.
+func normalizeStatus(status string) string {
+	status = strings.TrimSpace(status)
+	for known := range allowedTransitions {
+		if strings.EqualFold(known, status) {
+			return known
+		}
+	}
+	return ""
+}
+
+// validateStatusTransition checks that an order may move from one status to another.
+func validateStatusTransition(from, to string) error {
+	next, ok := allowedTransitions[from]
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownStatus, from)
+	}
+	if _, ok := allowedTransitions[to]; !ok {
+		return fmt.Errorf("%w: %q", errUnknownStatus, to)
+	}
+	for _, allowed := range next {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from %s to %s", errIllegalTransition, from, to)
+}
+
+// aggregateOrderStatus derives an order's overall status from its shipment statuses.
+// While any shipment is still active the order takes the least advanced active status.
+// Once none are, the order is Cancelled if every shipment was cancelled and Refunded otherwise.
+func aggregateOrderStatus(statuses []string) string {
+	overall := ""
+	anyRefunded := false
+	for _, status := range statuses {
+		rank, active := statusRank[status]
+		if !active {
+			anyRefunded = anyRefunded || status == StatusRefunded
+			continue
+		}
+		if overall == "" || rank < statusRank[overall] {
+			overall = status
+		}
+	}
+	switch {
+	case overall != "":
+		return overall
+	case anyRefunded:
+		return StatusRefunded
+	case len(statuses) > 0:
+		return StatusCancelled
+	default:
+		return StatusPending
+	}
+}
+
+// transitionShipmentStatus moves a seller's shipment to a new status, records the change
+// in OrderStatusHistory, and refreshes the order's aggregate status. The caller must run
+// it inside a transaction and pass an order row that has been locked for update.
+func transitionShipmentStatus(tx *gorm.DB, order *Order, shipment *OrderShipment, to string, changedBy uint, note string) error {
+	if err := validateStatusTransition(shipment.Status, to); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"status": to}
+	now := time.Now()
+	switch to {
+	case StatusShipped:
+		updates["shipped_at"] = now
+		shipment.ShippedAt = &now
+	case StatusDelivered:
+		updates["delivered_at"] = now
+		shipment.DeliveredAt = &now
+	}
+	if err := tx.Model(shipment).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update shipment status: %w", err)
+	}
+	history := OrderStatusHistory{
+		OrderID:         order.ID,
+		OrderShipmentID: shipment.ID,
+		FromStatus:      shipment.Status,
+		ToStatus:        to,
+		ChangedBy:       changedBy,
+		Note:            strings.TrimSpace(note),
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return fmt.Errorf("failed to record status history: %w", err)
+	}
+	shipment.Status = to
+	return syncOrderStatus(tx, order)
+}
+
+// syncOrderStatus recomputes the order's aggregate status from its shipments and saves it if it changed.
+func syncOrderStatus(tx *gorm.DB, order *Order) error {
+	var statuses []string
+	if err := tx.Model(&OrderShipment{}).Where("order_id = ?", order.ID).Pluck("status", &statuses).Error; err != nil {
+		return fmt.Errorf("failed to fetch shipment statuses: %w", err)
+	}
+	overall := aggregateOrderStatus(statuses)
+	if overall == order.OrderStatus {
+		return nil
+	}
+	if err := tx.Model(order).Update("order_status", overall).Error; err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+	order.OrderStatus = overall
+	return nil
+}
+
+// UpdateOrderStatus moves the authenticated seller's shipment in an order to a new status,
+// enforcing the order lifecycle. The order's overall status is recomputed from all shipments.
+//
+// @Summary      Update an order's status
+// @Description  Moves the authenticated seller's own shipment in an order into a new status. Other sellers' shipments are unaffected; the order's overall status follows the least advanced shipment. Allowed transitions: Pending → Processing → Shipped → Delivered; Pending/Processing → Cancelled; Shipped/Delivered/Cancelled → Refunded. Moving to Cancelled cancels all of the seller's outstanding items and restocks them, like /api/cancel_order, with the note as the reason.
+// @Tags         order
+// @Accept       json
+// @Param        id   query integer true "Order ID"
+// @Param        statusUpdate body OrderStatusUpdatePayload true "Target status and optional note"
+// @Success      200  {object}  map[string]string "The seller's new status (status) and the order's overall status (overallStatus)"
+// @Failure      400  {string}  string "Invalid Order ID, request body, or unknown status"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (user is not a seller for any product in this order)"
+// @Failure      404  {string}  string "Order not found"
+// @Failure      409  {string}  string "Illegal status transition, or nothing left to cancel"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/update_order_status [put]
+func UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("UpdateOrderStatus: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	userID := user.ID
+
+	// --- Get and Validate Order ID ---
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+	orderID := uint(orderID64)
+
+	var payload OrderStatusUpdatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	target := normalizeStatus(payload.Status)
+	if target == "" {
+		http.Error(w, fmt.Sprintf("Unknown order status %q", payload.Status), http.StatusBadRequest)
+		return
+	}
+
+	// --- Verify User Ownership ---
+	owner, ok := findOrderOwner(w, orderID, userID)
+	if !ok {
+		return
+	}
+
+	// --- Apply Transition ---
+	var status, overallStatus string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Cancelling restocks the seller's items, so it goes through the same path as CancelOrder
+		if target == StatusCancelled {
+			reason := strings.TrimSpace(payload.Note)
+			if reason == "" {
+				reason = defaultStatusCancelReason
+			}
+			result, errCancel := cancelOrderItems(tx, orderID, userID, nil, reason)
+			if errCancel != nil {
+				return errCancel
+			}
+			status, overallStatus = result.OrderStatus, result.OverallStatus
+			return nil
+		}
+
+		// Lock the order row so concurrent updates serialise on the current statuses
+		var order Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			return err
+		}
+		shipment, errFind := findOrCreateShipment(tx, owner)
+		if errFind != nil {
+			return errFind
+		}
+		if err := transitionShipmentStatus(tx, &order, shipment, target, userID, payload.Note); err != nil {
+			return err
+		}
+		status, overallStatus = shipment.Status, order.OrderStatus
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, fmt.Sprintf("Order with ID %d not found", orderID), http.StatusNotFound)
+		case errors.Is(err, errIllegalTransition), errors.Is(err, errUnknownStatus),
+			errors.Is(err, errOrderNotCancellable), errors.Is(err, errInvalidCancelCount):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error updating status of order %d: %v", orderID, err)
+			http.Error(w, "Internal server error while updating order status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": status, "overallStatus": overallStatus})
+}
+
+// GetOrderStatusHistory lists the recorded status changes for the seller's shipment in an order, oldest first.
+//
+// @Summary      Retrieve an order's status history
+// @Description  Lists every status change recorded for the authenticated seller's shipment in an order, oldest first. Changes recorded before shipments were tracked per seller are included for every seller.
+// @Tags         order
+// @Param        id   query integer true "Order ID"
+// @Success      200  {array}   OrderStatusHistoryReturn "Status changes (empty array if the status never changed)"
+// @Failure      400  {string}  string "Invalid Order ID format"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (user is not a seller for any product in this order)"
+// @Failure      404  {string}  string "Order not found"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_order_status_history [get]
+func GetOrderStatusHistory(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetOrderStatusHistory: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+	orderID := uint(orderID64)
+
+	owner, ok := findOrderOwner(w, orderID, user.ID)
+	if !ok {
+		return
+	}
+
+	var shipment OrderShipment
+	if err := db.Where("order_owner_id = ?", owner.ID).First(&shipment).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error fetching shipment for order %d (User: %d): %v", orderID, user.ID, err)
+		http.Error(w, "Database error fetching order status history", http.StatusInternalServerError)
+		return
+	}
+
+	// Include legacy order-level rows (shipment ID 0) alongside the seller's own
+	var history []OrderStatusHistory
+	if err := db.Where("order_id = ? AND order_shipment_id IN ?", orderID, []uint{0, shipment.ID}).
+		Order("changed_at asc, id asc").Find(&history).Error; err != nil {
+		log.Printf("Error fetching status history for order %d: %v", orderID, err)
+		http.Error(w, "Database error fetching order status history", http.StatusInternalServerError)
+		return
+	}
+
+	historyRet := make([]OrderStatusHistoryReturn, len(history))
+	for i, h := range history {
+		historyRet[i] = OrderStatusHistoryReturn{
+			FromStatus: h.FromStatus,
+			ToStatus:   h.ToStatus,
+			ChangedBy:  h.ChangedBy,
+			Note:       h.Note,
+			ChangedAt:  h.ChangedAt.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(historyRet); err != nil {
+		log.Printf("Error encoding status history for order %d: %v", orderID, err)
+	}
+}
+
+// checkOrderOwnership verifies that the user is linked to the order through OrderOwner.
+// It writes the appropriate HTTP error (403, 404, 500) and returns false if not.
+func checkOrderOwnership(w http.ResponseWriter, orderID, userID uint) bool {
+	_, ok := findOrderOwner(w, orderID, userID)
+	return ok
+}
+
+// findOrderOwner returns the OrderOwner row linking the user to the order.
+// It writes the appropriate HTTP error (403, 404, 500) and returns false if there is none.
+func findOrderOwner(w http.ResponseWriter, orderID, userID uint) (*OrderOwner, bool) {
+	var orderOwner OrderOwner
+	err := db.Where("order_id = ? AND user_id = ?", orderID, userID).First(&orderOwner).Error
+	if err == nil {
+		return &orderOwner, true
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Check if the order exists at all before returning 403
+		var orderExists Order
+		if errExists := db.First(&orderExists, orderID).Error; errors.Is(errExists, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("Order with ID %d not found", orderID), http.StatusNotFound)
+		} else {
+			http.Error(w, "Permission denied: You are not associated with this order", http.StatusForbidden)
+		}
+	} else {
+		log.Printf("Error checking order ownership (User: %d, Order: %d): %v", userID, orderID, err)
+		http.Error(w, "Database error checking order ownership", http.StatusInternalServerError)
+	}
+	return nil, false
+}
@@ -0,0 +1,229 @@
+// internal/orderstable/orderstatus_test.go
+package orderstable
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/prodtable"
+	"front-runner/internal/usertable"
+)
+
+func TestValidateStatusTransition(t *testing.T) {
+	testCases := []struct {
+		from, to string
+		wantErr  error
+	}{
+		{StatusPending, StatusProcessing, nil},
+		{StatusPending, StatusCancelled, nil},
+		{StatusProcessing, StatusShipped, nil},
+		{StatusShipped, StatusDelivered, nil},
+		{StatusDelivered, StatusRefunded, nil},
+		{StatusCancelled, StatusRefunded, nil},
+		{StatusPending, StatusShipped, errIllegalTransition},
+		{StatusShipped, StatusCancelled, errIllegalTransition},
+		{StatusDelivered, StatusPending, errIllegalTransition},
+		{StatusRefunded, StatusPending, errIllegalTransition},
+		{StatusPending, "Lost", errUnknownStatus},
+		{"Lost", StatusPending, errUnknownStatus},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s_to_%s", tc.from, tc.to), func(t *testing.T) {
+			err := validateStatusTransition(tc.from, tc.to)
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tc.wantErr), "Expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	assert.Equal(t, StatusShipped, normalizeStatus(" shipped "))
+	assert.Equal(t, "", normalizeStatus("teleported"))
+}
+
+func TestAggregateOrderStatus(t *testing.T) {
+	testCases := []struct {
+		name     string
+		statuses []string
+		want     string
+	}{
+		{"NoShipments", nil, StatusPending},
+		{"SingleShipment", []string{StatusShipped}, StatusShipped},
+		{"LeastAdvancedWins", []string{StatusDelivered, StatusProcessing, StatusShipped}, StatusProcessing},
+		{"CancelledIgnoredWhileActive", []string{StatusCancelled, StatusShipped}, StatusShipped},
+		{"AllCancelled", []string{StatusCancelled, StatusCancelled}, StatusCancelled},
+		{"CancelledAndRefunded", []string{StatusCancelled, StatusRefunded}, StatusRefunded},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, aggregateOrderStatus(tc.statuses))
+		})
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "statusseller@example.com", "password")
+	other := createTestUser(t, "statusother@example.com", "password")
+	product := createTestProduct(t, seller, "Status Prod", 1000, 10)
+	order := createTestOrder(t, "Status Cust", "status@test.com", map[*prodtable.Product]uint{product: 1})
+
+	updateStatus := func(t *testing.T, status string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(OrderStatusUpdatePayload{Status: status, Note: "test note"})
+		url := fmt.Sprintf("/api/update_order_status?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, seller, "PUT", url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		UpdateOrderStatus(rr, req)
+		return rr
+	}
+
+	t.Run("LegalTransitions", func(t *testing.T) {
+		for _, status := range []string{StatusProcessing, StatusShipped, StatusDelivered} {
+			rr := updateStatus(t, status)
+			require.Equal(t, http.StatusOK, rr.Code, "Expected 200 moving to %s, body: %s", status, rr.Body.String())
+		}
+
+		var updated Order
+		require.NoError(t, testDB.First(&updated, order.ID).Error)
+		assert.Equal(t, StatusDelivered, updated.OrderStatus)
+
+		var history []OrderStatusHistory
+		require.NoError(t, testDB.Where("order_id = ?", order.ID).Order("id asc").Find(&history).Error)
+		require.Len(t, history, 3, "Expected one history row per transition")
+		assert.Equal(t, StatusPending, history[0].FromStatus)
+		assert.Equal(t, StatusProcessing, history[0].ToStatus)
+		assert.Equal(t, seller.ID, history[0].ChangedBy)
+		assert.Equal(t, "test note", history[0].Note)
+		assert.Equal(t, StatusDelivered, history[2].ToStatus)
+	})
+
+	t.Run("IllegalTransition", func(t *testing.T) {
+		rr := updateStatus(t, StatusPending)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), "illegal status transition")
+	})
+
+	t.Run("UnknownStatus", func(t *testing.T) {
+		rr := updateStatus(t, "Teleported")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Forbidden_UserNotLinked", func(t *testing.T) {
+		body, _ := json.Marshal(OrderStatusUpdatePayload{Status: StatusRefunded})
+		url := fmt.Sprintf("/api/update_order_status?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, other, "PUT", url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		UpdateOrderStatus(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		url := fmt.Sprintf("/api/get_order_status_history?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, seller, "GET", url, nil)
+		rr := httptest.NewRecorder()
+		GetOrderStatusHistory(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp []OrderStatusHistoryReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp, 3)
+		assert.Equal(t, StatusProcessing, resp[0].ToStatus)
+	})
+
+	t.Run("PerSellerShipments", func(t *testing.T) {
+		seller2 := createTestUser(t, "statusseller2@example.com", "password")
+		product2 := createTestProduct(t, seller2, "Status Prod 2", 500, 10)
+		multi := createTestOrder(t, "Multi Cust", "multi@test.com", map[*prodtable.Product]uint{product: 1, product2: 1})
+
+		update := func(t *testing.T, user *usertable.User, status string) map[string]string {
+			t.Helper()
+			body, _ := json.Marshal(OrderStatusUpdatePayload{Status: status})
+			url := fmt.Sprintf("/api/update_order_status?id=%d", multi.ID)
+			req := createAuthenticatedRequest(t, user, "PUT", url, bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			UpdateOrderStatus(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+			var resp map[string]string
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			return resp
+		}
+
+		update(t, seller, StatusProcessing)
+		resp := update(t, seller, StatusShipped)
+		assert.Equal(t, StatusShipped, resp["status"])
+		assert.Equal(t, StatusPending, resp["overallStatus"], "Order stays Pending until seller 2 progresses")
+
+		var shipment OrderShipment
+		require.NoError(t, testDB.Where("order_id = ? AND user_id = ?", multi.ID, seller.ID).First(&shipment).Error)
+		require.NotNil(t, shipment.ShippedAt)
+
+		// Seller 2 sees their own status, not seller 1's
+		url := fmt.Sprintf("/api/get_order?id=%d", multi.ID)
+		req := createAuthenticatedRequest(t, seller2, "GET", url, nil)
+		rr := httptest.NewRecorder()
+		GetOrder(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var ret OrderReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ret))
+		assert.Equal(t, StatusPending, ret.OrderStatus)
+		assert.Empty(t, ret.ShippedAt)
+
+		update(t, seller2, StatusProcessing)
+		resp = update(t, seller2, StatusShipped)
+		assert.Equal(t, StatusShipped, resp["overallStatus"])
+	})
+
+	t.Run("CancelRestocks", func(t *testing.T) {
+		cancelled := createTestOrder(t, "Cancel Cust", "cancelstatus@test.com", map[*prodtable.Product]uint{product: 2})
+		var before prodtable.Product
+		require.NoError(t, testDB.First(&before, product.ID).Error)
+
+		body, _ := json.Marshal(OrderStatusUpdatePayload{Status: StatusCancelled, Note: "out of stock"})
+		url := fmt.Sprintf("/api/update_order_status?id=%d", cancelled.ID)
+		rr := httptest.NewRecorder()
+		UpdateOrderStatus(rr, createAuthenticatedRequest(t, seller, "PUT", url, bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, StatusCancelled, resp["status"])
+		assert.Equal(t, StatusCancelled, resp["overallStatus"])
+
+		var after prodtable.Product
+		require.NoError(t, testDB.First(&after, product.ID).Error)
+		assert.Equal(t, before.ProdCount+2, after.ProdCount, "The cancelled items are restocked")
+		var record OrderCancellation
+		require.NoError(t, testDB.Where("order_id = ?", cancelled.ID).First(&record).Error)
+		assert.Equal(t, uint(2), record.Count)
+		assert.Equal(t, "out of stock", record.Reason)
+		var movements int64
+		require.NoError(t, testDB.Model(&prodtable.StockMovement{}).
+			Where("product_id = ? AND reason = ? AND reference = ?", product.ID, prodtable.StockReasonCancellation, fmt.Sprintf("order:%d", cancelled.ID)).
+			Count(&movements).Error)
+		assert.Equal(t, int64(1), movements, "The restock is recorded in the stock ledger")
+
+		rr = httptest.NewRecorder()
+		body, _ = json.Marshal(OrderStatusUpdatePayload{Status: StatusCancelled})
+		UpdateOrderStatus(rr, createAuthenticatedRequest(t, seller, "PUT", url, bytes.NewReader(body)))
+		assert.Equal(t, http.StatusConflict, rr.Code, "An order cannot be cancelled twice")
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		url := fmt.Sprintf("/api/update_order_status?id=%d", order.ID)
+		req := httptest.NewRequest("PUT", url, bytes.NewReader([]byte(`{"status":"Processing"}`)))
+		rr := httptest.NewRecorder()
+		UpdateOrderStatus(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
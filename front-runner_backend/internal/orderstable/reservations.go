@@ -0,0 +1,339 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/prodtable"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reservation statuses. Only active reservations hold stock.
+const (
+	ReservationActive    = "active"
+	ReservationConverted = "converted" // Turned into an order, which took over the stock
+	ReservationReleased  = "released"  // Released by the client; stock returned
+	ReservationExpired   = "expired"   // Released by the sweeper after ExpiresAt; stock returned
+)
+
+const (
+	defaultReservationTTL    = 15 * time.Minute // Overridden by RESERVATION_TTL_SECONDS
+	maxReservationTTL        = time.Hour
+	reservationSweepInterval = time.Minute
+	reservationSweepBatch    = 100
+)
+
+// reservationTTL is how long a reservation holds stock when the request does not say.
+var reservationTTL = defaultReservationTTL
+
+var (
+	errReservationNotFound  = errors.New("reservation not found")
+	errReservationNotActive = errors.New("reservation is no longer active")
+)
+
+// StockReservation holds stock for a client, typically for a checkout in progress, until it is
+// converted into an order, released, or expires.
+type StockReservation struct {
+	ID        uint      `gorm:"primaryKey"`
+	SellerID  uint      `gorm:"not null;index"`     // Seller whose products are reserved
+	ChannelID uint      `gorm:"not null;default:0"` // StorefrontLink that made the reservation, 0 for sessions
+	Status    string    `gorm:"not null;index:idx_reservation_expiry"`
+	ExpiresAt time.Time `gorm:"not null;index:idx_reservation_expiry"`
+	OrderID   uint      `gorm:"not null;default:0"` // Order the reservation was converted into
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Items     []StockReservationItem `gorm:"foreignKey:ReservationID"`
+}
+
+// StockReservationItem is the quantity of a product, or one of its variants, held by a reservation.
+type StockReservationItem struct {
+	ID            uint `gorm:"primaryKey"`
+	ReservationID uint `gorm:"not null;index"`
+	ProdID        uint `gorm:"not null"`
+	VariantID     uint `gorm:"not null;default:0"`
+	Count         uint `gorm:"not null"`
+}
+
+// ReservationPayload is used to decode the JSON body when reserving stock.
+type ReservationPayload struct {
+	Items      []OrderProductPayload `json:"items"`      // Products or variants to reserve, as in an order
+	TTLSeconds uint                  `json:"ttlSeconds"` // How long to hold the stock; defaults to 15 minutes, at most an hour
+}
+
+// ReservationReturn describes a stock reservation.
+type ReservationReturn struct {
+	ReservationID uint                  `json:"reservationID"` // Pass as reservationID to /api/create_order
+	Status        string                `json:"status"`        // active, converted, released or expired
+	ExpiresAt     string                `json:"expiresAt"`     // When the stock is released unless converted first
+	OrderID       uint                  `json:"orderID,omitempty"`
+	Items         []OrderProductPayload `json:"items"`
+}
+
+// orderItems returns the reservation's items as order lines.
+func (res *StockReservation) orderItems() []OrderProductPayload {
+	items := make([]OrderProductPayload, 0, len(res.Items))
+	for _, item := range res.Items {
+		items = append(items, OrderProductPayload{ProdID: item.ProdID, VariantID: item.VariantID, Count: item.Count})
+	}
+	return items
+}
+
+// reference identifies the reservation in the stock ledger.
+func (res *StockReservation) reference() string {
+	return fmt.Sprintf("reservation:%d", res.ID)
+}
+
+// toReturn converts a reservation into its API representation.
+func (res *StockReservation) toReturn() ReservationReturn {
+	return ReservationReturn{
+		ReservationID: res.ID,
+		Status:        res.Status,
+		ExpiresAt:     res.ExpiresAt.Format(time.RFC3339),
+		OrderID:       res.OrderID,
+		Items:         res.orderItems(),
+	}
+}
+
+// reservationTTLFromEnv reads the default reservation lifetime from RESERVATION_TTL_SECONDS.
+func reservationTTLFromEnv() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("RESERVATION_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultReservationTTL
+	}
+	return min(time.Duration(seconds)*time.Second, maxReservationTTL)
+}
+
+// lockActiveReservation row-locks a seller's reservation along with its items, checking it still holds stock.
+func lockActiveReservation(tx *gorm.DB, reservationID, sellerID uint) (*StockReservation, error) {
+	var res StockReservation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&res, reservationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && res.SellerID != sellerID) {
+		return nil, fmt.Errorf("%w (ID %d)", errReservationNotFound, reservationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != ReservationActive || !time.Now().Before(res.ExpiresAt) {
+		return nil, fmt.Errorf("%w (ID %d)", errReservationNotActive, reservationID)
+	}
+	return &res, nil
+}
+
+// releaseReservation returns an active reservation's stock and marks it with status.
+// The reservation must be row-locked by the transaction. userID is who released it, 0 for the sweeper.
+func releaseReservation(tx *gorm.DB, res *StockReservation, status string, userID uint) error {
+	change := prodtable.StockChange{Reason: prodtable.StockReasonReservationRelease, Reference: res.reference(), UserID: userID, Note: status}
+	lines := &orderLines{Counts: make(map[orderLineKey]uint, len(res.Items))}
+	for _, item := range res.Items {
+		lines.Counts[orderLineKey{ProdID: item.ProdID, VariantID: item.VariantID}] += item.Count
+	}
+	for _, key := range lines.sortedKeys() {
+		if err := prodtable.ReturnStock(tx, key.ProdID, key.VariantID, lines.Counts[key], change); err != nil {
+			return fmt.Errorf("failed to restock product %d: %w", key.ProdID, err)
+		}
+	}
+	res.Status = status
+	return tx.Model(res).Update("status", status).Error
+}
+
+// ReserveStock holds stock for a checkout in progress.
+//
+// @Summary      Reserve stock
+// @Description  Takes the requested quantities out of stock and holds them for a limited time, so a checkout can complete without another order selling them first. Pass the returned reservationID to /api/create_order to turn the reservation into an order; otherwise the stock is returned once it expires or is released. Authenticated and rate limited like /api/create_order, signing "<timestamp>.POST./api/reserve_stock." followed by the raw body.
+// @Tags         order
+// @Accept       json
+// @Produce      json
+// @Param        X-Channel-ID header integer false "Storefront link ID, for requests signed by a storefront"
+// @Param        X-Channel-Timestamp header integer false "Unix time the request was signed at, within 5 minutes of the server's clock"
+// @Param        X-Channel-Signature header string false "Hex HMAC-SHA256 signature of the request"
+// @Param        reservation body ReservationPayload true "Items to reserve and for how long"
+// @Success      201  {object}  ReservationReturn "The reservation"
+// @Failure      400  {string}  string "Invalid request body, items, time to live, or insufficient stock"
+// @Failure      401  {string}  string "Missing or invalid session or storefront signature"
+// @Failure      403  {string}  string "Product belongs to another seller"
+// @Failure      404  {string}  string "Product not found"
+// @Failure      429  {string}  string "Too many requests from this storefront, seller or address"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/reserve_stock [post]
+func ReserveStock(w http.ResponseWriter, r *http.Request) {
+	client, body, ok := admitOrderClient(w, r)
+	if !ok {
+		return
+	}
+
+	var payload ReservationPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(payload.Items) == 0 {
+		http.Error(w, "Reservation must contain at least one product", http.StatusBadRequest)
+		return
+	}
+	ttl := reservationTTL
+	if payload.TTLSeconds != 0 {
+		ttl = time.Duration(payload.TTLSeconds) * time.Second
+		if ttl > maxReservationTTL {
+			http.Error(w, fmt.Sprintf("ttlSeconds must be at most %d", int(maxReservationTTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var res StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		lines, err := resolveOrderLines(tx, payload.Items, client.SellerID)
+		if err != nil {
+			return err
+		}
+		res = StockReservation{
+			SellerID:  client.SellerID,
+			ChannelID: client.ChannelID,
+			Status:    ReservationActive,
+			ExpiresAt: time.Now().Add(ttl),
+		}
+		for _, key := range lines.sortedKeys() {
+			res.Items = append(res.Items, StockReservationItem{ProdID: key.ProdID, VariantID: key.VariantID, Count: lines.Counts[key]})
+		}
+		if err := tx.Create(&res).Error; err != nil {
+			return err
+		}
+		return lines.takeStock(tx, prodtable.StockChange{Reason: prodtable.StockReasonReservation, Reference: res.reference(), UserID: client.SellerID})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotClientProduct):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, prodtable.ErrInsufficientStock), errors.Is(err, errInvalidOrderLine):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Printf("Error reserving stock for seller %d: %v", client.SellerID, err)
+			http.Error(w, "Internal server error while reserving stock", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res.toReturn())
+}
+
+// ReleaseReservation returns a reservation's stock before it expires, such as when a checkout is abandoned.
+//
+// @Summary      Release a stock reservation
+// @Description  Returns the stock held by an active reservation. Authenticated like /api/reserve_stock; storefronts sign "<timestamp>.POST./api/release_reservation?id=<id>." with an empty body.
+// @Tags         order
+// @Produce      json
+// @Param        id   query integer true "Reservation ID"
+// @Success      200  {object}  ReservationReturn "The released reservation"
+// @Failure      400  {string}  string "Invalid reservation ID"
+// @Failure      401  {string}  string "Missing or invalid session or storefront signature"
+// @Failure      404  {string}  string "Reservation not found"
+// @Failure      409  {string}  string "Reservation was already converted, released or expired"
+// @Failure      429  {string}  string "Too many requests from this storefront, seller or address"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/release_reservation [post]
+func ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	client, _, ok := admitOrderClient(w, r)
+	if !ok {
+		return
+	}
+
+	reservationID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid reservation ID format", http.StatusBadRequest)
+		return
+	}
+	reservationID := uint(reservationID64)
+
+	var res *StockReservation
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if res, err = lockActiveReservation(tx, reservationID, client.SellerID); err != nil {
+			return err
+		}
+		return releaseReservation(tx, res, ReservationReleased, client.SellerID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errReservationNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errReservationNotActive):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error releasing reservation %d: %v", reservationID, err)
+			http.Error(w, "Internal server error while releasing reservation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res.toReturn())
+}
+
+// ReleaseExpiredReservations returns the stock of every active reservation that expired by now,
+// and reports how many were released.
+func ReleaseExpiredReservations(now time.Time) (int, error) {
+	released := 0
+	for {
+		var ids []uint
+		if err := db.Model(&StockReservation{}).Where("status = ? AND expires_at <= ?", ReservationActive, now).
+			Order("id").Limit(reservationSweepBatch).Pluck("id", &ids).Error; err != nil {
+			return released, err
+		}
+		for _, id := range ids {
+			// Each reservation is released in its own transaction, rechecked under lock
+			// in case it was converted or released meanwhile
+			expired := false
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var res StockReservation
+				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&res, id).Error; err != nil {
+					return err
+				}
+				if res.Status != ReservationActive || res.ExpiresAt.After(now) {
+					return nil
+				}
+				expired = true
+				return releaseReservation(tx, &res, ReservationExpired, 0)
+			})
+			if err != nil {
+				return released, fmt.Errorf("failed to release reservation %d: %w", id, err)
+			}
+			if expired {
+				released++
+			}
+		}
+		if len(ids) < reservationSweepBatch {
+			return released, nil
+		}
+	}
+}
+
+// StartReservationSweeper releases expired reservations in the background every minute.
+func StartReservationSweeper() {
+	go func() {
+		ticker := time.NewTicker(reservationSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			released, err := ReleaseExpiredReservations(time.Now())
+			if err != nil {
+				log.Printf("Reservation sweeper: %v", err)
+			}
+			if released > 0 {
+				log.Printf("Reservation sweeper: released %d expired reservations", released)
+			}
+		}
+	}()
+}
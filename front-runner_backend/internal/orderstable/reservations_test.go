@@ -0,0 +1,152 @@
+// internal/orderstable/reservations_test.go
+package orderstable
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/prodtable"
+	"front-runner/internal/ratelimit"
+)
+
+// TestStockReservations tests reserving stock, converting and releasing reservations, and their expiry.
+func TestStockReservations(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "reserveseller@example.com", "password")
+	product := createTestProduct(t, seller, "Reserved Prod", 1500, 10)
+
+	previous := orderLimiter
+	orderLimiter = ratelimit.New(6000, 1000)
+	defer func() { orderLimiter = previous }()
+
+	reserve := func(t *testing.T, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		ReserveStock(rr, createAuthenticatedRequest(t, seller, "POST", "/api/reserve_stock", strings.NewReader(body)))
+		return rr
+	}
+	reserveOK := func(t *testing.T, count uint) ReservationReturn {
+		t.Helper()
+		rr := reserve(t, fmt.Sprintf(`{"items": [{"productID": %d, "count": %d}]}`, product.ID, count))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var res ReservationReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		return res
+	}
+	stock := func(t *testing.T) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, product.ID).Error)
+		return p.ProdCount
+	}
+
+	t.Run("ReserveHoldsStock", func(t *testing.T) {
+		res := reserveOK(t, 4)
+		assert.Equal(t, ReservationActive, res.Status)
+		assert.Equal(t, uint(6), stock(t))
+
+		rr := reserve(t, fmt.Sprintf(`{"items": [{"productID": %d, "count": 7}]}`, product.ID))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Only unreserved stock can be reserved")
+		rr = reserve(t, fmt.Sprintf(`{"items": [{"productID": %d, "count": 1}], "ttlSeconds": 7200}`, product.ID))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		// Release it again
+		rr = httptest.NewRecorder()
+		ReleaseReservation(rr, createAuthenticatedRequest(t, seller, "POST", fmt.Sprintf("/api/release_reservation?id=%d", res.ReservationID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(10), stock(t))
+
+		rr = httptest.NewRecorder()
+		ReleaseReservation(rr, createAuthenticatedRequest(t, seller, "POST", fmt.Sprintf("/api/release_reservation?id=%d", res.ReservationID), nil))
+		assert.Equal(t, http.StatusConflict, rr.Code, "A reservation is only released once")
+	})
+
+	t.Run("ConvertIntoOrder", func(t *testing.T) {
+		res := reserveOK(t, 3)
+		body := fmt.Sprintf(`{"customerName": "Res Cust", "customerEmail": "res@test.com", "reservationID": %d}`, res.ReservationID)
+		rr := httptest.NewRecorder()
+		CreateOrder(rr, createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(7), stock(t), "The order uses the reserved stock rather than taking more")
+
+		var created map[string]uint
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		var reservation StockReservation
+		require.NoError(t, testDB.First(&reservation, res.ReservationID).Error)
+		assert.Equal(t, ReservationConverted, reservation.Status)
+		assert.Equal(t, created["orderID"], reservation.OrderID)
+		var line OrderProd
+		require.NoError(t, testDB.Where("order_id = ?", created["orderID"]).First(&line).Error)
+		assert.Equal(t, uint(3), line.Count)
+
+		rr = httptest.NewRecorder()
+		CreateOrder(rr, createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(body)))
+		assert.Equal(t, http.StatusConflict, rr.Code, "A reservation converts into one order only")
+	})
+
+	t.Run("OtherSellersCannotUse", func(t *testing.T) {
+		res := reserveOK(t, 1)
+		other := createTestUser(t, "reserveother@example.com", "password")
+		rr := httptest.NewRecorder()
+		ReleaseReservation(rr, createAuthenticatedRequest(t, other, "POST", fmt.Sprintf("/api/release_reservation?id=%d", res.ReservationID), nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("ExpiredReservationsAreReleased", func(t *testing.T) {
+		before := stock(t)
+		res := reserveOK(t, 2)
+		assert.Equal(t, before-2, stock(t))
+
+		released, err := ReleaseExpiredReservations(time.Now())
+		require.NoError(t, err)
+		assert.Zero(t, released, "Unexpired reservations are kept")
+
+		released, err = ReleaseExpiredReservations(time.Now().Add(maxReservationTTL))
+		require.NoError(t, err)
+		assert.Equal(t, 2, released, "Both reservations still active have expired")
+		assert.Equal(t, before+1, stock(t))
+
+		var reservation StockReservation
+		require.NoError(t, testDB.First(&reservation, res.ReservationID).Error)
+		assert.Equal(t, ReservationExpired, reservation.Status)
+	})
+
+	t.Run("ConcurrentOrdersCannotOversell", func(t *testing.T) {
+		limited := createTestProduct(t, seller, "Limited Prod", 1000, 5)
+		body := fmt.Sprintf(`{"customerName": "Rush Cust", "customerEmail": "rush@test.com", "orderedProducts": [{"productID": %d, "count": 1}]}`, limited.ID)
+
+		var wg sync.WaitGroup
+		codes := make([]int, 12)
+		for i := range codes {
+			req := createAuthenticatedRequest(t, seller, "POST", "/api/create_order", strings.NewReader(body))
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rr := httptest.NewRecorder()
+				CreateOrder(rr, req)
+				codes[i] = rr.Code
+			}(i)
+		}
+		wg.Wait()
+
+		created := 0
+		for _, code := range codes {
+			if code == http.StatusCreated {
+				created++
+			}
+		}
+		assert.Equal(t, 5, created, "Only as many orders as there is stock succeed")
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, limited.ID).Error)
+		assert.Zero(t, p.ProdCount)
+	})
+}
@@ -0,0 +1,395 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/blobstore"
+	"front-runner/internal/oauth"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxLabelSize caps the size of an uploaded shipping label.
+const maxLabelSize = 10 << 20 // 10MB
+
+// labelContentTypes maps the accepted shipping label extensions to the content
+// type their bytes must sniff as.
+var labelContentTypes = map[string]string{
+	".pdf": "application/pdf",
+	".png": "image/png",
+}
+
+// OrderShipment holds one seller's fulfilment state for an order.
+// It is keyed on OrderOwner so each seller in a multi-seller order manages their own shipment.
+type OrderShipment struct {
+	ID             uint   `gorm:"primaryKey"`
+	OrderOwnerID   uint   `gorm:"not null;uniqueIndex"`
+	OrderID        uint   `gorm:"not null;index"`
+	UserID         uint   `gorm:"not null;index"`                   // Seller's User ID
+	Status         string `gorm:"not null;default:'Pending';index"` // One of the Status* constants; changed only through transitionShipmentStatus
+	Carrier        string
+	TrackingNumber string
+	LabelFile      string     // Blob store key of the uploaded shipping label
+	ShippedAt      *time.Time // Set when the shipment moves to Shipped
+	DeliveredAt    *time.Time // Set when the shipment moves to Delivered
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime"`
+}
+
+// OrderTrackingPayload is used to decode the JSON body when attaching tracking details.
+type OrderTrackingPayload struct {
+	Carrier        string `json:"carrier"`        // e.g. "UPS", "USPS", "FedEx"
+	TrackingNumber string `json:"trackingNumber"` // Carrier tracking number
+}
+
+// OrderShipmentReturn is returned to the frontend describing the seller's shipment for an order.
+type OrderShipmentReturn struct {
+	OrderID          uint   `json:"orderID"`
+	Status           string `json:"status"`
+	Carrier          string `json:"carrier"`
+	TrackingNumber   string `json:"trackingNumber"`
+	HasShippingLabel bool   `json:"hasShippingLabel"`
+	ShippedAt        string `json:"shippedAt,omitempty"`   // Formatted date string
+	DeliveredAt      string `json:"deliveredAt,omitempty"` // Formatted date string
+}
+
+// formatOptionalTime formats a nullable timestamp, returning "" when it is unset.
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// setShipmentReturn converts an OrderShipment to its API representation.
+func setShipmentReturn(shipment OrderShipment) OrderShipmentReturn {
+	return OrderShipmentReturn{
+		OrderID:          shipment.OrderID,
+		Status:           shipment.Status,
+		Carrier:          shipment.Carrier,
+		TrackingNumber:   shipment.TrackingNumber,
+		HasShippingLabel: shipment.LabelFile != "",
+		ShippedAt:        formatOptionalTime(shipment.ShippedAt),
+		DeliveredAt:      formatOptionalTime(shipment.DeliveredAt),
+	}
+}
+
+// applyShipment fills the fulfilment fields of an OrderReturn from the requesting seller's shipment.
+// OrderStatus becomes the seller's own status; the order-wide status is reported as OverallStatus.
+func applyShipment(ret *OrderReturn, order Order, shipment OrderShipment) {
+	ret.OverallStatus = order.OrderStatus
+	ret.OrderStatus = shipment.Status
+	if ret.OrderStatus == "" {
+		// No shipment row yet (older order); the seller shares the order's status
+		ret.OrderStatus = order.OrderStatus
+	}
+	ret.TrackingNumber = shipment.TrackingNumber
+	ret.Carrier = shipment.Carrier
+	ret.HasShippingLabel = shipment.LabelFile != ""
+	ret.ShippedAt = formatOptionalTime(shipment.ShippedAt)
+	ret.DeliveredAt = formatOptionalTime(shipment.DeliveredAt)
+}
+
+// findOrCreateShipment returns the shipment for an OrderOwner. Orders created before shipments
+// were tracked per seller get one on first use, starting from the order's current status.
+func findOrCreateShipment(tx *gorm.DB, owner *OrderOwner) (*OrderShipment, error) {
+	var shipment OrderShipment
+	err := tx.Where("order_owner_id = ?", owner.ID).First(&shipment).Error
+	if err == nil {
+		return &shipment, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var order Order
+	if err := tx.Select("id", "order_status", "tracking_number").First(&order, owner.OrderID).Error; err != nil {
+		return nil, err
+	}
+	shipment = OrderShipment{
+		OrderOwnerID:   owner.ID,
+		OrderID:        owner.OrderID,
+		UserID:         owner.UserID,
+		Status:         order.OrderStatus,
+		TrackingNumber: order.TrackingNumber,
+	}
+	if shipment.Status == "" {
+		shipment.Status = StatusPending
+	}
+	if err := tx.Create(&shipment).Error; err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// UpdateTracking attaches a carrier and tracking number to the authenticated seller's shipment for an order.
+//
+// @Summary      Attach tracking to an order
+// @Description  Sets the carrier and tracking number on the authenticated seller's own shipment for an order. Other sellers in a multi-seller order are unaffected.
+// @Tags         order
+// @Accept       json
+// @Param        id   query integer true "Order ID"
+// @Param        tracking body OrderTrackingPayload true "Carrier and tracking number"
+// @Success      200  {object}  OrderShipmentReturn "The seller's updated shipment"
+// @Failure      400  {string}  string "Invalid Order ID, request body, or missing fields"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (user is not a seller for any product in this order)"
+// @Failure      404  {string}  string "Order not found"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/update_tracking [put]
+func UpdateTracking(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("UpdateTracking: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+
+	var payload OrderTrackingPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	carrier := strings.TrimSpace(payload.Carrier)
+	trackingNumber := strings.TrimSpace(payload.TrackingNumber)
+	if carrier == "" || trackingNumber == "" {
+		http.Error(w, "Carrier and tracking number are required", http.StatusBadRequest)
+		return
+	}
+
+	owner, ok := findOrderOwner(w, uint(orderID64), user.ID)
+	if !ok {
+		return
+	}
+
+	var shipment *OrderShipment
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var errFind error
+		shipment, errFind = findOrCreateShipment(tx, owner)
+		if errFind != nil {
+			return errFind
+		}
+		shipment.Carrier = carrier
+		shipment.TrackingNumber = trackingNumber
+		return tx.Save(shipment).Error
+	})
+	if err != nil {
+		log.Printf("Error saving tracking for order %d (User: %d): %v", owner.OrderID, user.ID, err)
+		http.Error(w, "Database error saving tracking details", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(setShipmentReturn(*shipment))
+}
+
+// UploadShippingLabel stores a PDF or PNG shipping label for the authenticated seller's shipment.
+//
+// @Summary      Upload a shipping label
+// @Description  Uploads a PDF or PNG shipping label for the authenticated seller's own shipment in an order, replacing any previous label.
+// @Tags         order
+// @Accept       multipart/form-data
+// @Param        id     query    integer true "Order ID"
+// @Param        label  formData file    true "Shipping label (PDF or PNG)"
+// @Success      200  {object}  OrderShipmentReturn "The seller's updated shipment"
+// @Failure      400  {string}  string "Invalid Order ID, missing label, or unsupported file type"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (user is not a seller for any product in this order)"
+// @Failure      404  {string}  string "Order not found"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/upload_shipping_label [post]
+func UploadShippingLabel(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("UploadShippingLabel: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+
+	owner, ok := findOrderOwner(w, uint(orderID64), user.ID)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxLabelSize); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, handler, err := r.FormFile("label")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			http.Error(w, "Shipping label file is required", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Error retrieving the label file: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer file.Close()
+
+	// --- Validate File Type (extension and actual content) ---
+	ext := strings.ToLower(filepath.Ext(handler.Filename))
+	wantType, ok := labelContentTypes[ext]
+	if !ok {
+		http.Error(w, "Shipping label must be a PDF or PNG file", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxLabelSize+1))
+	if err != nil {
+		http.Error(w, "Error reading the label file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxLabelSize {
+		http.Error(w, "Shipping label is too large", http.StatusBadRequest)
+		return
+	}
+	if http.DetectContentType(data) != wantType {
+		http.Error(w, fmt.Sprintf("Shipping label content does not match a %s file", strings.TrimPrefix(ext, ".")), http.StatusBadRequest)
+		return
+	}
+
+	// --- Save Label File ---
+	labelFilename := "label_" + uuid.New().String() + ext
+	if err := store.Put(r.Context(), labelFilename, data, wantType); err != nil {
+		log.Printf("Error saving shipping label %s: %v", labelFilename, err)
+		http.Error(w, "Error saving shipping label", http.StatusInternalServerError)
+		return
+	}
+
+	var shipment *OrderShipment
+	oldLabel := ""
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var errFind error
+		shipment, errFind = findOrCreateShipment(tx, owner)
+		if errFind != nil {
+			return errFind
+		}
+		oldLabel = shipment.LabelFile
+		shipment.LabelFile = labelFilename
+		return tx.Save(shipment).Error
+	})
+	if err != nil {
+		log.Printf("Error saving shipping label record for order %d (User: %d): %v", owner.OrderID, user.ID, err)
+		http.Error(w, "Database error saving shipping label", http.StatusInternalServerError)
+		if err := store.Delete(r.Context(), labelFilename); err != nil { // Clean up saved label file
+			log.Printf("Warning: Failed to delete shipping label %s: %v", labelFilename, err)
+		}
+		return
+	}
+
+	// Delete the replaced label *after* the DB update is committed
+	if oldLabel != "" && oldLabel != labelFilename {
+		if err := store.Delete(r.Context(), oldLabel); err != nil {
+			log.Printf("Warning: Failed to delete old shipping label %s: %v", oldLabel, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(setShipmentReturn(*shipment))
+}
+
+// GetShippingLabel downloads the authenticated seller's shipping label for an order.
+//
+// @Summary      Download a shipping label
+// @Description  Serves the shipping label the authenticated seller uploaded for their shipment in an order.
+// @Tags         order
+// @Produce      application/pdf,image/png
+// @Param        id   query integer true "Order ID"
+// @Success      200  {file}    binary "Shipping label file"
+// @Failure      400  {string}  string "Invalid Order ID format"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      403  {string}  string "Permission denied (user is not a seller for any product in this order)"
+// @Failure      404  {string}  string "Order not found or no label uploaded"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_shipping_label [get]
+func GetShippingLabel(w http.ResponseWriter, r *http.Request) {
+	// --- Authentication ---
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetShippingLabel: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	orderID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		return
+	}
+
+	owner, ok := findOrderOwner(w, uint(orderID64), user.ID)
+	if !ok {
+		return
+	}
+
+	var shipment OrderShipment
+	if err := db.Where("order_owner_id = ?", owner.ID).First(&shipment).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error fetching shipment for order %d (User: %d): %v", owner.OrderID, user.ID, err)
+		http.Error(w, "Database error fetching shipment", http.StatusInternalServerError)
+		return
+	}
+	if shipment.LabelFile == "" {
+		http.Error(w, "No shipping label uploaded for this order", http.StatusNotFound)
+		return
+	}
+
+	obj, err := store.Get(r.Context(), shipment.LabelFile)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		log.Printf("Shipping label file %s missing for shipment %d", shipment.LabelFile, shipment.ID)
+		http.Error(w, "Shipping label file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading shipping label %s from storage: %v", shipment.LabelFile, err)
+		http.Error(w, "Error reading shipping label", http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+
+	downloadName := fmt.Sprintf("shipping_label_order_%d%s", owner.OrderID, filepath.Ext(shipment.LabelFile))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", downloadName))
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+	http.ServeContent(w, r, downloadName, shipment.UpdatedAt, obj)
+}
@@ -0,0 +1,164 @@
+// internal/orderstable/shipping_test.go
+package orderstable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/blobstore"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/usertable"
+)
+
+// Minimal PNG header; enough for http.DetectContentType to sniff image/png.
+var testPNGBytes = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+
+// Helper to build an authenticated multipart request uploading a shipping label
+func createLabelUploadRequest(t *testing.T, user *usertable.User, orderID uint, filename string, content []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("label", filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	url := fmt.Sprintf("/api/upload_shipping_label?id=%d", orderID)
+	req := createAuthenticatedRequest(t, user, "POST", url, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestShippingTracking(t *testing.T) {
+	setupTestEnvironment(t)
+	seller1 := createTestUser(t, "shipseller1@example.com", "password")
+	seller2 := createTestUser(t, "shipseller2@example.com", "password")
+	other := createTestUser(t, "shipother@example.com", "password")
+	productS1 := createTestProduct(t, seller1, "Ship S1 Prod", 1000, 5)
+	productS2 := createTestProduct(t, seller2, "Ship S2 Prod", 2000, 5)
+
+	order := createTestOrder(t, "Ship Cust", "ship@test.com", map[*prodtable.Product]uint{
+		productS1: 1,
+		productS2: 1,
+	})
+
+	updateTracking := func(t *testing.T, user *usertable.User, payload OrderTrackingPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		url := fmt.Sprintf("/api/update_tracking?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, user, "PUT", url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		UpdateTracking(rr, req)
+		return rr
+	}
+	getOrder := func(t *testing.T, user *usertable.User) OrderReturn {
+		t.Helper()
+		url := fmt.Sprintf("/api/get_order?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, user, "GET", url, nil)
+		rr := httptest.NewRecorder()
+		GetOrder(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp OrderReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("UpdateTracking_PerSeller", func(t *testing.T) {
+		rr := updateTracking(t, seller1, OrderTrackingPayload{Carrier: "UPS", TrackingNumber: "1Z999"})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		var resp OrderShipmentReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "UPS", resp.Carrier)
+		assert.Equal(t, "1Z999", resp.TrackingNumber)
+		assert.False(t, resp.HasShippingLabel)
+
+		ret := getOrder(t, seller1)
+		assert.Equal(t, "1Z999", ret.TrackingNumber)
+		assert.Equal(t, "UPS", ret.Carrier)
+
+		// Seller 2's view of the same order is unaffected
+		ret = getOrder(t, seller2)
+		assert.Empty(t, ret.TrackingNumber)
+		assert.Empty(t, ret.Carrier)
+	})
+
+	t.Run("UpdateTracking_MissingFields", func(t *testing.T) {
+		rr := updateTracking(t, seller1, OrderTrackingPayload{Carrier: "UPS"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("UpdateTracking_Forbidden", func(t *testing.T) {
+		rr := updateTracking(t, other, OrderTrackingPayload{Carrier: "UPS", TrackingNumber: "1Z000"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("UploadAndDownloadLabel", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadShippingLabel(rr, createLabelUploadRequest(t, seller2, order.ID, "label.png", testPNGBytes))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		var shipment OrderShipment
+		require.NoError(t, testDB.Where("order_id = ? AND user_id = ?", order.ID, seller2.ID).First(&shipment).Error)
+		require.NotEmpty(t, shipment.LabelFile)
+		defer store.Delete(context.Background(), shipment.LabelFile)
+		obj, err := store.Get(context.Background(), shipment.LabelFile)
+		require.NoError(t, err, "Label file should be in the blob store")
+		obj.Close()
+
+		assert.True(t, getOrder(t, seller2).HasShippingLabel)
+		assert.False(t, getOrder(t, seller1).HasShippingLabel)
+
+		url := fmt.Sprintf("/api/get_shipping_label?id=%d", order.ID)
+		req := createAuthenticatedRequest(t, seller2, "GET", url, nil)
+		rr = httptest.NewRecorder()
+		GetShippingLabel(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, testPNGBytes, rr.Body.Bytes())
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+
+		// Seller 1 has not uploaded a label of their own
+		req = createAuthenticatedRequest(t, seller1, "GET", url, nil)
+		rr = httptest.NewRecorder()
+		GetShippingLabel(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		// A new label replaces the stored one
+		rr = httptest.NewRecorder()
+		UploadShippingLabel(rr, createLabelUploadRequest(t, seller2, order.ID, "label.png", testPNGBytes))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var replaced OrderShipment
+		require.NoError(t, testDB.First(&replaced, shipment.ID).Error)
+		require.NotEqual(t, shipment.LabelFile, replaced.LabelFile)
+		defer store.Delete(context.Background(), replaced.LabelFile)
+		_, err = store.Get(context.Background(), shipment.LabelFile)
+		assert.ErrorIs(t, err, blobstore.ErrNotFound, "The replaced label is deleted")
+	})
+
+	t.Run("UploadLabel_InvalidType", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadShippingLabel(rr, createLabelUploadRequest(t, seller1, order.ID, "label.txt", []byte("hello")))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		// Right extension, wrong content
+		rr = httptest.NewRecorder()
+		UploadShippingLabel(rr, createLabelUploadRequest(t, seller1, order.ID, "label.pdf", testPNGBytes))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("UploadLabel_Forbidden", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UploadShippingLabel(rr, createLabelUploadRequest(t, other, order.ID, "label.png", testPNGBytes))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
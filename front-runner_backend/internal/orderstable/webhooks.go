@@ -0,0 +1,383 @@
+package orderstable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/connectors"
+	"front-runner/internal/oauth"
+	"front-runner/internal/storefronttable"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxWebhookBodyBytes bounds a webhook delivery, which is stored whole.
+const maxWebhookBodyBytes = 5 << 20
+
+// maxWebhookDeliveries is the most deliveries GetWebhookDeliveries returns.
+const maxWebhookDeliveries = 100
+
+// Webhook delivery statuses.
+const (
+	WebhookReceived  = "received"  // Stored but not processed yet
+	WebhookProcessed = "processed" // The order was imported or updated, or had none of the seller's SKUs
+	WebhookIgnored   = "ignored"   // Not an event that is handled
+	WebhookFailed    = "failed"    // Processing failed, see Error; it can be replayed
+)
+
+// webhookDeliveryIndex stops a delivery the marketplace retries from being stored twice.
+const webhookDeliveryIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_link_event ON webhook_deliveries (link_id, event_id) WHERE event_id <> ''`
+
+var (
+	errDeliveryNotFound = errors.New("webhook delivery not found")
+	errNoWebhooks       = errors.New("storefront link does not receive webhooks")
+)
+
+// WebhookDelivery is an event pushed by a storefront link's marketplace, stored as received so it can be replayed.
+type WebhookDelivery struct {
+	ID          uint        `gorm:"primaryKey"`
+	LinkID      uint        `gorm:"not null;index"` // StorefrontLink the event was sent to
+	UserID      uint        `gorm:"not null;index"` // Seller owning the link
+	EventID     string      `gorm:"not null;default:''"`
+	Topic       string      `gorm:"not null;default:''"` // The marketplace's name for the event
+	Header      http.Header `gorm:"serializer:json;type:text"`
+	Body        []byte      `gorm:"not null"`
+	Status      string      `gorm:"not null;index"`
+	Error       string      `gorm:"not null;default:''"` // Why processing last failed
+	Attempts    int         `gorm:"not null;default:0"`  // Times the delivery was processed, including replays
+	ReceivedAt  time.Time   `gorm:"autoCreateTime"`
+	ProcessedAt *time.Time
+}
+
+// WebhookDeliveryReturn is returned to the frontend, and to the marketplace, for a webhook delivery.
+type WebhookDeliveryReturn struct {
+	DeliveryID  uint   `json:"deliveryID"`
+	LinkID      uint   `json:"linkID"`
+	EventID     string `json:"eventID,omitempty"`
+	Topic       string `json:"topic"`
+	Status      string `json:"status"` // "received", "processed", "ignored" or "failed"
+	Error       string `json:"error,omitempty"`
+	Attempts    int    `json:"attempts"`
+	ReceivedAt  string `json:"receivedAt"`            // Formatted date string
+	ProcessedAt string `json:"processedAt,omitempty"` // Formatted date string
+}
+
+func (d *WebhookDelivery) toReturn() WebhookDeliveryReturn {
+	return WebhookDeliveryReturn{
+		DeliveryID:  d.ID,
+		LinkID:      d.LinkID,
+		EventID:     d.EventID,
+		Topic:       d.Topic,
+		Status:      d.Status,
+		Error:       d.Error,
+		Attempts:    d.Attempts,
+		ReceivedAt:  d.ReceivedAt.Format(time.RFC3339),
+		ProcessedAt: formatOptionalTime(d.ProcessedAt),
+	}
+}
+
+// webhookReceiver returns the link's connector as a receiver of webhooks, failing with errNoWebhooks
+// if its marketplace has no connector or does not push events.
+func webhookReceiver(link *storefronttable.StorefrontLink) (connectors.WebhookReceiver, error) {
+	conn, err := link.Connector()
+	if errors.Is(err, connectors.ErrUnsupported) {
+		return nil, errNoWebhooks
+	}
+	if err != nil {
+		return nil, err
+	}
+	receiver, ok := conn.(connectors.WebhookReceiver)
+	if !ok {
+		return nil, errNoWebhooks
+	}
+	return receiver, nil
+}
+
+// processWebhookDelivery imports the order of an order-created or order-cancelled delivery, like
+// the channel sync does, and records the outcome on the delivery.
+func processWebhookDelivery(link *storefronttable.StorefrontLink, receiver connectors.WebhookReceiver, delivery *WebhookDelivery) error {
+	status := WebhookProcessed
+	event, err := receiver.ParseWebhook(delivery.Header, delivery.Body)
+	if err == nil {
+		switch event.Type {
+		case connectors.EventOrderCreated, connectors.EventOrderCancelled:
+			if event.Order == nil {
+				err = fmt.Errorf("%s event without an order", event.Type)
+				break
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				_, err := importMarketplaceOrder(tx, link, *event.Order)
+				return err
+			})
+		default:
+			status = WebhookIgnored
+		}
+	}
+
+	now := time.Now()
+	delivery.Status, delivery.Error, delivery.ProcessedAt = status, "", &now
+	if err != nil {
+		delivery.Status, delivery.Error = WebhookFailed, err.Error()
+		if len(delivery.Error) > maxSyncErrorLength {
+			delivery.Error = delivery.Error[:maxSyncErrorLength]
+		}
+	}
+	delivery.Attempts++
+	if saveErr := db.Model(delivery).Select("Status", "Error", "ProcessedAt", "Attempts").Updates(delivery).Error; saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// ReceiveWebhook receives an event pushed by a linked storefront's marketplace.
+//
+// @Summary      Receive a marketplace webhook
+// @Description  The address to register with a marketplace (such as Shopify) for order webhooks. It is not session authenticated; instead every delivery must be signed with the storefront link's webhook secret (for Shopify, the X-Shopify-Hmac-Sha256 header keyed by the app's client secret). Verified deliveries are stored for replay, then order-created and order-cancelled events are imported like orders pulled by the channel sync. A redelivered event is acknowledged without being processed again. Processing failures are recorded on the delivery rather than returned, so the marketplace does not keep retrying them.
+// @Tags         order
+// @Accept       json
+// @Produce      json
+// @Param        storeType path string true "Store type of the storefront link, e.g. shopify"
+// @Param        linkID path integer true "ID of the Storefront Link"
+// @Success      200  {object}  WebhookDeliveryReturn "The stored delivery and whether it was processed"
+// @Failure      400  {string}  string "Unreadable request body"
+// @Failure      401  {string}  string "Missing or invalid signature, or the link has no webhook secret"
+// @Failure      404  {string}  string "No storefront link of this type with this ID receives webhooks"
+// @Failure      413  {string}  string "Request body too large"
+// @Failure      500  {string}  string "Internal server error"
+// @Router       /webhooks/{storeType}/{linkID} [post]
+func ReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	linkID64, err := strconv.ParseUint(vars["linkID"], 10, 32)
+	if err != nil {
+		http.Error(w, "Storefront link not found", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+		}
+		return
+	}
+
+	var link storefronttable.StorefrontLink
+	if err := db.First(&link, uint(linkID64)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Storefront link not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error finding storefront link %d for a webhook: %v", linkID64, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !strings.EqualFold(link.StoreType, vars["storeType"]) {
+		http.Error(w, "Storefront link not found", http.StatusNotFound)
+		return
+	}
+	receiver, err := webhookReceiver(&link)
+	if err != nil {
+		if errors.Is(err, errNoWebhooks) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			log.Printf("Cannot connect storefront link %d for a webhook: %v", link.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// --- Verify the Signature ---
+	secret, err := link.WebhookSecret()
+	if err != nil {
+		log.Printf("Error reading the webhook secret of storefront link %d: %v", link.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if secret == "" {
+		http.Error(w, "Storefront link has no webhook secret", http.StatusUnauthorized)
+		return
+	}
+	if err := receiver.VerifyWebhook(r.Header, body, secret); err != nil {
+		log.Printf("Rejected webhook for storefront link %d: %v", link.ID, err)
+		http.Error(w, "Invalid webhook signature", http.StatusUnauthorized)
+		return
+	}
+
+	// --- Store the Delivery, Once ---
+	// Even deliveries that cannot be parsed are stored, so they can be replayed
+	event, _ := receiver.ParseWebhook(r.Header, body)
+	header := r.Header.Clone()
+	header.Del("Authorization")
+	header.Del("Cookie")
+	delivery := WebhookDelivery{
+		LinkID:  link.ID,
+		UserID:  link.UserID,
+		EventID: event.ID,
+		Topic:   event.Topic,
+		Header:  header,
+		Body:    body,
+		Status:  WebhookReceived,
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery)
+	if result.Error != nil {
+		log.Printf("Error storing webhook for storefront link %d: %v", link.ID, result.Error)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		// A redelivery of an event already received
+		if err := db.Where("link_id = ? AND event_id = ?", link.ID, event.ID).First(&delivery).Error; err != nil {
+			log.Printf("Error finding webhook delivery %q of storefront link %d: %v", event.ID, link.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	} else if err := processWebhookDelivery(&link, receiver, &delivery); err != nil {
+		log.Printf("Error processing webhook delivery %d of storefront link %d: %v", delivery.ID, link.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery.toReturn())
+}
+
+// GetWebhookDeliveries lists the latest webhook deliveries to the user's storefront links.
+//
+// @Summary      List webhook deliveries
+// @Description  Lists the most recent webhook deliveries received from the marketplaces of the user's storefront links, newest first, with whether each was processed. At most 100 are returned.
+// @Tags         order
+// @Produce      json
+// @Param        linkID query integer false "Only deliveries to this Storefront Link"
+// @Param        status query string false "Only deliveries with this status: received, processed, ignored or failed"
+// @Success      200  {array}   WebhookDeliveryReturn "The deliveries"
+// @Failure      400  {string}  string "Invalid linkID or status"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_webhook_deliveries [get]
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetWebhookDeliveries: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	query := db.Where("user_id = ?", user.ID)
+	if linkIDStr := r.URL.Query().Get("linkID"); linkIDStr != "" {
+		linkID, err := strconv.ParseUint(linkIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid linkID", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("link_id = ?", linkID)
+	}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case WebhookReceived, WebhookProcessed, WebhookIgnored, WebhookFailed:
+		query = query.Where("status = ?", status)
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	// The stored headers and bodies are not needed to list deliveries
+	var deliveries []WebhookDelivery
+	if err := query.Omit("Header", "Body").Order("id desc").Limit(maxWebhookDeliveries).Find(&deliveries).Error; err != nil {
+		log.Printf("Error fetching webhook deliveries of user %d: %v", user.ID, err)
+		http.Error(w, "Database error fetching webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]WebhookDeliveryReturn, 0, len(deliveries))
+	for i := range deliveries {
+		ret = append(ret, deliveries[i].toReturn())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
+
+// ReplayWebhookDelivery processes a stored webhook delivery again, such as one that failed.
+//
+// @Summary      Replay a webhook delivery
+// @Description  Processes a stored webhook delivery again, as if it had just been received. Replaying is safe: orders already imported are not imported twice. Its signature was verified when it was received.
+// @Tags         order
+// @Produce      json
+// @Param        id query integer true "Webhook delivery ID"
+// @Success      200  {object}  WebhookDeliveryReturn "The delivery after processing; a failure is reported in its status and error"
+// @Failure      400  {string}  string "Invalid delivery ID"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      404  {string}  string "Delivery not found, or its storefront link no longer receives webhooks"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/replay_webhook_delivery [post]
+func ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ReplayWebhookDelivery: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	deliveryID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	// Deliveries of other sellers are reported as not found
+	var delivery WebhookDelivery
+	if err := db.Where("id = ? AND user_id = ?", deliveryID, user.ID).First(&delivery).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, errDeliveryNotFound.Error(), http.StatusNotFound)
+		} else {
+			log.Printf("Error finding webhook delivery %d: %v", deliveryID, err)
+			http.Error(w, "Database error fetching webhook delivery", http.StatusInternalServerError)
+		}
+		return
+	}
+	var link storefronttable.StorefrontLink
+	if err := db.First(&link, delivery.LinkID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "The delivery's storefront link no longer exists", http.StatusNotFound)
+		} else {
+			log.Printf("Error finding storefront link %d to replay webhook delivery %d: %v", delivery.LinkID, delivery.ID, err)
+			http.Error(w, "Database error fetching storefront link", http.StatusInternalServerError)
+		}
+		return
+	}
+	receiver, err := webhookReceiver(&link)
+	if err != nil {
+		if errors.Is(err, errNoWebhooks) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			log.Printf("Cannot connect storefront link %d to replay webhook delivery %d: %v", link.ID, delivery.ID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := processWebhookDelivery(&link, receiver, &delivery); err != nil {
+		log.Printf("Error replaying webhook delivery %d: %v", delivery.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery.toReturn())
+}
@@ -0,0 +1,199 @@
+// internal/orderstable/webhooks_test.go
+package orderstable
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/connectors"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/storefronttable"
+	"front-runner/internal/usertable"
+)
+
+// fakeMarketWebhook is the body of a "fakemarket" webhook delivery.
+type fakeMarketWebhook struct {
+	Topic string            `json:"topic"`
+	Order *connectors.Order `json:"order"`
+}
+
+// VerifyWebhook checks the hex HMAC-SHA256 of the body in X-Fake-Signature.
+func (fakeMarket) VerifyWebhook(header http.Header, body []byte, secret string) error {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if header.Get("X-Fake-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+		return connectors.ErrInvalidWebhookSignature
+	}
+	return nil
+}
+
+func (fakeMarket) ParseWebhook(header http.Header, body []byte) (connectors.WebhookEvent, error) {
+	event := connectors.WebhookEvent{ID: header.Get("X-Fake-Event-Id")}
+	var payload fakeMarketWebhook
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return event, err
+	}
+	event.Topic, event.Order = payload.Topic, payload.Order
+	switch payload.Topic {
+	case "order/created":
+		event.Type = connectors.EventOrderCreated
+	case "order/cancelled":
+		event.Type = connectors.EventOrderCancelled
+	}
+	return event, nil
+}
+
+// TestWebhooks tests verifying, storing, deduplicating, processing and replaying webhook deliveries.
+func TestWebhooks(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "webhookseller@example.com", "password")
+	other := createTestUser(t, "webhookother@example.com", "password")
+	mug := createTestProduct(t, seller, "Webhook Mug", 950, 10)
+	require.NoError(t, testDB.Model(mug).Update("sku", "MUG").Error)
+	link := storefronttable.StorefrontLink{UserID: seller.ID, StoreType: "fakemarket", StoreName: "Market"}
+	require.NoError(t, testDB.Create(&link).Error)
+
+	deliver := func(t *testing.T, storeType, eventID, secret string, payload fakeMarketWebhook) (*httptest.ResponseRecorder, WebhookDeliveryReturn) {
+		t.Helper()
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		linkID := strconv.FormatUint(uint64(link.ID), 10)
+		req := httptest.NewRequest("POST", "/webhooks/"+storeType+"/"+linkID, bytes.NewReader(body))
+		req.Header.Set("X-Fake-Signature", hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-Fake-Event-Id", eventID)
+		req = mux.SetURLVars(req, map[string]string{"storeType": storeType, "linkID": linkID})
+		rr := httptest.NewRecorder()
+		ReceiveWebhook(rr, req)
+		var ret WebhookDeliveryReturn
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ret))
+		}
+		return rr, ret
+	}
+	orderPayload := func(topic, id string, count uint) fakeMarketWebhook {
+		at := link.CreatedAt.Add(time.Minute)
+		order := connectors.Order{ExternalID: id, Number: "#" + id, Currency: "USD", CreatedAt: at, UpdatedAt: at,
+			Lines: []connectors.OrderLine{{SKU: "MUG", Quantity: count, PriceMinor: 950}}}
+		if topic == "order/cancelled" {
+			order.CancelledAt = &at
+		}
+		return fakeMarketWebhook{Topic: topic, Order: &order}
+	}
+	stock := func(t *testing.T) uint {
+		t.Helper()
+		var p prodtable.Product
+		require.NoError(t, testDB.First(&p, mug.ID).Error)
+		return p.ProdCount
+	}
+	stored := func(t *testing.T) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, testDB.Model(&WebhookDelivery{}).Where("link_id = ?", link.ID).Count(&count).Error)
+		return count
+	}
+
+	t.Run("NeedsWebhookSecret", func(t *testing.T) {
+		rr, _ := deliver(t, "fakemarket", "evt-0", "", orderPayload("order/created", "2000", 1))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = httptest.NewRecorder()
+		storefronttable.UpdateStorefront(rr, createAuthenticatedRequest(t, seller, "PUT", fmt.Sprintf("/api/update_storefront?id=%d", link.ID),
+			strings.NewReader(`{"storeName": "Market", "webhookSecret": "hook-secret"}`)))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Contains(t, rr.Body.String(), `"hasWebhookSecret":true`)
+	})
+
+	t.Run("RejectsBadRequests", func(t *testing.T) {
+		rr, _ := deliver(t, "fakemarket", "evt-1", "wrong-secret", orderPayload("order/created", "2001", 1))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		rr, _ = deliver(t, "shopify", "evt-1", "hook-secret", orderPayload("order/created", "2001", 1))
+		assert.Equal(t, http.StatusNotFound, rr.Code, "The store type must match the link's")
+		assert.Zero(t, stored(t), "Unverified deliveries are not stored")
+	})
+
+	var first WebhookDeliveryReturn
+	t.Run("OrderCreated", func(t *testing.T) {
+		var rr *httptest.ResponseRecorder
+		rr, first = deliver(t, "fakemarket", "evt-1", "hook-secret", orderPayload("order/created", "2001", 2))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, WebhookProcessed, first.Status)
+		assert.Equal(t, uint(8), stock(t))
+		var order Order
+		require.NoError(t, testDB.Where("channel_id = ? AND external_id = ?", link.ID, "2001").First(&order).Error)
+		assert.Equal(t, OrderSourceMarketplace, order.Source)
+
+		// A redelivery is acknowledged without importing the order again
+		rr, again := deliver(t, "fakemarket", "evt-1", "hook-secret", orderPayload("order/created", "2001", 2))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, first.DeliveryID, again.DeliveryID)
+		assert.Equal(t, 1, again.Attempts)
+		assert.Equal(t, uint(8), stock(t))
+	})
+
+	t.Run("OtherTopicsAreIgnored", func(t *testing.T) {
+		_, ret := deliver(t, "fakemarket", "evt-2", "hook-secret", fakeMarketWebhook{Topic: "product/updated"})
+		assert.Equal(t, WebhookIgnored, ret.Status)
+	})
+
+	t.Run("ReplayFailedDelivery", func(t *testing.T) {
+		rr, failed := deliver(t, "fakemarket", "evt-3", "hook-secret", orderPayload("order/created", "2002", 9))
+		require.Equal(t, http.StatusOK, rr.Code, "Processing failures are not reported to the marketplace")
+		assert.Equal(t, WebhookFailed, failed.Status)
+		assert.Contains(t, failed.Error, "insufficient stock")
+
+		rr = httptest.NewRecorder()
+		GetWebhookDeliveries(rr, createAuthenticatedRequest(t, seller, "GET", "/api/get_webhook_deliveries?status=failed", nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var deliveries []WebhookDeliveryReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &deliveries))
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, failed.DeliveryID, deliveries[0].DeliveryID)
+
+		replay := func(t *testing.T, user *usertable.User) *httptest.ResponseRecorder {
+			t.Helper()
+			rr := httptest.NewRecorder()
+			ReplayWebhookDelivery(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/replay_webhook_delivery?id=%d", failed.DeliveryID), nil))
+			return rr
+		}
+		assert.Equal(t, http.StatusNotFound, replay(t, other).Code, "Only the seller can replay their deliveries")
+
+		require.NoError(t, prodtable.AdjustStock(testDB, mug.ID, 0, 10, prodtable.StockChange{Reason: prodtable.StockReasonSync}))
+		rr = replay(t, seller)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var replayed WebhookDeliveryReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &replayed))
+		assert.Equal(t, WebhookProcessed, replayed.Status)
+		assert.Empty(t, replayed.Error)
+		assert.Equal(t, 2, replayed.Attempts)
+		assert.Equal(t, uint(9), stock(t))
+	})
+
+	t.Run("OrderCancelled", func(t *testing.T) {
+		_, ret := deliver(t, "fakemarket", "evt-4", "hook-secret", orderPayload("order/cancelled", "2001", 2))
+		assert.Equal(t, WebhookProcessed, ret.Status)
+		assert.Equal(t, uint(11), stock(t), "The cancelled order's stock is returned")
+	})
+
+	t.Run("OnlyOwnDeliveries", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GetWebhookDeliveries(rr, createAuthenticatedRequest(t, other, "GET", "/api/get_webhook_deliveries", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `[]`, rr.Body.String())
+	})
+}
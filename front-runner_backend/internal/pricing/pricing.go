@@ -0,0 +1,321 @@
+// Package pricing holds each seller's tax rates, shipping rates and discount codes, and turns
+// them into the tax, shipping and discount adjustment lines of an order.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"front-runner/internal/coredbutils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	db        *gorm.DB
+	setupOnce sync.Once
+)
+
+// ErrInvalidDiscount is returned when a discount code is unknown, expired, used up or not applicable.
+var ErrInvalidDiscount = errors.New("invalid discount code")
+
+// Adjustment kinds.
+const (
+	KindDiscount = "discount"
+	KindTax      = "tax"
+	KindShipping = "shipping"
+)
+
+// basisPoints is 100%, the scale of every rate (825 = 8.25%).
+const basisPoints = 10000
+
+// TaxRate is a seller's tax rate for a region. Region "" applies where no more specific rate exists.
+type TaxRate struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"not null;index:idx_tax_region,unique"`
+	Region string `gorm:"not null;index:idx_tax_region,unique"` // e.g. "US-CA", "US" or ""
+	Name   string `gorm:"not null"`                             // Shown on orders, e.g. "CA sales tax"
+	RateBP int64  `gorm:"not null"`                             // Basis points, 825 = 8.25%
+}
+
+// ShippingRate is a seller's flat shipping charge for a region, in one currency.
+type ShippingRate struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        uint   `gorm:"not null;index:idx_shipping_region,unique"`
+	Region        string `gorm:"not null;index:idx_shipping_region,unique"`
+	Currency      string `gorm:"size:3;not null;index:idx_shipping_region,unique"`
+	AmountMinor   int64  `gorm:"not null"`
+	FreeOverMinor int64  `gorm:"not null;default:0"` // Shipping is free from this discounted subtotal; 0 for never
+}
+
+// DiscountCode is a seller's code taking a percentage or a fixed amount off their items.
+type DiscountCode struct {
+	ID               uint       `gorm:"primaryKey"`
+	UserID           uint       `gorm:"not null;index:idx_discount_code,unique"`
+	Code             string     `gorm:"not null;index:idx_discount_code,unique"` // Stored upper-case
+	PercentBP        int64      `gorm:"not null;default:0"`                      // Percentage off in basis points, or 0 for a fixed amount
+	AmountMinor      int64      `gorm:"not null;default:0"`                      // Fixed amount off in Currency
+	Currency         string     `gorm:"size:3;not null;default:''"`              // Required for fixed amounts and minimum subtotals; "" for any
+	MinSubtotalMinor int64      `gorm:"not null;default:0"`
+	MaxUses          uint       `gorm:"not null;default:0"` // 0 for unlimited
+	Uses             uint       `gorm:"not null;default:0"`
+	ExpiresAt        *time.Time // nil for never
+	CreatedAt        time.Time
+}
+
+// Setup initializes the database connection for the pricing package.
+func Setup() {
+	setupOnce.Do(func() {
+		coredbutils.LoadEnv() // Ensure env vars are loaded if needed by GetDB
+		var err error
+		db, err = coredbutils.GetDB()
+		if err != nil {
+			log.Fatalf("pricing Setup: Failed to get database connection: %v", err)
+		}
+		if db == nil {
+			log.Fatal("pricing Setup: Database connection is nil after GetDB.")
+		}
+		log.Println("pricing package setup complete (DB connection obtained).")
+	})
+}
+
+// MigratePricingDB runs GORM auto-migration for the tax, shipping and discount tables.
+func MigratePricingDB() {
+	if db == nil {
+		log.Fatal("Database connection is not initialized for pricing migration")
+	}
+	log.Println("Running pricing database migrations...")
+	if err := db.AutoMigrate(&TaxRate{}, &ShippingRate{}, &DiscountCode{}); err != nil {
+		log.Fatalf("Pricing migration failed: %v", err)
+	}
+	log.Println("Pricing database migration complete")
+}
+
+// Line is a tax, shipping or discount adjustment to one seller's share of an order.
+type Line struct {
+	Kind        string
+	Label       string // e.g. the tax name or discount code
+	RateBP      int64  // Percentage in basis points for tax and percentage discounts; 0 for fixed amounts
+	FixedMinor  int64  // Amount of shipping and fixed discounts
+	AmountMinor int64  // Signed amount added to the total, negative for discounts; set by Compute
+}
+
+// Totals breaks down a seller's share of an order. Discount is reported as a positive amount.
+type Totals struct {
+	Subtotal int64
+	Discount int64
+	Tax      int64
+	Shipping int64
+	Total    int64
+}
+
+// percentOf returns bp basis points of amount, rounding half up. bp is at most basisPoints;
+// amount is split so that no intermediate product can overflow.
+func percentOf(amount, bp int64) int64 {
+	whole, rest := amount/basisPoints, amount%basisPoints
+	return whole*bp + (rest*bp+basisPoints/2)/basisPoints
+}
+
+// Compute sets the amount of every line for the given merchandise subtotal and returns the totals.
+// Discounts apply first and never exceed the subtotal; tax is charged on the discounted subtotal.
+// When nothing is left to charge for, as after a full cancellation, every line is zero.
+func Compute(subtotal int64, lines []Line) Totals {
+	discounted := subtotal
+	for i := range lines {
+		l := &lines[i]
+		l.AmountMinor = 0
+		if l.Kind != KindDiscount || subtotal <= 0 {
+			continue
+		}
+		off := l.FixedMinor
+		if l.RateBP != 0 {
+			off = percentOf(subtotal, l.RateBP)
+		}
+		off = min(off, discounted)
+		discounted -= off
+		l.AmountMinor = -off
+	}
+	for i := range lines {
+		l := &lines[i]
+		switch {
+		case subtotal <= 0:
+		case l.Kind == KindTax:
+			l.AmountMinor = percentOf(discounted, l.RateBP)
+		case l.Kind == KindShipping:
+			l.AmountMinor = l.FixedMinor
+		}
+	}
+	return Summarize(subtotal, lines)
+}
+
+// Summarize totals lines whose amounts are already set.
+func Summarize(subtotal int64, lines []Line) Totals {
+	totals := Totals{Subtotal: subtotal, Total: subtotal}
+	for _, l := range lines {
+		switch l.Kind {
+		case KindDiscount:
+			totals.Discount -= l.AmountMinor
+		case KindTax:
+			totals.Tax += l.AmountMinor
+		case KindShipping:
+			totals.Shipping += l.AmountMinor
+		}
+		totals.Total += l.AmountMinor
+	}
+	return totals
+}
+
+// NormalizeRegion upper-cases a region code such as "us-ca". Regions are a country code
+// optionally followed by "-" and a subdivision.
+func NormalizeRegion(region string) (string, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if len(region) > 16 {
+		return "", fmt.Errorf("invalid region %q", region)
+	}
+	for _, c := range region {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return "", fmt.Errorf("invalid region %q", region)
+		}
+	}
+	return region, nil
+}
+
+// regionCandidates lists the regions whose rates apply to region, most specific first.
+func regionCandidates(region string) []string {
+	candidates := []string{region}
+	if country, _, ok := strings.Cut(region, "-"); ok {
+		candidates = append(candidates, country)
+	}
+	if region != "" {
+		candidates = append(candidates, "")
+	}
+	return candidates
+}
+
+// mostSpecific returns the index of the first candidate region present in regions, or -1.
+func mostSpecific(candidates []string, regions []string) int {
+	for _, c := range candidates {
+		for i, r := range regions {
+			if r == c {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Request describes one seller's share of an order to price.
+type Request struct {
+	SellerID     uint
+	Currency     string
+	Region       string // Normalized shipping region; "" when unknown
+	Subtotal     int64  // Merchandise subtotal in minor units of Currency
+	DiscountCode string // Code assigned to this seller by AssignDiscountCodes; "" for none
+}
+
+// AssignDiscountCodes matches the buyer's codes to the sellers in an order. Every code must
+// belong to one of the sellers, and each seller accepts at most one code.
+func AssignDiscountCodes(tx *gorm.DB, codes []string, sellerIDs []uint) (map[uint]string, error) {
+	assigned := make(map[uint]string)
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		var matches []DiscountCode
+		if err := tx.Where("code = ? AND user_id IN ?", code, sellerIDs).Find(&matches).Error; err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w %q", ErrInvalidDiscount, code)
+		}
+		for _, m := range matches {
+			if existing, ok := assigned[m.UserID]; ok && existing != code {
+				return nil, fmt.Errorf("%w %q: only one code per seller can be used", ErrInvalidDiscount, code)
+			}
+			assigned[m.UserID] = code
+		}
+	}
+	return assigned, nil
+}
+
+// Quote builds the adjustment lines for one seller's share of an order, redeeming the
+// discount code if one applies. It must run inside the order's transaction.
+func Quote(tx *gorm.DB, req Request) ([]Line, error) {
+	var lines []Line
+
+	if req.DiscountCode != "" {
+		line, err := redeemDiscount(tx, req)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	candidates := regionCandidates(req.Region)
+	var taxRates []TaxRate
+	if err := tx.Where("user_id = ? AND region IN ?", req.SellerID, candidates).Find(&taxRates).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tax rates: %w", err)
+	}
+	taxRegions := make([]string, len(taxRates))
+	for i, r := range taxRates {
+		taxRegions[i] = r.Region
+	}
+	if i := mostSpecific(candidates, taxRegions); i >= 0 {
+		lines = append(lines, Line{Kind: KindTax, Label: taxRates[i].Name, RateBP: taxRates[i].RateBP})
+	}
+
+	var shippingRates []ShippingRate
+	if err := tx.Where("user_id = ? AND currency = ? AND region IN ?", req.SellerID, req.Currency, candidates).
+		Find(&shippingRates).Error; err != nil {
+		return nil, fmt.Errorf("failed to load shipping rates: %w", err)
+	}
+	shippingRegions := make([]string, len(shippingRates))
+	for i, r := range shippingRates {
+		shippingRegions[i] = r.Region
+	}
+	if i := mostSpecific(candidates, shippingRegions); i >= 0 {
+		rate := shippingRates[i]
+		line := Line{Kind: KindShipping, Label: "Shipping", FixedMinor: rate.AmountMinor}
+		// Free shipping thresholds are judged on the discounted subtotal
+		if totals := Compute(req.Subtotal, lines); rate.FreeOverMinor > 0 && totals.Subtotal-totals.Discount >= rate.FreeOverMinor {
+			line.Label, line.FixedMinor = "Free shipping", 0
+		}
+		lines = append(lines, line)
+	}
+
+	Compute(req.Subtotal, lines)
+	return lines, nil
+}
+
+// redeemDiscount validates the seller's discount code for the order and counts one use of it.
+func redeemDiscount(tx *gorm.DB, req Request) (Line, error) {
+	var code DiscountCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND code = ?", req.SellerID, req.DiscountCode).First(&code).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Line{}, fmt.Errorf("%w %q", ErrInvalidDiscount, req.DiscountCode)
+	}
+	if err != nil {
+		return Line{}, err
+	}
+	switch {
+	case code.ExpiresAt != nil && !time.Now().Before(*code.ExpiresAt):
+		return Line{}, fmt.Errorf("%w %q: expired", ErrInvalidDiscount, code.Code)
+	case code.MaxUses > 0 && code.Uses >= code.MaxUses:
+		return Line{}, fmt.Errorf("%w %q: no uses left", ErrInvalidDiscount, code.Code)
+	case code.Currency != "" && code.Currency != req.Currency:
+		return Line{}, fmt.Errorf("%w %q: not valid for %s orders", ErrInvalidDiscount, code.Code, req.Currency)
+	case req.Subtotal < code.MinSubtotalMinor:
+		return Line{}, fmt.Errorf("%w %q: order subtotal is below the minimum", ErrInvalidDiscount, code.Code)
+	}
+	if err := tx.Model(&code).Update("uses", gorm.Expr("uses + 1")).Error; err != nil {
+		return Line{}, err
+	}
+	return Line{Kind: KindDiscount, Label: code.Code, RateBP: code.PercentBP, FixedMinor: code.AmountMinor}, nil
+}
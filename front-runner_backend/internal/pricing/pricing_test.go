@@ -0,0 +1,85 @@
+// internal/pricing/pricing_test.go
+package pricing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/money"
+)
+
+func TestCompute(t *testing.T) {
+	lines := []Line{
+		{Kind: KindDiscount, Label: "SAVE10", RateBP: 1000},
+		{Kind: KindTax, Label: "Tax", RateBP: 825},
+		{Kind: KindShipping, Label: "Shipping", FixedMinor: 500},
+	}
+	totals := Compute(6000, lines)
+	assert.Equal(t, Totals{Subtotal: 6000, Discount: 600, Tax: 446, Shipping: 500, Total: 6346}, totals)
+	assert.Equal(t, int64(-600), lines[0].AmountMinor, "Discounts are negative lines")
+
+	t.Run("FixedDiscountCappedAtSubtotal", func(t *testing.T) {
+		lines := []Line{{Kind: KindDiscount, FixedMinor: 5000}, {Kind: KindTax, RateBP: 1000}}
+		totals := Compute(3000, lines)
+		assert.Equal(t, int64(3000), totals.Discount)
+		assert.Equal(t, int64(0), totals.Tax)
+		assert.Equal(t, int64(0), totals.Total)
+	})
+
+	t.Run("LargestAmounts", func(t *testing.T) {
+		lines := []Line{{Kind: KindDiscount, RateBP: 1000}, {Kind: KindTax, RateBP: basisPoints}}
+		totals := Compute(money.MaxMinor, lines)
+		assert.Equal(t, Totals{Subtotal: money.MaxMinor, Discount: money.MaxMinor / 10, Tax: money.MaxMinor / 10 * 9,
+			Total: money.MaxMinor / 10 * 18}, totals, "Percentages of the largest subtotal do not overflow")
+	})
+
+	t.Run("NothingLeftToCharge", func(t *testing.T) {
+		totals := Compute(0, lines)
+		assert.Equal(t, Totals{}, totals, "Shipping is not charged once every item is cancelled")
+	})
+}
+
+func TestNormalizeRegion(t *testing.T) {
+	region, err := NormalizeRegion(" us-ca ")
+	require.NoError(t, err)
+	assert.Equal(t, "US-CA", region)
+	_, err = NormalizeRegion("US CA")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"US-CA", "US", ""}, regionCandidates("US-CA"))
+	assert.Equal(t, []string{"DE", ""}, regionCandidates("DE"))
+	assert.Equal(t, []string{""}, regionCandidates(""))
+	assert.Equal(t, 1, mostSpecific(regionCandidates("US-CA"), []string{"", "US"}))
+	assert.Equal(t, -1, mostSpecific(regionCandidates("DE"), []string{"US"}))
+}
+
+func TestNewDiscountCode(t *testing.T) {
+	percent, amount, minSubtotal := json.Number("12.5"), json.Number("5"), json.Number("20")
+
+	code, err := newDiscountCode(1, DiscountCodePayload{Code: " summer ", Percent: &percent})
+	require.NoError(t, err)
+	assert.Equal(t, "SUMMER", code.Code)
+	assert.Equal(t, int64(1250), code.PercentBP)
+
+	code, err = newDiscountCode(1, DiscountCodePayload{Code: "FIVE", Amount: &amount, Currency: "usd", MinSubtotal: &minSubtotal})
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), code.AmountMinor)
+	assert.Equal(t, int64(2000), code.MinSubtotalMinor)
+	assert.Equal(t, "USD", code.Currency)
+
+	tooMuch := json.Number("101")
+	for name, payload := range map[string]DiscountCodePayload{
+		"NoCode":            {Percent: &percent},
+		"PercentAndAmount":  {Code: "X", Percent: &percent, Amount: &amount, Currency: "USD"},
+		"Neither":           {Code: "X"},
+		"AmountNoCurrency":  {Code: "X", Amount: &amount},
+		"OverHundredPct":    {Code: "X", Percent: &tooMuch},
+		"MinimumNoCurrency": {Code: "X", Percent: &percent, MinSubtotal: &minSubtotal},
+	} {
+		_, err := newDiscountCode(1, payload)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,548 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"front-runner/internal/money"
+	"front-runner/internal/oauth"
+
+	"gorm.io/gorm/clause"
+)
+
+// TaxRatePayload sets the tax rate of a region.
+type TaxRatePayload struct {
+	Region string      `json:"region"` // e.g. "US-CA", "US", or "" for everywhere else
+	Name   string      `json:"name"`   // Defaults to "Tax"
+	Rate   json.Number `json:"rate"`   // Percentage with up to two decimals, e.g. 8.25
+}
+
+// TaxRateReturn is a tax rate as returned by the API.
+type TaxRateReturn struct {
+	ID     uint        `json:"id"`
+	Region string      `json:"region"`
+	Name   string      `json:"name"`
+	Rate   json.Number `json:"rate"`
+}
+
+// ShippingRatePayload sets the shipping charge of a region in one currency.
+type ShippingRatePayload struct {
+	Region   string       `json:"region"`
+	Currency string       `json:"currency"`
+	Amount   json.Number  `json:"amount"`
+	FreeOver *json.Number `json:"freeOver"` // Optional subtotal, after discounts, from which shipping is free
+}
+
+// ShippingRateReturn is a shipping rate as returned by the API.
+type ShippingRateReturn struct {
+	ID       uint        `json:"id"`
+	Region   string      `json:"region"`
+	Currency string      `json:"currency"`
+	Amount   json.Number `json:"amount"`
+	FreeOver json.Number `json:"freeOver,omitempty"`
+}
+
+// DiscountCodePayload creates a discount code. Exactly one of Percent and Amount must be given.
+type DiscountCodePayload struct {
+	Code        string       `json:"code"`
+	Percent     *json.Number `json:"percent"`     // Percentage off, e.g. 10
+	Amount      *json.Number `json:"amount"`      // Fixed amount off; requires currency
+	Currency    string       `json:"currency"`    // Restricts the code to orders in this currency
+	MinSubtotal *json.Number `json:"minSubtotal"` // Minimum subtotal of the seller's items; requires currency
+	MaxUses     uint         `json:"maxUses"`     // 0 for unlimited
+	ExpiresAt   *time.Time   `json:"expiresAt"`
+}
+
+// DiscountCodeReturn is a discount code as returned by the API.
+type DiscountCodeReturn struct {
+	ID          uint        `json:"id"`
+	Code        string      `json:"code"`
+	Percent     json.Number `json:"percent,omitempty"`
+	Amount      json.Number `json:"amount,omitempty"`
+	Currency    string      `json:"currency,omitempty"`
+	MinSubtotal json.Number `json:"minSubtotal,omitempty"`
+	MaxUses     uint        `json:"maxUses"`
+	Uses        uint        `json:"uses"`
+	ExpiresAt   *time.Time  `json:"expiresAt,omitempty"`
+}
+
+// parseRate converts a percentage such as "8.25" into basis points, rejecting rates above 100%.
+func parseRate(rate json.Number) (int64, error) {
+	bp, err := money.ParseDecimal(string(rate), 2)
+	if err != nil || bp > basisPoints {
+		return 0, fmt.Errorf("invalid rate %q: must be a percentage between 0 and 100 with at most two decimals", rate)
+	}
+	return bp, nil
+}
+
+// formatRate renders basis points as a percentage.
+func formatRate(bp int64) json.Number {
+	return json.Number(money.FormatDecimal(bp, 2))
+}
+
+func setTaxRateReturn(r TaxRate) TaxRateReturn {
+	return TaxRateReturn{ID: r.ID, Region: r.Region, Name: r.Name, Rate: formatRate(r.RateBP)}
+}
+
+func setShippingRateReturn(r ShippingRate) ShippingRateReturn {
+	ret := ShippingRateReturn{ID: r.ID, Region: r.Region, Currency: r.Currency, Amount: money.Number(r.AmountMinor, r.Currency)}
+	if r.FreeOverMinor > 0 {
+		ret.FreeOver = money.Number(r.FreeOverMinor, r.Currency)
+	}
+	return ret
+}
+
+func setDiscountCodeReturn(c DiscountCode) DiscountCodeReturn {
+	ret := DiscountCodeReturn{ID: c.ID, Code: c.Code, Currency: c.Currency, MaxUses: c.MaxUses, Uses: c.Uses, ExpiresAt: c.ExpiresAt}
+	if c.PercentBP > 0 {
+		ret.Percent = formatRate(c.PercentBP)
+	} else {
+		ret.Amount = money.Number(c.AmountMinor, c.Currency)
+	}
+	if c.MinSubtotalMinor > 0 {
+		ret.MinSubtotal = money.Number(c.MinSubtotalMinor, c.Currency)
+	}
+	return ret
+}
+
+// newDiscountCode validates a discount code payload.
+func newDiscountCode(userID uint, payload DiscountCodePayload) (*DiscountCode, error) {
+	code := &DiscountCode{
+		UserID:    userID,
+		Code:      strings.ToUpper(strings.TrimSpace(payload.Code)),
+		MaxUses:   payload.MaxUses,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	if code.Code == "" || len(code.Code) > 64 || strings.ContainsAny(code.Code, " \t\n") {
+		return nil, errors.New("code is required and cannot contain spaces")
+	}
+	if payload.Currency != "" {
+		currency, err := money.NormalizeCurrency(payload.Currency)
+		if err != nil {
+			return nil, err
+		}
+		code.Currency = currency
+	}
+
+	switch {
+	case (payload.Percent == nil) == (payload.Amount == nil):
+		return nil, errors.New("give either a percent or an amount")
+	case payload.Percent != nil:
+		bp, err := parseRate(*payload.Percent)
+		if err != nil || bp == 0 {
+			return nil, fmt.Errorf("invalid percent %q", *payload.Percent)
+		}
+		code.PercentBP = bp
+	default:
+		if code.Currency == "" {
+			return nil, errors.New("a fixed amount requires a currency")
+		}
+		amount, err := money.Parse(string(*payload.Amount), code.Currency)
+		if err != nil || amount == 0 {
+			return nil, fmt.Errorf("invalid amount %q", *payload.Amount)
+		}
+		code.AmountMinor = amount
+	}
+
+	if payload.MinSubtotal != nil {
+		if code.Currency == "" {
+			return nil, errors.New("a minimum subtotal requires a currency")
+		}
+		minSubtotal, err := money.Parse(string(*payload.MinSubtotal), code.Currency)
+		if err != nil {
+			return nil, err
+		}
+		code.MinSubtotalMinor = minSubtotal
+	}
+	return code, nil
+}
+
+// SetTaxRate creates or replaces the authenticated seller's tax rate for a region.
+//
+// @Summary      Set a tax rate
+// @Description  Creates or replaces the seller's tax rate for a region. The most specific rate applies to an order: "US-CA", then "US", then the default region "".
+// @Tags         Pricing
+// @Accept       application/json
+// @Produce      application/json
+// @Param        rate  body      TaxRatePayload  true  "Region and rate"
+// @Success      200   {object}  TaxRateReturn "The saved tax rate"
+// @Failure      400   {string}  string "Bad Request: Invalid region or rate"
+// @Failure      401   {string}  string "Unauthorized: User not authenticated"
+// @Failure      500   {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/set_tax_rate [put]
+func SetTaxRate(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("SetTaxRate: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var payload TaxRatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	region, err := NormalizeRegion(payload.Region)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bp, err := parseRate(payload.Rate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate := TaxRate{UserID: user.ID, Region: region, Name: strings.TrimSpace(payload.Name), RateBP: bp}
+	if rate.Name == "" {
+		rate.Name = "Tax"
+	}
+
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "region"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "rate_bp"}),
+	}).Create(&rate).Error
+	if err != nil {
+		log.Printf("SetTaxRate: Error saving tax rate for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, setTaxRateReturn(rate))
+}
+
+// GetTaxRates lists the authenticated seller's tax rates.
+//
+// @Summary      Get tax rates
+// @Description  Lists the seller's tax rates by region.
+// @Tags         Pricing
+// @Produce      application/json
+// @Success      200  {array}   TaxRateReturn "The seller's tax rates"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_tax_rates [get]
+func GetTaxRates(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetTaxRates: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var rates []TaxRate
+	if err := db.Where("user_id = ?", user.ID).Order("region").Find(&rates).Error; err != nil {
+		log.Printf("GetTaxRates: Error loading tax rates for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]TaxRateReturn, len(rates))
+	for i, rate := range rates {
+		ret[i] = setTaxRateReturn(rate)
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// DeleteTaxRate removes one of the authenticated seller's tax rates.
+//
+// @Summary      Delete a tax rate
+// @Description  Deletes a tax rate. Existing orders keep the tax they were charged.
+// @Tags         Pricing
+// @Produce      text/plain
+// @Param        id   query     int  true  "Tax rate ID" Format(uint64)
+// @Success      200  {string}  string "Tax rate deleted successfully"
+// @Failure      400  {string}  string "Bad Request: Invalid tax rate ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      404  {string}  string "Not Found: Tax rate not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/delete_tax_rate [delete]
+func DeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	deleteOwned(w, r, "DeleteTaxRate", "Tax rate", &TaxRate{})
+}
+
+// SetShippingRate creates or replaces the authenticated seller's shipping charge for a region and currency.
+//
+// @Summary      Set a shipping rate
+// @Description  Creates or replaces the seller's flat shipping charge for a region and currency, optionally free from a subtotal after discounts. The most specific region applies, as for tax rates.
+// @Tags         Pricing
+// @Accept       application/json
+// @Produce      application/json
+// @Param        rate  body      ShippingRatePayload  true  "Region, currency and charge"
+// @Success      200   {object}  ShippingRateReturn "The saved shipping rate"
+// @Failure      400   {string}  string "Bad Request: Invalid region, currency or amount"
+// @Failure      401   {string}  string "Unauthorized: User not authenticated"
+// @Failure      500   {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/set_shipping_rate [put]
+func SetShippingRate(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("SetShippingRate: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var payload ShippingRatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	region, err := NormalizeRegion(payload.Region)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	currency, err := money.NormalizeCurrency(payload.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate := ShippingRate{UserID: user.ID, Region: region, Currency: currency}
+	if rate.AmountMinor, err = money.Parse(string(payload.Amount), currency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.FreeOver != nil {
+		if rate.FreeOverMinor, err = money.Parse(string(*payload.FreeOver), currency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "region"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"amount_minor", "free_over_minor"}),
+	}).Create(&rate).Error
+	if err != nil {
+		log.Printf("SetShippingRate: Error saving shipping rate for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, setShippingRateReturn(rate))
+}
+
+// GetShippingRates lists the authenticated seller's shipping rates.
+//
+// @Summary      Get shipping rates
+// @Description  Lists the seller's shipping rates by region and currency.
+// @Tags         Pricing
+// @Produce      application/json
+// @Success      200  {array}   ShippingRateReturn "The seller's shipping rates"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_shipping_rates [get]
+func GetShippingRates(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetShippingRates: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var rates []ShippingRate
+	if err := db.Where("user_id = ?", user.ID).Order("region, currency").Find(&rates).Error; err != nil {
+		log.Printf("GetShippingRates: Error loading shipping rates for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]ShippingRateReturn, len(rates))
+	for i, rate := range rates {
+		ret[i] = setShippingRateReturn(rate)
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// DeleteShippingRate removes one of the authenticated seller's shipping rates.
+//
+// @Summary      Delete a shipping rate
+// @Description  Deletes a shipping rate. Existing orders keep the shipping they were charged.
+// @Tags         Pricing
+// @Produce      text/plain
+// @Param        id   query     int  true  "Shipping rate ID" Format(uint64)
+// @Success      200  {string}  string "Shipping rate deleted successfully"
+// @Failure      400  {string}  string "Bad Request: Invalid shipping rate ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      404  {string}  string "Not Found: Shipping rate not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/delete_shipping_rate [delete]
+func DeleteShippingRate(w http.ResponseWriter, r *http.Request) {
+	deleteOwned(w, r, "DeleteShippingRate", "Shipping rate", &ShippingRate{})
+}
+
+// AddDiscountCode creates a discount code for the authenticated seller's products.
+//
+// @Summary      Add a discount code
+// @Description  Creates a code taking a percentage or a fixed amount off the seller's items in an order. Codes are case-insensitive and can be limited by currency, minimum subtotal, number of uses and expiry.
+// @Tags         Pricing
+// @Accept       application/json
+// @Produce      application/json
+// @Param        code  body      DiscountCodePayload  true  "Discount code details"
+// @Success      201   {object}  DiscountCodeReturn "The created discount code"
+// @Failure      400   {string}  string "Bad Request: Invalid discount code"
+// @Failure      401   {string}  string "Unauthorized: User not authenticated"
+// @Failure      409   {string}  string "Conflict: Code already exists"
+// @Failure      500   {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/add_discount_code [post]
+func AddDiscountCode(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("AddDiscountCode: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var payload DiscountCodePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	code, err := newDiscountCode(user.ID, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(code)
+	if result.Error != nil {
+		log.Printf("AddDiscountCode: Error creating discount code for user %d: %v", user.ID, result.Error)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "You already have a discount code "+code.Code, http.StatusConflict)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, setDiscountCodeReturn(*code))
+}
+
+// GetDiscountCodes lists the authenticated seller's discount codes.
+//
+// @Summary      Get discount codes
+// @Description  Lists the seller's discount codes with how often each has been used.
+// @Tags         Pricing
+// @Produce      application/json
+// @Success      200  {array}   DiscountCodeReturn "The seller's discount codes"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_discount_codes [get]
+func GetDiscountCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetDiscountCodes: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var codes []DiscountCode
+	if err := db.Where("user_id = ?", user.ID).Order("code").Find(&codes).Error; err != nil {
+		log.Printf("GetDiscountCodes: Error loading discount codes for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]DiscountCodeReturn, len(codes))
+	for i, code := range codes {
+		ret[i] = setDiscountCodeReturn(code)
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// DeleteDiscountCode removes one of the authenticated seller's discount codes.
+//
+// @Summary      Delete a discount code
+// @Description  Deletes a discount code so it can no longer be used. Existing orders keep their discount.
+// @Tags         Pricing
+// @Produce      text/plain
+// @Param        id   query     int  true  "Discount code ID" Format(uint64)
+// @Success      200  {string}  string "Discount code deleted successfully"
+// @Failure      400  {string}  string "Bad Request: Invalid discount code ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      404  {string}  string "Not Found: Discount code not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/delete_discount_code [delete]
+func DeleteDiscountCode(w http.ResponseWriter, r *http.Request) {
+	deleteOwned(w, r, "DeleteDiscountCode", "Discount code", &DiscountCode{})
+}
+
+// deleteOwned deletes the row of model with the requested ID if it belongs to the authenticated user.
+func deleteOwned(w http.ResponseWriter, r *http.Request, handler, what string, model interface{}) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("%s: Error getting current user: %v", handler, err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid "+what+" ID", http.StatusBadRequest)
+		return
+	}
+	result := db.Where("id = ? AND user_id = ?", id, user.ID).Delete(model)
+	if result.Error != nil {
+		log.Printf("%s: Error deleting ID %d: %v", handler, id, result.Error)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, what+" not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, what+" deleted successfully")
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response to JSON: %v", err)
+	}
+}
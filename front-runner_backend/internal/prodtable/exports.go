@@ -0,0 +1,417 @@
+package prodtable
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"front-runner/internal/money"
+	"front-runner/internal/oauth"
+)
+
+// Export formats.
+const (
+	ExportFormatCSV   = "csv"   // Same columns as an import file, so it can be imported again
+	ExportFormatJSONL = "jsonl" // One ProductExportItem per line
+	ExportFormatFeed  = "feed"  // Google Merchant RSS 2.0 product feed
+)
+
+// exportBatchSize is the number of products read from the database at a time while exporting.
+const exportBatchSize = 200
+
+// maxFeedAdditionalImages is the most additional_image_link entries a Merchant feed item may have.
+const maxFeedAdditionalImages = 10
+
+// imageBaseURL is where product image files are publicly served from, e.g. a CDN in front of the
+// blob store; set from IMAGE_BASE_URL. Without it, JSON Lines exports link to GetProductImage,
+// and CSV and feed exports, which are read without the seller's session, are refused.
+var imageBaseURL string
+
+// ProductVariantExport is a variant in a JSON Lines export.
+type ProductVariantExport struct {
+	SKU     string            `json:"sku"`
+	Title   string            `json:"title"`
+	Options map[string]string `json:"options"`
+	Price   json.Number       `json:"price" swaggertype:"string"`
+	Count   uint              `json:"count"`
+	Image   string            `json:"image,omitempty"` // URL of the variant's image
+}
+
+// ProductExportItem is one line of a JSON Lines export.
+type ProductExportItem struct {
+	ID               uint                   `json:"id"`
+	ProductName      string                 `json:"productName"`
+	Description      string                 `json:"description"`
+	Price            json.Number            `json:"price" swaggertype:"string"`
+	Currency         string                 `json:"currency"`
+	Count            uint                   `json:"count"`
+	ReorderThreshold uint                   `json:"reorderThreshold"`
+	Tags             []string               `json:"tags"`
+	Images           []string               `json:"images"` // URLs, primary image first
+	Variants         []ProductVariantExport `json:"variants,omitempty"`
+}
+
+// feedItem is an item of a Google Merchant product feed.
+type feedItem struct {
+	XMLName              xml.Name `xml:"item"`
+	ID                   string   `xml:"g:id"`
+	ItemGroupID          string   `xml:"g:item_group_id,omitempty"` // Product ID shared by its variants
+	Title                string   `xml:"g:title"`
+	Description          string   `xml:"g:description"`
+	Link                 string   `xml:"g:link"`
+	ImageLink            string   `xml:"g:image_link,omitempty"`
+	AdditionalImageLinks []string `xml:"g:additional_image_link"`
+	Availability         string   `xml:"g:availability"`
+	Price                string   `xml:"g:price"`
+	ProductType          string   `xml:"g:product_type,omitempty"`
+}
+
+// errNoPublicImages is returned for CSV and feed exports when IMAGE_BASE_URL is not configured.
+var errNoPublicImages = errors.New("csv and feed exports need public image URLs: IMAGE_BASE_URL is not configured")
+
+// imageURLFunc returns absolute URLs of stored images by filename.
+type imageURLFunc func(name string) string
+
+// exportImageURL builds image URLs under imageBaseURL, or else through GetProductImage on the
+// host the request came to.
+func exportImageURL(r *http.Request) imageURLFunc {
+	if imageBaseURL != "" {
+		return func(name string) string { return imageBaseURL + "/" + url.PathEscape(name) }
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host + "/api/get_product_image?image="
+	return func(name string) string { return base + url.QueryEscape(name) }
+}
+
+// galleryURLs returns the URLs of a product's images, primary image first. Images must be preloaded.
+func galleryURLs(product *Product, imageURL imageURLFunc) []string {
+	urls := make([]string, 0, len(product.Images))
+	for _, img := range product.Images {
+		if img.ID == product.ImgID {
+			urls = append([]string{imageURL(img.URL)}, urls...)
+		} else {
+			urls = append(urls, imageURL(img.URL))
+		}
+	}
+	return urls
+}
+
+// tagNames returns the product's tag names, sorted. Tags must be preloaded.
+func tagNames(product *Product) []string {
+	if len(product.Tags) == 0 {
+		return []string{}
+	}
+	return strings.Split(joinTagNames(product.Tags), ",")
+}
+
+// exportCSVHeader lists the columns of a CSV export, which are those of an import file.
+var exportCSVHeader = importColumns
+
+// exportCSVRecord returns a product's row of a CSV export. The count of a product with variants
+// is left blank: its stock is the variants', which an import cannot set.
+func exportCSVRecord(product *Product, imageURL imageURLFunc) []string {
+	count := ""
+	if len(product.Variants) == 0 {
+		count = strconv.FormatUint(uint64(product.ProdCount), 10)
+	}
+	return []string{
+		product.ProdName,
+		product.ProdDescription,
+		money.Format(product.PriceMinor, product.Currency),
+		product.Currency,
+		count,
+		strconv.FormatUint(uint64(product.ReorderThreshold), 10),
+		joinTagNames(product.Tags),
+		strings.Join(galleryURLs(product, imageURL), importImageSeparator),
+	}
+}
+
+// exportJSONItem returns a product's line of a JSON Lines export.
+func exportJSONItem(product *Product, imageURL imageURLFunc) ProductExportItem {
+	item := ProductExportItem{
+		ID:               product.ID,
+		ProductName:      product.ProdName,
+		Description:      product.ProdDescription,
+		Price:            money.Number(product.PriceMinor, product.Currency),
+		Currency:         product.Currency,
+		Count:            product.ProdCount,
+		ReorderThreshold: product.ReorderThreshold,
+		Tags:             tagNames(product),
+		Images:           galleryURLs(product, imageURL),
+	}
+	images := imagesByID(product)
+	for _, v := range product.Variants {
+		variant := ProductVariantExport{
+			SKU:     v.SKU,
+			Title:   v.Title,
+			Options: v.Options,
+			Price:   money.Number(v.PriceMinor, product.Currency),
+			Count:   v.Count,
+		}
+		if v.ImageID != nil {
+			if name, ok := images[*v.ImageID]; ok {
+				variant.Image = imageURL(name)
+			}
+		}
+		item.Variants = append(item.Variants, variant)
+	}
+	return item
+}
+
+// imagesByID maps the IDs of a product's images to their filenames.
+func imagesByID(product *Product) map[uint]string {
+	images := make(map[uint]string, len(product.Images))
+	for _, img := range product.Images {
+		images[img.ID] = img.URL
+	}
+	return images
+}
+
+// feedAvailability returns the Merchant availability of a stock count.
+func feedAvailability(count uint) string {
+	if count > 0 {
+		return "in_stock"
+	}
+	return "out_of_stock"
+}
+
+// exportFeedItems returns a product's items of a Merchant feed: the product itself, or one item per
+// variant grouped by the product's ID. link has "{id}" replaced by the product's ID.
+func exportFeedItems(product *Product, link string, imageURL imageURLFunc) []feedItem {
+	urls := galleryURLs(product, imageURL)
+	base := feedItem{
+		ID:          strconv.FormatUint(uint64(product.ID), 10),
+		Title:       product.ProdName,
+		Description: product.ProdDescription,
+		Link:        strings.ReplaceAll(link, "{id}", strconv.FormatUint(uint64(product.ID), 10)),
+		ProductType: joinTagNames(product.Tags),
+	}
+	if len(urls) > 0 {
+		base.ImageLink = urls[0]
+		base.AdditionalImageLinks = urls[1:min(len(urls), maxFeedAdditionalImages+1)]
+	}
+	if len(product.Variants) == 0 {
+		base.Availability = feedAvailability(product.ProdCount)
+		base.Price = money.Format(product.PriceMinor, product.Currency) + " " + product.Currency
+		return []feedItem{base}
+	}
+
+	images := imagesByID(product)
+	items := make([]feedItem, 0, len(product.Variants))
+	for _, v := range product.Variants {
+		item := base
+		item.ID = v.SKU
+		item.ItemGroupID = base.ID
+		item.Title = product.ProdName + " - " + v.Title
+		item.Availability = feedAvailability(v.Count)
+		item.Price = money.Format(v.PriceMinor, product.Currency) + " " + product.Currency
+		if v.ImageID != nil {
+			if name, ok := images[*v.ImageID]; ok {
+				item.ImageLink = imageURL(name)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// streamProducts calls fn with the user's products in ID order, a batch at a time, with their
+// images, tags and variants preloaded. Only one batch is held in memory.
+func streamProducts(ctx context.Context, userID uint, fn func([]Product) error) error {
+	var lastID uint
+	for {
+		var batch []Product
+		if err := db.WithContext(ctx).Preload("Images", orderImages).Preload("Tags").Preload("Variants", orderVariants).
+			Where("user_id = ? AND id > ?", userID, lastID).Order("id").Limit(exportBatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+// productExporter writes products in one export format.
+type productExporter struct {
+	begin func(w io.Writer) error
+	write func(w io.Writer, product *Product) error
+	end   func(w io.Writer) error
+}
+
+// newCSVExporter exports products as CSV.
+func newCSVExporter(imageURL imageURLFunc) productExporter {
+	var writer *csv.Writer
+	return productExporter{
+		begin: func(w io.Writer) error {
+			writer = csv.NewWriter(w)
+			return writer.Write(exportCSVHeader)
+		},
+		write: func(_ io.Writer, product *Product) error {
+			return writer.Write(exportCSVRecord(product, imageURL))
+		},
+		end: func(io.Writer) error {
+			writer.Flush()
+			return writer.Error()
+		},
+	}
+}
+
+// newJSONLExporter exports products as JSON Lines.
+func newJSONLExporter(imageURL imageURLFunc) productExporter {
+	return productExporter{
+		begin: func(io.Writer) error { return nil },
+		write: func(w io.Writer, product *Product) error {
+			return json.NewEncoder(w).Encode(exportJSONItem(product, imageURL))
+		},
+		end: func(io.Writer) error { return nil },
+	}
+}
+
+// newFeedExporter exports products as a Google Merchant RSS 2.0 feed.
+func newFeedExporter(title, channelLink, itemLink string, imageURL imageURLFunc) productExporter {
+	return productExporter{
+		begin: func(w io.Writer) error {
+			if _, err := io.WriteString(w, xml.Header+`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0">`+"\n<channel>\n"); err != nil {
+				return err
+			}
+			for _, el := range [][2]string{{"title", title}, {"link", channelLink}, {"description", title}} {
+				if _, err := fmt.Fprintf(w, "<%s>", el[0]); err != nil {
+					return err
+				}
+				if err := xml.EscapeText(w, []byte(el[1])); err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "</%s>\n", el[0]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		write: func(w io.Writer, product *Product) error {
+			for _, item := range exportFeedItems(product, itemLink, imageURL) {
+				out, err := xml.Marshal(item)
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(append(out, '\n')); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		end: func(w io.Writer) error {
+			_, err := io.WriteString(w, "</channel>\n</rss>\n")
+			return err
+		},
+	}
+}
+
+// ExportProducts streams all of the current user's products in the requested format.
+//
+// @Summary      Export products
+// @Description  Streams every product of the authenticated user with absolute image URLs, as CSV (the columns of an import file, so it can be imported again; count is left blank for products with variants, whose stock is kept per variant), JSON Lines (one ProductExportItem per line) or a Google Merchant RSS 2.0 product feed, where products with variants become one item per variant grouped by product ID. Image URLs are under IMAGE_BASE_URL when it is configured; otherwise JSON Lines image URLs point at /api/get_product_image, which needs the seller's session, and CSV and feed exports are refused, as their images would not load where they are used. If the export fails partway, the connection is closed without completing the response.
+// @Tags         Products
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Produce      application/xml
+// @Param        format        query  string  true   "Export format" Enums(csv, jsonl, feed)
+// @Param        linkTemplate  query  string  false  "Feed only (required): absolute URL of a product's page, with {id} replaced by the product ID"
+// @Param        title         query  string  false  "Feed only: title of the feed"
+// @Success      200  {file}    file "The exported products"
+// @Failure      400  {string}  string "Bad Request: Invalid format or link template, or IMAGE_BASE_URL not configured for a CSV or feed export"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Security     ApiKeyAuth
+// @Router       /api/export_products [get]
+func ExportProducts(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ExportProducts: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	imageURL := exportImageURL(r)
+	var exporter productExporter
+	var contentType, filename string
+	format := strings.ToLower(q.Get("format"))
+	if (format == ExportFormatCSV || format == ExportFormatFeed) && imageBaseURL == "" {
+		http.Error(w, errNoPublicImages.Error(), http.StatusBadRequest)
+		return
+	}
+	switch format {
+	case ExportFormatCSV:
+		exporter, contentType, filename = newCSVExporter(imageURL), "text/csv; charset=utf-8", "products.csv"
+	case ExportFormatJSONL:
+		exporter, contentType, filename = newJSONLExporter(imageURL), "application/x-ndjson", "products.jsonl"
+	case ExportFormatFeed:
+		link := q.Get("linkTemplate")
+		parsed, err := url.Parse(strings.ReplaceAll(link, "{id}", "0"))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || !strings.Contains(link, "{id}") {
+			http.Error(w, "linkTemplate must be an absolute http(s) URL containing {id}", http.StatusBadRequest)
+			return
+		}
+		title := q.Get("title")
+		if title == "" {
+			title = "Products"
+			if user.Name != "" {
+				title = user.Name + " products"
+			}
+		}
+		channelLink := parsed.Scheme + "://" + parsed.Host
+		exporter, contentType, filename = newFeedExporter(title, channelLink, link, imageURL), "application/xml; charset=utf-8", "products.xml"
+	default:
+		http.Error(w, "format must be csv, jsonl or feed", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	rc := http.NewResponseController(w)
+	err = exporter.begin(w)
+	if err == nil {
+		err = streamProducts(r.Context(), user.ID, func(batch []Product) error {
+			for i := range batch {
+				if err := exporter.write(w, &batch[i]); err != nil {
+					return err
+				}
+			}
+			// Send each batch on rather than buffering the whole export
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = exporter.end(w)
+	}
+	if err != nil {
+		// The status line is already sent, so abort the connection to signal an incomplete export
+		log.Printf("ExportProducts: Error exporting products of user %d: %v", user.ID, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,226 @@
+// internal/prodtable/exports_test.go
+package prodtable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImageURL(name string) string { return "https://cdn.example.com/" + name }
+
+// exportTestProduct is a product with its images, tags and variants loaded, as streamProducts returns them.
+func exportTestProduct() *Product {
+	blue := uint(12)
+	return &Product{
+		ID: 7, ProdName: "Tee", ProdDescription: "Cotton T-shirt", PriceMinor: 1550, Currency: "USD",
+		ProdCount: 3, ReorderThreshold: 2, ImgID: 11,
+		Images: []Image{{ID: 12, URL: "blue.png"}, {ID: 11, URL: "front.png"}},
+		Tags:   []Tag{{Name: "summer"}, {Name: "clothing"}},
+		Variants: []ProductVariant{
+			{SKU: "TEE-S", Title: "S", Options: map[string]string{"Size": "S"}, PriceMinor: 1500, Count: 0},
+			{SKU: "TEE-M", Title: "M", Options: map[string]string{"Size": "M"}, PriceMinor: 1600, Count: 3, ImageID: &blue},
+		},
+	}
+}
+
+func TestExportCSVRecord(t *testing.T) {
+	record := exportCSVRecord(exportTestProduct(), testImageURL)
+	assert.Equal(t, []string{"Tee", "Cotton T-shirt", "15.50", "USD", "", "2", "clothing,summer",
+		"https://cdn.example.com/front.png|https://cdn.example.com/blue.png"}, record, "Stock is per variant")
+
+	// An export can be imported again
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	require.NoError(t, writer.Write(exportCSVHeader))
+	require.NoError(t, writer.Write(record))
+	writer.Flush()
+	rows, err := parseImportCSV(&buf)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "15.50", rows[0].Price)
+	assert.Equal(t, []string{"clothing", "summer"}, rows[0].Tags)
+	assert.Equal(t, []string{"https://cdn.example.com/front.png", "https://cdn.example.com/blue.png"}, rows[0].Images)
+	assert.Empty(t, rows[0].Count, "Importing the row leaves the variants' stock alone")
+
+	product := exportTestProduct()
+	product.Variants = nil
+	assert.Equal(t, "3", exportCSVRecord(product, testImageURL)[4], "Products without variants export their stock")
+}
+
+func TestExportJSONItem(t *testing.T) {
+	item := exportJSONItem(exportTestProduct(), testImageURL)
+	assert.Equal(t, json.Number("15.50"), item.Price)
+	assert.Equal(t, []string{"clothing", "summer"}, item.Tags)
+	assert.Equal(t, "https://cdn.example.com/front.png", item.Images[0], "The primary image comes first")
+	require.Len(t, item.Variants, 2)
+	assert.Empty(t, item.Variants[0].Image)
+	assert.Equal(t, "https://cdn.example.com/blue.png", item.Variants[1].Image)
+}
+
+func TestExportFeedItems(t *testing.T) {
+	product := exportTestProduct()
+	items := exportFeedItems(product, "https://shop.example.com/p/{id}", testImageURL)
+	require.Len(t, items, 2, "Variants are listed as items of their own")
+	assert.Equal(t, "TEE-S", items[0].ID)
+	assert.Equal(t, "7", items[0].ItemGroupID)
+	assert.Equal(t, "Tee - S", items[0].Title)
+	assert.Equal(t, "https://shop.example.com/p/7", items[0].Link)
+	assert.Equal(t, "out_of_stock", items[0].Availability)
+	assert.Equal(t, "15.00 USD", items[0].Price)
+	assert.Equal(t, "https://cdn.example.com/front.png", items[0].ImageLink)
+	assert.Equal(t, []string{"https://cdn.example.com/blue.png"}, items[0].AdditionalImageLinks)
+	assert.Equal(t, "https://cdn.example.com/blue.png", items[1].ImageLink, "Variants show their own image")
+
+	product.Variants = nil
+	items = exportFeedItems(product, "https://shop.example.com/p/{id}", testImageURL)
+	require.Len(t, items, 1)
+	assert.Equal(t, "7", items[0].ID)
+	assert.Empty(t, items[0].ItemGroupID)
+	assert.Equal(t, "in_stock", items[0].Availability)
+	assert.Equal(t, "15.50 USD", items[0].Price)
+
+	out, err := xml.Marshal(items[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "<g:id>7</g:id>")
+	assert.Contains(t, string(out), "<g:price>15.50 USD</g:price>")
+}
+
+func TestFeedExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := newFeedExporter("Tom & Co", "https://shop.example.com", "https://shop.example.com/p/{id}", testImageURL)
+	require.NoError(t, exporter.begin(&buf))
+	require.NoError(t, exporter.write(&buf, exportTestProduct()))
+	require.NoError(t, exporter.end(&buf))
+
+	var feed struct {
+		Title string `xml:"channel>title"`
+		Items []struct {
+			ID          string `xml:"http://base.google.com/ns/1.0 id"`
+			ItemGroupID string `xml:"http://base.google.com/ns/1.0 item_group_id"`
+		} `xml:"channel>item"`
+	}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &feed), "feed: %s", buf.String())
+	assert.Equal(t, "Tom & Co", feed.Title)
+	require.Len(t, feed.Items, 2)
+	assert.Equal(t, "TEE-M", feed.Items[1].ID)
+	assert.Equal(t, "7", feed.Items[1].ItemGroupID)
+}
+
+func TestExportImageURL(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://api.example.com/api/export_products", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal(t, "https://api.example.com/api/get_product_image?image=a+b.png", exportImageURL(req)("a b.png"))
+
+	imageBaseURL = "https://cdn.example.com/images"
+	defer func() { imageBaseURL = "" }()
+	assert.Equal(t, "https://cdn.example.com/images/a%20b.png", exportImageURL(req)("a b.png"))
+}
+
+// TestExportProducts tests exporting a user's products in each format.
+func TestExportProducts(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "exporter@example.com", "password")
+	other := createTestUser(t, "exportother@example.com", "password")
+
+	for _, p := range []struct {
+		owner  uint
+		name   string
+		count  uint
+		tags   []string
+		images []string
+	}{
+		{user.ID, "Mug", 4, []string{"kitchen"}, []string{"mug.png", "mug2.png"}},
+		{user.ID, "Plate", 0, nil, []string{"plate.png"}},
+		{other.ID, "Other", 1, nil, []string{"other.png"}},
+	} {
+		product := Product{UserID: p.owner, ProdName: p.name, ProdDescription: p.name + " description", PriceMinor: 950, Currency: "USD", ProdCount: p.count}
+		require.NoError(t, createProduct(testDB, &product, p.images, p.tags, StockChange{Reason: StockReasonInitial}))
+	}
+
+	export := func(t *testing.T, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		ExportProducts(rr, createAuthenticatedRequest(t, user, "GET", "/api/export_products"+query, nil))
+		return rr
+	}
+
+	t.Run("NeedsPublicImages", func(t *testing.T) {
+		for _, query := range []string{"?format=csv", "?format=feed&linkTemplate=https://shop.example.com/products/{id}"} {
+			rr := export(t, query)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "Without IMAGE_BASE_URL, %s would link to images that need a session", query)
+			assert.Contains(t, rr.Body.String(), "IMAGE_BASE_URL")
+		}
+	})
+
+	imageBaseURL = "https://cdn.example.com/images"
+	defer func() { imageBaseURL = "" }()
+
+	t.Run("CSV", func(t *testing.T) {
+		rr := export(t, "?format=csv")
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), "products.csv")
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3, "A header and the user's two products")
+		assert.Equal(t, importColumns, records[0])
+		assert.Equal(t, "Mug", records[1][0])
+		assert.Equal(t, "9.50", records[1][2])
+		assert.Equal(t, "kitchen", records[1][6])
+		assert.Equal(t, "https://cdn.example.com/images/mug.png|https://cdn.example.com/images/mug2.png", records[1][7])
+	})
+
+	t.Run("JSONLines", func(t *testing.T) {
+		rr := export(t, "?format=jsonl")
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var items []ProductExportItem
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var item ProductExportItem
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &item))
+			items = append(items, item)
+		}
+		require.Len(t, items, 2)
+		assert.Equal(t, "Mug", items[0].ProductName)
+		assert.Equal(t, "https://cdn.example.com/images/mug.png", items[0].Images[0])
+		assert.Equal(t, []string{"kitchen"}, items[0].Tags)
+		assert.Equal(t, "Plate", items[1].ProductName)
+		assert.Equal(t, []string{}, items[1].Tags)
+	})
+
+	t.Run("Feed", func(t *testing.T) {
+		rr := export(t, "?format=feed&title=My+Shop&linkTemplate=https://shop.example.com/products/{id}")
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var feed struct {
+			Title string `xml:"channel>title"`
+			Items []struct {
+				Title        string `xml:"http://base.google.com/ns/1.0 title"`
+				Link         string `xml:"http://base.google.com/ns/1.0 link"`
+				Availability string `xml:"http://base.google.com/ns/1.0 availability"`
+			} `xml:"channel>item"`
+		}
+		require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed), "body: %s", rr.Body.String())
+		assert.Equal(t, "My Shop", feed.Title)
+		require.Len(t, feed.Items, 2)
+		assert.Equal(t, "Mug", feed.Items[0].Title)
+		assert.True(t, strings.HasPrefix(feed.Items[0].Link, "https://shop.example.com/products/"))
+		assert.Equal(t, "out_of_stock", feed.Items[1].Availability)
+	})
+
+	t.Run("InvalidRequests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, export(t, "").Code)
+		assert.Equal(t, http.StatusBadRequest, export(t, "?format=xlsx").Code)
+		assert.Equal(t, http.StatusBadRequest, export(t, "?format=feed").Code, "Feeds need a link template")
+		assert.Equal(t, http.StatusBadRequest, export(t, "?format=feed&linkTemplate=https://shop.example.com/").Code)
+	})
+}
@@ -0,0 +1,532 @@
+package prodtable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"front-runner/internal/imagepipeline"
+	"front-runner/internal/oauth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxProductImages caps the size of a product's image gallery.
+const maxProductImages = 10
+
+var (
+	errProductNotOwned    = errors.New("you do not own this product")
+	errImageNotFound      = errors.New("image not found for this product")
+	errLastImage          = errors.New("a product must keep at least one image")
+	errTooManyImages      = fmt.Errorf("a product may have at most %d images", maxProductImages)
+	errImageOrderMismatch = errors.New("imageIDs must list each of the product's images exactly once")
+)
+
+// ProductImageReturn describes one image in a product's gallery.
+type ProductImageReturn struct {
+	ID       uint   `json:"id"`
+	Image    string `json:"image"` // Filename, served by GetProductImage
+	Position int    `json:"position"`
+	Primary  bool   `json:"primary"`
+}
+
+// ImageReorderPayload lists a product's image IDs in their new gallery order.
+type ImageReorderPayload struct {
+	ImageIDs []uint `json:"imageIDs"`
+}
+
+// orderImages sorts images into gallery order; used when preloading Product.Images.
+func orderImages(tx *gorm.DB) *gorm.DB {
+	return tx.Order("position ASC, id ASC")
+}
+
+// setProductImagesReturn converts a product's gallery to its API form.
+func setProductImagesReturn(primaryID uint, images []Image) []ProductImageReturn {
+	ret := make([]ProductImageReturn, 0, len(images))
+	for _, img := range images {
+		ret = append(ret, ProductImageReturn{
+			ID:       img.ID,
+			Image:    img.URL,
+			Position: img.Position,
+			Primary:  img.ID == primaryID,
+		})
+	}
+	return ret
+}
+
+// imageIDs returns the IDs of the given images.
+func imageIDs(images []Image) []uint {
+	ids := make([]uint, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	return ids
+}
+
+// saveImageFiles runs the uploaded files through the image pipeline and writes every rendition
+// to the blob store, returning the original's unique filename for each. If any file fails, the ones
+// already written are removed. Errors wrapping imagepipeline.ErrInvalidImage are the client's fault.
+func saveImageFiles(ctx context.Context, files []*multipart.FileHeader) ([]string, error) {
+	names := make([]string, 0, len(files))
+	for _, fh := range files {
+		name, err := saveImageFile(ctx, fh)
+		if err != nil {
+			removeImageFiles(ctx, names)
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// saveImageFile processes a single uploaded file and writes its renditions to the blob store.
+// The stored extension comes from the detected format, not the client's filename.
+func saveImageFile(ctx context.Context, fh *multipart.FileHeader) (string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return "", fmt.Errorf("error reading image %q: %w", fh.Filename, err)
+	}
+	defer src.Close()
+
+	data, err := imagepipeline.Read(src)
+	if err != nil {
+		return "", fmt.Errorf("image %q: %w", fh.Filename, err)
+	}
+	processed, err := imagepipeline.Process(data)
+	if err != nil {
+		return "", fmt.Errorf("image %q: %w", fh.Filename, err)
+	}
+	return storeImage(ctx, processed)
+}
+
+// storeImage writes every rendition of a processed image to the blob store under a new unique filename.
+func storeImage(ctx context.Context, processed *imagepipeline.Processed) (string, error) {
+	name := uuid.New().String() + processed.Ext
+	for size, content := range processed.Renditions {
+		key := imagepipeline.FileName(name, size)
+		if err := store.Put(ctx, key, content, processed.ContentType); err != nil {
+			removeImageFiles(ctx, []string{name})
+			return "", fmt.Errorf("error storing image file %s: %w", key, err)
+		}
+	}
+	return name, nil
+}
+
+// removeImageFiles deletes every rendition of the named images from the blob store, logging any failure.
+func removeImageFiles(ctx context.Context, names []string) {
+	for _, name := range names {
+		for _, key := range imagepipeline.FileNames(name) {
+			if err := store.Delete(ctx, key); err != nil {
+				log.Printf("Warning: Failed to delete image file %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// writeImageSaveError responds to a failed saveImageFiles call.
+func writeImageSaveError(w http.ResponseWriter, handler string, err error) {
+	if errors.Is(err, imagepipeline.ErrInvalidImage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("%s: %v", handler, err)
+	http.Error(w, "Error saving image", http.StatusInternalServerError)
+}
+
+// createProductImages adds Image rows for already-saved files to the end of a product's gallery.
+func createProductImages(tx *gorm.DB, product *Product, names []string) ([]Image, error) {
+	var count int64
+	var next int
+	if err := tx.Model(&Image{}).Where("product_id = ?", product.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count)+len(names) > maxProductImages {
+		return nil, errTooManyImages
+	}
+	if err := tx.Model(&Image{}).Where("product_id = ?", product.ID).
+		Select("COALESCE(MAX(position) + 1, 0)").Scan(&next).Error; err != nil {
+		return nil, err
+	}
+	images := make([]Image, len(names))
+	for i, name := range names {
+		images[i] = Image{URL: name, UserID: product.UserID, ProductID: product.ID, Position: next + i}
+	}
+	if err := tx.Create(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// lockOwnedProduct loads and row-locks the product for a gallery change, so concurrent
+// changes to the same product's images are applied one at a time.
+func lockOwnedProduct(tx *gorm.DB, productID, userID uint) (*Product, error) {
+	var product Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+		return nil, err
+	}
+	if product.UserID != userID {
+		return nil, errProductNotOwned
+	}
+	return &product, nil
+}
+
+// loadProductImages returns the product's images in gallery order.
+func loadProductImages(tx *gorm.DB, productID uint) ([]Image, error) {
+	var images []Image
+	err := orderImages(tx.Where("product_id = ?", productID)).Find(&images).Error
+	return images, err
+}
+
+// writeImageError maps the errors of the gallery endpoints to HTTP responses.
+func writeImageError(w http.ResponseWriter, handler string, productID uint64, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, errProductNotOwned):
+		http.Error(w, "Permission denied: You do not own this product", http.StatusForbidden)
+	case errors.Is(err, errImageNotFound):
+		http.Error(w, "Image not found for this product", http.StatusNotFound)
+	case errors.Is(err, errLastImage), errors.Is(err, errTooManyImages), errors.Is(err, errImageOrderMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: Error updating images of product %d: %v", handler, productID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// writeProductImages responds with the product's gallery.
+func writeProductImages(w http.ResponseWriter, status int, primaryID uint, images []Image) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(setProductImagesReturn(primaryID, images)); err != nil {
+		log.Printf("Error encoding product images to JSON: %v", err)
+	}
+}
+
+// AddProductImages uploads one or more images and appends them to a product's gallery.
+//
+// @Summary      Add product images
+// @Description  Uploads one or more JPEG, PNG or GIF images (repeat the image field) and appends them to the end of the product's gallery. A product may have at most 10 images.
+// @Tags         Products
+// @Accept       multipart/form-data
+// @Produce      application/json
+// @Param        id     query     int   true  "Product ID" Format(uint64)
+// @Param        image  formData  file  true  "Image file; may be repeated"
+// @Success      201  {array}   ProductImageReturn "The product's updated gallery"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID, missing or invalid images, or gallery full"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      500  {string}  string "Internal Server Error: Database or file system error"
+// @Security     ApiKeyAuth
+// @Router       /api/add_product_images [post]
+func AddProductImages(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("AddProductImages: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		http.Error(w, "At least one image is required", http.StatusBadRequest)
+		return
+	}
+	if len(files) > maxProductImages {
+		http.Error(w, errTooManyImages.Error(), http.StatusBadRequest)
+		return
+	}
+
+	names, err := saveImageFiles(r.Context(), files)
+	if err != nil {
+		writeImageSaveError(w, "AddProductImages", err)
+		return
+	}
+
+	var product *Product
+	var images []Image
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if product, err = lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		if _, err = createProductImages(tx, product, names); err != nil {
+			return err
+		}
+		images, err = loadProductImages(tx, product.ID)
+		return err
+	})
+	if err != nil {
+		removeImageFiles(r.Context(), names)
+		writeImageError(w, "AddProductImages", productID, err)
+		return
+	}
+
+	writeProductImages(w, http.StatusCreated, product.ImgID, images)
+}
+
+// ReorderProductImages sets the order of a product's gallery.
+//
+// @Summary      Reorder product images
+// @Description  Sets the gallery order of a product's images. The body must list every image ID of the product exactly once, in the new order.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       query  int                  true  "Product ID" Format(uint64)
+// @Param        payload  body   ImageReorderPayload  true  "Image IDs in their new order"
+// @Success      200  {array}   ProductImageReturn "The product's reordered gallery"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID or image list"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/reorder_product_images [put]
+func ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ReorderProductImages: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var payload ImageReorderPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var product *Product
+	var images []Image
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if product, err = lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		if images, err = loadProductImages(tx, product.ID); err != nil {
+			return err
+		}
+
+		// The new order must be a permutation of the current images
+		if len(payload.ImageIDs) != len(images) {
+			return errImageOrderMismatch
+		}
+		position := make(map[uint]int, len(payload.ImageIDs))
+		for i, id := range payload.ImageIDs {
+			if _, dup := position[id]; dup {
+				return errImageOrderMismatch
+			}
+			position[id] = i
+		}
+		for _, img := range images {
+			if _, ok := position[img.ID]; !ok {
+				return errImageOrderMismatch
+			}
+		}
+
+		for _, img := range images {
+			if img.Position == position[img.ID] {
+				continue
+			}
+			if err := tx.Model(&Image{}).Where("id = ?", img.ID).Update("position", position[img.ID]).Error; err != nil {
+				return err
+			}
+		}
+		images, err = loadProductImages(tx, product.ID)
+		return err
+	})
+	if err != nil {
+		writeImageError(w, "ReorderProductImages", productID, err)
+		return
+	}
+
+	writeProductImages(w, http.StatusOK, product.ImgID, images)
+}
+
+// SetPrimaryProductImage chooses which of a product's images is its primary image.
+//
+// @Summary      Set a product's primary image
+// @Description  Makes one of the product's gallery images its primary image (the one returned as "image" in product responses).
+// @Tags         Products
+// @Produce      application/json
+// @Param        id       query  int  true  "Product ID" Format(uint64)
+// @Param        imageID  query  int  true  "ID of the image to make primary" Format(uint64)
+// @Success      200  {array}   ProductImageReturn "The product's gallery"
+// @Failure      400  {string}  string "Bad Request: Invalid product or image ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product or image not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/set_primary_image [put]
+func SetPrimaryProductImage(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("SetPrimaryProductImage: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	imageID, err := strconv.ParseUint(r.URL.Query().Get("imageID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Image ID", http.StatusBadRequest)
+		return
+	}
+
+	var product *Product
+	var images []Image
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if product, err = lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		if images, err = loadProductImages(tx, product.ID); err != nil {
+			return err
+		}
+		found := false
+		for _, img := range images {
+			found = found || img.ID == uint(imageID)
+		}
+		if !found {
+			return errImageNotFound
+		}
+		product.ImgID = uint(imageID)
+		return tx.Model(&Product{}).Where("id = ?", product.ID).Update("img_id", product.ImgID).Error
+	})
+	if err != nil {
+		writeImageError(w, "SetPrimaryProductImage", productID, err)
+		return
+	}
+
+	writeProductImages(w, http.StatusOK, product.ImgID, images)
+}
+
+// DeleteProductImage removes one image from a product's gallery along with its file.
+// Deleting the primary image promotes the next image in gallery order; the last image cannot be deleted.
+//
+// @Summary      Delete a product image
+// @Description  Deletes one of the product's gallery images and its file. If it was the primary image, the first remaining image becomes primary. A product must keep at least one image.
+// @Tags         Products
+// @Produce      application/json
+// @Param        id       query  int  true  "Product ID" Format(uint64)
+// @Param        imageID  query  int  true  "ID of the image to delete" Format(uint64)
+// @Success      200  {array}   ProductImageReturn "The product's remaining gallery"
+// @Failure      400  {string}  string "Bad Request: Invalid IDs or last remaining image"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product or image not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/delete_product_image [delete]
+func DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("DeleteProductImage: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	imageID, err := strconv.ParseUint(r.URL.Query().Get("imageID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Image ID", http.StatusBadRequest)
+		return
+	}
+
+	var product *Product
+	var deleted Image
+	var remaining []Image
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if product, err = lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		images, err := loadProductImages(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			if img.ID == uint(imageID) {
+				deleted = img
+			} else {
+				remaining = append(remaining, img)
+			}
+		}
+		if deleted.ID == 0 {
+			return errImageNotFound
+		}
+		if len(remaining) == 0 {
+			return errLastImage
+		}
+
+		// Repoint the product before deleting the image it references
+		if product.ImgID == deleted.ID {
+			product.ImgID = remaining[0].ID
+			if err := tx.Model(&Product{}).Where("id = ?", product.ID).Update("img_id", product.ImgID).Error; err != nil {
+				return err
+			}
+		}
+		// Variants shown with this image fall back to the product's images
+		if err := tx.Model(&ProductVariant{}).Where("image_id = ?", deleted.ID).Update("image_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&deleted).Error
+	})
+	if err != nil {
+		writeImageError(w, "DeleteProductImage", productID, err)
+		return
+	}
+
+	// Remove the file only once the row is gone for good
+	removeImageFiles(r.Context(), []string{deleted.URL})
+
+	writeProductImages(w, http.StatusOK, product.ImgID, remaining)
+}
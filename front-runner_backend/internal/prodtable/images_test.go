@@ -0,0 +1,253 @@
+// internal/prodtable/images_test.go
+package prodtable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/imagepipeline"
+	"front-runner/internal/usertable"
+)
+
+// testImageBytes encodes a small real image, as JPEG for .jpg/.jpeg names and PNG otherwise.
+func testImageBytes(t *testing.T, name string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	switch filepath.Ext(name) {
+	case ".jpg", ".jpeg":
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	default:
+		require.NoError(t, png.Encode(&buf, img))
+	}
+	return buf.Bytes()
+}
+
+// createImagesRequest builds an authenticated multipart request carrying the given form fields and image files.
+func createImagesRequest(t *testing.T, user *usertable.User, method, url string, fields map[string]string, filenames ...string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		require.NoError(t, writer.WriteField(k, v))
+	}
+	for _, name := range filenames {
+		part, err := writer.CreateFormFile("image", name)
+		require.NoError(t, err)
+		_, err = part.Write(testImageBytes(t, name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	req := createAuthenticatedRequest(t, user, method, url, &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// TestProductImages tests gallery uploads, reordering, choosing a primary image and deleting images.
+func TestProductImages(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "gallery@example.com", "password")
+	other := createTestUser(t, "galleryother@example.com", "password")
+
+	req := createImagesRequest(t, user, "POST", "/api/add_product", map[string]string{
+		"productName": "Gallery Lamp",
+		"description": "Lamp with many photos",
+		"price":       "30",
+		"count":       "2",
+	}, "front.png", "side.jpg")
+	rr := httptest.NewRecorder()
+	AddProduct(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+
+	var product Product
+	require.NoError(t, testDB.Where("user_id = ? AND prod_name = ?", user.ID, "Gallery Lamp").First(&product).Error)
+
+	getGallery := func(t *testing.T) ProductReturn {
+		t.Helper()
+		req := createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_product?id=%d", product.ID), nil)
+		rr := httptest.NewRecorder()
+		GetProduct(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp ProductReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+	ids := func(images []ProductImageReturn) []uint {
+		out := make([]uint, len(images))
+		for i, img := range images {
+			out[i] = img.ID
+		}
+		return out
+	}
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder) []ProductImageReturn {
+		t.Helper()
+		var resp []ProductImageReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("AddProductCreatesGallery", func(t *testing.T) {
+		resp := getGallery(t)
+		require.Len(t, resp.Images, 2)
+		assert.True(t, resp.Images[0].Primary, "First uploaded image should be primary")
+		assert.Equal(t, resp.ImgPath, resp.Images[0].Image)
+		assert.Equal(t, ".jpg", filepath.Ext(resp.Images[1].Image))
+		for _, img := range resp.Images {
+			for _, file := range imagepipeline.FileNames(img.Image) {
+				assert.FileExists(t, filepath.Join("uploads", file), "Original and variants should be stored")
+			}
+		}
+	})
+
+	t.Run("ServeThumbnail", func(t *testing.T) {
+		primary := getGallery(t).ImgPath
+		req := createAuthenticatedRequest(t, user, "GET", "/api/get_product_image?size=thumb&image="+primary, nil)
+		rr := httptest.NewRecorder()
+		GetProductImage(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(rr.Body.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, 200, cfg.Width, "Thumbnail should be scaled down")
+
+		req = createAuthenticatedRequest(t, user, "GET", "/api/get_product_image?size=huge&image="+primary, nil)
+		rr = httptest.NewRecorder()
+		GetProductImage(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("RejectsNonImage", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		part, err := writer.CreateFormFile("image", "fake.png")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("<html>not an image</html>"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		req := createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/add_product_images?id=%d", product.ID), &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		AddProductImages(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+		assert.Len(t, getGallery(t).Images, 2)
+	})
+
+	t.Run("AddImages", func(t *testing.T) {
+		req := createImagesRequest(t, user, "POST", fmt.Sprintf("/api/add_product_images?id=%d", product.ID), nil, "back.png")
+		rr := httptest.NewRecorder()
+		AddProductImages(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		resp := decode(t, rr)
+		require.Len(t, resp, 3)
+		assert.Equal(t, 2, resp[2].Position, "New images should be appended")
+		assert.FileExists(t, filepath.Join("uploads", resp[2].Image))
+	})
+
+	t.Run("AddImagesForbidden", func(t *testing.T) {
+		req := createImagesRequest(t, other, "POST", fmt.Sprintf("/api/add_product_images?id=%d", product.ID), nil, "evil.png")
+		rr := httptest.NewRecorder()
+		AddProductImages(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		files, _ := os.ReadDir("uploads")
+		assert.Len(t, files, 3*len(imagepipeline.FileNames("x.png")), "Rejected uploads should not leave files behind")
+	})
+
+	t.Run("Reorder", func(t *testing.T) {
+		current := ids(getGallery(t).Images)
+		reversed := []uint{current[2], current[1], current[0]}
+		body, _ := json.Marshal(ImageReorderPayload{ImageIDs: reversed})
+		req := createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/reorder_product_images?id=%d", product.ID), bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		ReorderProductImages(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, reversed, ids(decode(t, rr)))
+		assert.Equal(t, reversed, ids(getGallery(t).Images))
+
+		body, _ = json.Marshal(ImageReorderPayload{ImageIDs: reversed[:2]})
+		req = createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/reorder_product_images?id=%d", product.ID), bytes.NewReader(body))
+		rr = httptest.NewRecorder()
+		ReorderProductImages(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Every image must be listed")
+	})
+
+	t.Run("SetPrimary", func(t *testing.T) {
+		gallery := getGallery(t).Images
+		req := createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/set_primary_image?id=%d&imageID=%d", product.ID, gallery[0].ID), nil)
+		rr := httptest.NewRecorder()
+		SetPrimaryProductImage(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		resp := getGallery(t)
+		assert.True(t, resp.Images[0].Primary)
+		assert.Equal(t, gallery[0].Image, resp.ImgPath)
+
+		req = createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/set_primary_image?id=%d&imageID=999999", product.ID), nil)
+		rr = httptest.NewRecorder()
+		SetPrimaryProductImage(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("DeleteImage", func(t *testing.T) {
+		gallery := getGallery(t).Images
+		primary := gallery[0]
+		req := createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_image?id=%d&imageID=%d", product.ID, primary.ID), nil)
+		rr := httptest.NewRecorder()
+		DeleteProductImage(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		resp := getGallery(t)
+		require.Len(t, resp.Images, 2)
+		assert.Equal(t, gallery[1].Image, resp.ImgPath, "Next image should be promoted to primary")
+		assert.NoFileExists(t, filepath.Join("uploads", primary.Image))
+		var count int64
+		testDB.Model(&Image{}).Where("id = ?", primary.ID).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("CannotDeleteLastImage", func(t *testing.T) {
+		gallery := getGallery(t).Images
+		req := createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_image?id=%d&imageID=%d", product.ID, gallery[0].ID), nil)
+		rr := httptest.NewRecorder()
+		DeleteProductImage(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		req = createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_image?id=%d&imageID=%d", product.ID, gallery[1].ID), nil)
+		rr = httptest.NewRecorder()
+		DeleteProductImage(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("DeleteProductRemovesGallery", func(t *testing.T) {
+		req := createImagesRequest(t, user, "POST", fmt.Sprintf("/api/add_product_images?id=%d", product.ID), nil, "a.png", "b.png")
+		rr := httptest.NewRecorder()
+		AddProductImages(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+
+		req = createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product?id=%d", product.ID), nil)
+		rr = httptest.NewRecorder()
+		DeleteProduct(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		var count int64
+		testDB.Model(&Image{}).Where("product_id = ?", product.ID).Count(&count)
+		assert.Zero(t, count, "All image rows should be removed")
+		files, _ := os.ReadDir("uploads")
+		assert.Empty(t, files, "All image files should be removed")
+	})
+}
@@ -0,0 +1,874 @@
+package prodtable
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"front-runner/internal/imagepipeline"
+	"front-runner/internal/money"
+	"front-runner/internal/oauth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Import job statuses.
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed" // Every row was processed; some may have failed
+	ImportFailed    = "failed"    // The job stopped early, see ProductImport.Error
+)
+
+// Import file formats.
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+)
+
+const (
+	maxImportBytes       = 64 << 20 // Largest accepted request, import file and images archive together
+	maxImportRows        = 5000
+	importImageTimeout   = 30 * time.Second
+	importStaleAfter     = 15 * time.Minute // Running jobs without progress for this long were lost, e.g. to a restart
+	importImageSeparator = "|"              // Separates image references in a CSV cell
+)
+
+// importColumns are the CSV columns an import file may have, matched case-insensitively.
+var importColumns = []string{"productName", "description", "price", "currency", "count", "reorderThreshold", "tags", "images"}
+
+var (
+	errImportInProgress = errors.New("an import is already in progress")
+	errImportDryRun     = errors.New("dry run") // Rolls back a dry-run row's changes
+)
+
+// ProductImport is an asynchronous bulk import of products from a CSV or JSON file.
+// Rows are matched to the user's existing products by name and update them, or create new products.
+type ProductImport struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Format     string `gorm:"not null"`
+	DryRun     bool   `gorm:"not null;default:false"` // Validate every row without saving anything
+	Status     string `gorm:"not null;index"`
+	TotalRows  int    `gorm:"not null;default:0"`
+	Processed  int    `gorm:"not null;default:0"`
+	Created    int    `gorm:"not null;default:0"`
+	Updated    int    `gorm:"not null;default:0"`
+	Failed     int    `gorm:"not null;default:0"`
+	Error      string // Why the job failed as a whole
+	CreatedAt  time.Time
+	UpdatedAt  time.Time // Bumped with every processed row
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	Errors     []ProductImportError `gorm:"foreignKey:ImportID;constraint:OnDelete:CASCADE"`
+}
+
+// ProductImportError records why one row of an import was not imported.
+type ProductImportError struct {
+	ID          uint   `gorm:"primaryKey"`
+	ImportID    uint   `gorm:"not null;index"`
+	Row         int    `gorm:"not null"` // 1-based position among the file's products, not counting the CSV header
+	ProductName string `gorm:"not null;default:''"`
+	Field       string `gorm:"not null;default:''"` // Column or JSON field at fault, if any
+	Message     string `gorm:"not null"`
+}
+
+// ProductImportItem is one product of a JSON import file, which holds an array of them.
+// Blank fields of existing products are left unchanged.
+type ProductImportItem struct {
+	ProductName      string      `json:"productName"`                            // Matches the user's existing product of the same name
+	Description      string      `json:"description"`                            // Required for new products
+	Price            json.Number `json:"price" swaggertype:"string"`             // Decimal; required for new products
+	Currency         string      `json:"currency"`                               // Defaults to the product's currency, or DEFAULT_CURRENCY for new products
+	Count            json.Number `json:"count" swaggertype:"integer"`            // Stock; required for new products
+	ReorderThreshold json.Number `json:"reorderThreshold" swaggertype:"integer"` // Low-stock alert threshold
+	Tags             []string    `json:"tags"`                                   // Replace the product's tags when given
+	Images           []string    `json:"images"`                                 // http(s) URLs or names of files in the images archive; replace the gallery when given
+}
+
+// ProductImportErrorReturn describes a row that was not imported.
+type ProductImportErrorReturn struct {
+	Row         int    `json:"row"`
+	ProductName string `json:"productName,omitempty"`
+	Field       string `json:"field,omitempty"`
+	Message     string `json:"message"`
+}
+
+// ProductImportReturn describes an import job and its progress.
+type ProductImportReturn struct {
+	ID         uint                       `json:"id"`
+	Status     string                     `json:"status"` // pending, running, completed or failed
+	Format     string                     `json:"format"`
+	DryRun     bool                       `json:"dryRun"` // Counts are what the import would have done
+	TotalRows  int                        `json:"totalRows"`
+	Processed  int                        `json:"processed"`
+	Created    int                        `json:"created"`
+	Updated    int                        `json:"updated"`
+	Failed     int                        `json:"failed"`
+	Error      string                     `json:"error,omitempty"`
+	Errors     []ProductImportErrorReturn `json:"errors"`
+	CreatedAt  string                     `json:"createdAt"`
+	StartedAt  string                     `json:"startedAt,omitempty"`
+	FinishedAt string                     `json:"finishedAt,omitempty"`
+}
+
+func (job *ProductImport) toReturn() ProductImportReturn {
+	ret := ProductImportReturn{
+		ID:        job.ID,
+		Status:    job.Status,
+		Format:    job.Format,
+		DryRun:    job.DryRun,
+		TotalRows: job.TotalRows,
+		Processed: job.Processed,
+		Created:   job.Created,
+		Updated:   job.Updated,
+		Failed:    job.Failed,
+		Error:     job.Error,
+		Errors:    make([]ProductImportErrorReturn, 0, len(job.Errors)),
+		CreatedAt: job.CreatedAt.Format(time.RFC3339),
+	}
+	for _, e := range job.Errors {
+		ret.Errors = append(ret.Errors, ProductImportErrorReturn{Row: e.Row, ProductName: e.ProductName, Field: e.Field, Message: e.Message})
+	}
+	if job.StartedAt != nil {
+		ret.StartedAt = job.StartedAt.Format(time.RFC3339)
+	}
+	if job.FinishedAt != nil {
+		ret.FinishedAt = job.FinishedAt.Format(time.RFC3339)
+	}
+	return ret
+}
+
+// importRow is one product of an import file, with its fields as written.
+type importRow struct {
+	Name             string
+	Description      string
+	Price            string
+	Currency         string
+	Count            string
+	ReorderThreshold string
+	Tags             []string
+	Images           []string
+}
+
+// importRowError is a problem with a row's data, reported back to the user.
+type importRowError struct {
+	Field   string
+	Message string
+}
+
+func (e *importRowError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+func rowError(field, format string, args ...interface{}) error {
+	return &importRowError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseImportCSV reads products from a CSV file with a header row naming its columns.
+// Tags are comma-separated and images separated by "|".
+func parseImportCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Short rows leave the missing fields blank
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")) // Spreadsheets may start the file with a byte order mark
+		known := ""
+		for _, column := range importColumns {
+			if strings.EqualFold(name, column) {
+				known = column
+			}
+		}
+		if known == "" {
+			return nil, fmt.Errorf("unknown CSV column %q (use %s)", name, strings.Join(importColumns, ", "))
+		}
+		if _, dup := columns[known]; dup {
+			return nil, fmt.Errorf("duplicate CSV column %q", name)
+		}
+		columns[known] = i
+	}
+	if _, ok := columns["productName"]; !ok {
+		return nil, errors.New("CSV header must include a productName column")
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("an import may have at most %d products", maxImportRows)
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, importRow{
+			Name:             field("productName"),
+			Description:      field("description"),
+			Price:            field("price"),
+			Currency:         field("currency"),
+			Count:            field("count"),
+			ReorderThreshold: field("reorderThreshold"),
+			Tags:             parseTagNames(field("tags")),
+			Images:           splitImageRefs(strings.Split(field("images"), importImageSeparator)),
+		})
+	}
+	return rows, nil
+}
+
+// parseImportJSON reads products from a JSON array of ProductImportItem.
+func parseImportJSON(r io.Reader) ([]importRow, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	var items []ProductImportItem
+	if err := decoder.Decode(&items); err != nil {
+		return nil, fmt.Errorf("reading JSON: %w", err)
+	}
+	if len(items) > maxImportRows {
+		return nil, fmt.Errorf("an import may have at most %d products", maxImportRows)
+	}
+	rows := make([]importRow, len(items))
+	for i, item := range items {
+		rows[i] = importRow{
+			Name:             strings.TrimSpace(item.ProductName),
+			Description:      strings.TrimSpace(item.Description),
+			Price:            item.Price.String(),
+			Currency:         strings.TrimSpace(item.Currency),
+			Count:            item.Count.String(),
+			ReorderThreshold: item.ReorderThreshold.String(),
+			Tags:             normalizeTagNames(item.Tags),
+			Images:           splitImageRefs(item.Images),
+		}
+	}
+	return rows, nil
+}
+
+// splitImageRefs trims image references, dropping blanks.
+func splitImageRefs(refs []string) []string {
+	out := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// importFormat picks the format named in the request, or else the one matching the file's extension.
+func importFormat(requested, filename string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(requested))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch format {
+	case ImportFormatCSV, ImportFormatJSON:
+		return format, nil
+	default:
+		return "", errors.New("format must be csv or json")
+	}
+}
+
+// publicAddressesOnly refuses connections to loopback, private and other non-public addresses,
+// so image URLs cannot be used to reach services on the server's own network.
+func publicAddressesOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("refusing to fetch an image from non-public address %s", host)
+	}
+	return nil
+}
+
+// importImageClient fetches images referenced by URL.
+var importImageClient = &http.Client{
+	Timeout: importImageTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressesOnly}).DialContext,
+	},
+}
+
+// importJob runs one ProductImport.
+type importJob struct {
+	ProductImport
+	archive map[string]*zip.File // Images archive entries by path and by base name
+}
+
+// newImportArchive indexes the files of an images archive.
+func newImportArchive(data []byte) (map[string]*zip.File, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[path.Clean(f.Name)] = f
+	}
+	// Allow files in folders to be referred to by name alone, unless the name is ambiguous
+	byBase := map[string]*zip.File{}
+	for name, f := range files {
+		base := path.Base(name)
+		if _, taken := byBase[base]; taken {
+			byBase[base] = nil
+		} else {
+			byBase[base] = f
+		}
+	}
+	for base, f := range byBase {
+		if _, exists := files[base]; !exists && f != nil {
+			files[base] = f
+		}
+	}
+	return files, nil
+}
+
+// loadImage reads an image referenced by a row from its URL or the images archive.
+func (job *importJob) loadImage(ctx context.Context, ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+		if err != nil {
+			return nil, rowError("images", "invalid image URL %q", ref)
+		}
+		resp, err := importImageClient.Do(req)
+		if err != nil {
+			return nil, rowError("images", "could not fetch %q: %v", ref, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, rowError("images", "could not fetch %q: %s", ref, resp.Status)
+		}
+		data, err := imagepipeline.Read(resp.Body)
+		if err != nil {
+			return nil, rowError("images", "image %q: %v", ref, err)
+		}
+		return data, nil
+	}
+
+	f := job.archive[path.Clean(ref)]
+	if f == nil {
+		if job.archive == nil {
+			return nil, rowError("images", "image %q is not a URL and no images archive was uploaded", ref)
+		}
+		return nil, rowError("images", "image %q is not in the images archive", ref)
+	}
+	if f.UncompressedSize64 > imagepipeline.MaxUploadBytes {
+		return nil, rowError("images", "image %q is larger than %d MB", ref, imagepipeline.MaxUploadBytes>>20)
+	}
+	src, err := f.Open()
+	if err != nil {
+		return nil, rowError("images", "could not read %q from the images archive: %v", ref, err)
+	}
+	defer src.Close()
+	data, err := imagepipeline.Read(src)
+	if err != nil {
+		return nil, rowError("images", "image %q: %v", ref, err)
+	}
+	return data, nil
+}
+
+// prepareImages loads and processes a row's images, then stores them unless this is a dry run,
+// in which case placeholder filenames are returned. On error nothing is left stored.
+func (job *importJob) prepareImages(ctx context.Context, refs []string) ([]string, error) {
+	if len(refs) > maxProductImages {
+		return nil, rowError("images", "%v", errTooManyImages)
+	}
+	processed := make([]*imagepipeline.Processed, len(refs))
+	for i, ref := range refs {
+		data, err := job.loadImage(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if processed[i], err = imagepipeline.Process(data); err != nil {
+			return nil, rowError("images", "image %q: %v", ref, err)
+		}
+	}
+
+	names := make([]string, 0, len(processed))
+	for _, p := range processed {
+		if job.DryRun {
+			names = append(names, "dry-run-"+uuid.New().String()+p.Ext)
+			continue
+		}
+		name, err := storeImage(ctx, p)
+		if err != nil {
+			removeImageFiles(ctx, names)
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// importProduct creates or updates the product described by a row, reporting whether it was created.
+func (job *importJob) importProduct(ctx context.Context, row importRow) (bool, error) {
+	if row.Name == "" {
+		return false, rowError("productName", "productName is required")
+	}
+	currency := ""
+	if row.Currency != "" {
+		var err error
+		if currency, err = money.NormalizeCurrency(row.Currency); err != nil {
+			return false, rowError("currency", "invalid currency %q", row.Currency)
+		}
+	}
+	var count, threshold *uint
+	if row.Count != "" {
+		n, err := strconv.Atoi(row.Count)
+		if err != nil || n < 0 {
+			return false, rowError("count", "invalid count %q", row.Count)
+		}
+		c := uint(n)
+		count = &c
+	}
+	if row.ReorderThreshold != "" {
+		n, err := strconv.Atoi(row.ReorderThreshold)
+		if err != nil || n < 0 {
+			return false, rowError("reorderThreshold", "invalid reorderThreshold %q", row.ReorderThreshold)
+		}
+		t := uint(n)
+		threshold = &t
+	}
+
+	// Images are fetched and stored before locking anything, as that can take a while
+	imageNames, err := job.prepareImages(ctx, row.Images)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+	var replacedImages []string
+	change := StockChange{UserID: job.UserID, Reference: fmt.Sprintf("import:%d", job.ID)}
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND prod_name = ?", job.UserID, row.Name).First(&product).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			created = true
+			err = job.createProduct(tx, row, currency, count, threshold, imageNames, change)
+		} else if err == nil {
+			replacedImages, err = job.updateProduct(tx, &product, row, currency, count, threshold, imageNames, change)
+		}
+		if err == nil && job.DryRun {
+			err = errImportDryRun
+		}
+		return err
+	})
+	if errors.Is(err, errImportDryRun) {
+		return created, nil
+	}
+	if err != nil {
+		if !job.DryRun {
+			removeImageFiles(ctx, imageNames)
+		}
+		return false, err
+	}
+	removeImageFiles(ctx, replacedImages)
+	return created, nil
+}
+
+// createProduct creates the new product described by a row.
+func (job *importJob) createProduct(tx *gorm.DB, row importRow, currency string, count, threshold *uint, imageNames []string, change StockChange) error {
+	switch {
+	case row.Description == "":
+		return rowError("description", "description is required for a new product")
+	case row.Price == "":
+		return rowError("price", "price is required for a new product")
+	case count == nil:
+		return rowError("count", "count is required for a new product")
+	case len(imageNames) == 0:
+		return rowError("images", "at least one image is required for a new product")
+	}
+	if currency == "" {
+		currency = money.DefaultCurrency()
+	}
+	price, err := money.Parse(row.Price, currency)
+	if err != nil {
+		return rowError("price", "invalid price %q", row.Price)
+	}
+	product := Product{
+		UserID:          job.UserID,
+		ProdName:        row.Name,
+		ProdDescription: row.Description,
+		PriceMinor:      price,
+		Currency:        currency,
+		ProdCount:       *count,
+	}
+	if threshold != nil {
+		product.ReorderThreshold = *threshold
+	}
+	change.Reason = StockReasonInitial
+	return createProduct(tx, &product, imageNames, row.Tags, change)
+}
+
+// updateProduct applies a row's non-blank fields to an existing, locked product. It returns the
+// filenames of any images replaced, to be deleted once the change is committed.
+func (job *importJob) updateProduct(tx *gorm.DB, product *Product, row importRow, currency string, count, threshold *uint, imageNames []string, change StockChange) ([]string, error) {
+	updates := map[string]interface{}{}
+	if row.Description != "" {
+		updates["ProdDescription"] = row.Description
+	}
+	if currency == "" {
+		currency = product.Currency
+	}
+	withVariants, err := hasVariants(tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	if currency != product.Currency {
+		// Existing prices are in the old currency's minor units, so they cannot carry over
+		if row.Price == "" {
+			return nil, rowError("price", "a new price is required when changing the currency")
+		}
+		if withVariants {
+			return nil, rowError("currency", "%v", errCurrencyOnVariants)
+		}
+		updates["Currency"] = currency
+	}
+	if row.Price != "" {
+		price, err := money.Parse(row.Price, currency)
+		if err != nil {
+			return nil, rowError("price", "invalid price %q", row.Price)
+		}
+		updates["PriceMinor"] = price
+	}
+	if threshold != nil {
+		updates["ReorderThreshold"] = *threshold
+	}
+	if count != nil && withVariants {
+		return nil, rowError("count", "%v", errStockOnVariants)
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(product).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	if count != nil {
+		change.Reason = StockReasonAdjustment
+		if err := setStock(tx, product, nil, *count, change); err != nil {
+			return nil, err
+		}
+	}
+	if len(row.Tags) > 0 {
+		if err := setProductTags(tx, product, row.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if len(imageNames) == 0 {
+		return nil, nil
+	}
+
+	// The row's images replace the product's gallery
+	old, err := loadProductImages(tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, len(imageNames))
+	for i, name := range imageNames {
+		images[i] = Image{URL: name, UserID: product.UserID, ProductID: product.ID, Position: i}
+	}
+	if err := tx.Create(&images).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(product).Update("img_id", images[0].ID).Error; err != nil {
+		return nil, err
+	}
+	if len(old) == 0 {
+		return nil, nil
+	}
+	if err := tx.Model(&ProductVariant{}).Where("image_id IN ?", imageIDs(old)).Update("image_id", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Delete(&old).Error; err != nil {
+		return nil, err
+	}
+	replaced := make([]string, len(old))
+	for i, img := range old {
+		replaced[i] = img.URL
+	}
+	return replaced, nil
+}
+
+// run imports every row, recording progress after each one. It stops early if the job was
+// given up on as stale.
+func (job *importJob) run(ctx context.Context, rows []importRow) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Product import %d panicked: %v", job.ID, r)
+			job.finish(ImportFailed, "Internal error while importing")
+		}
+	}()
+
+	now := time.Now()
+	if err := db.Model(&ProductImport{}).Where("id = ? AND status = ?", job.ID, ImportPending).
+		Updates(map[string]interface{}{"status": ImportRunning, "started_at": now}).Error; err != nil {
+		log.Printf("Error starting product import %d: %v", job.ID, err)
+		return
+	}
+
+	firstRow := make(map[string]int, len(rows))
+	for i, row := range rows {
+		var err error
+		created := false
+		if first, dup := firstRow[row.Name]; dup && row.Name != "" {
+			err = rowError("productName", "duplicate of row %d", first)
+		} else {
+			firstRow[row.Name] = i + 1
+			created, err = job.importProduct(ctx, row)
+		}
+
+		counter := "updated"
+		if created {
+			counter = "created"
+		}
+		if err != nil {
+			counter = "failed"
+			rowErr := &importRowError{}
+			if !errors.As(err, &rowErr) {
+				log.Printf("Product import %d: error importing row %d: %v", job.ID, i+1, err)
+				rowErr = &importRowError{Message: "Internal error while importing this row"}
+			}
+			if err := db.Create(&ProductImportError{ImportID: job.ID, Row: i + 1, ProductName: row.Name, Field: rowErr.Field, Message: rowErr.Message}).Error; err != nil {
+				log.Printf("Product import %d: error recording failure of row %d: %v", job.ID, i+1, err)
+			}
+		}
+		res := db.Model(&ProductImport{}).Where("id = ? AND status = ?", job.ID, ImportRunning).
+			Updates(map[string]interface{}{"processed": gorm.Expr("processed + 1"), counter: gorm.Expr(counter + " + 1")})
+		if res.Error != nil {
+			log.Printf("Product import %d: error recording progress: %v", job.ID, res.Error)
+		} else if res.RowsAffected == 0 {
+			log.Printf("Product import %d was marked as failed while running; stopping", job.ID)
+			return
+		}
+	}
+	job.finish(ImportCompleted, "")
+}
+
+// finish records the outcome of a running job.
+func (job *importJob) finish(status, message string) {
+	if err := db.Model(&ProductImport{}).Where("id = ? AND status IN ?", job.ID, []string{ImportPending, ImportRunning}).
+		Updates(map[string]interface{}{"status": status, "error": message, "finished_at": time.Now()}).Error; err != nil {
+		log.Printf("Error finishing product import %d: %v", job.ID, err)
+	}
+}
+
+// failStaleImports marks the user's unfinished jobs that stopped making progress as failed,
+// as happens when the server restarts during an import.
+func failStaleImports(tx *gorm.DB, userID uint, now time.Time) error {
+	return tx.Model(&ProductImport{}).
+		Where("user_id = ? AND status IN ? AND updated_at < ?", userID, []string{ImportPending, ImportRunning}, now.Add(-importStaleAfter)).
+		Updates(map[string]interface{}{"status": ImportFailed, "error": "Import was interrupted; run it again", "finished_at": now}).Error
+}
+
+// ImportProducts starts an asynchronous bulk import of products.
+//
+// @Summary      Import products
+// @Description  Creates or updates products in bulk from a CSV or JSON file. Rows are matched to the user's products by name: existing products are updated with the row's non-blank fields, and new products require a description, price, count and at least one image. CSV files need a header row naming their columns (productName, description, price, currency, count, reorderThreshold, tags, images), with comma-separated tags and "|"-separated images; JSON files hold an array of ProductImportItem. Images are http(s) URLs or names of files in an optional zip archive, and replace an existing product's gallery. The import runs in the background; poll /api/get_product_import for its progress and per-row errors. One import runs per user at a time.
+// @Tags         Products
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        file    formData  file    true   "CSV or JSON file of products"
+// @Param        format  formData  string  false  "csv or json; defaults to the file's extension" Enums(csv, json)
+// @Param        images  formData  file    false  "Zip archive of the images the file refers to by name"
+// @Param        dryRun  formData  boolean false  "Validate every row without saving anything"
+// @Success      202  {object}  ProductImportReturn "The started import"
+// @Failure      400  {string}  string "Bad Request: Missing or unreadable file, unknown format or invalid archive"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      409  {string}  string "Conflict: An import is already in progress"
+// @Failure      413  {string}  string "Request Entity Too Large"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/import_products [post]
+func ImportProducts(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ImportProducts: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Import is larger than %d MB", maxImportBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "An import file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	format, err := importFormat(r.FormValue("format"), header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := false
+	if s := r.FormValue("dryRun"); s != "" {
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, "Invalid dryRun", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var rows []importRow
+	if format == ImportFormatCSV {
+		rows, err = parseImportCSV(file)
+	} else {
+		rows, err = parseImportJSON(file)
+	}
+	if err != nil {
+		http.Error(w, "Invalid import file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		http.Error(w, "Import file contains no products", http.StatusBadRequest)
+		return
+	}
+
+	job := &importJob{ProductImport: ProductImport{UserID: user.ID, Format: format, DryRun: dryRun, Status: ImportPending, TotalRows: len(rows)}}
+	if archive, _, err := r.FormFile("images"); err == nil {
+		defer archive.Close()
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			http.Error(w, "Error reading images archive", http.StatusBadRequest)
+			return
+		}
+		if job.archive, err = newImportArchive(data); err != nil {
+			http.Error(w, "Invalid images archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if !errors.Is(err, http.ErrMissingFile) {
+		http.Error(w, "Error reading images archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Serialise a user's imports on their user row so only one is started at a time
+		if err := tx.Exec("SELECT id FROM users WHERE id = ? FOR UPDATE", user.ID).Error; err != nil {
+			return err
+		}
+		if err := failStaleImports(tx, user.ID, now); err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&ProductImport{}).Where("user_id = ? AND status IN ?", user.ID, []string{ImportPending, ImportRunning}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return errImportInProgress
+		}
+		return tx.Create(&job.ProductImport).Error
+	})
+	if err != nil {
+		if errors.Is(err, errImportInProgress) {
+			http.Error(w, "An import is already in progress; wait for it to finish", http.StatusConflict)
+			return
+		}
+		log.Printf("Error creating product import for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The job outlives the request, so it must not use the request's context
+	go job.run(context.Background(), rows)
+
+	writeJSON(w, http.StatusAccepted, job.toReturn())
+}
+
+// GetProductImport reports the progress of an import and the rows that failed.
+//
+// @Summary      Get a product import
+// @Description  Returns the status and counts of one of the user's imports, with an error for each row that was not imported.
+// @Tags         Products
+// @Produce      json
+// @Param        id   query     int  true  "Import ID" Format(uint64)
+// @Success      200  {object}  ProductImportReturn "The import"
+// @Failure      400  {string}  string "Bad Request: Invalid import ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      404  {string}  string "Not Found: Import not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_product_import [get]
+func GetProductImport(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetProductImport: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	importID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid import ID", http.StatusBadRequest)
+		return
+	}
+	if err := failStaleImports(db, user.ID, time.Now()); err != nil {
+		log.Printf("Error failing stale product imports of user %d: %v", user.ID, err)
+	}
+
+	var job ProductImport
+	err = db.Preload("Errors", func(tx *gorm.DB) *gorm.DB { return tx.Order(`"row" ASC`) }).
+		Where("id = ? AND user_id = ?", importID, user.ID).First(&job).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Import not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching product import %d: %v", importID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.toReturn())
+}
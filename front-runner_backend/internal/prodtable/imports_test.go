@@ -0,0 +1,241 @@
+// internal/prodtable/imports_test.go
+package prodtable
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := parseImportCSV(strings.NewReader("\ufeffProductName,price,count,tags,images\n" +
+		"Mug,9.50,4,\"Kitchen, Gifts\",mug.png | https://example.com/mug2.jpg\n" +
+		"Plate,3\n"))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, importRow{Name: "Mug", Price: "9.50", Count: "4", Tags: []string{"kitchen", "gifts"},
+		Images: []string{"mug.png", "https://example.com/mug2.jpg"}}, rows[0])
+	assert.Equal(t, "Plate", rows[1].Name)
+	assert.Empty(t, rows[1].Count, "Short rows leave the missing fields blank")
+
+	_, err = parseImportCSV(strings.NewReader("productName,colour\nMug,red\n"))
+	assert.ErrorContains(t, err, "unknown CSV column")
+	_, err = parseImportCSV(strings.NewReader("price,count\n1,2\n"))
+	assert.ErrorContains(t, err, "productName")
+}
+
+func TestParseImportJSON(t *testing.T) {
+	rows, err := parseImportJSON(strings.NewReader(`[{"productName": " Mug ", "price": 9.5, "count": "4", "tags": ["Kitchen", "kitchen"], "images": ["mug.png", ""]}]`))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, importRow{Name: "Mug", Price: "9.5", Count: "4", Tags: []string{"kitchen"}, Images: []string{"mug.png"}}, rows[0])
+
+	_, err = parseImportJSON(strings.NewReader(`[{"productName": "Mug", "colour": "red"}]`))
+	assert.Error(t, err, "Unknown fields are rejected")
+	_, err = parseImportJSON(strings.NewReader(`{"productName": "Mug"}`))
+	assert.Error(t, err, "The file must hold an array")
+}
+
+func TestImportFormat(t *testing.T) {
+	format, err := importFormat("", "catalog.CSV")
+	require.NoError(t, err)
+	assert.Equal(t, ImportFormatCSV, format)
+	format, err = importFormat("json", "catalog.txt")
+	require.NoError(t, err)
+	assert.Equal(t, ImportFormatJSON, format)
+	_, err = importFormat("", "catalog.xlsx")
+	assert.Error(t, err)
+}
+
+func TestPublicAddressesOnly(t *testing.T) {
+	assert.NoError(t, publicAddressesOnly("tcp", "93.184.216.34:443", nil))
+	for _, addr := range []string{"127.0.0.1:80", "10.0.0.5:80", "192.168.1.1:80", "169.254.169.254:80", "[::1]:80", "[fd00::1]:80"} {
+		assert.Error(t, publicAddressesOnly("tcp", addr, nil), addr)
+	}
+}
+
+// zipBytes builds a zip archive of the given files.
+func zipBytes(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestNewImportArchive(t *testing.T) {
+	archive, err := newImportArchive(zipBytes(t, map[string][]byte{
+		"photos/mug.png":   []byte("a"),
+		"photos/a/cup.png": []byte("b"),
+		"photos/b/cup.png": []byte("c"),
+	}))
+	require.NoError(t, err)
+	assert.NotNil(t, archive["photos/mug.png"])
+	assert.NotNil(t, archive["mug.png"], "Files can be referred to by name alone")
+	assert.Nil(t, archive["cup.png"], "Ambiguous names need their folder")
+	assert.NotNil(t, archive["photos/a/cup.png"])
+
+	_, err = newImportArchive([]byte("not a zip"))
+	assert.Error(t, err)
+}
+
+// TestProductImport tests importing products from CSV and JSON files, with dry runs and row errors.
+func TestProductImport(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "importer@example.com", "password")
+
+	// Serve images by URL; the test server is on loopback, so allow it
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/remote.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testImageBytes(t, "remote.jpg"))
+	}))
+	defer server.Close()
+	previous := importImageClient
+	importImageClient = server.Client()
+	defer func() { importImageClient = previous }()
+
+	start := func(t *testing.T, filename, content string, fields map[string]string, archive []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			require.NoError(t, writer.WriteField(k, v))
+		}
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+		if archive != nil {
+			part, err := writer.CreateFormFile("images", "images.zip")
+			require.NoError(t, err)
+			_, err = part.Write(archive)
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+		req := createAuthenticatedRequest(t, user, "POST", "/api/import_products", &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		ImportProducts(rr, req)
+		return rr
+	}
+	wait := func(t *testing.T, rr *httptest.ResponseRecorder) ProductImportReturn {
+		t.Helper()
+		require.Equal(t, http.StatusAccepted, rr.Code, "body: %s", rr.Body.String())
+		var job ProductImportReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		require.Eventually(t, func() bool {
+			rr := httptest.NewRecorder()
+			GetProductImport(rr, createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_product_import?id=%d", job.ID), nil))
+			require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+			return job.Status != ImportPending && job.Status != ImportRunning
+		}, 10*time.Second, 20*time.Millisecond)
+		return job
+	}
+	productByName := func(t *testing.T, name string) *Product {
+		t.Helper()
+		var product Product
+		if err := testDB.Preload("Tags").Where("user_id = ? AND prod_name = ?", user.ID, name).First(&product).Error; err != nil {
+			return nil
+		}
+		return &product
+	}
+
+	csvFile := "productName,description,price,count,tags,images\n" +
+		"Mug,Stoneware mug,9.50,4,\"kitchen,gifts\",mug.png\n" +
+		"Remote,Fetched by URL,20,1,," + server.URL + "/remote.jpg\n" +
+		"Bad Price,Desc,abc,1,,mug.png\n" +
+		"No Image,Desc,1,1,,\n" +
+		"Mug,Again,1,1,,mug.png\n"
+	archive := zipBytes(t, map[string][]byte{"photos/mug.png": testImageBytes(t, "mug.png")})
+
+	t.Run("DryRun", func(t *testing.T) {
+		job := wait(t, start(t, "catalog.csv", csvFile, map[string]string{"dryRun": "true"}, archive))
+		assert.Equal(t, ImportCompleted, job.Status)
+		assert.True(t, job.DryRun)
+		assert.Equal(t, 5, job.Processed)
+		assert.Equal(t, 2, job.Created)
+		assert.Equal(t, 3, job.Failed)
+		assert.Nil(t, productByName(t, "Mug"), "Dry runs save nothing")
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		job := wait(t, start(t, "catalog.csv", csvFile, nil, archive))
+		assert.Equal(t, ImportCompleted, job.Status)
+		assert.Equal(t, 2, job.Created)
+		require.Len(t, job.Errors, 3)
+		assert.Equal(t, ProductImportErrorReturn{Row: 3, ProductName: "Bad Price", Field: "price", Message: `invalid price "abc"`}, job.Errors[0])
+		assert.Equal(t, "images", job.Errors[1].Field)
+		assert.Equal(t, "duplicate of row 1", job.Errors[2].Message)
+
+		mug := productByName(t, "Mug")
+		require.NotNil(t, mug)
+		assert.Equal(t, int64(950), mug.PriceMinor)
+		assert.Equal(t, uint(4), mug.ProdCount)
+		assert.Len(t, mug.Tags, 2)
+		assert.NotNil(t, productByName(t, "Remote"))
+
+		var movement StockMovement
+		require.NoError(t, testDB.Where("product_id = ?", mug.ID).First(&movement).Error)
+		assert.Equal(t, fmt.Sprintf("import:%d", job.ID), movement.Reference)
+	})
+
+	t.Run("JSONUpdatesExisting", func(t *testing.T) {
+		job := wait(t, start(t, "catalog.json", `[{"productName": "Mug", "price": "11", "count": 7}, {"productName": "Remote", "count": -1}]`, nil, nil))
+		assert.Equal(t, 1, job.Updated)
+		require.Len(t, job.Errors, 1)
+		assert.Equal(t, "count", job.Errors[0].Field)
+
+		mug := productByName(t, "Mug")
+		require.NotNil(t, mug)
+		assert.Equal(t, int64(1100), mug.PriceMinor)
+		assert.Equal(t, uint(7), mug.ProdCount)
+		assert.Equal(t, "Stoneware mug", mug.ProdDescription, "Blank fields are left unchanged")
+		assert.Len(t, mug.Tags, 2)
+	})
+
+	t.Run("InvalidRequests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, start(t, "catalog.xlsx", "x", nil, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, start(t, "catalog.csv", "price\n1\n", nil, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, start(t, "catalog.json", `[]`, nil, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, start(t, "catalog.csv", csvFile, nil, []byte("not a zip")).Code)
+
+		other := createTestUser(t, "importother@example.com", "password")
+		var job ProductImport
+		require.NoError(t, testDB.Where("user_id = ?", user.ID).First(&job).Error)
+		rr := httptest.NewRecorder()
+		GetProductImport(rr, createAuthenticatedRequest(t, other, "GET", fmt.Sprintf("/api/get_product_import?id=%d", job.ID), nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("OneImportAtATime", func(t *testing.T) {
+		running := ProductImport{UserID: user.ID, Format: ImportFormatCSV, Status: ImportRunning, TotalRows: 1}
+		require.NoError(t, testDB.Create(&running).Error)
+		assert.Equal(t, http.StatusConflict, start(t, "catalog.json", `[{"productName": "Mug"}]`, nil, nil).Code)
+
+		// Imports that stop making progress are given up on
+		require.NoError(t, testDB.Model(&running).UpdateColumn("updated_at", time.Now().Add(-time.Hour)).Error)
+		wait(t, start(t, "catalog.json", `[{"productName": "Mug"}]`, nil, nil))
+		require.NoError(t, testDB.First(&running, running.ID).Error)
+		assert.Equal(t, ImportFailed, running.Status)
+	})
+}
@@ -0,0 +1,299 @@
+package prodtable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"front-runner/internal/oauth"
+	"front-runner/internal/storefronttable"
+
+	"gorm.io/gorm"
+)
+
+var (
+	errLinkNotFound      = errors.New("storefront link not found")
+	errLinkNotOwned      = errors.New("you do not own this storefront link")
+	errListingNotFound   = errors.New("the product is not linked to this storefront")
+	errInvalidListing    = errors.New("invalid listing")
+	errAlreadyListed     = errors.New("the product is already linked to a listing on this storefront; unlink it first")
+	errListingLinkedElse = errors.New("this listing is already linked to another product")
+)
+
+// Sync states of a product's listing, set by the inventory sync as it pushes the product's stock.
+const (
+	ListingSyncPending = "pending" // Stock not pushed since the listing was linked or the stock changed
+	ListingSynced      = "synced"  // The listing has the product's stock
+	ListingSyncError   = "error"   // The last push failed; see SyncError
+)
+
+const maxExternalIDLength = 255
+
+// ProductListing records that a product is listed on a linked storefront's marketplace. Listings
+// are removed with their product or storefront link.
+type ProductListing struct {
+	ID                uint                           `gorm:"primaryKey"`
+	ProductID         uint                           `gorm:"not null;index:idx_product_listing,unique"`
+	LinkID            uint                           `gorm:"not null;index:idx_product_listing,unique;index:idx_link_listing,unique"`
+	Link              storefronttable.StorefrontLink `gorm:"foreignKey:LinkID;constraint:OnDelete:CASCADE"`
+	ExternalListingID string                         `gorm:"not null;index:idx_link_listing,unique"` // The marketplace's ID of the listing
+	ExternalSKU       string                         `gorm:"not null;default:''"`                    // SKU of the product's own stock on the listing, when not the product's SKU
+	SyncStatus        string                         `gorm:"not null;default:'pending'"`             // One of the ListingSync constants
+	SyncError         string
+	LastSyncedAt      *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// ProductListingPayload is used to decode the JSON body of LinkProductListing.
+type ProductListingPayload struct {
+	LinkID            uint   `json:"linkID"`
+	ExternalListingID string `json:"externalListingID"`
+	ExternalSKU       string `json:"externalSKU"` // Optional; defaults to the product's SKU. Variants are matched by their own SKUs.
+}
+
+// ProductListingReturn is a product's listing on a storefront returned to the frontend.
+type ProductListingReturn struct {
+	ListingID         uint   `json:"listingID"`
+	LinkID            uint   `json:"linkID"`
+	StoreType         string `json:"storeType"`
+	StoreName         string `json:"storeName"`
+	ExternalListingID string `json:"externalListingID"`
+	ExternalSKU       string `json:"externalSKU,omitempty"`
+	SyncStatus        string `json:"syncStatus"` // pending, synced or error
+	SyncError         string `json:"syncError,omitempty"`
+	LastSyncedAt      string `json:"lastSyncedAt,omitempty"` // Formatted date string
+}
+
+// setProductListingsReturn converts listings, with their links preloaded, to their API model.
+func setProductListingsReturn(listings []ProductListing) []ProductListingReturn {
+	ret := make([]ProductListingReturn, 0, len(listings))
+	for _, l := range listings {
+		r := ProductListingReturn{
+			ListingID:         l.ID,
+			LinkID:            l.LinkID,
+			StoreType:         l.Link.StoreType,
+			StoreName:         l.Link.StoreName,
+			ExternalListingID: l.ExternalListingID,
+			ExternalSKU:       l.ExternalSKU,
+			SyncStatus:        l.SyncStatus,
+			SyncError:         l.SyncError,
+		}
+		if l.LastSyncedAt != nil {
+			r.LastSyncedAt = l.LastSyncedAt.Format(time.RFC3339)
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// orderListings orders preloaded listings by storefront link.
+func orderListings(tx *gorm.DB) *gorm.DB {
+	return tx.Order("link_id")
+}
+
+// queueListingStock queues a product's stock to be pushed to its listings: each variant's stock,
+// or the product's own when it has no variants.
+func queueListingStock(tx *gorm.DB, productID uint) error {
+	var variantIDs []uint
+	if err := tx.Model(&ProductVariant{}).Where("product_id = ?", productID).Pluck("id", &variantIDs).Error; err != nil {
+		return err
+	}
+	entries := []StockOutboxEntry{}
+	for _, id := range variantIDs {
+		entries = append(entries, StockOutboxEntry{ProductID: productID, VariantID: id})
+	}
+	if len(entries) == 0 {
+		entries = append(entries, StockOutboxEntry{ProductID: productID})
+	}
+	return queueStockPush(tx, entries)
+}
+
+// writeListingError maps the errors of the listing endpoints to HTTP responses.
+func writeListingError(w http.ResponseWriter, handler string, productID uint64, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, errLinkNotFound), errors.Is(err, errListingNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errProductNotOwned):
+		http.Error(w, "Permission denied: You do not own this product", http.StatusForbidden)
+	case errors.Is(err, errLinkNotOwned):
+		http.Error(w, "Permission denied: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, errAlreadyListed), errors.Is(err, errListingLinkedElse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidListing):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: Error with listings of product %d: %v", handler, productID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// LinkProductListing records that a product is listed on one of the user's storefronts.
+//
+// @Summary      Link a product to a storefront listing
+// @Description  Records which listing on a linked storefront's marketplace sells the product, e.g. that it is listed on Etsy as listing 123. When the marketplace has a connector (such as "shopify"), the product's stock is then pushed to that listing: variants by their SKUs, and a product without variants by externalSKU, or its own SKU when omitted. Products not linked to a listing are matched to the marketplace's listings by SKU. A product can be linked to one listing per storefront.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       query  int                    true  "Product ID" Format(uint64)
+// @Param        listing  body   ProductListingPayload  true  "The storefront link and the marketplace's listing ID"
+// @Success      201  {object}  ProductListingReturn "The linked listing, pending its first stock push"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID or listing"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product or storefront link"
+// @Failure      404  {string}  string "Not Found: Product or storefront link not found"
+// @Failure      409  {string}  string "Conflict: The product is already linked on this storefront, or the listing to another product"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/link_product_listing [post]
+func LinkProductListing(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("LinkProductListing: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var payload ProductListingPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	payload.ExternalListingID = strings.TrimSpace(payload.ExternalListingID)
+	if payload.ExternalSKU, err = normalizeSKU(payload.ExternalSKU); err != nil {
+		writeListingError(w, "LinkProductListing", productID, fmt.Errorf("%w: externalSKU must be at most %d characters", errInvalidListing, maxSKULength))
+		return
+	}
+	switch {
+	case payload.LinkID == 0:
+		writeListingError(w, "LinkProductListing", productID, fmt.Errorf("%w: linkID is required", errInvalidListing))
+		return
+	case payload.ExternalListingID == "":
+		writeListingError(w, "LinkProductListing", productID, fmt.Errorf("%w: externalListingID is required", errInvalidListing))
+		return
+	case len(payload.ExternalListingID) > maxExternalIDLength:
+		writeListingError(w, "LinkProductListing", productID, fmt.Errorf("%w: externalListingID must be at most %d characters", errInvalidListing, maxExternalIDLength))
+		return
+	}
+
+	var listing ProductListing
+	err = db.Transaction(func(tx *gorm.DB) error {
+		product, err := lockOwnedProduct(tx, uint(productID), user.ID)
+		if err != nil {
+			return err
+		}
+		var link storefronttable.StorefrontLink
+		if err := tx.First(&link, payload.LinkID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errLinkNotFound
+			}
+			return err
+		}
+		if link.UserID != user.ID {
+			return errLinkNotOwned
+		}
+
+		var existing ProductListing
+		err = tx.Where("link_id = ? AND (product_id = ? OR external_listing_id = ?)", link.ID, product.ID, payload.ExternalListingID).First(&existing).Error
+		switch {
+		case err == nil && existing.ProductID == product.ID:
+			return errAlreadyListed
+		case err == nil:
+			return errListingLinkedElse
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		listing = ProductListing{ProductID: product.ID, LinkID: link.ID, Link: link, ExternalListingID: payload.ExternalListingID,
+			ExternalSKU: payload.ExternalSKU, SyncStatus: ListingSyncPending}
+		if err := tx.Omit("Link").Create(&listing).Error; err != nil {
+			return err
+		}
+		return queueListingStock(tx, product.ID)
+	})
+	if err != nil {
+		writeListingError(w, "LinkProductListing", productID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, setProductListingsReturn([]ProductListing{listing})[0])
+}
+
+// UnlinkProductListing removes the record of a product's listing on one of the user's storefronts.
+//
+// @Summary      Unlink a product from a storefront listing
+// @Description  Stops pushing the product's stock to the listing it was linked to on the storefront. Its stock is still pushed to any listing on the marketplace with the same SKU. Nothing is changed on the marketplace.
+// @Tags         Products
+// @Produce      text/plain
+// @Param        id      query  int  true  "Product ID" Format(uint64)
+// @Param        linkID  query  int  true  "ID of the Storefront Link" Format(uint64)
+// @Success      200  {string}  string "Listing unlinked successfully"
+// @Failure      400  {string}  string "Bad Request: Invalid product or link ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found, or not linked to the storefront"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/unlink_product_listing [delete]
+func UnlinkProductListing(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("UnlinkProductListing: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	productID, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	linkID, err := strconv.ParseUint(q.Get("linkID"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid linkID", http.StatusBadRequest)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		res := tx.Where("product_id = ? AND link_id = ?", productID, linkID).Delete(&ProductListing{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errListingNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		writeListingError(w, "UnlinkProductListing", productID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Listing unlinked successfully")
+}
@@ -0,0 +1,107 @@
+// internal/prodtable/listings_test.go
+package prodtable
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"front-runner/internal/storefronttable"
+)
+
+// TestProductListings tests linking products to storefront listings, listing them with the product, and unlinking them.
+func TestProductListings(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "listings@example.com", "password")
+	other := createTestUser(t, "listingsother@example.com", "password")
+
+	mug := Product{UserID: user.ID, ProdName: "Mug", PriceMinor: 950, Currency: "USD", SKU: "MUG"}
+	require.NoError(t, createProduct(testDB, &mug, []string{"mug.png"}, nil, StockChange{Reason: StockReasonInitial}))
+	plate := Product{UserID: user.ID, ProdName: "Plate", PriceMinor: 500, Currency: "USD"}
+	require.NoError(t, createProduct(testDB, &plate, []string{"plate.png"}, nil, StockChange{Reason: StockReasonInitial}))
+	etsy := storefronttable.StorefrontLink{UserID: user.ID, StoreType: "etsy", StoreName: "My Etsy"}
+	require.NoError(t, testDB.Create(&etsy).Error)
+	othersLink := storefronttable.StorefrontLink{UserID: other.ID, StoreType: "etsy", StoreName: "Their Etsy"}
+	require.NoError(t, testDB.Create(&othersLink).Error)
+
+	link := func(t *testing.T, productID uint, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		LinkProductListing(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/link_product_listing?id=%d", productID), strings.NewReader(body)))
+		return rr
+	}
+	getListings := func(t *testing.T, productID uint) []ProductListingReturn {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetProduct(rr, createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_product?id=%d", productID), nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var prod ProductReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &prod))
+		return prod.Listings
+	}
+
+	t.Run("Link", func(t *testing.T) {
+		require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockOutboxEntry{}).Error)
+		rr := link(t, mug.ID, fmt.Sprintf(`{"linkID": %d, "externalListingID": " 123 "}`, etsy.ID))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var listing ProductListingReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+		assert.Equal(t, "123", listing.ExternalListingID)
+		assert.Equal(t, "etsy", listing.StoreType)
+		assert.Equal(t, "My Etsy", listing.StoreName)
+		assert.Equal(t, ListingSyncPending, listing.SyncStatus)
+
+		var queued int64
+		require.NoError(t, testDB.Model(&StockOutboxEntry{}).Where("product_id = ?", mug.ID).Count(&queued).Error)
+		assert.Equal(t, int64(1), queued, "The product's stock is queued to be pushed to the listing")
+
+		listings := getListings(t, mug.ID)
+		require.Len(t, listings, 1)
+		assert.Equal(t, listing, listings[0])
+		assert.Empty(t, getListings(t, plate.ID))
+	})
+
+	t.Run("RejectsInvalidLinks", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, link(t, plate.ID, fmt.Sprintf(`{"linkID": %d}`, etsy.ID)).Code)
+		assert.Equal(t, http.StatusBadRequest, link(t, plate.ID, `{"externalListingID": "456"}`).Code)
+		assert.Equal(t, http.StatusNotFound, link(t, plate.ID, `{"linkID": 999999, "externalListingID": "456"}`).Code)
+		assert.Equal(t, http.StatusForbidden, link(t, plate.ID, fmt.Sprintf(`{"linkID": %d, "externalListingID": "456"}`, othersLink.ID)).Code)
+		assert.Equal(t, http.StatusConflict, link(t, mug.ID, fmt.Sprintf(`{"linkID": %d, "externalListingID": "456"}`, etsy.ID)).Code,
+			"A product has one listing per storefront")
+		assert.Equal(t, http.StatusConflict, link(t, plate.ID, fmt.Sprintf(`{"linkID": %d, "externalListingID": "123"}`, etsy.ID)).Code,
+			"A listing sells one product")
+
+		rr := httptest.NewRecorder()
+		LinkProductListing(rr, createAuthenticatedRequest(t, other, "POST", fmt.Sprintf("/api/link_product_listing?id=%d", plate.ID),
+			strings.NewReader(fmt.Sprintf(`{"linkID": %d, "externalListingID": "456"}`, othersLink.ID))))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Unlink", func(t *testing.T) {
+		unlink := func(t *testing.T, productID, linkID uint) int {
+			t.Helper()
+			rr := httptest.NewRecorder()
+			UnlinkProductListing(rr, createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/unlink_product_listing?id=%d&linkID=%d", productID, linkID), nil))
+			return rr.Code
+		}
+		assert.Equal(t, http.StatusNotFound, unlink(t, plate.ID, etsy.ID))
+		assert.Equal(t, http.StatusOK, unlink(t, mug.ID, etsy.ID))
+		assert.Empty(t, getListings(t, mug.ID))
+		assert.Equal(t, http.StatusCreated, link(t, plate.ID, fmt.Sprintf(`{"linkID": %d, "externalListingID": "123"}`, etsy.ID)).Code,
+			"An unlinked listing can be linked again")
+	})
+
+	t.Run("RemovedWithStorefrontLink", func(t *testing.T) {
+		require.NoError(t, testDB.Delete(&etsy).Error)
+		var count int64
+		require.NoError(t, testDB.Model(&ProductListing{}).Where("link_id = ?", etsy.ID).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
@@ -4,74 +4,83 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"front-runner/internal/blobstore"
 	"front-runner/internal/coredbutils"
+	"front-runner/internal/imagepipeline"
+	"front-runner/internal/money"
 	"front-runner/internal/oauth" // Import oauth
 
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 
-	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Image struct definition
 type Image struct {
-	ID     uint   `gorm:"primaryKey;autoIncrement"`
-	URL    string `gorm:"unique;not null"`
-	UserID uint   `gorm:"not null;index"`
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	URL       string `gorm:"unique;not null"`
+	UserID    uint   `gorm:"not null;index"`
+	ProductID uint   `gorm:"not null;default:0;index"` // Product whose gallery holds this image
+	Position  int    `gorm:"not null;default:0"`       // Gallery order, lowest first
 }
 
 // Product struct definition
 type Product struct {
-	ID              uint   `gorm:"primaryKey"`
-	UserID          uint   `gorm:"not null;index:idx_product,unique"`
-	ProdName        string `gorm:"not null;index:idx_product,unique"`
-	ProdDescription string `gorm:"not null"`
-	ImgID           uint
-	Img             Image `gorm:"foreignKey:ImgID"`
-	ProdPrice       float64
-	ProdCount       uint
-	ProdTags        string
+	ID               uint             `gorm:"primaryKey"`
+	UserID           uint             `gorm:"not null;index:idx_product,unique"`
+	ProdName         string           `gorm:"not null;index:idx_product,unique"`
+	ProdDescription  string           `gorm:"not null"`
+	ImgID            uint             // Primary image; always one of Images
+	Img              Image            `gorm:"foreignKey:ImgID"`
+	Images           []Image          `gorm:"foreignKey:ProductID;constraint:-"` // Gallery; preload with orderImages
+	PriceMinor       int64            `gorm:"not null;default:0"`                // Price in minor units of Currency, e.g. cents
+	Currency         string           `gorm:"size:3;not null;default:'USD'"`     // ISO 4217 code
+	ProdCount        uint             // Total of the variants' stock when the product has variants
+	SKU              string           `gorm:"not null;default:''"` // Optional; unique per seller among products and variants
+	ReorderThreshold uint             `gorm:"not null;default:0"`  // Stock alerts are raised when ProdCount falls below this; 0 disables them
+	Tags             []Tag            `gorm:"many2many:product_tags;constraint:OnDelete:CASCADE"`
+	Options          []ProductOption  `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"` // Preload with orderOptions
+	Variants         []ProductVariant `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"` // Preload with orderVariants
+	Listings         []ProductListing `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"` // Preload with orderListings, and Listings.Link
 }
 
-// AfterDelete hook to clean up associated image file and record.
+// AfterDelete hook to clean up the product's image files and records.
 func (p *Product) AfterDelete(tx *gorm.DB) (err error) {
-	// Find the image associated with the product
-	var img Image
-	if err := tx.First(&img, "id = ?", p.ImgID).Error; err == nil {
-		// Delete the image file from disk
-		imagePath := filepath.Join("uploads", img.URL)
-		if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
-			// Log error if deletion fails for reasons other than file not existing
-			log.Printf("Error deleting image file %s during product deletion: %v", imagePath, err)
-			// Decide if this should halt the transaction or just be logged
-			// return fmt.Errorf("failed to delete image file: %w", err) // Uncomment to make it critical
-		}
-		// Delete the image record from the database
-		if err := tx.Delete(&Image{}, "id = ?", p.ImgID).Error; err != nil {
-			log.Printf("Error deleting image record %d during product deletion: %v", p.ImgID, err)
-			return err // Return DB error
-		}
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		// Log error if fetching image fails for reasons other than not found
-		log.Printf("Error finding image record %d during product deletion: %v", p.ImgID, err)
+	// Find every image in the product's gallery, plus the primary image in case it was never linked
+	var images []Image
+	if err := tx.Where("product_id = ? OR id = ?", p.ID, p.ImgID).Find(&images).Error; err != nil {
+		log.Printf("Error finding image records of product %d during deletion: %v", p.ID, err)
+		return err // Return DB error
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	// Delete the image files (original and resized variants) from storage; failures are only logged
+	for _, img := range images {
+		removeImageFiles(tx.Statement.Context, []string{img.URL})
+	}
+	// Delete the image records from the database
+	if err := tx.Delete(&images).Error; err != nil {
+		log.Printf("Error deleting image records of product %d during deletion: %v", p.ID, err)
 		return err // Return DB error
 	}
-	// If image not found or successfully deleted, proceed
 	return nil
 }
 
 var (
 	db        *gorm.DB
+	store     blobstore.Store // Where image files live; selected by BLOB_STORE
 	setupOnce sync.Once
 )
 
-// Setup initializes database connection and ensures uploads directory exists.
+// Setup initializes the database connection and the blob store that holds product images.
 func Setup() {
 	setupOnce.Do(func() {
 		coredbutils.LoadEnv() // Ensure env vars are loaded if needed by GetDB
@@ -84,41 +93,86 @@ func Setup() {
 		if db == nil {
 			log.Fatal("prodtable Setup: Database connection is nil after GetDB.")
 		}
+		// Image storage: the local uploads directory by default, or an S3-compatible bucket
+		store, err = blobstore.FromEnv()
+		if err != nil {
+			log.Fatalf("prodtable Setup: Failed to configure image storage: %v", err)
+		}
+		imageBaseURL = strings.TrimRight(os.Getenv("IMAGE_BASE_URL"), "/")
 		log.Println("prodtable package setup complete (DB connection obtained).")
 	})
-
-	// Ensure uploads directory exists
-	uploadsDir := "uploads"
-	if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-		log.Printf("Creating uploads directory: %s", uploadsDir)
-		if err := os.Mkdir(uploadsDir, 0755); err != nil { // Use 0755 permissions
-			log.Fatalf("Failed to create uploads directory '%s': %v", uploadsDir, err)
-		}
-	} else if err != nil {
-		log.Fatalf("Error checking uploads directory '%s': %v", uploadsDir, err)
-	}
-}
-
-// doesFileExist checks if a file exists at the given path.
-func doesFileExist(filepath string) bool {
-	_, err := os.Stat(filepath)
-	return !errors.Is(err, os.ErrNotExist)
 }
 
-// MigrateProdDB runs GORM auto-migration for Product and Image models.
+// MigrateProdDB runs GORM auto-migration for Product, Image, Tag, variant, stock ledger and import models.
 func MigrateProdDB() {
 	if db == nil {
 		log.Fatal("Database connection is not initialized")
 	}
 	log.Println("Running product and image database migrations...")
-	err := db.AutoMigrate(&Product{}, &Image{})
+	err := db.AutoMigrate(&Tag{}, &Product{}, &Image{}, &ProductOption{}, &ProductVariant{}, &StockMovement{}, &StockOutboxEntry{}, &ProductListing{}, &ProductImport{}, &ProductImportError{})
 	if err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
+	// Convert float prices from before money was stored in minor units
+	if err := db.Transaction(migrateLegacyPrices); err != nil {
+		log.Fatalf("Migration failed converting prices: %v", err)
+	}
+	// Link images created before galleries existed to the product that uses them
+	if err := db.Exec(`UPDATE images SET product_id = products.id FROM products
+		WHERE products.img_id = images.id AND images.product_id = 0`).Error; err != nil {
+		log.Fatalf("Migration failed linking images to products: %v", err)
+	}
+	// Move tags out of the legacy comma-separated column if it is still present
+	if db.Migrator().HasColumn(&Product{}, "prod_tags") {
+		if err := db.Transaction(migrateLegacyTags); err != nil {
+			log.Fatalf("Migration failed moving legacy tags: %v", err)
+		}
+	}
+	// Start the stock ledger of products from before it existed
+	if err := db.Transaction(migrateOpeningBalances); err != nil {
+		log.Fatalf("Migration failed recording opening stock balances: %v", err)
+	}
+	if err := db.Exec(productSKUIndex).Error; err != nil {
+		log.Fatalf("Migration failed creating product SKU index: %v", err)
+	}
+	if err := db.Exec(stockOutboxIndex).Error; err != nil {
+		log.Fatalf("Migration failed creating stock outbox index: %v", err)
+	}
+	// Expression indexes used by GetProducts' search and sort options
+	for _, stmt := range productSearchIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Fatalf("Migration failed creating product search index: %v", err)
+		}
+	}
 	log.Println("Product and Image database migration complete")
 }
 
-// ClearProdTable removes all records from the Product and Image tables. USE WITH CAUTION.
+// migrateLegacyPrices converts the float price columns used before prices were stored in
+// minor units. Existing rows are assumed to be in the default currency.
+func migrateLegacyPrices(tx *gorm.DB) error {
+	currency := money.DefaultCurrency()
+	scale := money.Scale(currency)
+	if tx.Migrator().HasColumn(&Product{}, "prod_price") {
+		if err := tx.Exec(`UPDATE products SET price_minor = ROUND(COALESCE(prod_price, 0) * ?)::bigint, currency = ?`,
+			scale, currency).Error; err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropColumn(&Product{}, "prod_price"); err != nil {
+			return err
+		}
+	}
+	if tx.Migrator().HasColumn(&ProductVariant{}, "price") {
+		if err := tx.Exec(`UPDATE product_variants SET price_minor = ROUND(COALESCE(price, 0) * ?)::bigint`, scale).Error; err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropColumn(&ProductVariant{}, "price"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearProdTable removes all records from the Product, Image, StockMovement, Tag and import tables. USE WITH CAUTION.
 func ClearProdTable(db *gorm.DB) error {
 	// It's safer to delete images first if there's no strict foreign key constraint ensuring cascade delete
 	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Image{}).Error; err != nil {
@@ -127,6 +181,20 @@ func ClearProdTable(db *gorm.DB) error {
 	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Product{}).Error; err != nil {
 		return fmt.Errorf("error clearing product table: %w", err)
 	}
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockMovement{}).Error; err != nil {
+		return fmt.Errorf("error clearing stock movements table: %w", err)
+	}
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockOutboxEntry{}).Error; err != nil {
+		return fmt.Errorf("error clearing stock outbox table: %w", err)
+	}
+	// Import row errors are removed with their imports (ON DELETE CASCADE)
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ProductImport{}).Error; err != nil {
+		return fmt.Errorf("error clearing product imports table: %w", err)
+	}
+	// Product tag links, options and variants are removed with their products (ON DELETE CASCADE)
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Tag{}).Error; err != nil {
+		return fmt.Errorf("error clearing tags table: %w", err)
+	}
 	// Optionally clear the uploads directory
 	// files, err := filepath.Glob(filepath.Join("uploads", "*"))
 	// if err == nil {
@@ -141,19 +209,23 @@ func ClearProdTable(db *gorm.DB) error {
 // It expects product details and an image file via multipart/form-data.
 //
 // @Summary      Add a new product
-// @Description  Creates a new product listing associated with the authenticated user. Requires product details and an image upload.
+// @Description  Creates a new product listing associated with the authenticated user. Requires product details and at least one image upload; the first image becomes the primary image.
 // @Tags         Products
 // @Accept       multipart/form-data
 // @Produce      text/plain
 // @Param        productName  formData  string  true  "Name of the product"
 // @Param        description  formData  string  true  "Description of the product"
-// @Param        price        formData  number  true  "Price of the product (e.g., 19.99)" Format(float)
+// @Param        price        formData  string  true  "Price of the product as a decimal (e.g., 19.99)"
+// @Param        currency     formData  string  false "ISO 4217 currency code of the price (defaults to DEFAULT_CURRENCY)"
 // @Param        count        formData  integer true  "Available stock count" Format(int32)
+// @Param        reorderThreshold formData integer false "Raise low-stock alerts when stock falls below this count; 0 (default) disables stock alerts" Format(int32)
+// @Param        sku          formData  string  false "Stock keeping unit, unique among the seller's products and variants; matches marketplace order lines"
 // @Param        tags         formData  string  false "Comma-separated tags for the product"
-// @Param        image        formData  file    true  "Product image file"
+// @Param        image        formData  file    true  "JPEG, PNG or GIF image; repeat for a gallery (first is primary, max 10)"
 // @Success      201  {string}  string "Product added successfully"
 // @Failure      400  {string}  string "Bad Request: Missing required fields, invalid data format, or image error"
 // @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      409  {string}  string "Conflict: Another product or variant has this SKU"
 // @Failure      500  {string}  string "Internal Server Error: Database or file system error"
 // @Security     ApiKeyAuth
 // @Router       /api/products [post]
@@ -190,8 +262,15 @@ func AddProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	productPrice, err := strconv.ParseFloat(productPriceStr, 64)
-	if err != nil || productPrice < 0 {
+	currency := money.DefaultCurrency()
+	if s := r.FormValue("currency"); s != "" {
+		if currency, err = money.NormalizeCurrency(s); err != nil {
+			http.Error(w, "Invalid currency", http.StatusBadRequest)
+			return
+		}
+	}
+	productPrice, err := money.Parse(productPriceStr, currency)
+	if err != nil {
 		http.Error(w, "Invalid product price", http.StatusBadRequest)
 		return
 	}
@@ -200,90 +279,63 @@ func AddProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid product count", http.StatusBadRequest)
 		return
 	}
-
-	// Handle File Upload
-	file, handler, err := r.FormFile("image")
-	if err != nil {
-		if errors.Is(err, http.ErrMissingFile) {
-			http.Error(w, "Product image is required", http.StatusBadRequest)
-		} else {
-			http.Error(w, "Error retrieving the image file: "+err.Error(), http.StatusBadRequest)
+	reorderThreshold := 0
+	if s := r.FormValue("reorderThreshold"); s != "" {
+		if reorderThreshold, err = strconv.Atoi(s); err != nil || reorderThreshold < 0 {
+			http.Error(w, "Invalid reorder threshold", http.StatusBadRequest)
+			return
 		}
-		return
 	}
-	defer file.Close()
-
-	// Generate unique filename and save image
-	imageFilename := uuid.New().String() + filepath.Ext(handler.Filename)
-	imagePath := filepath.Join("uploads", imageFilename)
-	dst, err := os.Create(imagePath)
+	sku, err := normalizeSKU(r.FormValue("sku"))
 	if err != nil {
-		log.Printf("Error creating image file %s: %v", imagePath, err)
-		http.Error(w, "Error saving image", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer dst.Close() // Ensure destination file is closed
 
-	_, err = io.Copy(dst, file) // Copy file content
-	if err != nil {
-		log.Printf("Error copying image data to %s: %v", imagePath, err)
-		http.Error(w, "Error saving image data", http.StatusInternalServerError)
-		// Attempt to clean up partially created file
-		os.Remove(imagePath)
+	// Handle file uploads; the first image becomes the primary image
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		http.Error(w, "Product image is required", http.StatusBadRequest)
 		return
 	}
-	// Explicitly close dst here before proceeding, to ensure data is flushed
-	if err := dst.Close(); err != nil {
-		log.Printf("Error closing image file %s after write: %v", imagePath, err)
-		// Continue, but log the error
-	}
-
-	// Use transaction for database operations
-	tx := db.Begin()
-	if tx.Error != nil {
-		log.Printf("Failed to begin transaction: %v", tx.Error)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		os.Remove(imagePath) // Clean up saved image file
+	if len(files) > maxProductImages {
+		http.Error(w, errTooManyImages.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Save Image record
-	image := Image{
-		URL:    imageFilename,
-		UserID: userID,
-	}
-	if err := tx.Create(&image).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error saving image record for user %d: %v", userID, err)
-		http.Error(w, "Error saving image metadata", http.StatusInternalServerError)
-		os.Remove(imagePath) // Clean up saved image file
+	imageFilenames, err := saveImageFiles(r.Context(), files)
+	if err != nil {
+		writeImageSaveError(w, "AddProduct", err)
 		return
 	}
 
-	// Create Product record
 	product := Product{
-		UserID:          userID,
-		ProdName:        productName,
-		ProdDescription: productDescription,
-		ProdPrice:       productPrice,
-		ProdCount:       uint(productCount),
-		ProdTags:        productTags,
-		ImgID:           image.ID, // Link the image ID
-	}
-	if err := tx.Create(&product).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error saving product record for user %d: %v", userID, err)
-		http.Error(w, "Error saving product", http.StatusInternalServerError)
-		os.Remove(imagePath) // Clean up saved image file
-		return
-	}
-
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback() // Attempt rollback on commit failure (might be redundant)
-		log.Printf("Failed to commit transaction for user %d: %v", userID, err)
-		http.Error(w, "Database error during commit", http.StatusInternalServerError)
-		os.Remove(imagePath) // Clean up saved image file
+		UserID:           userID,
+		ProdName:         productName,
+		ProdDescription:  productDescription,
+		PriceMinor:       productPrice,
+		Currency:         currency,
+		ProdCount:        uint(productCount),
+		ReorderThreshold: uint(reorderThreshold),
+		SKU:              sku,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if sku != "" {
+			if inUse, err := skuInUse(tx, userID, sku, 0, 0); err != nil {
+				return err
+			} else if inUse {
+				return errDuplicateSKU
+			}
+		}
+		return createProduct(tx, &product, imageFilenames, parseTagNames(productTags), StockChange{Reason: StockReasonInitial, UserID: userID})
+	})
+	if err != nil {
+		if errors.Is(err, errDuplicateSKU) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			log.Printf("Error saving product for user %d: %v", userID, err)
+			http.Error(w, "Error saving product", http.StatusInternalServerError)
+		}
+		removeImageFiles(r.Context(), imageFilenames) // Clean up saved image files
 		return
 	}
 
@@ -291,6 +343,38 @@ func AddProduct(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Product added successfully") // Use fmt.Fprint for consistency
 }
 
+// createProduct saves a new product with a gallery of already-stored images, the first of which
+// becomes its primary image, and the given normalised tags. Its stock is recorded in the ledger.
+func createProduct(tx *gorm.DB, product *Product, imageNames []string, tagNames []string, change StockChange) error {
+	// Save Image records; they are linked to the product once it exists
+	images := make([]Image, len(imageNames))
+	for i, name := range imageNames {
+		images[i] = Image{URL: name, UserID: product.UserID, Position: i}
+	}
+	if err := tx.Create(&images).Error; err != nil {
+		return fmt.Errorf("saving image records: %w", err)
+	}
+	product.ImgID = images[0].ID // Link the primary image ID
+	if err := tx.Create(product).Error; err != nil {
+		return fmt.Errorf("saving product record: %w", err)
+	}
+	if err := tx.Model(&Image{}).Where("id IN ?", imageIDs(images)).Update("product_id", product.ID).Error; err != nil {
+		return fmt.Errorf("linking images to product: %w", err)
+	}
+	if product.ProdCount > 0 {
+		if err := recordMovement(tx, product.ID, 0, int64(product.ProdCount), product.ProdCount, change); err != nil {
+			return fmt.Errorf("recording initial stock: %w", err)
+		}
+	}
+	// Link tags, creating any the user doesn't have yet
+	if len(tagNames) > 0 {
+		if err := setProductTags(tx, product, tagNames); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteProduct removes a product if it belongs to the logged-in user.
 // It uses the product's ID provided as a query parameter.
 //
@@ -388,16 +472,19 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 // @Param        id           query     int     true  "ID of the product to update" Format(uint64)
 // @Param        productName  formData  string  false "New name for the product"
 // @Param        description  formData  string  false "New description for the product"
-// @Param        price        formData  number  false "New price for the product (e.g., 29.99)" Format(float)
+// @Param        price        formData  string  false "New price for the product as a decimal (e.g., 29.99)"
+// @Param        currency     formData  string  false "New ISO 4217 currency code; requires a new price and a product without variants"
 // @Param        count        formData  integer false "New available stock count" Format(int32)
+// @Param        reorderThreshold formData integer false "New low-stock alert threshold; 0 disables stock alerts" Format(int32)
+// @Param        sku          formData  string  false "New SKU; send it empty to remove the product's SKU"
 // @Param        tags         formData  string  false "New comma-separated tags (replaces old tags)"
-// @Param        image        formData  file    false "New product image file (replaces old image)"
+// @Param        image        formData  file    false "New JPEG, PNG or GIF image (replaces the primary image)"
 // @Success      200  {string}  string "Product updated successfully"
-// @Failure      400  {string}  string "Bad Request: Invalid Product ID or data format"
+// @Failure      400  {string}  string "Bad Request: Invalid Product ID, data format or image"
 // @Failure      401  {string}  string "Unauthorized: User not authenticated"
 // @Failure      403  {string}  string "Forbidden: User does not own this product"
 // @Failure      404  {string}  string "Not Found: Product not found"
-// @Failure      409  {string}  string "Conflict: Product name already exists for this user" // If name is updated
+// @Failure      409  {string}  string "Conflict: Product name already exists for this user, or another product or variant has the SKU"
 // @Failure      500  {string}  string "Internal Server Error: Database or file system error during update"
 // @Security     ApiKeyAuth
 // @Router       /api/products [put] // Or PATCH if partial updates are the primary intent
@@ -423,18 +510,16 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use transaction
-	tx := db.Begin()
-	if tx.Error != nil {
-		log.Printf("Failed to begin transaction for update: %v", tx.Error)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	// Parse the multipart form for updates
+	err = r.ParseMultipartForm(10 << 20) // 10 MB limit
+	if err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Check the product exists and is the user's before storing anything for it
 	var product Product
-	// Find the product, preloading image info
-	if err := tx.Preload("Img").First(&product, "id = ?", uint(productID)).Error; err != nil {
-		tx.Rollback()
+	if err := db.Select("id", "user_id").First(&product, "id = ?", uint(productID)).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			http.Error(w, "Product not found", http.StatusNotFound)
 		} else {
@@ -443,22 +528,11 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-
-	// Check ownership
 	if product.UserID != userID {
-		tx.Rollback()
 		http.Error(w, "Unauthorized: You do not own this product", http.StatusForbidden)
 		return
 	}
 
-	// Parse the multipart form for updates
-	err = r.ParseMultipartForm(10 << 20) // 10 MB limit
-	if err != nil {
-		tx.Rollback()
-		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
 	// Prepare map for product updates
 	productUpdates := map[string]interface{}{}
 	if productName := r.FormValue("productName"); productName != "" {
@@ -468,88 +542,157 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	if productDescription := r.FormValue("description"); productDescription != "" {
 		productUpdates["ProdDescription"] = productDescription
 	}
-	// Note: Swagger doc uses 'item_price', form likely uses 'price'
-	if productPriceStr := r.FormValue("price"); productPriceStr != "" {
-		if productPrice, err := strconv.ParseFloat(productPriceStr, 64); err == nil && productPrice >= 0 {
-			productUpdates["ProdPrice"] = productPrice
-		} else {
-			tx.Rollback()
-			http.Error(w, "Invalid product price format", http.StatusBadRequest)
+	newCurrency := ""
+	if currencyStr := r.FormValue("currency"); currencyStr != "" {
+		if newCurrency, err = money.NormalizeCurrency(currencyStr); err != nil {
+			http.Error(w, "Invalid currency", http.StatusBadRequest)
 			return
 		}
 	}
 	// Note: Swagger doc uses 'stock_amount', form likely uses 'count'
+	// Stock is set separately so the change is recorded in the stock ledger
+	var newCount uint
+	countChanged := false
 	if productCountStr := r.FormValue("count"); productCountStr != "" {
 		if productCount, err := strconv.Atoi(productCountStr); err == nil && productCount >= 0 {
-			productUpdates["ProdCount"] = uint(productCount)
+			newCount = uint(productCount)
+			countChanged = true
 		} else {
-			tx.Rollback()
 			http.Error(w, "Invalid product count format", http.StatusBadRequest)
 			return
 		}
 	}
-	if productTags := r.FormValue("tags"); productTags != "" { // Allow empty tags to clear? Decide policy.
-		productUpdates["ProdTags"] = productTags
+	if thresholdStr := r.FormValue("reorderThreshold"); thresholdStr != "" {
+		if threshold, err := strconv.Atoi(thresholdStr); err == nil && threshold >= 0 {
+			productUpdates["ReorderThreshold"] = uint(threshold)
+		} else {
+			http.Error(w, "Invalid reorder threshold", http.StatusBadRequest)
+			return
+		}
+	}
+	_, skuChanged := r.MultipartForm.Value["sku"]
+	sku, err := normalizeSKU(r.FormValue("sku"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	tagNames := parseTagNames(r.FormValue("tags")) // Allow empty tags to clear? Decide policy.
 
-	// Handle image update if provided
-	file, handler, err := r.FormFile("image")
+	// Store a new image, if provided, before locking the product, as that can take a while; it replaces the primary image
+	_, handler, err := r.FormFile("image")
 	newImageFilename := ""
-	newImagePath := ""
 	if err == nil { // New image provided
-		defer file.Close()
-
-		// Save new image
-		newImageFilename = uuid.New().String() + filepath.Ext(handler.Filename)
-		newImagePath = filepath.Join("uploads", newImageFilename)
-		dst, err := os.Create(newImagePath)
+		newImageFilename, err = saveImageFile(r.Context(), handler)
 		if err != nil {
-			tx.Rollback()
-			log.Printf("Error creating new image file %s for update: %v", newImagePath, err)
-			http.Error(w, "Error saving new image", http.StatusInternalServerError)
+			writeImageSaveError(w, "UpdateProduct", err)
 			return
 		}
-		defer dst.Close() // Ensure destination file is closed
+	} else if !errors.Is(err, http.ErrMissingFile) {
+		// Error occurred other than missing file
+		http.Error(w, "Error processing image upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// If err is http.ErrMissingFile, proceed without image update
 
-		_, err = io.Copy(dst, file)
-		if err != nil {
+	// Clean up the new image unless the update is committed
+	committed := false
+	defer func() {
+		if !committed && newImageFilename != "" {
+			removeImageFiles(r.Context(), []string{newImageFilename})
+		}
+	}()
+
+	// Use transaction
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Printf("Failed to begin transaction for update: %v", tx.Error)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Re-read the product, preloading image info, and lock it so concurrent orders cannot change its stock meanwhile
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Img").First(&product, "id = ?", uint(productID)).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error finding product %d for update: %v", productID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Note: Swagger doc uses 'item_price', form likely uses 'price'
+	currency := product.Currency
+	if newCurrency != "" {
+		currency = newCurrency
+	}
+	if currency != product.Currency {
+		// Existing prices are in the old currency's minor units, so they cannot carry over
+		if r.FormValue("price") == "" {
+			tx.Rollback()
+			http.Error(w, "A new price is required when changing the currency", http.StatusBadRequest)
+			return
+		}
+		if withVariants, err := hasVariants(tx, product.ID); err != nil || withVariants {
+			tx.Rollback()
+			if err != nil {
+				log.Printf("Error checking variants of product %d: %v", productID, err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			} else {
+				http.Error(w, errCurrencyOnVariants.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		productUpdates["Currency"] = currency
+	}
+	if productPriceStr := r.FormValue("price"); productPriceStr != "" {
+		if productPrice, err := money.Parse(productPriceStr, currency); err == nil {
+			productUpdates["PriceMinor"] = productPrice
+		} else {
 			tx.Rollback()
-			log.Printf("Error copying new image data to %s: %v", newImagePath, err)
-			http.Error(w, "Error saving new image data", http.StatusInternalServerError)
-			os.Remove(newImagePath) // Clean up partially created file
+			http.Error(w, "Invalid product price format", http.StatusBadRequest)
 			return
 		}
-		// Explicitly close dst here before proceeding
-		if err := dst.Close(); err != nil {
-			log.Printf("Error closing new image file %s after write: %v", newImagePath, err)
-			// Continue, but log the error
+	}
+	if countChanged {
+		if withVariants, err := hasVariants(tx, product.ID); err != nil || withVariants {
+			tx.Rollback()
+			if err != nil {
+				log.Printf("Error checking variants of product %d: %v", productID, err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			} else {
+				http.Error(w, errStockOnVariants.Error(), http.StatusBadRequest)
+			}
+			return
 		}
+	}
+	if skuChanged {
+		if sku != "" {
+			if inUse, err := skuInUse(tx, userID, sku, product.ID, 0); err != nil || inUse {
+				tx.Rollback()
+				if err != nil {
+					log.Printf("Error checking SKU of product %d: %v", productID, err)
+					http.Error(w, "Database error", http.StatusInternalServerError)
+				} else {
+					http.Error(w, errDuplicateSKU.Error(), http.StatusConflict)
+				}
+				return
+			}
+		}
+		productUpdates["SKU"] = sku
+	}
 
-		// Update image record in DB
+	// Point the primary image at the new file
+	if newImageFilename != "" {
 		imageUpdates := map[string]interface{}{"URL": newImageFilename}
 		if err := tx.Model(&Image{}).Where("id = ?", product.ImgID).Updates(imageUpdates).Error; err != nil {
 			tx.Rollback()
 			log.Printf("Error updating image record %d in DB: %v", product.ImgID, err)
 			http.Error(w, "Error updating image metadata", http.StatusInternalServerError)
-			os.Remove(newImagePath) // Clean up new image file
 			return
 		}
-
-		// Delete old image file *after* DB update is successful
-		if product.Img.URL != "" && product.Img.URL != newImageFilename {
-			oldImagePath := filepath.Join("uploads", product.Img.URL)
-			if err := os.Remove(oldImagePath); err != nil && !os.IsNotExist(err) {
-				// Log error but don't fail the request, DB is updated
-				log.Printf("Warning: Failed to delete old image file %s during update: %v", oldImagePath, err)
-			}
-		}
-	} else if !errors.Is(err, http.ErrMissingFile) {
-		// Error occurred other than missing file
-		tx.Rollback()
-		http.Error(w, "Error processing image upload: "+err.Error(), http.StatusBadRequest)
-		return
 	}
-	// If err is http.ErrMissingFile, proceed without image update
 
 	// Apply product updates if any were provided
 	if len(productUpdates) > 0 {
@@ -557,9 +700,25 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 			tx.Rollback()
 			log.Printf("Error updating product %d: %v", productID, err)
 			http.Error(w, "Error updating product details", http.StatusInternalServerError)
-			if newImagePath != "" {
-				os.Remove(newImagePath)
-			} // Clean up new image if product update failed
+			return
+		}
+	}
+
+	if countChanged {
+		if err := setStock(tx, &product, nil, newCount, StockChange{Reason: StockReasonAdjustment, UserID: userID}); err != nil {
+			tx.Rollback()
+			log.Printf("Error updating stock of product %d: %v", productID, err)
+			http.Error(w, "Error updating product details", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Replace tags if any were provided
+	if len(tagNames) > 0 {
+		if err := setProductTags(tx, &product, tagNames); err != nil {
+			tx.Rollback()
+			log.Printf("Error updating tags for product %d: %v", productID, err)
+			http.Error(w, "Error updating product tags", http.StatusInternalServerError)
 			return
 		}
 	}
@@ -569,11 +728,14 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		tx.Rollback() // Attempt rollback
 		log.Printf("Failed to commit transaction for update product %d: %v", productID, err)
 		http.Error(w, "Database error during commit", http.StatusInternalServerError)
-		if newImagePath != "" {
-			os.Remove(newImagePath)
-		} // Clean up new image if commit failed
 		return
 	}
+	committed = true
+
+	// Delete the replaced image's files only once the new one is committed
+	if newImageFilename != "" && product.Img.URL != "" && product.Img.URL != newImageFilename {
+		removeImageFiles(r.Context(), []string{product.Img.URL})
+	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "Product updated successfully")
@@ -581,13 +743,20 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 // ProductReturn struct definition for returning product data via the API.
 type ProductReturn struct {
-	ProdID          uint    `json:"prodID"`
-	ProdName        string  `json:"prodName"`
-	ProdDescription string  `json:"prodDesc"`
-	ImgPath         string  `json:"image"` // Consider renaming to imageURL or similar
-	ProdPrice       float64 `json:"prodPrice"`
-	ProdCount       uint    `json:"prodCount"`
-	ProdTags        string  `json:"prodTags"`
+	ProdID           uint                   `json:"prodID"`
+	ProdName         string                 `json:"prodName"`
+	ProdDescription  string                 `json:"prodDesc"`
+	ImgPath          string                 `json:"image"`     // Primary image; consider renaming to imageURL or similar
+	ProdPrice        json.Number            `json:"prodPrice"` // Exact decimal in Currency
+	Currency         string                 `json:"currency"`
+	ProdCount        uint                   `json:"prodCount"`
+	SKU              string                 `json:"sku"`              // Empty when the product has none
+	ReorderThreshold uint                   `json:"reorderThreshold"` // Stock below which alerts are raised, 0 for none
+	ProdTags         string                 `json:"prodTags"`         // Comma-separated tag names, sorted
+	Images           []ProductImageReturn   `json:"images"`           // Gallery in display order
+	Options          []ProductOptionReturn  `json:"options"`          // Variant option axes, empty without variants
+	Variants         []ProductVariantReturn `json:"variants"`         // Purchasable variants, empty without variants
+	Listings         []ProductListingReturn `json:"listings"`         // Listings on the seller's storefronts
 }
 
 // setProductReturn converts a Product DB model to a ProductReturn API model.
@@ -597,16 +766,23 @@ func setProductReturn(product Product) ProductReturn {
 	ret.ProdName = product.ProdName
 	ret.ProdDescription = product.ProdDescription
 	ret.ImgPath = product.Img.URL // This is just the filename, client needs to construct full URL or use GetProductImage
-	ret.ProdPrice = product.ProdPrice
+	ret.ProdPrice = money.Number(product.PriceMinor, product.Currency)
+	ret.Currency = product.Currency
 	ret.ProdCount = product.ProdCount
-	ret.ProdTags = product.ProdTags
+	ret.ReorderThreshold = product.ReorderThreshold
+	ret.SKU = product.SKU
+	ret.ProdTags = joinTagNames(product.Tags)                                   // Tags must be preloaded
+	ret.Images = setProductImagesReturn(product.ImgID, product.Images)          // Images must be preloaded
+	ret.Options = setProductOptionsReturn(product.Options)                      // Options must be preloaded
+	ret.Variants = setProductVariantsReturn(product.Currency, product.Variants) // Variants must be preloaded
+	ret.Listings = setProductListingsReturn(product.Listings)                   // Listings and their links must be preloaded
 	return ret
 }
 
 // GetProduct retrieves the information about a specified product if it belongs to the logged-in user.
 //
 // @Summary      Get a specific product
-// @Description  Retrieves details for a specific product owned by the authenticated user, identified by its ID.
+// @Description  Retrieves details for a specific product owned by the authenticated user, identified by its ID, including its variants and its listings on the user's storefronts.
 // @Tags         Products
 // @Produce      application/json
 // @Param        id   query     int  true  "ID of the product to retrieve" Format(uint64)
@@ -641,8 +817,10 @@ func GetProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var product Product
-	// Preload image data when fetching the product
-	if err := db.Preload("Img").First(&product, "id = ?", uint(productID)).Error; err != nil {
+	// Preload image and tag data when fetching the product
+	if err := db.Preload("Img").Preload("Images", orderImages).Preload("Tags").
+		Preload("Options", orderOptions).Preload("Variants", orderVariants).Preload("Listings", orderListings).Preload("Listings.Link").
+		First(&product, "id = ?", uint(productID)).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			http.Error(w, "Product not found", http.StatusNotFound)
 		} else {
@@ -668,13 +846,28 @@ func GetProduct(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetProducts retrieves information about all products belonging to the logged-in user.
+// GetProducts retrieves a page of the logged-in user's products, optionally searched and filtered.
+// Results use keyset pagination: when more products follow, the X-Next-Cursor response header
+// holds the cursor to pass back for the next page.
 //
 // @Summary      Get all products for the user
-// @Description  Retrieves a list of all products owned by the authenticated user.
+// @Description  Retrieves the authenticated user's products. Supports full-text search over name and description, tag, price and stock filters, sorting, and cursor pagination. When more results exist, the X-Next-Cursor header contains the cursor for the next page.
 // @Tags         Products
 // @Produce      application/json
+// @Param        q         query     string  false "Search text matched against product name and description (word prefixes)"
+// @Param        tags      query     string  false "Comma-separated tags; products must have all of them"
+// @Param        currency  query     string  false "Only products priced in this ISO 4217 currency"
+// @Param        minPrice  query     string  false "Minimum price (inclusive) as a decimal in currency, or the default currency"
+// @Param        maxPrice  query     string  false "Maximum price (inclusive) as a decimal in currency, or the default currency"
+// @Param        minCount  query     integer false "Minimum stock count (inclusive)"
+// @Param        maxCount  query     integer false "Maximum stock count (inclusive)"
+// @Param        sort      query     string  false "Sort key: id (default), name, price or count"
+// @Param        order     query     string  false "Sort direction: asc (default) or desc"
+// @Param        limit     query     integer false "Products per page (default 50, max 200)"
+// @Param        cursor    query     string  false "Cursor from a previous response's X-Next-Cursor header"
 // @Success      200  {array}   ProductReturn "Successfully retrieved list of products"
+// @Header       200  {string}  X-Next-Cursor "Cursor for the next page; absent on the last page"
+// @Failure      400  {string}  string "Bad Request: Invalid search, filter, sort or pagination parameter"
 // @Failure      401  {string}  string "Unauthorized: User not authenticated"
 // @Failure      500  {string}  string "Internal Server Error: Database error"
 // @Security     ApiKeyAuth
@@ -694,23 +887,33 @@ func GetProducts(w http.ResponseWriter, r *http.Request) {
 	userID := user.ID
 	// --- End Updated Auth Check ---
 
+	params, err := parseProductListParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := params.query(db.Preload("Img").Preload("Images", orderImages).Preload("Tags").
+		Preload("Options", orderOptions).Preload("Variants", orderVariants).Preload("Listings", orderListings).Preload("Listings.Link"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var products []Product
-	// Find all products for the user, preloading image data
-	if err := db.Preload("Img").Where("user_id = ?", userID).Find(&products).Error; err != nil {
+	// Find the requested page of the user's products, preloading image and tag data
+	if err := query.Find(&products).Error; err != nil {
 		log.Printf("Error fetching products for user %d: %v", userID, err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Return empty JSON array if no products found, instead of just "[]" string
-	if len(products) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "[]")
-		return
+	// The query fetches one extra row to detect whether another page follows
+	if len(products) > params.Limit {
+		products = products[:params.Limit]
+		w.Header().Set("X-Next-Cursor", encodeProductCursor(params, products[len(products)-1]))
 	}
 
-	var productsRet []ProductReturn
+	productsRet := make([]ProductReturn, 0, len(products))
 	for _, product := range products {
 		retrieve := setProductReturn(product)
 		productsRet = append(productsRet, retrieve)
@@ -724,16 +927,18 @@ func GetProducts(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetProductImage serves the image file associated with a product.
-// It requires the image filename (e.g., the UUID.ext stored in the Image record) as a query parameter.
+// It requires the image filename (e.g., the UUID.ext stored in the Image record) as a query parameter,
+// and optionally a size to serve a resized variant instead of the original.
 // Authentication checks if the user owns the image record.
 //
 // @Summary      Get a product image
-// @Description  Retrieves and serves the image file associated with a product, identified by its filename. Requires the user to be authenticated and own the product/image.
+// @Description  Retrieves and serves the image file associated with a product, identified by its filename. Use size=thumb (200px) or size=medium (800px) for resized variants; images uploaded before variants existed are served at their original size. Requires the user to be authenticated and own the product/image.
 // @Tags         Products
 // @Produce      image/*
 // @Param        image  query     string true  "Filename of the image to retrieve (e.g., 'uuid.jpg')"
+// @Param        size   query     string false "Rendition to serve: original (default), medium or thumb"
 // @Success      200    {file}    binary "Product image file"
-// @Failure      400    {string}  string "Bad Request: Missing or invalid image filename"
+// @Failure      400    {string}  string "Bad Request: Missing or invalid image filename or size"
 // @Failure      401    {string}  string "Unauthorized: User not authenticated"
 // @Failure      403    {string}  string "Forbidden: User does not own this image"
 // @Failure      404    {string}  string "Not Found: Image metadata or file not found"
@@ -769,6 +974,11 @@ func GetProductImage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid image filename", http.StatusBadRequest)
 		return
 	}
+	size, err := imagepipeline.ParseSize(r.URL.Query().Get("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var image Image
 	// Find the image record by URL (filename)
@@ -788,15 +998,29 @@ func GetProductImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imagePath := filepath.Join("uploads", imageFilename)
-
-	// Check if file exists on disk *after* checking DB and ownership
-	if !doesFileExist(imagePath) {
-		log.Printf("Image file not found on disk: %s (DB record exists, ID: %d)", imagePath, image.ID)
+	// Fetch the requested variant; images uploaded before the pipeline only have the original
+	name := imagepipeline.FileName(imageFilename, size)
+	obj, err := store.Get(r.Context(), name)
+	if errors.Is(err, blobstore.ErrNotFound) && name != imageFilename {
+		name = imageFilename
+		obj, err = store.Get(r.Context(), name)
+	}
+	// Check the file exists in storage *after* checking DB and ownership
+	if errors.Is(err, blobstore.ErrNotFound) {
+		log.Printf("Image file not found in storage: %s (DB record exists, ID: %d)", name, image.ID)
 		http.Error(w, "Image file not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Printf("Error reading image %s from storage: %v", name, err)
+		http.Error(w, "Error reading image", http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
 
-	// Serve the file - http.ServeFile handles Content-Type, caching headers etc.
-	http.ServeFile(w, r, imagePath)
+	// Serve the file - http.ServeContent handles Content-Type (from the name), ranges and caching headers
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+	http.ServeContent(w, r, name, obj.ModTime, obj)
 }
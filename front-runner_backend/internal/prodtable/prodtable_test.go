@@ -27,7 +27,9 @@ import (
 
 	"front-runner/internal/coredbutils"
 	"front-runner/internal/login" // Needed for session constants/setup
+	"front-runner/internal/money"
 	"front-runner/internal/oauth" // Needed for oauth.Setup
+	"front-runner/internal/storefronttable"
 	"front-runner/internal/usertable"
 )
 
@@ -214,8 +216,15 @@ func setupTestEnvironment(t *testing.T) {
 	require.NoError(t, testDB.Exec("DELETE FROM orders").Error, "Failed to clear orders table via raw SQL")
 	// *** END RAW SQL DELETE ***
 
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockMovement{}).Error, "Failed to clear stock movements table")
+	// Import row errors are removed with their imports
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ProductImport{}).Error, "Failed to clear product imports table")
+	// Product listings are removed with their storefront links
+	require.NoError(t, storefronttable.ClearStorefrontTable(testDB), "Failed to clear storefront links table")
 	// Now delete Product (which OrderProd depended on)
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Product{}).Error, "Failed to clear product table")
+	// Tags (product_tags links were removed with their products)
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Tag{}).Error, "Failed to clear tags table")
 	// Then Image (which Product depends on)
 	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Image{}).Error, "Failed to clear image table")
 	// Finally User (which Product and OrderOwner depended on)
@@ -301,7 +310,7 @@ func TestAddProduct(t *testing.T) {
 	// Create a dummy file part
 	part, err := writer.CreateFormFile("image", "widget.png")
 	require.NoError(t, err, "Failed to create form file")
-	_, err = io.Copy(part, strings.NewReader("dummy png content"))
+	_, err = part.Write(testImageBytes(t, "widget.png"))
 	require.NoError(t, err, "Failed to copy dummy content to form file")
 	writer.Close() // Close writer to finalize multipart form
 
@@ -319,12 +328,13 @@ func TestAddProduct(t *testing.T) {
 
 	// Verify database insertion
 	var product Product
-	err = testDB.Preload("Img").Where("user_id = ? AND prod_name = ?", user.ID, "Test Widget").First(&product).Error
+	err = testDB.Preload("Img").Preload("Tags").Where("user_id = ? AND prod_name = ?", user.ID, "Test Widget").First(&product).Error
 	require.NoError(t, err, "Failed to find created product in DB")
 	assert.Equal(t, "A wonderful test widget.", product.ProdDescription)
-	assert.Equal(t, 19.95, product.ProdPrice)
+	assert.Equal(t, int64(1995), product.PriceMinor, "Price should be stored exactly in minor units")
+	assert.Equal(t, money.DefaultCurrency(), product.Currency)
 	assert.Equal(t, uint(100), product.ProdCount)
-	assert.Equal(t, "widget,test", product.ProdTags)
+	assert.Equal(t, "test,widget", joinTagNames(product.Tags), "Tags should be stored as Tag rows")
 	require.NotNil(t, product.Img, "Product should have an associated image")
 	assert.NotEmpty(t, product.Img.URL, "Image URL should not be empty")
 	assert.True(t, strings.HasSuffix(product.Img.URL, ".png"), "Image URL should have .png extension")
@@ -363,7 +373,7 @@ func TestDeleteProduct(t *testing.T) {
 		ProdName:        "Product To Delete",
 		ProdDescription: "Delete me",
 		ImgID:           image.ID,
-		ProdPrice:       1.00,
+		PriceMinor:      100,
 		ProdCount:       1,
 	}
 	err = testDB.Create(&product).Error
@@ -420,12 +430,12 @@ func TestUpdateProduct(t *testing.T) {
 		ProdName:        "Product To Update",
 		ProdDescription: "Old Description",
 		ImgID:           image.ID,
-		ProdPrice:       5.00,
+		PriceMinor:      500,
 		ProdCount:       5,
-		ProdTags:        "old,tag",
 	}
 	err = testDB.Create(&product).Error
 	require.NoError(t, err)
+	require.NoError(t, setProductTags(testDB, &product, []string{"old", "tag"}))
 
 	// Prepare update data (including a new image)
 	var buf bytes.Buffer
@@ -434,9 +444,9 @@ func TestUpdateProduct(t *testing.T) {
 	_ = writer.WriteField("price", "9.99")
 	_ = writer.WriteField("count", "50")
 	_ = writer.WriteField("tags", "new,updated")
-	part, err := writer.CreateFormFile("image", "new_image.webp")
+	part, err := writer.CreateFormFile("image", "new_image.jpg")
 	require.NoError(t, err)
-	_, err = io.Copy(part, strings.NewReader("new webp content"))
+	_, err = part.Write(testImageBytes(t, "new_image.jpg"))
 	require.NoError(t, err)
 	writer.Close()
 
@@ -455,15 +465,15 @@ func TestUpdateProduct(t *testing.T) {
 
 	// Verify database update
 	var updatedProduct Product
-	err = testDB.Preload("Img").Where("id = ?", product.ID).First(&updatedProduct).Error
+	err = testDB.Preload("Img").Preload("Tags").Where("id = ?", product.ID).First(&updatedProduct).Error
 	require.NoError(t, err, "Failed to find updated product in DB")
 	assert.Equal(t, "New Updated Description", updatedProduct.ProdDescription)
-	assert.Equal(t, 9.99, updatedProduct.ProdPrice)
+	assert.Equal(t, int64(999), updatedProduct.PriceMinor)
 	assert.Equal(t, uint(50), updatedProduct.ProdCount)
-	assert.Equal(t, "new,updated", updatedProduct.ProdTags)
+	assert.Equal(t, "new,updated", joinTagNames(updatedProduct.Tags))
 	assert.Equal(t, "Product To Update", updatedProduct.ProdName) // Name wasn't updated
 	require.NotNil(t, updatedProduct.Img, "Updated product should still have an image")
-	assert.True(t, strings.HasSuffix(updatedProduct.Img.URL, ".webp"), "Image URL should have been updated to .webp")
+	assert.True(t, strings.HasSuffix(updatedProduct.Img.URL, ".jpg"), "Image URL should have been updated to .jpg")
 	assert.NotEqual(t, initialImageFilename, updatedProduct.Img.URL, "Image URL should have changed")
 
 	// Verify file changes
@@ -472,6 +482,26 @@ func TestUpdateProduct(t *testing.T) {
 	newImagePath := filepath.Join("uploads", updatedProduct.Img.URL)
 	_, err = os.Stat(newImagePath)
 	assert.NoError(t, err, "Expected new image file '%s' to exist", newImagePath)
+
+	// A failed update removes the image it stored
+	before, err := os.ReadDir("uploads")
+	require.NoError(t, err)
+	buf.Reset()
+	writer = multipart.NewWriter(&buf)
+	_ = writer.WriteField("price", "not a price")
+	part, err = writer.CreateFormFile("image", "rejected.jpg")
+	require.NoError(t, err)
+	_, err = part.Write(testImageBytes(t, "rejected.jpg"))
+	require.NoError(t, err)
+	writer.Close()
+	req = createAuthenticatedRequest(t, user, "PUT", targetURL, &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	UpdateProduct(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	after, err := os.ReadDir("uploads")
+	require.NoError(t, err)
+	assert.Len(t, after, len(before), "The rejected update's image should be removed")
 	_ = os.RemoveAll("uploads") // Cleanup
 }
 
@@ -491,12 +521,12 @@ func TestGetProduct(t *testing.T) {
 		ProdName:        "Specific Product",
 		ProdDescription: "Details here",
 		ImgID:           image.ID,
-		ProdPrice:       12.34,
+		PriceMinor:      1234,
 		ProdCount:       12,
-		ProdTags:        "get,specific",
 	}
 	err = testDB.Create(&product).Error
 	require.NoError(t, err)
+	require.NoError(t, setProductTags(testDB, &product, []string{"get", "specific"}))
 
 	// Create authenticated request
 	// Use the /api/products/details path based on previous Swagger doc refinement
@@ -520,7 +550,8 @@ func TestGetProduct(t *testing.T) {
 	assert.Equal(t, product.ID, returnedProduct.ProdID)
 	assert.Equal(t, "Specific Product", returnedProduct.ProdName)
 	assert.Equal(t, "Details here", returnedProduct.ProdDescription)
-	assert.Equal(t, 12.34, returnedProduct.ProdPrice)
+	assert.Equal(t, json.Number("12.34"), returnedProduct.ProdPrice)
+	assert.Equal(t, "USD", returnedProduct.Currency)
 	assert.Equal(t, uint(12), returnedProduct.ProdCount)
 	assert.Equal(t, "get,specific", returnedProduct.ProdTags)
 	assert.Equal(t, imageFilename, returnedProduct.ImgPath) // Check filename matches
@@ -535,18 +566,18 @@ func TestGetProducts(t *testing.T) {
 	// Create products for user1
 	img1 := Image{URL: uuid.NewString() + ".tga", UserID: user1.ID}
 	require.NoError(t, testDB.Create(&img1).Error)
-	prod1 := Product{UserID: user1.ID, ProdName: "User1 Prod A", ImgID: img1.ID, ProdPrice: 1.00}
+	prod1 := Product{UserID: user1.ID, ProdName: "User1 Prod A", ImgID: img1.ID, PriceMinor: 100}
 	require.NoError(t, testDB.Create(&prod1).Error)
 
 	img2 := Image{URL: uuid.NewString() + ".bmp", UserID: user1.ID}
 	require.NoError(t, testDB.Create(&img2).Error)
-	prod2 := Product{UserID: user1.ID, ProdName: "User1 Prod B", ImgID: img2.ID, ProdPrice: 2.00}
+	prod2 := Product{UserID: user1.ID, ProdName: "User1 Prod B", ImgID: img2.ID, PriceMinor: 200}
 	require.NoError(t, testDB.Create(&prod2).Error)
 
 	// Create product for user2 (should not be returned)
 	img3 := Image{URL: uuid.NewString() + ".pcx", UserID: user2.ID}
 	require.NoError(t, testDB.Create(&img3).Error)
-	prod3 := Product{UserID: user2.ID, ProdName: "User2 Prod C", ImgID: img3.ID, ProdPrice: 3.00}
+	prod3 := Product{UserID: user2.ID, ProdName: "User2 Prod C", ImgID: img3.ID, PriceMinor: 300}
 	require.NoError(t, testDB.Create(&prod3).Error)
 
 	// Create authenticated request for user1
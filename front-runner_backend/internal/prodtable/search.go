@@ -0,0 +1,271 @@
+package prodtable
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"front-runner/internal/money"
+
+	"gorm.io/gorm"
+)
+
+// Page size limits for GetProducts.
+const (
+	defaultProductsLimit = 50
+	maxProductsLimit     = 200
+)
+
+// productSearchVector is shared by the text search query and the index that serves it.
+// It must match the index definition in productSearchIndexes exactly for Postgres to use it.
+const productSearchVector = `to_tsvector('simple', coalesce(prod_name, '') || ' ' || coalesce(prod_description, ''))`
+
+// productSearchIndexes are created by MigrateProdDB to keep GetProducts fast on large catalogs.
+var productSearchIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN (` + productSearchVector + `)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_user_name ON products (user_id, prod_name, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_user_price ON products (user_id, price_minor, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_user_count ON products (user_id, prod_count, id)`,
+}
+
+// productSortColumns maps the accepted sort keys to their columns.
+var productSortColumns = map[string]string{
+	"id":    "id",
+	"name":  "prod_name",
+	"price": "price_minor",
+	"count": "prod_count",
+}
+
+// productCursor marks the position after the last product of a page for keyset pagination.
+type productCursor struct {
+	Sort  string          `json:"s"`
+	Desc  bool            `json:"d"`
+	Value json.RawMessage `json:"v"` // Sort column value of the last product
+	ID    uint            `json:"id"`
+}
+
+// productListParams holds the parsed search, filter, sort and pagination options for GetProducts.
+type productListParams struct {
+	TextQuery string   // Postgres tsquery built from the search text; "" for no text search
+	Tags      []string // Lowercased tags that must all be present
+	Currency  string   // Only products priced in this currency; "" for any
+	MinPrice  *int64   // Minor units of Currency
+	MaxPrice  *int64
+	MinCount  *uint64
+	MaxCount  *uint64
+	Sort      string // One of the productSortColumns keys
+	Desc      bool
+	Limit     int
+	Cursor    *productCursor
+}
+
+// buildPrefixTSQuery turns free-form search text into a tsquery that matches every word as a prefix.
+// Anything other than letters and digits separates words, so user input cannot inject tsquery syntax.
+func buildPrefixTSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// parsePriceParam parses an optional decimal price query parameter into minor units of currency.
+func parsePriceParam(q url.Values, name, currency string) (*int64, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := money.Parse(s, currency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return &v, nil
+}
+
+// parseUintParam parses an optional unsigned integer query parameter.
+func parseUintParam(q url.Values, name string) (*uint64, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return &v, nil
+}
+
+// encodeProductCursor returns the opaque cursor pointing after the given product.
+func encodeProductCursor(params productListParams, product Product) string {
+	var value interface{}
+	switch params.Sort {
+	case "name":
+		value = product.ProdName
+	case "price":
+		value = product.PriceMinor
+	case "count":
+		value = product.ProdCount
+	default:
+		value = product.ID
+	}
+	raw, _ := json.Marshal(value)
+	data, _ := json.Marshal(productCursor{Sort: params.Sort, Desc: params.Desc, Value: raw, ID: product.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeProductCursor parses a cursor produced by encodeProductCursor.
+func decodeProductCursor(s string) (*productCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// parseProductListParams reads GetProducts' query parameters, applying defaults.
+func parseProductListParams(q url.Values) (productListParams, error) {
+	params := productListParams{Sort: "id", Limit: defaultProductsLimit}
+	var err error
+
+	params.TextQuery = buildPrefixTSQuery(q.Get("q"))
+	params.Tags = parseTagNames(q.Get("tags"))
+	if s := q.Get("currency"); s != "" {
+		if params.Currency, err = money.NormalizeCurrency(s); err != nil {
+			return params, fmt.Errorf("invalid currency %q", s)
+		}
+	}
+	// Price bounds are only comparable within one currency, the default unless one is given
+	if q.Get("minPrice") != "" || q.Get("maxPrice") != "" {
+		if params.Currency == "" {
+			params.Currency = money.DefaultCurrency()
+		}
+	}
+	if params.MinPrice, err = parsePriceParam(q, "minPrice", params.Currency); err != nil {
+		return params, err
+	}
+	if params.MaxPrice, err = parsePriceParam(q, "maxPrice", params.Currency); err != nil {
+		return params, err
+	}
+	if params.MinCount, err = parseUintParam(q, "minCount"); err != nil {
+		return params, err
+	}
+	if params.MaxCount, err = parseUintParam(q, "maxCount"); err != nil {
+		return params, err
+	}
+
+	if s := strings.ToLower(q.Get("sort")); s != "" {
+		if _, ok := productSortColumns[s]; !ok {
+			return params, fmt.Errorf("invalid sort %q (use id, name, price or count)", s)
+		}
+		params.Sort = s
+	}
+	switch order := strings.ToLower(q.Get("order")); order {
+	case "", "asc":
+	case "desc":
+		params.Desc = true
+	default:
+		return params, fmt.Errorf("invalid order %q (use asc or desc)", order)
+	}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return params, fmt.Errorf("invalid limit %q", s)
+		}
+		params.Limit = min(limit, maxProductsLimit)
+	}
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := decodeProductCursor(s)
+		if err != nil {
+			return params, fmt.Errorf("invalid cursor")
+		}
+		// A cursor only makes sense for the ordering that produced it
+		if cursor.Sort != params.Sort || cursor.Desc != params.Desc {
+			return params, fmt.Errorf("cursor does not match the requested sort")
+		}
+		params.Cursor = cursor
+	}
+	return params, nil
+}
+
+// cursorValue decodes the cursor's sort value into the Go type of the sort column.
+func (p productListParams) cursorValue() (interface{}, error) {
+	switch p.Sort {
+	case "name":
+		var v string
+		err := json.Unmarshal(p.Cursor.Value, &v)
+		return v, err
+	case "price":
+		var v int64
+		err := json.Unmarshal(p.Cursor.Value, &v)
+		return v, err
+	default:
+		var v uint64
+		err := json.Unmarshal(p.Cursor.Value, &v)
+		return v, err
+	}
+}
+
+// query builds the user's product query with the search, filters, ordering and page limit applied.
+// One extra row is fetched so the caller can tell whether another page follows.
+func (p productListParams) query(tx *gorm.DB, userID uint) (*gorm.DB, error) {
+	query := tx.Where("user_id = ?", userID)
+
+	if p.TextQuery != "" {
+		query = query.Where(productSearchVector+" @@ to_tsquery('simple', ?)", p.TextQuery)
+	}
+	if len(p.Tags) > 0 {
+		// Products linked to every requested tag
+		query = query.Where(`id IN (SELECT pt.product_id FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE t.user_id = ? AND t.name IN ? GROUP BY pt.product_id HAVING COUNT(*) = ?)`,
+			userID, p.Tags, len(p.Tags))
+	}
+	if p.Currency != "" {
+		query = query.Where("currency = ?", p.Currency)
+	}
+	if p.MinPrice != nil {
+		query = query.Where("price_minor >= ?", *p.MinPrice)
+	}
+	if p.MaxPrice != nil {
+		query = query.Where("price_minor <= ?", *p.MaxPrice)
+	}
+	if p.MinCount != nil {
+		query = query.Where("prod_count >= ?", *p.MinCount)
+	}
+	if p.MaxCount != nil {
+		query = query.Where("prod_count <= ?", *p.MaxCount)
+	}
+
+	column := productSortColumns[p.Sort]
+	dir, cmp := "ASC", ">"
+	if p.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if p.Cursor != nil {
+		value, err := p.cursorValue()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if column == "id" {
+			query = query.Where("id "+cmp+" ?", p.Cursor.ID)
+		} else {
+			query = query.Where("("+column+", id) "+cmp+" (?, ?)", value, p.Cursor.ID)
+		}
+	}
+	if column == "id" {
+		query = query.Order("id " + dir)
+	} else {
+		query = query.Order(column + " " + dir).Order("id " + dir)
+	}
+	return query.Limit(p.Limit + 1), nil
+}
@@ -0,0 +1,169 @@
+// internal/prodtable/search_test.go
+package prodtable
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPrefixTSQuery(t *testing.T) {
+	assert.Equal(t, "red:* & shoe:*", buildPrefixTSQuery("  Red   shoe"))
+	assert.Equal(t, "a:* & b:*", buildPrefixTSQuery("a' | b:*&"), "tsquery operators must be stripped")
+	assert.Equal(t, "", buildPrefixTSQuery("!!!"))
+}
+
+func TestParseProductListParams(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		params, err := parseProductListParams(url.Values{})
+		require.NoError(t, err)
+		assert.Equal(t, "id", params.Sort)
+		assert.False(t, params.Desc)
+		assert.Equal(t, defaultProductsLimit, params.Limit)
+		assert.Empty(t, params.Tags)
+		assert.Nil(t, params.Cursor)
+	})
+
+	t.Run("AllOptions", func(t *testing.T) {
+		t.Setenv("DEFAULT_CURRENCY", "")
+		params, err := parseProductListParams(url.Values{
+			"q":        {"blue mug"},
+			"tags":     {" Kitchen, ,Gift "},
+			"minPrice": {"1.5"},
+			"maxPrice": {"20"},
+			"minCount": {"1"},
+			"maxCount": {"10"},
+			"sort":     {"price"},
+			"order":    {"desc"},
+			"limit":    {"5000"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "blue:* & mug:*", params.TextQuery)
+		assert.Equal(t, []string{"kitchen", "gift"}, params.Tags)
+		assert.Equal(t, "USD", params.Currency, "Price bounds default to the default currency")
+		assert.Equal(t, int64(150), *params.MinPrice, "Prices are parsed into minor units")
+		assert.Equal(t, int64(2000), *params.MaxPrice)
+		assert.Equal(t, uint64(1), *params.MinCount)
+		assert.Equal(t, uint64(10), *params.MaxCount)
+		assert.Equal(t, "price", params.Sort)
+		assert.True(t, params.Desc)
+		assert.Equal(t, maxProductsLimit, params.Limit, "Limit should be capped")
+	})
+
+	t.Run("CursorRoundTrip", func(t *testing.T) {
+		params, err := parseProductListParams(url.Values{"sort": {"name"}})
+		require.NoError(t, err)
+		cursor := encodeProductCursor(params, Product{ID: 7, ProdName: "Widget"})
+
+		params, err = parseProductListParams(url.Values{"sort": {"name"}, "cursor": {cursor}})
+		require.NoError(t, err)
+		require.NotNil(t, params.Cursor)
+		assert.Equal(t, uint(7), params.Cursor.ID)
+		value, err := params.cursorValue()
+		require.NoError(t, err)
+		assert.Equal(t, "Widget", value)
+
+		_, err = parseProductListParams(url.Values{"sort": {"price"}, "cursor": {cursor}})
+		assert.Error(t, err, "Cursor from a different sort must be rejected")
+	})
+
+	for _, q := range []url.Values{
+		{"minPrice": {"-1"}},
+		{"minPrice": {"1.005"}},
+		{"currency": {"dollars"}},
+		{"maxCount": {"lots"}},
+		{"sort": {"colour"}},
+		{"order": {"up"}},
+		{"limit": {"0"}},
+		{"cursor": {"not-a-cursor"}},
+	} {
+		t.Run("Invalid_"+q.Encode(), func(t *testing.T) {
+			_, err := parseProductListParams(q)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestGetProducts_Search tests GetProducts' search, filter and pagination options.
+func TestGetProducts_Search(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "searchprods@example.com", "password")
+
+	create := func(name, desc, tags string, priceMinor int64, count uint) Product {
+		img := Image{URL: uuid.NewString() + ".png", UserID: user.ID}
+		require.NoError(t, testDB.Create(&img).Error)
+		prod := Product{UserID: user.ID, ProdName: name, ProdDescription: desc, ImgID: img.ID, PriceMinor: priceMinor, ProdCount: count}
+		require.NoError(t, testDB.Create(&prod).Error)
+		require.NoError(t, setProductTags(testDB, &prod, parseTagNames(tags)))
+		return prod
+	}
+	mug := create("Blue Mug", "Ceramic coffee mug", "kitchen, gift", 1200, 5)
+	plate := create("Dinner Plate", "Blue ceramic plate", "Kitchen", 800, 0)
+	scarf := create("Wool Scarf", "Warm winter scarf", "clothing,gift", 2500, 3)
+
+	getProducts := func(t *testing.T, query string) ([]ProductReturn, *httptest.ResponseRecorder) {
+		t.Helper()
+		req := createAuthenticatedRequest(t, user, "GET", "/api/get_products?"+query, nil)
+		rr := httptest.NewRecorder()
+		GetProducts(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp []ProductReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp, rr
+	}
+	ids := func(products []ProductReturn) []uint {
+		out := make([]uint, len(products))
+		for i, p := range products {
+			out[i] = p.ProdID
+		}
+		return out
+	}
+
+	t.Run("TextSearch", func(t *testing.T) {
+		resp, _ := getProducts(t, "q=blue")
+		assert.ElementsMatch(t, []uint{mug.ID, plate.ID}, ids(resp), "Should match name and description")
+
+		resp, _ = getProducts(t, "q=cer+mu")
+		assert.Equal(t, []uint{mug.ID}, ids(resp), "Words should match as prefixes")
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		resp, _ := getProducts(t, "tags=kitchen")
+		assert.ElementsMatch(t, []uint{mug.ID, plate.ID}, ids(resp))
+
+		resp, _ = getProducts(t, "tags=gift,KITCHEN")
+		assert.Equal(t, []uint{mug.ID}, ids(resp))
+	})
+
+	t.Run("Ranges", func(t *testing.T) {
+		resp, _ := getProducts(t, "minPrice=10&maxPrice=20")
+		assert.Equal(t, []uint{mug.ID}, ids(resp))
+
+		resp, _ = getProducts(t, "minCount=1")
+		assert.ElementsMatch(t, []uint{mug.ID, scarf.ID}, ids(resp))
+	})
+
+	t.Run("SortAndCursor", func(t *testing.T) {
+		resp, rr := getProducts(t, "sort=price&order=desc&limit=2")
+		assert.Equal(t, []uint{scarf.ID, mug.ID}, ids(resp))
+		cursor := rr.Header().Get("X-Next-Cursor")
+		require.NotEmpty(t, cursor)
+
+		resp, rr = getProducts(t, "sort=price&order=desc&limit=2&cursor="+url.QueryEscape(cursor))
+		assert.Equal(t, []uint{plate.ID}, ids(resp))
+		assert.Empty(t, rr.Header().Get("X-Next-Cursor"), "Last page should have no cursor")
+	})
+
+	t.Run("InvalidParam", func(t *testing.T) {
+		req := createAuthenticatedRequest(t, user, "GET", "/api/get_products?sort=colour", nil)
+		rr := httptest.NewRecorder()
+		GetProducts(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,69 @@
+package prodtable
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrSKUNotFound is returned by FindSKU when none of the seller's products or variants has the SKU.
+var ErrSKUNotFound = errors.New("no product or variant has this SKU")
+
+// maxSKULength bounds product and variant SKUs.
+const maxSKULength = 100
+
+// productSKUIndex keeps product SKUs unique per seller; products without a SKU are exempt.
+const productSKUIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_products_user_sku ON products (user_id, sku) WHERE sku <> ''`
+
+// normalizeSKU trims a SKU and checks its length. An empty SKU is allowed and means none.
+func normalizeSKU(sku string) (string, error) {
+	sku = strings.TrimSpace(sku)
+	if len(sku) > maxSKULength {
+		return "", fmt.Errorf("sku must be at most %d characters", maxSKULength)
+	}
+	return sku, nil
+}
+
+// skuInUse reports whether any of the seller's products other than productID, or any variant
+// other than variantID, has the SKU. Products and variants share one SKU namespace per seller.
+func skuInUse(tx *gorm.DB, userID uint, sku string, productID, variantID uint) (bool, error) {
+	var count int64
+	if err := tx.Model(&Product{}).Where("user_id = ? AND sku = ? AND id <> ?", userID, sku, productID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := tx.Model(&ProductVariant{}).Where("user_id = ? AND sku = ? AND id <> ?", userID, sku, variantID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FindSKU returns the product the seller sells under a SKU, and the variant when the SKU is a
+// variant's (0 otherwise), such as to match the lines of a marketplace order.
+func FindSKU(tx *gorm.DB, userID uint, sku string) (productID, variantID uint, err error) {
+	sku = strings.TrimSpace(sku)
+	if sku == "" {
+		return 0, 0, fmt.Errorf("%w: the SKU is empty", ErrSKUNotFound)
+	}
+	var variant ProductVariant
+	err = tx.Where("user_id = ? AND sku = ?", userID, sku).Take(&variant).Error
+	if err == nil {
+		return variant.ProductID, variant.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, err
+	}
+	var product Product
+	err = tx.Select("id").Where("user_id = ? AND sku = ?", userID, sku).Take(&product).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, fmt.Errorf("%w (%q)", ErrSKUNotFound, sku)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return product.ID, 0, nil
+}
@@ -0,0 +1,89 @@
+// internal/prodtable/sku_test.go
+package prodtable
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProductSKUs tests setting product SKUs, their uniqueness alongside variant SKUs, and finding products by SKU.
+func TestProductSKUs(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "sku@example.com", "password")
+	other := createTestUser(t, "skuother@example.com", "password")
+
+	addProduct := func(t *testing.T, name, sku string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		AddProduct(rr, createImagesRequest(t, user, "POST", "/api/add_product", map[string]string{
+			"productName": name, "description": name, "price": "5", "count": "3", "sku": sku,
+		}, "sku.png"))
+		return rr
+	}
+	findProduct := func(t *testing.T, name string) Product {
+		t.Helper()
+		var product Product
+		require.NoError(t, testDB.Where("user_id = ? AND prod_name = ?", user.ID, name).First(&product).Error)
+		return product
+	}
+
+	rr := addProduct(t, "Mug", " MUG-1 ")
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	mug := findProduct(t, "Mug")
+	assert.Equal(t, "MUG-1", mug.SKU)
+	require.Equal(t, http.StatusCreated, addProduct(t, "Plate", "").Code, "SKUs are optional")
+
+	tee := Product{UserID: user.ID, ProdName: "Tee", PriceMinor: 1500, Currency: "USD"}
+	require.NoError(t, createProduct(testDB, &tee, []string{"tee.png"}, nil, StockChange{Reason: StockReasonInitial}))
+	variant := ProductVariant{ProductID: tee.ID, UserID: user.ID, SKU: "TEE-M", Options: map[string]string{"Size": "M"}, OptionsKey: "size=m", Title: "M"}
+	require.NoError(t, testDB.Create(&variant).Error)
+
+	t.Run("UniquePerSeller", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, addProduct(t, "Mug 2", "MUG-1").Code)
+		assert.Equal(t, http.StatusConflict, addProduct(t, "Tee 2", "TEE-M").Code, "Products and variants share SKUs")
+
+		rr := httptest.NewRecorder()
+		AddProduct(rr, createImagesRequest(t, other, "POST", "/api/add_product", map[string]string{
+			"productName": "Mug", "description": "Mug", "price": "5", "count": "3", "sku": "MUG-1",
+		}, "sku.png"))
+		assert.Equal(t, http.StatusCreated, rr.Code, "Other sellers may use the same SKU")
+	})
+
+	t.Run("FindSKU", func(t *testing.T) {
+		productID, variantID, err := FindSKU(testDB, user.ID, "MUG-1")
+		require.NoError(t, err)
+		assert.Equal(t, mug.ID, productID)
+		assert.Zero(t, variantID)
+
+		productID, variantID, err = FindSKU(testDB, user.ID, "TEE-M")
+		require.NoError(t, err)
+		assert.Equal(t, tee.ID, productID)
+		assert.Equal(t, variant.ID, variantID)
+
+		_, _, err = FindSKU(testDB, user.ID, "NOPE")
+		assert.ErrorIs(t, err, ErrSKUNotFound)
+		_, _, err = FindSKU(testDB, user.ID, "")
+		assert.ErrorIs(t, err, ErrSKUNotFound)
+	})
+
+	t.Run("UpdateSKU", func(t *testing.T) {
+		plate := findProduct(t, "Plate")
+		update := func(t *testing.T, productID uint, sku string) int {
+			t.Helper()
+			rr := httptest.NewRecorder()
+			UpdateProduct(rr, createImagesRequest(t, user, "PUT", fmt.Sprintf("/api/update_product?id=%d", productID), map[string]string{"sku": sku}))
+			return rr.Code
+		}
+		assert.Equal(t, http.StatusConflict, update(t, plate.ID, "MUG-1"))
+		assert.Equal(t, http.StatusOK, update(t, mug.ID, "MUG-1"), "A product keeps its own SKU")
+		assert.Equal(t, http.StatusOK, update(t, mug.ID, ""))
+		assert.Empty(t, findProduct(t, "Mug").SKU, "An empty SKU removes it")
+		assert.Equal(t, http.StatusOK, update(t, plate.ID, "MUG-1"))
+		assert.Equal(t, "MUG-1", findProduct(t, "Plate").SKU)
+	})
+}
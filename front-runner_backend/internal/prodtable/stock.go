@@ -0,0 +1,595 @@
+package prodtable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/oauth"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInsufficientStock is returned by TakeStock and AdjustStock when fewer units are available than requested.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+var errInvalidStockReason = errors.New("invalid stock reason")
+
+// Reasons recorded in the stock ledger.
+const (
+	StockReasonInitial            = "initial"             // Stock a product or variant was created with, or its opening balance
+	StockReasonSale               = "sale"                // Sold in an order
+	StockReasonCancellation       = "cancellation"        // Returned by cancelling order items
+	StockReasonReservation        = "reservation"         // Held for a checkout
+	StockReasonReservationRelease = "reservation_release" // Returned when a reservation is released or expires
+	StockReasonRestock            = "restock"             // New stock received
+	StockReasonAdjustment         = "adjustment"          // Set or corrected by the seller
+	StockReasonSync               = "sync"                // Set from a marketplace's inventory
+	StockReasonReconciliation     = "reconciliation"      // Brings the ledger back in line with the stock counts
+)
+
+// Page size limits for GetStockHistory.
+const (
+	defaultStockHistoryLimit = 50
+	maxStockHistoryLimit     = 200
+)
+
+// StockMovement is an entry in the append-only stock ledger. A product's movements add up to its
+// ProdCount, and a variant's movements to the variant's Count.
+type StockMovement struct {
+	ID        uint      `gorm:"primaryKey"`
+	ProductID uint      `gorm:"not null;index"`
+	VariantID uint      `gorm:"not null;default:0"` // Variant whose stock changed, 0 for the product's own stock
+	Delta     int64     `gorm:"not null"`           // Units added, negative for units removed
+	Balance   int64     `gorm:"not null"`           // The product's total stock after the movement
+	Reason    string    `gorm:"not null"`           // One of the StockReason constants
+	Reference string    // What caused the movement, e.g. "order:12"
+	UserID    uint      `gorm:"not null;default:0"` // User who made the change, 0 for automatic changes
+	Note      string    // Free text, such as a cancellation reason
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// StockChange describes why stock is changed, for the ledger.
+type StockChange struct {
+	Reason    string
+	Reference string
+	UserID    uint
+	Note      string
+}
+
+// StockMovementReturn is a ledger entry returned to the frontend.
+type StockMovementReturn struct {
+	ID        uint   `json:"id"`
+	VariantID uint   `json:"variantID,omitempty"`
+	Delta     int64  `json:"delta"`
+	Balance   int64  `json:"balance"` // The product's stock after the movement
+	Reason    string `json:"reason"`
+	Reference string `json:"reference,omitempty"`
+	UserID    uint   `json:"userID,omitempty"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// StockHistoryReturn is a page of a product's stock history, newest first.
+type StockHistoryReturn struct {
+	ProductID     uint                  `json:"productID"`
+	Stock         uint                  `json:"stock"`         // Current stock
+	LedgerBalance int64                 `json:"ledgerBalance"` // Sum of every movement
+	InSync        bool                  `json:"inSync"`        // Whether the product's and variants' stock match the ledger
+	Movements     []StockMovementReturn `json:"movements"`
+	NextBefore    uint                  `json:"nextBefore,omitempty"` // Pass as before to fetch the next page
+}
+
+// StockAdjustmentPayload is used to decode the JSON body of AdjustProductStock.
+type StockAdjustmentPayload struct {
+	VariantID uint   `json:"variantID"` // Required for products with variants
+	Delta     int64  `json:"delta"`     // Units to add, or remove if negative
+	Reason    string `json:"reason"`    // "restock" or "adjustment"
+	Note      string `json:"note"`
+}
+
+func setStockMovementReturn(m StockMovement) StockMovementReturn {
+	return StockMovementReturn{
+		ID:        m.ID,
+		VariantID: m.VariantID,
+		Delta:     m.Delta,
+		Balance:   m.Balance,
+		Reason:    m.Reason,
+		Reference: m.Reference,
+		UserID:    m.UserID,
+		Note:      m.Note,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// Every stock change locks the product row first, then the variant row if any. Holding the
+// product lock serialises changes to its variants too, since their total is the product's stock.
+// Callers changing several products should do so in ascending product ID order to avoid deadlocks.
+
+// lockStock row-locks a product and, for variantID != 0, one of its variants.
+func lockStock(tx *gorm.DB, productID, variantID uint) (*Product, *ProductVariant, error) {
+	var product Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+		return nil, nil, err
+	}
+	if variantID == 0 {
+		return &product, nil, nil
+	}
+	var variant ProductVariant
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return &product, &variant, nil
+}
+
+// StockOutboxEntry marks the stock of a product, or of one of its variants, as changed since it was
+// last pushed to the seller's linked storefronts. It is written in the same transaction as the
+// change, and removed once the inventory sync has queued the pushes.
+type StockOutboxEntry struct {
+	ID        uint `gorm:"primaryKey"`
+	ProductID uint `gorm:"not null"`
+	VariantID uint `gorm:"not null;default:0"` // 0 for the product's own stock
+	CreatedAt time.Time
+}
+
+// stockOutboxIndex keeps one entry per product or variant, which covers every change made before
+// it is handled.
+const stockOutboxIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_outbox_entries_stock ON stock_outbox_entries (product_id, variant_id)`
+
+// queueStockPush adds outbox entries for stock to be pushed to the seller's linked storefronts. An
+// entry already queued is updated rather than skipped: the sync locks entries until it removes them,
+// so the update waits for the sync to finish and then queues the entry again, and the change is pushed
+// by a later pass. Skipping it (DO NOTHING) would not wait, and the change could be lost.
+func queueStockPush(tx *gorm.DB, entries []StockOutboxEntry) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"created_at"}),
+	}).Create(&entries).Error
+}
+
+// recordMovement appends a movement of delta units to the ledger, and queues the new stock to be
+// pushed to the seller's linked storefronts.
+func recordMovement(tx *gorm.DB, productID, variantID uint, delta int64, balance uint, change StockChange) error {
+	if err := queueStockPush(tx, []StockOutboxEntry{{ProductID: productID, VariantID: variantID}}); err != nil {
+		return err
+	}
+	return tx.Create(&StockMovement{
+		ProductID: productID,
+		VariantID: variantID,
+		Delta:     delta,
+		Balance:   int64(balance),
+		Reason:    change.Reason,
+		Reference: change.Reference,
+		UserID:    change.UserID,
+		Note:      change.Note,
+	}).Error
+}
+
+// setStock sets the stock of a locked product, or of one of its variants, and records the change.
+func setStock(tx *gorm.DB, product *Product, variant *ProductVariant, count uint, change StockChange) error {
+	if variant == nil {
+		delta := int64(count) - int64(product.ProdCount)
+		if delta == 0 {
+			return nil
+		}
+		if err := tx.Model(product).Update("prod_count", count).Error; err != nil {
+			return err
+		}
+		product.ProdCount = count
+		return recordMovement(tx, product.ID, 0, delta, count, change)
+	}
+
+	delta := int64(count) - int64(variant.Count)
+	if delta == 0 {
+		return nil
+	}
+	if err := tx.Model(variant).Update("count", count).Error; err != nil {
+		return err
+	}
+	variant.Count = count
+	if err := SyncVariantStock(tx, product.ID); err != nil {
+		return err
+	}
+	if err := tx.Model(&Product{}).Where("id = ?", product.ID).Select("prod_count").Scan(&product.ProdCount).Error; err != nil {
+		return err
+	}
+	return recordMovement(tx, product.ID, variant.ID, delta, product.ProdCount, change)
+}
+
+// AdjustStock adds delta units to the stock of a product, or of one of its variants, failing with
+// ErrInsufficientStock if that would leave less than none. It must run inside a transaction.
+func AdjustStock(tx *gorm.DB, productID, variantID uint, delta int64, change StockChange) error {
+	product, variant, err := lockStock(tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+	current := int64(product.ProdCount)
+	if variant != nil {
+		current = int64(variant.Count)
+	}
+	if current+delta < 0 {
+		if variant != nil {
+			return fmt.Errorf("%w for variant ID %d (requested: %d, available: %d)", ErrInsufficientStock, variantID, -delta, current)
+		}
+		return fmt.Errorf("%w for product ID %d (requested: %d, available: %d)", ErrInsufficientStock, productID, -delta, current)
+	}
+	return setStock(tx, product, variant, uint(current+delta), change)
+}
+
+// TakeStock removes count units of a product, or of one of its variants, from stock.
+// It must run inside a transaction.
+func TakeStock(tx *gorm.DB, productID, variantID uint, count uint, change StockChange) error {
+	return AdjustStock(tx, productID, variantID, -int64(count), change)
+}
+
+// ReturnStock puts count units of a product, or of one of its variants, back into stock.
+// Stock of products or variants deleted in the meantime is dropped. It must run inside a transaction.
+func ReturnStock(tx *gorm.DB, productID, variantID uint, count uint, change StockChange) error {
+	err := AdjustStock(tx, productID, variantID, int64(count), change)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// SetStock sets the stock of a product, or of one of its variants, such as to a count from a
+// marketplace. It must run inside a transaction.
+func SetStock(tx *gorm.DB, productID, variantID uint, count uint, change StockChange) error {
+	product, variant, err := lockStock(tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+	return setStock(tx, product, variant, count, change)
+}
+
+// addVariantStock records the stock of a variant just created with none. A product's own stock
+// is dropped when it gets its first variant, since its stock becomes the total of its variants'.
+func addVariantStock(tx *gorm.DB, product *Product, variant *ProductVariant, count uint, userID uint) error {
+	if err := SyncVariantStock(tx, product.ID); err != nil {
+		return err
+	}
+	var total uint
+	if err := tx.Model(&Product{}).Where("id = ?", product.ID).Select("prod_count").Scan(&total).Error; err != nil {
+		return err
+	}
+	if total != product.ProdCount {
+		change := StockChange{Reason: StockReasonAdjustment, UserID: userID, Note: "Replaced by the stock of the product's variants"}
+		if err := recordMovement(tx, product.ID, 0, int64(total)-int64(product.ProdCount), total, change); err != nil {
+			return err
+		}
+		product.ProdCount = total
+	}
+	return setStock(tx, product, variant, count, StockChange{Reason: StockReasonInitial, UserID: userID})
+}
+
+// stockDrift returns the movements that would bring a locked product's ledger in line with its
+// stock: one per variant whose movements do not add up to its count, and one for the product's
+// own stock if the total still differs.
+func stockDrift(tx *gorm.DB, product *Product) ([]StockMovement, error) {
+	var sums []struct {
+		VariantID uint
+		Total     int64
+	}
+	if err := tx.Model(&StockMovement{}).Select("variant_id, SUM(delta)::bigint AS total").Where("product_id = ?", product.ID).
+		Group("variant_id").Scan(&sums).Error; err != nil {
+		return nil, err
+	}
+	ledger := make(map[uint]int64, len(sums))
+	var balance int64
+	for _, s := range sums {
+		ledger[s.VariantID] = s.Total
+		balance += s.Total
+	}
+
+	var variants []ProductVariant
+	if err := tx.Where("product_id = ?", product.ID).Order("id").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	var drift []StockMovement
+	for _, v := range variants {
+		if delta := int64(v.Count) - ledger[v.ID]; delta != 0 {
+			balance += delta
+			drift = append(drift, StockMovement{ProductID: product.ID, VariantID: v.ID, Delta: delta, Balance: balance})
+		}
+	}
+	if delta := int64(product.ProdCount) - balance; delta != 0 {
+		drift = append(drift, StockMovement{ProductID: product.ID, Delta: delta, Balance: int64(product.ProdCount)})
+	}
+	return drift, nil
+}
+
+// ReconcileStock records reconciliation movements for any stock changed without going through the
+// ledger, such as by hand in the database, and returns them. It must run inside a transaction.
+func ReconcileStock(tx *gorm.DB, productID, userID uint) ([]StockMovement, error) {
+	product, _, err := lockStock(tx, productID, 0)
+	if err != nil {
+		return nil, err
+	}
+	drift, err := stockDrift(tx, product)
+	if err != nil {
+		return nil, err
+	}
+	for i := range drift {
+		drift[i].Reason, drift[i].UserID = StockReasonReconciliation, userID
+		if err := tx.Create(&drift[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+	return drift, nil
+}
+
+// migrateOpeningBalances gives products with stock but no ledger entries, such as those created
+// before the ledger existed, an opening balance movement for their current stock.
+func migrateOpeningBalances(tx *gorm.DB) error {
+	if err := tx.Exec(`
+		INSERT INTO stock_movements (product_id, variant_id, delta, balance, reason, note, created_at)
+		SELECT p.id, 0, p.prod_count, p.prod_count, ?, 'Opening balance', NOW()
+		FROM products p
+		WHERE p.prod_count > 0
+		  AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+		  AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.product_id = p.id)`, StockReasonInitial).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`
+		INSERT INTO stock_movements (product_id, variant_id, delta, balance, reason, note, created_at)
+		SELECT v.product_id, v.id, v.count, SUM(v.count) OVER (PARTITION BY v.product_id ORDER BY v.id), ?, 'Opening balance', NOW()
+		FROM product_variants v
+		WHERE v.count > 0
+		  AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.product_id = v.product_id)`, StockReasonInitial).Error
+}
+
+// writeStockError maps the errors of the stock endpoints to HTTP responses.
+func writeStockError(w http.ResponseWriter, handler string, productID uint64, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Product or variant not found", http.StatusNotFound)
+	case errors.Is(err, errProductNotOwned):
+		http.Error(w, "Permission denied: You do not own this product", http.StatusForbidden)
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, errInvalidStockReason), errors.Is(err, errStockOnVariants):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: Error with stock of product %d: %v", handler, productID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// GetStockHistory lists the stock ledger of a product.
+//
+// @Summary      Get a product's stock history
+// @Description  Lists the movements of a product's stock, newest first, with why each happened: sales, cancellations, reservations, restocks, manual adjustments, marketplace syncs and reconciliations. Also reports whether the current stock matches the ledger.
+// @Tags         Products
+// @Produce      application/json
+// @Param        id         query  int  true   "Product ID" Format(uint64)
+// @Param        variantID  query  int  false  "Only movements of this variant" Format(uint64)
+// @Param        limit      query  int  false  "Movements per page (default 50, max 200)"
+// @Param        before     query  int  false  "Only movements older than this movement ID, from nextBefore"
+// @Success      200  {object}  StockHistoryReturn "A page of the product's stock history"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID or paging parameters"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_stock_history [get]
+func GetStockHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetStockHistory: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	productID, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var variantID, before uint64
+	if s := q.Get("variantID"); s != "" {
+		if variantID, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, "Invalid variantID", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("before"); s != "" {
+		if before, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultStockHistoryLimit
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxStockHistoryLimit)
+	}
+
+	var product Product
+	if err := db.First(&product, productID).Error; err != nil {
+		writeStockError(w, "GetStockHistory", productID, err)
+		return
+	}
+	if product.UserID != user.ID {
+		writeStockError(w, "GetStockHistory", productID, errProductNotOwned)
+		return
+	}
+
+	ret := StockHistoryReturn{ProductID: product.ID, Stock: product.ProdCount, Movements: []StockMovementReturn{}}
+	if err := db.Model(&StockMovement{}).Select("COALESCE(SUM(delta), 0)::bigint").Where("product_id = ?", product.ID).
+		Scan(&ret.LedgerBalance).Error; err != nil {
+		writeStockError(w, "GetStockHistory", productID, err)
+		return
+	}
+	drift, err := stockDrift(db, &product)
+	if err != nil {
+		writeStockError(w, "GetStockHistory", productID, err)
+		return
+	}
+	ret.InSync = len(drift) == 0
+
+	query := db.Where("product_id = ?", product.ID)
+	if variantID != 0 {
+		query = query.Where("variant_id = ?", variantID)
+	}
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+	var movements []StockMovement
+	// One extra row tells whether another page follows
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&movements).Error; err != nil {
+		writeStockError(w, "GetStockHistory", productID, err)
+		return
+	}
+	if len(movements) > limit {
+		movements = movements[:limit]
+		ret.NextBefore = movements[limit-1].ID
+	}
+	for _, m := range movements {
+		ret.Movements = append(ret.Movements, setStockMovementReturn(m))
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// AdjustProductStock records a restock or manual correction of a product's stock.
+//
+// @Summary      Adjust a product's stock
+// @Description  Adds units to (or, with a negative delta, removes them from) a product's stock, or its variant's, recording the reason in the stock ledger.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id          query  int                     true  "Product ID" Format(uint64)
+// @Param        adjustment  body   StockAdjustmentPayload  true  "Units to add or remove, and why"
+// @Success      200  {object}  StockMovementReturn "The recorded movement"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID, reason, or not enough stock to remove"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product or variant not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/adjust_stock [post]
+func AdjustProductStock(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("AdjustProductStock: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var payload StockAdjustmentPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if payload.Reason != StockReasonRestock && payload.Reason != StockReasonAdjustment {
+		http.Error(w, fmt.Sprintf("%v: must be %q or %q", errInvalidStockReason, StockReasonRestock, StockReasonAdjustment), http.StatusBadRequest)
+		return
+	}
+	if payload.Delta == 0 {
+		http.Error(w, "delta must not be zero", http.StatusBadRequest)
+		return
+	}
+
+	var movement StockMovement
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		if payload.VariantID == 0 {
+			if withVariants, err := hasVariants(tx, uint(productID)); err != nil || withVariants {
+				if err != nil {
+					return err
+				}
+				return errStockOnVariants
+			}
+		}
+		change := StockChange{Reason: payload.Reason, UserID: user.ID, Note: strings.TrimSpace(payload.Note)}
+		if err := AdjustStock(tx, uint(productID), payload.VariantID, payload.Delta, change); err != nil {
+			return err
+		}
+		return tx.Where("product_id = ?", productID).Order("id DESC").First(&movement).Error
+	})
+	if err != nil {
+		writeStockError(w, "AdjustProductStock", productID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, setStockMovementReturn(movement))
+}
+
+// ReconcileProductStock brings a product's stock ledger in line with its current stock.
+//
+// @Summary      Reconcile a product's stock ledger
+// @Description  Records a reconciliation movement for every variant, and the product itself, whose stock does not match the sum of its ledger movements, e.g. after stock was changed directly in the database. The current stock counts are kept.
+// @Tags         Products
+// @Produce      application/json
+// @Param        id   query  int  true  "Product ID" Format(uint64)
+// @Success      200  {array}   StockMovementReturn "The reconciliation movements recorded; empty if the ledger already matched"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/reconcile_stock [post]
+func ReconcileProductStock(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ReconcileProductStock: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+
+	var recorded []StockMovement
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockOwnedProduct(tx, uint(productID), user.ID); err != nil {
+			return err
+		}
+		var err error
+		recorded, err = ReconcileStock(tx, uint(productID), user.ID)
+		return err
+	})
+	if err != nil {
+		writeStockError(w, "ReconcileProductStock", productID, err)
+		return
+	}
+
+	ret := make([]StockMovementReturn, 0, len(recorded))
+	for _, m := range recorded {
+		ret = append(ret, setStockMovementReturn(m))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
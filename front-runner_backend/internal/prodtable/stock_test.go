@@ -0,0 +1,135 @@
+// internal/prodtable/stock_test.go
+package prodtable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStockLedger tests that stock changes are recorded in the ledger, listed as history and reconciled.
+func TestStockLedger(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "ledger@example.com", "password")
+	other := createTestUser(t, "ledgerother@example.com", "password")
+
+	rr := httptest.NewRecorder()
+	AddProduct(rr, createImagesRequest(t, user, "POST", "/api/add_product", map[string]string{
+		"productName": "Mug",
+		"description": "Stoneware mug",
+		"price":       "9",
+		"count":       "5",
+	}, "mug.png"))
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	var product Product
+	require.NoError(t, testDB.Where("user_id = ? AND prod_name = ?", user.ID, "Mug").First(&product).Error)
+
+	history := func(t *testing.T, query string) StockHistoryReturn {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetStockHistory(rr, createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_stock_history?id=%d%s", product.ID, query), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp StockHistoryReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+	adjust := func(t *testing.T, payload StockAdjustmentPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		rr := httptest.NewRecorder()
+		AdjustProductStock(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/adjust_stock?id=%d", product.ID), bytes.NewReader(body)))
+		return rr
+	}
+
+	t.Run("ChangesAreRecorded", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UpdateProduct(rr, createImagesRequest(t, user, "PUT", fmt.Sprintf("/api/update_product?id=%d", product.ID), map[string]string{"count": "8"}))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		rr = adjust(t, StockAdjustmentPayload{Delta: 4, Reason: StockReasonRestock, Note: "Delivery"})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var movement StockMovementReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &movement))
+		assert.Equal(t, int64(12), movement.Balance)
+
+		tx := testDB.Begin()
+		require.NoError(t, TakeStock(tx, product.ID, 0, 2, StockChange{Reason: StockReasonSale, Reference: "order:1"}))
+		require.NoError(t, tx.Commit().Error)
+
+		resp := history(t, "")
+		assert.Equal(t, uint(10), resp.Stock)
+		assert.Equal(t, int64(10), resp.LedgerBalance)
+		assert.True(t, resp.InSync)
+		require.Len(t, resp.Movements, 4)
+		var reasons []string
+		for _, m := range resp.Movements {
+			reasons = append(reasons, m.Reason)
+		}
+		assert.Equal(t, []string{StockReasonSale, StockReasonRestock, StockReasonAdjustment, StockReasonInitial}, reasons, "Newest first")
+		assert.Equal(t, int64(3), resp.Movements[2].Delta)
+		assert.Equal(t, "order:1", resp.Movements[0].Reference)
+
+		page := history(t, "&limit=3")
+		assert.Len(t, page.Movements, 3)
+		next := history(t, fmt.Sprintf("&limit=3&before=%d", page.NextBefore))
+		require.Len(t, next.Movements, 1)
+		assert.Equal(t, StockReasonInitial, next.Movements[0].Reason)
+	})
+
+	t.Run("InvalidAdjustments", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, adjust(t, StockAdjustmentPayload{Delta: -11, Reason: StockReasonAdjustment}).Code, "Stock cannot go below zero")
+		assert.Equal(t, http.StatusBadRequest, adjust(t, StockAdjustmentPayload{Delta: 1, Reason: StockReasonSale}).Code, "Sales are recorded by orders")
+
+		rr := httptest.NewRecorder()
+		GetStockHistory(rr, createAuthenticatedRequest(t, other, "GET", fmt.Sprintf("/api/get_stock_history?id=%d", product.ID), nil))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Reconcile", func(t *testing.T) {
+		require.NoError(t, testDB.Model(&Product{}).Where("id = ?", product.ID).Update("prod_count", 13).Error)
+		assert.False(t, history(t, "").InSync)
+
+		rr := httptest.NewRecorder()
+		ReconcileProductStock(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/reconcile_stock?id=%d", product.ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var recorded []StockMovementReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &recorded))
+		require.Len(t, recorded, 1)
+		assert.Equal(t, int64(3), recorded[0].Delta)
+		assert.Equal(t, StockReasonReconciliation, recorded[0].Reason)
+
+		resp := history(t, "")
+		assert.True(t, resp.InSync)
+		assert.Equal(t, int64(13), resp.LedgerBalance)
+	})
+
+	t.Run("Variants", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		options, _ := json.Marshal([]ProductOptionPayload{{Name: "Colour", Values: []string{"White", "Black"}}})
+		SetProductOptions(rr, createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/set_product_options?id=%d", product.ID), bytes.NewReader(options)))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		rr = httptest.NewRecorder()
+		AddProductVariant(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/add_product_variant?id=%d", product.ID),
+			bytes.NewReader([]byte(`{"sku": "MUG-W", "options": {"Colour": "White"}, "count": 6}`))))
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var variant ProductVariantReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &variant))
+
+		rr = adjust(t, StockAdjustmentPayload{Delta: 1, Reason: StockReasonRestock})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Products with variants are restocked by variant")
+		rr = adjust(t, StockAdjustmentPayload{VariantID: variant.ID, Delta: -2, Reason: StockReasonAdjustment, Note: "Broken"})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		resp := history(t, "")
+		assert.Equal(t, uint(4), resp.Stock)
+		assert.True(t, resp.InSync, "The product's own stock is written off when it gets variants")
+		assert.Len(t, history(t, fmt.Sprintf("&variantID=%d", variant.ID)).Movements, 2)
+	})
+}
@@ -0,0 +1,406 @@
+package prodtable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"front-runner/internal/oauth"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tag is a user-defined label that can be applied to any number of the user's products.
+type Tag struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"not null;index:idx_user_tag,unique"`
+	Name   string `gorm:"not null;index:idx_user_tag,unique"` // Normalised: trimmed and lowercase
+}
+
+// TagReturn is returned to the frontend for each tag, with the number of products using it.
+type TagReturn struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"` // Number of products with this tag
+}
+
+// TagRenamePayload is used to decode the JSON body when renaming a tag.
+type TagRenamePayload struct {
+	Name string `json:"name"` // New tag name; merges into an existing tag with this name
+}
+
+// ApplyTagsPayload is used to decode the JSON body when bulk-applying tags.
+type ApplyTagsPayload struct {
+	ProductIDs []uint   `json:"productIDs"` // Products to change; all must belong to the user
+	Add        []string `json:"add"`        // Tags to add (created if they don't exist yet)
+	Remove     []string `json:"remove"`     // Tags to remove
+}
+
+// parseTagNames splits a comma-separated tag string into normalised, de-duplicated tag names.
+func parseTagNames(s string) []string {
+	return normalizeTagNames(strings.Split(s, ","))
+}
+
+// normalizeTagNames trims and lowercases tag names, dropping blanks and duplicates.
+func normalizeTagNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
+
+// joinTagNames returns the product's tag names as a sorted comma-separated string.
+func joinTagNames(tags []Tag) string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// findOrCreateTags returns the user's tags with the given normalised names, creating any that are missing.
+func findOrCreateTags(tx *gorm.DB, userID uint, names []string) ([]Tag, error) {
+	if len(names) == 0 {
+		return []Tag{}, nil
+	}
+	newTags := make([]Tag, len(names))
+	for i, name := range names {
+		newTags[i] = Tag{UserID: userID, Name: name}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newTags).Error; err != nil {
+		return nil, fmt.Errorf("failed to create tags: %w", err)
+	}
+	var tags []Tag
+	if err := tx.Where("user_id = ? AND name IN ?", userID, names).Find(&tags).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch tags: %w", err)
+	}
+	return tags, nil
+}
+
+// setProductTags replaces the product's tags with the given normalised names.
+func setProductTags(tx *gorm.DB, product *Product, names []string) error {
+	tags, err := findOrCreateTags(tx, product.UserID, names)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(product).Association("Tags").Replace(tags); err != nil {
+		return fmt.Errorf("failed to update product tags: %w", err)
+	}
+	product.Tags = tags
+	return nil
+}
+
+// migrateLegacyTags moves the old comma-separated products.prod_tags column into the
+// tags tables and drops the column. It runs once, while the column still exists.
+func migrateLegacyTags(tx *gorm.DB) error {
+	var rows []struct {
+		ID       uint
+		UserID   uint
+		ProdTags string
+	}
+	if err := tx.Table("products").Select("id, user_id, prod_tags").
+		Where("COALESCE(prod_tags, '') <> ''").Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to read legacy tags: %w", err)
+	}
+	for _, row := range rows {
+		tags, err := findOrCreateTags(tx, row.UserID, parseTagNames(row.ProdTags))
+		if err != nil {
+			return err
+		}
+		links := make([]map[string]interface{}, len(tags))
+		for i, tag := range tags {
+			links[i] = map[string]interface{}{"product_id": row.ID, "tag_id": tag.ID}
+		}
+		if len(links) > 0 {
+			if err := tx.Table("product_tags").Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+				return fmt.Errorf("failed to link legacy tags for product %d: %w", row.ID, err)
+			}
+		}
+	}
+	if err := tx.Migrator().DropColumn(&Product{}, "prod_tags"); err != nil {
+		return fmt.Errorf("failed to drop legacy prod_tags column: %w", err)
+	}
+	log.Printf("Migrated legacy tags for %d products", len(rows))
+	return nil
+}
+
+// GetTags lists the logged-in user's tags with how many products use each.
+//
+// @Summary      List tags
+// @Description  Lists all tags created by the authenticated user, sorted by name, with the number of products using each tag.
+// @Tags         Products
+// @Produce      application/json
+// @Success      200  {array}   TagReturn "The user's tags"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_tags [get]
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetTags: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tags := make([]TagReturn, 0)
+	if err := db.Table("tags t").
+		Select("t.id, t.name, COUNT(pt.product_id) AS count").
+		Joins("LEFT JOIN product_tags pt ON pt.tag_id = t.id").
+		Where("t.user_id = ?", user.ID).
+		Group("t.id, t.name").
+		Order("t.name").
+		Scan(&tags).Error; err != nil {
+		log.Printf("Error fetching tags for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		log.Printf("Error encoding tags for user %d to JSON: %v", user.ID, err)
+	}
+}
+
+// RenameTag renames one of the logged-in user's tags. If the user already has a tag with
+// the new name, the two are merged: products keep a single copy of the surviving tag.
+//
+// @Summary      Rename or merge a tag
+// @Description  Renames a tag owned by the authenticated user. If another of the user's tags already has the new name, the tags are merged into that one.
+// @Tags         Products
+// @Accept       json
+// @Produce      application/json
+// @Param        id    query  int               true "ID of the tag to rename" Format(uint64)
+// @Param        tag   body   TagRenamePayload  true "New tag name"
+// @Success      200  {object}  TagReturn "The renamed (or merged) tag"
+// @Failure      400  {string}  string "Bad Request: Invalid tag ID or name"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this tag"
+// @Failure      404  {string}  string "Not Found: Tag not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/rename_tag [put]
+func RenameTag(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("RenameTag: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tagID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Tag ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload TagRenamePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	names := normalizeTagNames([]string{payload.Name})
+	if len(names) == 0 || strings.Contains(names[0], ",") {
+		http.Error(w, "Tag name must be non-empty and may not contain commas", http.StatusBadRequest)
+		return
+	}
+	newName := names[0]
+
+	var tag Tag
+	if err := db.First(&tag, uint(tagID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error finding tag %d: %v", tagID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if tag.UserID != user.ID {
+		http.Error(w, "Permission denied: You do not own this tag", http.StatusForbidden)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if tag.Name == newName {
+			return nil
+		}
+		var existing Tag
+		err := tx.Where("user_id = ? AND name = ?", user.ID, newName).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Plain rename
+			tag.Name = newName
+			return tx.Model(&tag).Update("name", newName).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// Merge: move this tag's products onto the existing tag, then delete this one
+		if err := tx.Exec(`INSERT INTO product_tags (product_id, tag_id)
+			SELECT product_id, ? FROM product_tags WHERE tag_id = ?
+			ON CONFLICT DO NOTHING`, existing.ID, tag.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM product_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&tag).Error; err != nil {
+			return err
+		}
+		tag = existing
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error renaming tag %d for user %d: %v", tagID, user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ret := TagReturn{ID: tag.ID, Name: tag.Name}
+	if err := db.Table("product_tags").Where("tag_id = ?", tag.ID).Count(&ret.Count).Error; err != nil {
+		log.Printf("Error counting products for tag %d: %v", tag.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
+
+// ApplyTags adds and/or removes tags on a set of the logged-in user's products in one request.
+//
+// @Summary      Bulk-apply tags
+// @Description  Adds and/or removes tags on several products owned by the authenticated user. Tags that don't exist yet are created. Either every product is updated or none are.
+// @Tags         Products
+// @Accept       json
+// @Produce      application/json
+// @Param        tags  body  ApplyTagsPayload  true "Products and the tags to add or remove"
+// @Success      200  {object}  map[string]int "Number of products updated"
+// @Failure      400  {string}  string "Bad Request: Invalid body, no products, or no tags"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own one of the products"
+// @Failure      404  {string}  string "Not Found: One or more products not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/apply_tags [post]
+func ApplyTags(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("ApplyTags: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var payload ApplyTagsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	add := normalizeTagNames(payload.Add)
+	remove := normalizeTagNames(payload.Remove)
+	if len(payload.ProductIDs) == 0 {
+		http.Error(w, "At least one product ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		http.Error(w, "At least one tag to add or remove is required", http.StatusBadRequest)
+		return
+	}
+	for _, name := range add {
+		if strings.Contains(name, ",") {
+			http.Error(w, "Tag names may not contain commas", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// De-duplicate the product IDs and verify they all exist and belong to the user
+	productIDs := make([]uint, 0, len(payload.ProductIDs))
+	seen := make(map[uint]bool, len(payload.ProductIDs))
+	for _, id := range payload.ProductIDs {
+		if !seen[id] {
+			seen[id] = true
+			productIDs = append(productIDs, id)
+		}
+	}
+	var products []Product
+	if err := db.Select("id", "user_id").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		log.Printf("Error fetching products for tagging (user %d): %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(products) != len(productIDs) {
+		http.Error(w, "One or more products not found", http.StatusNotFound)
+		return
+	}
+	for _, product := range products {
+		if product.UserID != user.ID {
+			http.Error(w, fmt.Sprintf("Permission denied: You do not own product %d", product.ID), http.StatusForbidden)
+			return
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(add) > 0 {
+			tags, err := findOrCreateTags(tx, user.ID, add)
+			if err != nil {
+				return err
+			}
+			links := make([]map[string]interface{}, 0, len(productIDs)*len(tags))
+			for _, productID := range productIDs {
+				for _, tag := range tags {
+					links = append(links, map[string]interface{}{"product_id": productID, "tag_id": tag.ID})
+				}
+			}
+			if err := tx.Table("product_tags").Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+				return fmt.Errorf("failed to add tags: %w", err)
+			}
+		}
+		if len(remove) > 0 {
+			if err := tx.Exec(`DELETE FROM product_tags WHERE product_id IN ?
+				AND tag_id IN (SELECT id FROM tags WHERE user_id = ? AND name IN ?)`,
+				productIDs, user.ID, remove).Error; err != nil {
+				return fmt.Errorf("failed to remove tags: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error applying tags for user %d: %v", user.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"updatedProducts": len(productIDs)})
+}
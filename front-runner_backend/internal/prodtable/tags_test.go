@@ -0,0 +1,138 @@
+// internal/prodtable/tags_test.go
+package prodtable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"front-runner/internal/usertable"
+)
+
+func TestParseTagNames(t *testing.T) {
+	assert.Equal(t, []string{"red", "shoe"}, parseTagNames(" Red, shoe,,RED , "))
+	assert.Empty(t, parseTagNames(""))
+	assert.Equal(t, "a,b,c", joinTagNames([]Tag{{Name: "c"}, {Name: "a"}, {Name: "b"}}))
+}
+
+// TestTags tests the tag listing, renaming/merging and bulk-apply endpoints.
+func TestTags(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "tags@example.com", "password")
+	other := createTestUser(t, "tagsother@example.com", "password")
+
+	create := func(owner *usertable.User, name string, tags ...string) Product {
+		img := Image{URL: uuid.NewString() + ".png", UserID: owner.ID}
+		require.NoError(t, testDB.Create(&img).Error)
+		prod := Product{UserID: owner.ID, ProdName: name, ProdDescription: name, ImgID: img.ID}
+		require.NoError(t, testDB.Create(&prod).Error)
+		require.NoError(t, setProductTags(testDB, &prod, tags))
+		return prod
+	}
+	mug := create(user, "Mug", "kitchen", "gift")
+	plate := create(user, "Plate", "kitchen")
+	otherProd := create(other, "Other", "kitchen")
+
+	getTags := func(t *testing.T) map[string]TagReturn {
+		t.Helper()
+		req := createAuthenticatedRequest(t, user, "GET", "/api/get_tags", nil)
+		rr := httptest.NewRecorder()
+		GetTags(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp []TagReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		byName := make(map[string]TagReturn, len(resp))
+		for _, tag := range resp {
+			byName[tag.Name] = tag
+		}
+		return byName
+	}
+	tagsOf := func(t *testing.T, product Product) string {
+		t.Helper()
+		var p Product
+		require.NoError(t, testDB.Preload("Tags").First(&p, product.ID).Error)
+		return joinTagNames(p.Tags)
+	}
+	rename := func(t *testing.T, user *usertable.User, tagID uint, name string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(TagRenamePayload{Name: name})
+		req := createAuthenticatedRequest(t, user, "PUT", fmt.Sprintf("/api/rename_tag?id=%d", tagID), bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		RenameTag(rr, req)
+		return rr
+	}
+	apply := func(t *testing.T, payload ApplyTagsPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := createAuthenticatedRequest(t, user, "POST", "/api/apply_tags", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		ApplyTags(rr, req)
+		return rr
+	}
+
+	t.Run("ListWithCounts", func(t *testing.T) {
+		tags := getTags(t)
+		require.Len(t, tags, 2, "Only the user's own tags should be listed")
+		assert.Equal(t, int64(2), tags["kitchen"].Count)
+		assert.Equal(t, int64(1), tags["gift"].Count)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		rr := rename(t, user, getTags(t)["gift"].ID, " Presents ")
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, "kitchen,presents", tagsOf(t, mug))
+	})
+
+	t.Run("RenameMerges", func(t *testing.T) {
+		tags := getTags(t)
+		rr := rename(t, user, tags["presents"].ID, "kitchen")
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		var resp TagReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, tags["kitchen"].ID, resp.ID, "Should merge into the existing tag")
+		assert.Equal(t, int64(2), resp.Count)
+		assert.Equal(t, "kitchen", tagsOf(t, mug))
+		assert.NotContains(t, getTags(t), "presents")
+	})
+
+	t.Run("RenameForbidden", func(t *testing.T) {
+		var otherTag Tag
+		require.NoError(t, testDB.Where("user_id = ?", other.ID).First(&otherTag).Error)
+		rr := rename(t, user, otherTag.ID, "mine")
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("ApplyAddAndRemove", func(t *testing.T) {
+		rr := apply(t, ApplyTagsPayload{
+			ProductIDs: []uint{mug.ID, plate.ID, mug.ID},
+			Add:        []string{"Sale", "ceramic"},
+			Remove:     []string{"kitchen"},
+		})
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, "ceramic,sale", tagsOf(t, mug))
+		assert.Equal(t, "ceramic,sale", tagsOf(t, plate))
+		assert.Equal(t, "kitchen", tagsOf(t, otherProd), "Other user's product must be untouched")
+	})
+
+	t.Run("ApplyForbidden", func(t *testing.T) {
+		rr := apply(t, ApplyTagsPayload{ProductIDs: []uint{mug.ID, otherProd.ID}, Add: []string{"stolen"}})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, "ceramic,sale", tagsOf(t, mug), "Nothing should change when any product is rejected")
+	})
+
+	t.Run("ApplyInvalid", func(t *testing.T) {
+		rr := apply(t, ApplyTagsPayload{ProductIDs: []uint{mug.ID}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = apply(t, ApplyTagsPayload{ProductIDs: []uint{999999}, Add: []string{"x"}})
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
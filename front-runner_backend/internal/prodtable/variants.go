@@ -0,0 +1,679 @@
+package prodtable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"front-runner/internal/money"
+	"front-runner/internal/oauth"
+
+	"gorm.io/gorm"
+)
+
+var (
+	errNoOptions          = errors.New("define the product's options before adding variants")
+	errInvalidOptions     = errors.New("invalid options")
+	errInvalidVariant     = errors.New("invalid variant")
+	errVariantNotFound    = errors.New("variant not found")
+	errDuplicateVariant   = errors.New("a variant with these options already exists")
+	errDuplicateSKU       = errors.New("you already have a product or variant with this SKU")
+	errOptionsInUse       = errors.New("existing variants do not fit the new options; update or delete them first")
+	errStockOnVariants    = errors.New("stock of a product with variants is managed per variant")
+	errCurrencyOnVariants = errors.New("the currency of a product with variants cannot be changed")
+	errVariantImageOwner  = errors.New("variant image must be one of the product's images")
+)
+
+// ProductOption is one axis along which a product's variants differ, e.g. Size: S, M, L.
+type ProductOption struct {
+	ID        uint     `gorm:"primaryKey"`
+	ProductID uint     `gorm:"not null;index:idx_product_option,unique"`
+	Name      string   `gorm:"not null;index:idx_product_option,unique"`
+	Position  int      `gorm:"not null;default:0"` // Display order of the axes
+	Values    []string `gorm:"serializer:json;type:text;not null"`
+}
+
+// ProductVariant is a purchasable combination of option values with its own SKU, price and stock.
+// A product with variants keeps ProdCount equal to the sum of its variants' stock (see SyncVariantStock).
+type ProductVariant struct {
+	ID         uint              `gorm:"primaryKey"`
+	ProductID  uint              `gorm:"not null;index:idx_variant_options,unique"`
+	UserID     uint              `gorm:"not null;index:idx_variant_sku,unique"` // Seller; SKUs are unique per seller
+	SKU        string            `gorm:"not null;index:idx_variant_sku,unique"`
+	Options    map[string]string `gorm:"serializer:json;type:text;not null"`        // Option name -> value
+	OptionsKey string            `gorm:"not null;index:idx_variant_options,unique"` // Canonical form of Options
+	Title      string            `gorm:"not null"`                                  // e.g. "M / Red", in option order
+	PriceMinor int64             `gorm:"not null;default:0"`                        // Minor units of the product's currency
+	Count      uint              `gorm:"not null;default:0"`
+	ImageID    *uint             `gorm:"index"` // Optional image from the product's gallery
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ProductOptionPayload describes one option axis.
+type ProductOptionPayload struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariantPayload creates or updates a variant. Omitted fields keep their value on update.
+type ProductVariantPayload struct {
+	SKU     *string           `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   *json.Number      `json:"price"`   // Decimal in the product's currency; defaults to the product's price when creating
+	Count   *uint             `json:"count"`   // Stock; defaults to 0 when creating
+	ImageID *uint             `json:"imageID"` // 0 clears the variant's image
+}
+
+// ProductOptionReturn is an option axis as returned by the API.
+type ProductOptionReturn struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariantReturn is a variant as returned by the API.
+type ProductVariantReturn struct {
+	ID      uint              `json:"id"`
+	SKU     string            `json:"sku"`
+	Title   string            `json:"title"`
+	Options map[string]string `json:"options"`
+	Price   json.Number       `json:"price"` // Exact decimal in the product's currency
+	Count   uint              `json:"count"`
+	ImageID *uint             `json:"imageID,omitempty"`
+}
+
+// ProductVariantsReturn is the response of GetProductVariants.
+type ProductVariantsReturn struct {
+	Options  []ProductOptionReturn  `json:"options"`
+	Variants []ProductVariantReturn `json:"variants"`
+}
+
+// orderOptions sorts option axes into display order; used when preloading Product.Options.
+func orderOptions(tx *gorm.DB) *gorm.DB {
+	return tx.Order("position ASC, id ASC")
+}
+
+// orderVariants sorts variants by creation; used when preloading Product.Variants.
+func orderVariants(tx *gorm.DB) *gorm.DB {
+	return tx.Order("id ASC")
+}
+
+func setProductOptionsReturn(options []ProductOption) []ProductOptionReturn {
+	ret := make([]ProductOptionReturn, 0, len(options))
+	for _, o := range options {
+		ret = append(ret, ProductOptionReturn{Name: o.Name, Values: o.Values})
+	}
+	return ret
+}
+
+func setProductVariantReturn(currency string, v ProductVariant) ProductVariantReturn {
+	return ProductVariantReturn{
+		ID:      v.ID,
+		SKU:     v.SKU,
+		Title:   v.Title,
+		Options: v.Options,
+		Price:   money.Number(v.PriceMinor, currency),
+		Count:   v.Count,
+		ImageID: v.ImageID,
+	}
+}
+
+func setProductVariantsReturn(currency string, variants []ProductVariant) []ProductVariantReturn {
+	ret := make([]ProductVariantReturn, 0, len(variants))
+	for _, v := range variants {
+		ret = append(ret, setProductVariantReturn(currency, v))
+	}
+	return ret
+}
+
+// normalizeOptions validates option axes, trimming names and values.
+func normalizeOptions(payload []ProductOptionPayload) ([]ProductOption, error) {
+	options := make([]ProductOption, 0, len(payload))
+	seenNames := make(map[string]bool, len(payload))
+	for i, p := range payload {
+		name := strings.TrimSpace(p.Name)
+		if name == "" {
+			return nil, fmt.Errorf("%w: option names must be non-empty", errInvalidOptions)
+		}
+		if seenNames[strings.ToLower(name)] {
+			return nil, fmt.Errorf("%w: duplicate option %q", errInvalidOptions, name)
+		}
+		seenNames[strings.ToLower(name)] = true
+
+		values := make([]string, 0, len(p.Values))
+		seenValues := make(map[string]bool, len(p.Values))
+		for _, v := range p.Values {
+			v = strings.TrimSpace(v)
+			if v == "" || seenValues[strings.ToLower(v)] {
+				return nil, fmt.Errorf("%w: values of %q must be non-empty and distinct", errInvalidOptions, name)
+			}
+			seenValues[strings.ToLower(v)] = true
+			values = append(values, v)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%w: option %q needs at least one value", errInvalidOptions, name)
+		}
+		options = append(options, ProductOption{Name: name, Position: i, Values: values})
+	}
+	return options, nil
+}
+
+// resolveVariantOptions checks that selected picks exactly one listed value per option axis and
+// returns the canonical key and display title for the combination.
+func resolveVariantOptions(options []ProductOption, selected map[string]string) (key, title string, err error) {
+	if len(options) == 0 {
+		return "", "", errNoOptions
+	}
+	if len(selected) != len(options) {
+		return "", "", fmt.Errorf("%w: choose exactly one value for each option", errInvalidVariant)
+	}
+	keyParts := make([]string, 0, len(options))
+	titleParts := make([]string, 0, len(options))
+	for _, o := range options {
+		value, ok := selected[o.Name]
+		if !ok {
+			return "", "", fmt.Errorf("%w: missing value for option %q", errInvalidVariant, o.Name)
+		}
+		listed := false
+		for _, v := range o.Values {
+			listed = listed || v == value
+		}
+		if !listed {
+			return "", "", fmt.Errorf("%w: %q is not a value of option %q", errInvalidVariant, value, o.Name)
+		}
+		keyParts = append(keyParts, strings.ToLower(o.Name)+"="+strings.ToLower(value))
+		titleParts = append(titleParts, value)
+	}
+	sort.Strings(keyParts)
+	return strings.Join(keyParts, ";"), strings.Join(titleParts, " / "), nil
+}
+
+// SyncVariantStock sets a product's ProdCount to the total stock of its variants.
+// It is a no-op for products without variants.
+func SyncVariantStock(tx *gorm.DB, productID uint) error {
+	return tx.Exec(`UPDATE products SET prod_count = (SELECT COALESCE(SUM(count), 0) FROM product_variants WHERE product_id = ?)
+		WHERE id = ? AND EXISTS (SELECT 1 FROM product_variants WHERE product_id = ?)`, productID, productID, productID).Error
+}
+
+// hasVariants reports whether the product has any variants.
+func hasVariants(tx *gorm.DB, productID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&ProductVariant{}).Where("product_id = ?", productID).Count(&count).Error
+	return count > 0, err
+}
+
+// applyVariantPayload validates a create or update payload and applies it to variant.
+// The product must be locked by the caller.
+func applyVariantPayload(tx *gorm.DB, product *Product, variant *ProductVariant, payload ProductVariantPayload) error {
+	if payload.SKU != nil {
+		sku, err := normalizeSKU(*payload.SKU)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidVariant, err)
+		}
+		variant.SKU = sku
+	}
+	if variant.SKU == "" {
+		return fmt.Errorf("%w: sku is required", errInvalidVariant)
+	}
+	if payload.Price != nil {
+		price, err := money.Parse(payload.Price.String(), product.Currency)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidVariant, err)
+		}
+		variant.PriceMinor = price
+	}
+	if payload.Count != nil {
+		variant.Count = *payload.Count
+	}
+	if payload.ImageID != nil {
+		if *payload.ImageID == 0 {
+			variant.ImageID = nil
+		} else {
+			var count int64
+			if err := tx.Model(&Image{}).Where("id = ? AND product_id = ?", *payload.ImageID, product.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return errVariantImageOwner
+			}
+			imageID := *payload.ImageID
+			variant.ImageID = &imageID
+		}
+	}
+	if payload.Options != nil || variant.ID == 0 {
+		var options []ProductOption
+		if err := orderOptions(tx.Where("product_id = ?", product.ID)).Find(&options).Error; err != nil {
+			return err
+		}
+		selected := make(map[string]string, len(payload.Options))
+		for k, v := range payload.Options {
+			selected[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		key, title, err := resolveVariantOptions(options, selected)
+		if err != nil {
+			return err
+		}
+		variant.Options, variant.OptionsKey, variant.Title = selected, key, title
+	}
+
+	// Check uniqueness up front for clear errors; the unique indexes still guard against races
+	var count int64
+	if err := tx.Model(&ProductVariant{}).Where("product_id = ? AND options_key = ? AND id <> ?", product.ID, variant.OptionsKey, variant.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errDuplicateVariant
+	}
+	if inUse, err := skuInUse(tx, product.UserID, variant.SKU, 0, variant.ID); err != nil {
+		return err
+	} else if inUse {
+		return errDuplicateSKU
+	}
+	return nil
+}
+
+// lockOwnedVariant loads a variant and row-locks its product, checking the user owns it.
+func lockOwnedVariant(tx *gorm.DB, variantID, userID uint) (*Product, *ProductVariant, error) {
+	var variant ProductVariant
+	if err := tx.First(&variant, variantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errVariantNotFound
+		}
+		return nil, nil, err
+	}
+	product, err := lockOwnedProduct(tx, variant.ProductID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Re-read the variant now the product is locked, as its stock may have changed meanwhile
+	if err := tx.First(&variant, variantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errVariantNotFound
+		}
+		return nil, nil, err
+	}
+	return product, &variant, nil
+}
+
+// writeVariantError maps the errors of the variant endpoints to HTTP responses.
+func writeVariantError(w http.ResponseWriter, handler string, id uint64, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case errors.Is(err, errVariantNotFound):
+		http.Error(w, "Variant not found", http.StatusNotFound)
+	case errors.Is(err, errProductNotOwned):
+		http.Error(w, "Permission denied: You do not own this product", http.StatusForbidden)
+	case errors.Is(err, errDuplicateVariant), errors.Is(err, errDuplicateSKU), errors.Is(err, errOptionsInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errNoOptions), errors.Is(err, errInvalidOptions), errors.Is(err, errInvalidVariant),
+		errors.Is(err, errVariantImageOwner):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: Error updating variants (ID %d): %v", handler, id, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response to JSON: %v", err)
+	}
+}
+
+// SetProductOptions replaces a product's option axes.
+//
+// @Summary      Set product options
+// @Description  Replaces the option axes (e.g. Size, Colour) that the product's variants choose from. Fails with 409 if an existing variant would no longer fit.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       query  int                     true  "Product ID" Format(uint64)
+// @Param        options  body   []ProductOptionPayload  true  "Option axes in display order"
+// @Success      200  {object}  ProductVariantsReturn "The product's options and variants"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID or options"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      409  {string}  string "Conflict: Existing variants do not fit the new options"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/set_product_options [put]
+func SetProductOptions(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("SetProductOptions: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var payload []ProductOptionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	options, err := normalizeOptions(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var variants []ProductVariant
+	var currency string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		product, err := lockOwnedProduct(tx, uint(productID), user.ID)
+		if err != nil {
+			return err
+		}
+		currency = product.Currency
+		if err := orderVariants(tx.Where("product_id = ?", product.ID)).Find(&variants).Error; err != nil {
+			return err
+		}
+		// Every existing variant must still name a valid combination; refresh titles for the new order
+		for i := range variants {
+			key, title, err := resolveVariantOptions(options, variants[i].Options)
+			if err != nil {
+				return fmt.Errorf("%w (variant %s: %v)", errOptionsInUse, variants[i].SKU, err)
+			}
+			if key != variants[i].OptionsKey || title != variants[i].Title {
+				variants[i].OptionsKey, variants[i].Title = key, title
+				if err := tx.Model(&variants[i]).Updates(map[string]interface{}{"options_key": key, "title": title}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Where("product_id = ?", product.ID).Delete(&ProductOption{}).Error; err != nil {
+			return err
+		}
+		for i := range options {
+			options[i].ProductID = product.ID
+		}
+		if len(options) > 0 {
+			return tx.Create(&options).Error
+		}
+		return nil
+	})
+	if err != nil {
+		writeVariantError(w, "SetProductOptions", productID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ProductVariantsReturn{
+		Options:  setProductOptionsReturn(options),
+		Variants: setProductVariantsReturn(currency, variants),
+	})
+}
+
+// GetProductVariants lists a product's option axes and variants.
+//
+// @Summary      Get product variants
+// @Description  Retrieves the option axes and variants of a product owned by the authenticated user.
+// @Tags         Products
+// @Produce      application/json
+// @Param        id   query     int  true  "Product ID" Format(uint64)
+// @Success      200  {object}  ProductVariantsReturn "The product's options and variants"
+// @Failure      400  {string}  string "Bad Request: Invalid Product ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_product_variants [get]
+func GetProductVariants(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetProductVariants: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+
+	var product Product
+	if err := db.Preload("Options", orderOptions).Preload("Variants", orderVariants).First(&product, uint(productID)).Error; err != nil {
+		writeVariantError(w, "GetProductVariants", productID, err)
+		return
+	}
+	if product.UserID != user.ID {
+		writeVariantError(w, "GetProductVariants", productID, errProductNotOwned)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ProductVariantsReturn{
+		Options:  setProductOptionsReturn(product.Options),
+		Variants: setProductVariantsReturn(product.Currency, product.Variants),
+	})
+}
+
+// AddProductVariant creates a variant of a product.
+//
+// @Summary      Add a product variant
+// @Description  Creates a variant with its own SKU, price, stock and optional image. The options must pick one value of each of the product's option axes. Once a product has variants its stock is the total of their stock.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       query  int                    true  "Product ID" Format(uint64)
+// @Param        variant  body   ProductVariantPayload  true  "Variant details"
+// @Success      201  {object}  ProductVariantReturn "The created variant"
+// @Failure      400  {string}  string "Bad Request: Invalid product ID or variant"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Product not found"
+// @Failure      409  {string}  string "Conflict: Duplicate options or SKU"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/add_product_variant [post]
+func AddProductVariant(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("AddProductVariant: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		return
+	}
+	var payload ProductVariantPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var variant ProductVariant
+	var currency string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		product, err := lockOwnedProduct(tx, uint(productID), user.ID)
+		if err != nil {
+			return err
+		}
+		currency = product.Currency
+		variant = ProductVariant{ProductID: product.ID, UserID: product.UserID, PriceMinor: product.PriceMinor}
+		if err := applyVariantPayload(tx, product, &variant, payload); err != nil {
+			return err
+		}
+		// The variant's stock is added through the stock ledger
+		count := variant.Count
+		variant.Count = 0
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		return addVariantStock(tx, product, &variant, count, user.ID)
+	})
+	if err != nil {
+		writeVariantError(w, "AddProductVariant", productID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, setProductVariantReturn(currency, variant))
+}
+
+// UpdateProductVariant changes a variant's SKU, options, price, stock or image.
+//
+// @Summary      Update a product variant
+// @Description  Updates the provided fields of a variant; omitted fields are left unchanged. An imageID of 0 clears the variant's image.
+// @Tags         Products
+// @Accept       application/json
+// @Produce      application/json
+// @Param        id       query  int                    true  "Variant ID" Format(uint64)
+// @Param        variant  body   ProductVariantPayload  true  "Fields to update"
+// @Success      200  {object}  ProductVariantReturn "The updated variant"
+// @Failure      400  {string}  string "Bad Request: Invalid variant ID or fields"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Variant not found"
+// @Failure      409  {string}  string "Conflict: Duplicate options or SKU"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/update_product_variant [put]
+func UpdateProductVariant(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("UpdateProductVariant: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	variantID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Variant ID", http.StatusBadRequest)
+		return
+	}
+	var payload ProductVariantPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var variant *ProductVariant
+	var currency string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		product, v, err := lockOwnedVariant(tx, uint(variantID), user.ID)
+		if err != nil {
+			return err
+		}
+		variant, currency = v, product.Currency
+		oldCount := variant.Count
+		if err := applyVariantPayload(tx, product, variant, payload); err != nil {
+			return err
+		}
+		if err := tx.Model(variant).Select("sku", "options", "options_key", "title", "price_minor", "image_id").
+			Updates(variant).Error; err != nil {
+			return err
+		}
+		// The stock is changed through the stock ledger
+		count := variant.Count
+		variant.Count = oldCount
+		return setStock(tx, product, variant, count, StockChange{Reason: StockReasonAdjustment, UserID: user.ID})
+	})
+	if err != nil {
+		writeVariantError(w, "UpdateProductVariant", variantID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, setProductVariantReturn(currency, *variant))
+}
+
+// DeleteProductVariant removes a variant. Past orders keep their recorded variant details.
+//
+// @Summary      Delete a product variant
+// @Description  Deletes a variant of a product owned by the authenticated user. The product's stock is recalculated from the remaining variants.
+// @Tags         Products
+// @Produce      text/plain
+// @Param        id   query     int  true  "Variant ID" Format(uint64)
+// @Success      200  {string}  string "Variant deleted successfully"
+// @Failure      400  {string}  string "Bad Request: Invalid Variant ID"
+// @Failure      401  {string}  string "Unauthorized: User not authenticated"
+// @Failure      403  {string}  string "Forbidden: User does not own this product"
+// @Failure      404  {string}  string "Not Found: Variant not found"
+// @Failure      500  {string}  string "Internal Server Error: Database error"
+// @Security     ApiKeyAuth
+// @Router       /api/delete_product_variant [delete]
+func DeleteProductVariant(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("DeleteProductVariant: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	variantID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Variant ID", http.StatusBadRequest)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		product, variant, err := lockOwnedVariant(tx, uint(variantID), user.ID)
+		if err != nil {
+			return err
+		}
+		change := StockChange{Reason: StockReasonAdjustment, UserID: user.ID, Note: "Variant deleted"}
+		if err := setStock(tx, product, variant, 0, change); err != nil {
+			return err
+		}
+		if err := tx.Delete(variant).Error; err != nil {
+			return err
+		}
+		// Without variants left the product keeps the stock of the ones remaining, i.e. none
+		if err := SyncVariantStock(tx, product.ID); err != nil {
+			return err
+		}
+		if more, err := hasVariants(tx, product.ID); err != nil || more {
+			return err
+		}
+		return tx.Model(&Product{}).Where("id = ?", product.ID).Update("prod_count", 0).Error
+	})
+	if err != nil {
+		writeVariantError(w, "DeleteProductVariant", variantID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Variant deleted successfully")
+}
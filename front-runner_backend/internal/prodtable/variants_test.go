@@ -0,0 +1,219 @@
+// internal/prodtable/variants_test.go
+package prodtable
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeOptions(t *testing.T) {
+	options, err := normalizeOptions([]ProductOptionPayload{
+		{Name: " Size ", Values: []string{"S", " M "}},
+		{Name: "Colour", Values: []string{"Red"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, options, 2)
+	assert.Equal(t, "Size", options[0].Name)
+	assert.Equal(t, []string{"S", "M"}, options[0].Values)
+	assert.Equal(t, 1, options[1].Position)
+
+	for _, bad := range [][]ProductOptionPayload{
+		{{Name: "", Values: []string{"S"}}},
+		{{Name: "Size", Values: nil}},
+		{{Name: "Size", Values: []string{"S", "s"}}},
+		{{Name: "Size", Values: []string{"S"}}, {Name: "size", Values: []string{"M"}}},
+	} {
+		_, err := normalizeOptions(bad)
+		assert.True(t, errors.Is(err, errInvalidOptions), "%v should be rejected", bad)
+	}
+}
+
+func TestResolveVariantOptions(t *testing.T) {
+	options := []ProductOption{
+		{Name: "Size", Values: []string{"S", "M"}},
+		{Name: "Colour", Values: []string{"Red", "Blue"}},
+	}
+	key, title, err := resolveVariantOptions(options, map[string]string{"Colour": "Blue", "Size": "M"})
+	require.NoError(t, err)
+	assert.Equal(t, "colour=blue;size=m", key)
+	assert.Equal(t, "M / Blue", title, "Title follows the option order")
+
+	_, _, err = resolveVariantOptions(options, map[string]string{"Size": "M"})
+	assert.True(t, errors.Is(err, errInvalidVariant), "Every option needs a value")
+	_, _, err = resolveVariantOptions(options, map[string]string{"Size": "XL", "Colour": "Red"})
+	assert.True(t, errors.Is(err, errInvalidVariant), "Values must be listed")
+	_, _, err = resolveVariantOptions(options, map[string]string{"Size": "M", "Fit": "Slim"})
+	assert.True(t, errors.Is(err, errInvalidVariant), "Unknown options are rejected")
+	_, _, err = resolveVariantOptions(nil, map[string]string{"Size": "M"})
+	assert.True(t, errors.Is(err, errNoOptions))
+}
+
+// TestProductVariants tests option axes and variant CRUD, including the product stock roll-up.
+func TestProductVariants(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "variants@example.com", "password")
+	other := createTestUser(t, "variantsother@example.com", "password")
+
+	req := createImagesRequest(t, user, "POST", "/api/add_product", map[string]string{
+		"productName": "Tee",
+		"description": "Cotton T-shirt",
+		"price":       "15",
+		"count":       "7",
+	}, "front.png", "blue.png")
+	rr := httptest.NewRecorder()
+	AddProduct(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	var product Product
+	require.NoError(t, testDB.Preload("Images", orderImages).Where("user_id = ? AND prod_name = ?", user.ID, "Tee").First(&product).Error)
+
+	jsonRequest := func(t *testing.T, method, url string, body interface{}) *http.Request {
+		t.Helper()
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		return createAuthenticatedRequest(t, user, method, url, bytes.NewReader(data))
+	}
+	addVariant := func(t *testing.T, payload map[string]interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		AddProductVariant(rr, jsonRequest(t, "POST", fmt.Sprintf("/api/add_product_variant?id=%d", product.ID), payload))
+		return rr
+	}
+	productCount := func(t *testing.T) uint {
+		t.Helper()
+		var p Product
+		require.NoError(t, testDB.First(&p, product.ID).Error)
+		return p.ProdCount
+	}
+
+	t.Run("VariantNeedsOptions", func(t *testing.T) {
+		rr := addVariant(t, map[string]interface{}{"sku": "TEE-S", "options": map[string]string{"Size": "S"}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	var small, medium ProductVariantReturn
+	t.Run("SetOptionsAndAddVariants", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		SetProductOptions(rr, jsonRequest(t, "PUT", fmt.Sprintf("/api/set_product_options?id=%d", product.ID), []ProductOptionPayload{
+			{Name: "Size", Values: []string{"S", "M"}},
+			{Name: "Colour", Values: []string{"Blue"}},
+		}))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+
+		rr = addVariant(t, map[string]interface{}{"sku": "TEE-S", "options": map[string]string{"Size": "S", "Colour": "Blue"}, "count": 3})
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &small))
+		assert.Equal(t, "S / Blue", small.Title)
+		assert.Equal(t, json.Number("15.00"), small.Price, "Price defaults to the product's")
+
+		rr = addVariant(t, map[string]interface{}{"sku": "TEE-M", "options": map[string]string{"Size": "M", "Colour": "Blue"},
+			"count": 4, "price": 17.5, "imageID": product.Images[1].ID})
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &medium))
+		require.NotNil(t, medium.ImageID)
+		assert.Equal(t, product.Images[1].ID, *medium.ImageID)
+
+		assert.Equal(t, uint(7), productCount(t), "Product stock should be the variants' total")
+	})
+
+	t.Run("RejectsDuplicates", func(t *testing.T) {
+		rr := addVariant(t, map[string]interface{}{"sku": "TEE-S2", "options": map[string]string{"Size": "S", "Colour": "Blue"}})
+		assert.Equal(t, http.StatusConflict, rr.Code, "Same options")
+		rr = addVariant(t, map[string]interface{}{"sku": "TEE-S", "options": map[string]string{"Size": "M", "Colour": "Blue"}})
+		assert.Equal(t, http.StatusConflict, rr.Code, "Same SKU")
+		rr = addVariant(t, map[string]interface{}{"sku": strings.Repeat("X", maxSKULength+1), "options": map[string]string{"Size": "M", "Colour": "Blue"}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "SKU too long")
+	})
+
+	t.Run("UpdateVariant", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		UpdateProductVariant(rr, jsonRequest(t, "PUT", fmt.Sprintf("/api/update_product_variant?id=%d", small.ID),
+			map[string]interface{}{"count": 10, "price": 14}))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp ProductVariantReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, uint(10), resp.Count)
+		assert.Equal(t, json.Number("14.00"), resp.Price)
+		assert.Equal(t, "TEE-S", resp.SKU, "Omitted fields are unchanged")
+		assert.Equal(t, uint(14), productCount(t))
+
+		rr = httptest.NewRecorder()
+		UpdateProductVariant(rr, jsonRequest(t, "PUT", fmt.Sprintf("/api/update_product_variant?id=%d", small.ID),
+			map[string]interface{}{"price": 14.005}))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Prices finer than a cent are rejected")
+
+		rr = httptest.NewRecorder()
+		req := createAuthenticatedRequest(t, other, "PUT", fmt.Sprintf("/api/update_product_variant?id=%d", small.ID),
+			bytes.NewReader([]byte(`{"count": 1}`)))
+		UpdateProductVariant(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("ProductCountManagedByVariants", func(t *testing.T) {
+		req := createImagesRequest(t, user, "PUT", fmt.Sprintf("/api/update_product?id=%d", product.ID), map[string]string{"count": "3"})
+		rr := httptest.NewRecorder()
+		UpdateProduct(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "body: %s", rr.Body.String())
+	})
+
+	t.Run("OptionsInUse", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		SetProductOptions(rr, jsonRequest(t, "PUT", fmt.Sprintf("/api/set_product_options?id=%d", product.ID), []ProductOptionPayload{
+			{Name: "Size", Values: []string{"M"}},
+			{Name: "Colour", Values: []string{"Blue"}},
+		}))
+		assert.Equal(t, http.StatusConflict, rr.Code, "Variant S would no longer fit")
+	})
+
+	t.Run("GetVariants", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GetProductVariants(rr, createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_product_variants?id=%d", product.ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp ProductVariantsReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Options, 2)
+		require.Len(t, resp.Variants, 2)
+		assert.Equal(t, "TEE-S", resp.Variants[0].SKU)
+
+		rr = httptest.NewRecorder()
+		GetProduct(rr, createAuthenticatedRequest(t, user, "GET", fmt.Sprintf("/api/get_product?id=%d", product.ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var prod ProductReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &prod))
+		assert.Len(t, prod.Variants, 2)
+	})
+
+	t.Run("DeletingImageClearsVariantImage", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		DeleteProductImage(rr, createAuthenticatedRequest(t, user, "DELETE",
+			fmt.Sprintf("/api/delete_product_image?id=%d&imageID=%d", product.ID, product.Images[1].ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var variant ProductVariant
+		require.NoError(t, testDB.First(&variant, medium.ID).Error)
+		assert.Nil(t, variant.ImageID)
+	})
+
+	t.Run("DeleteVariants", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		DeleteProductVariant(rr, createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_variant?id=%d", small.ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(4), productCount(t))
+
+		rr = httptest.NewRecorder()
+		DeleteProductVariant(rr, createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_variant?id=%d", medium.ID), nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		assert.Equal(t, uint(0), productCount(t), "No variants left means no stock")
+
+		rr = httptest.NewRecorder()
+		DeleteProductVariant(rr, createAuthenticatedRequest(t, user, "DELETE", fmt.Sprintf("/api/delete_product_variant?id=%d", medium.ID), nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,107 @@
+// Package ratelimit provides in-memory token bucket rate limiting keyed by client.
+package ratelimit
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets bounds memory use; once exceeded, buckets that have refilled are dropped.
+const maxIdleBuckets = 10000
+
+// Limiter allows each key a sustained number of requests per minute with bursts up to a limit.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter allowing perMinute requests per minute per key, in bursts of up to burst.
+func New(perMinute, burst int) *Limiter {
+	if perMinute < 1 {
+		perMinute = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// FromEnv returns a Limiter configured by the named environment variables, using the
+// defaults for unset or invalid values.
+func FromEnv(perMinuteVar, burstVar string, defaultPerMinute, defaultBurst int) *Limiter {
+	return New(envInt(perMinuteVar, defaultPerMinute), envInt(burstVar, defaultBurst))
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name))); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// Allow takes a token from key's bucket. If none is left it returns false and how long
+// until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.take(key, 1)
+}
+
+// Check reports whether key has a token left without taking it.
+func (l *Limiter) Check(key string) (bool, time.Duration) {
+	return l.take(key, 0)
+}
+
+func (l *Limiter) take(key string, n float64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens -= n
+	return true, 0
+}
+
+// sweep drops the buckets that would be full by now, as they carry no state.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RetryAfter formats a wait for the Retry-After header in whole seconds, rounding up.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,50 @@
+// internal/ratelimit/ratelimit_test.go
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(60, 2) // One token per second
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Check("a")
+	assert.True(t, ok, "Check does not take a token")
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, wait := l.Allow("a")
+	assert.False(t, ok, "Burst exhausted")
+	assert.Equal(t, time.Second, wait)
+	assert.Equal(t, "1", RetryAfter(wait))
+
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "Keys are limited independently")
+
+	now = now.Add(1500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok, "Tokens refill over time")
+	ok, wait = l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	assert.Equal(t, "1", RetryAfter(wait))
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(60, 1)
+	l.now = func() time.Time { return now }
+	l.Allow("idle")
+	l.Allow("busy")
+	now = now.Add(2 * time.Second)
+	l.Allow("busy")
+	l.sweep(now.Add(500 * time.Millisecond))
+	assert.NotContains(t, l.buckets, "idle")
+	assert.Contains(t, l.buckets, "busy")
+}
@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"front-runner/internal/inventorysync"
 	"front-runner/internal/login"
 	"front-runner/internal/oauth"
 	"front-runner/internal/orderstable"
+	"front-runner/internal/pricing"
 	"front-runner/internal/prodtable"
+	"front-runner/internal/stockalerts"
 	"front-runner/internal/storefronttable"
 	"front-runner/internal/usertable"
 	"log"
@@ -112,19 +115,69 @@ func RegisterRoutes(router *mux.Router, logging bool) http.Handler {
 	api.HandleFunc("/get_product", prodtable.GetProduct).Methods("GET")
 	api.HandleFunc("/get_products", prodtable.GetProducts).Methods("GET")
 	api.HandleFunc("/get_product_image", prodtable.GetProductImage).Methods("GET")
+	api.HandleFunc("/add_product_images", prodtable.AddProductImages).Methods("POST")
+	api.HandleFunc("/reorder_product_images", prodtable.ReorderProductImages).Methods("PUT")
+	api.HandleFunc("/set_primary_image", prodtable.SetPrimaryProductImage).Methods("PUT")
+	api.HandleFunc("/delete_product_image", prodtable.DeleteProductImage).Methods("DELETE")
+	api.HandleFunc("/set_product_options", prodtable.SetProductOptions).Methods("PUT")
+	api.HandleFunc("/get_product_variants", prodtable.GetProductVariants).Methods("GET")
+	api.HandleFunc("/add_product_variant", prodtable.AddProductVariant).Methods("POST")
+	api.HandleFunc("/update_product_variant", prodtable.UpdateProductVariant).Methods("PUT")
+	api.HandleFunc("/delete_product_variant", prodtable.DeleteProductVariant).Methods("DELETE")
+	api.HandleFunc("/get_stock_history", prodtable.GetStockHistory).Methods("GET")
+	api.HandleFunc("/adjust_stock", prodtable.AdjustProductStock).Methods("POST")
+	api.HandleFunc("/reconcile_stock", prodtable.ReconcileProductStock).Methods("POST")
+	api.HandleFunc("/link_product_listing", prodtable.LinkProductListing).Methods("POST")
+	api.HandleFunc("/unlink_product_listing", prodtable.UnlinkProductListing).Methods("DELETE")
+	api.HandleFunc("/get_tags", prodtable.GetTags).Methods("GET")
+	api.HandleFunc("/rename_tag", prodtable.RenameTag).Methods("PUT")
+	api.HandleFunc("/apply_tags", prodtable.ApplyTags).Methods("POST")
+	api.HandleFunc("/import_products", prodtable.ImportProducts).Methods("POST")
+	api.HandleFunc("/get_product_import", prodtable.GetProductImport).Methods("GET")
+	api.HandleFunc("/export_products", prodtable.ExportProducts).Methods("GET")
+	api.HandleFunc("/get_stock_alerts", stockalerts.GetStockAlerts).Methods("GET")
+	api.HandleFunc("/acknowledge_stock_alert", stockalerts.AcknowledgeStockAlert).Methods("POST")
+	api.HandleFunc("/get_inventory_pushes", inventorysync.GetInventoryPushes).Methods("GET")
 	// Storefront Table
 	api.HandleFunc("/add_storefront", storefronttable.AddStorefront).Methods("POST")
 	api.HandleFunc("/get_storefronts", storefronttable.GetStorefronts).Methods("GET")
 	api.HandleFunc("/update_storefront", storefronttable.UpdateStorefront).Methods("PUT")
 	api.HandleFunc("/delete_storefront", storefronttable.DeleteStorefront).Methods("DELETE")
+	api.HandleFunc("/rotate_storefront_secret", storefronttable.RotateSigningSecret).Methods("POST")
+	api.HandleFunc("/get_storefront_listings", storefronttable.GetStorefrontListings).Methods("GET")
 
 	//Orders Table
 	api.HandleFunc("/create_order", orderstable.CreateOrder).Methods("POST")
 	api.HandleFunc("/get_order", orderstable.GetOrder).Methods("GET")
 	api.HandleFunc("/get_orders", orderstable.GetOrders).Methods("GET")
+	api.HandleFunc("/update_order_status", orderstable.UpdateOrderStatus).Methods("PUT")
+	api.HandleFunc("/get_order_status_history", orderstable.GetOrderStatusHistory).Methods("GET")
+	api.HandleFunc("/cancel_order", orderstable.CancelOrder).Methods("POST")
+	api.HandleFunc("/update_tracking", orderstable.UpdateTracking).Methods("PUT")
+	api.HandleFunc("/upload_shipping_label", orderstable.UploadShippingLabel).Methods("POST")
+	api.HandleFunc("/get_shipping_label", orderstable.GetShippingLabel).Methods("GET")
+	api.HandleFunc("/reserve_stock", orderstable.ReserveStock).Methods("POST")
+	api.HandleFunc("/release_reservation", orderstable.ReleaseReservation).Methods("POST")
+	api.HandleFunc("/get_channel_sync_status", orderstable.GetChannelSyncStatus).Methods("GET")
+	api.HandleFunc("/get_webhook_deliveries", orderstable.GetWebhookDeliveries).Methods("GET")
+	api.HandleFunc("/replay_webhook_delivery", orderstable.ReplayWebhookDelivery).Methods("POST")
+
+	// Pricing (tax, shipping and discounts)
+	api.HandleFunc("/set_tax_rate", pricing.SetTaxRate).Methods("PUT")
+	api.HandleFunc("/get_tax_rates", pricing.GetTaxRates).Methods("GET")
+	api.HandleFunc("/delete_tax_rate", pricing.DeleteTaxRate).Methods("DELETE")
+	api.HandleFunc("/set_shipping_rate", pricing.SetShippingRate).Methods("PUT")
+	api.HandleFunc("/get_shipping_rates", pricing.GetShippingRates).Methods("GET")
+	api.HandleFunc("/delete_shipping_rate", pricing.DeleteShippingRate).Methods("DELETE")
+	api.HandleFunc("/add_discount_code", pricing.AddDiscountCode).Methods("POST")
+	api.HandleFunc("/get_discount_codes", pricing.GetDiscountCodes).Methods("GET")
+	api.HandleFunc("/delete_discount_code", pricing.DeleteDiscountCode).Methods("DELETE")
 
 	api.PathPrefix("/").HandlerFunc(InvalidAPI)
 
+	// Marketplace webhooks authenticate by their signatures rather than a session
+	router.HandleFunc("/webhooks/{storeType}/{linkID}", orderstable.ReceiveWebhook).Methods("POST")
+
 	// Serve Swagger UI on /swagger/*
 	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
@@ -129,10 +129,56 @@ func TestRouteExistenceAndBasicHandling(t *testing.T) {
 		{"GET", "/api/get_product?id=1", http.StatusUnauthorized, "", ""},
 		{"GET", "/api/get_products", http.StatusUnauthorized, "", ""},
 		{"GET", "/api/get_product_image?image=test.jpg", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/add_product_images?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/reorder_product_images?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/set_primary_image?id=1&imageID=1", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/delete_product_image?id=1&imageID=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/set_product_options?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_product_variants?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/add_product_variant?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/update_product_variant?id=1", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/delete_product_variant?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_stock_history?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/adjust_stock?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/reconcile_stock?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/link_product_listing?id=1", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/unlink_product_listing?id=1&linkID=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_tags", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/rename_tag?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/apply_tags", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/import_products", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_product_import?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/export_products?format=csv", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_stock_alerts", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/acknowledge_stock_alert?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_inventory_pushes", http.StatusUnauthorized, "", ""},
 		{"POST", "/api/add_storefront", http.StatusUnauthorized, "", ""},
 		{"GET", "/api/get_storefronts", http.StatusUnauthorized, "", ""},
 		{"PUT", "/api/update_storefront?id=1", http.StatusUnauthorized, "", ""},
 		{"DELETE", "/api/delete_storefront?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/rotate_storefront_secret?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_storefront_listings?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/create_order", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/update_order_status?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_order_status_history?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/cancel_order?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/update_tracking?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/upload_shipping_label?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_shipping_label?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/reserve_stock", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/release_reservation?id=1", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_channel_sync_status", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_webhook_deliveries", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/replay_webhook_delivery?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/set_tax_rate", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_tax_rates", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/delete_tax_rate?id=1", http.StatusUnauthorized, "", ""},
+		{"PUT", "/api/set_shipping_rate", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_shipping_rates", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/delete_shipping_rate?id=1", http.StatusUnauthorized, "", ""},
+		{"POST", "/api/add_discount_code", http.StatusUnauthorized, "", ""},
+		{"GET", "/api/get_discount_codes", http.StatusUnauthorized, "", ""},
+		{"DELETE", "/api/delete_discount_code?id=1", http.StatusUnauthorized, "", ""},
 
 		// --- Invalid API Route ---
 		{"GET", "/api/nonexistent/route", http.StatusNotFound, "", ""},
@@ -0,0 +1,203 @@
+package stockalerts
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"front-runner/internal/oauth"
+
+	"gorm.io/gorm"
+)
+
+// StockAlertReturn describes a stock alert.
+type StockAlertReturn struct {
+	ID             uint   `json:"id"`
+	ProductID      uint   `json:"productID"`
+	ProductName    string `json:"productName"` // Empty if the product was deleted
+	Kind           string `json:"kind"`        // low_stock or out_of_stock
+	Stock          uint   `json:"stock"`       // Stock when the alert was raised
+	Threshold      uint   `json:"threshold"`   // Reorder threshold when the alert was raised
+	Status         string `json:"status"`      // open, acknowledged or resolved
+	CreatedAt      string `json:"createdAt"`
+	AcknowledgedAt string `json:"acknowledgedAt,omitempty"`
+	ResolvedAt     string `json:"resolvedAt,omitempty"`
+}
+
+// alertRow is a stock alert with the name of its product.
+type alertRow struct {
+	StockAlert
+	ProductName string
+}
+
+func (a *alertRow) toReturn() StockAlertReturn {
+	ret := StockAlertReturn{
+		ID:          a.ID,
+		ProductID:   a.ProductID,
+		ProductName: a.ProductName,
+		Kind:        a.Kind,
+		Stock:       a.Stock,
+		Threshold:   a.Threshold,
+		Status:      a.Status,
+		CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+	}
+	if a.AcknowledgedAt != nil {
+		ret.AcknowledgedAt = a.AcknowledgedAt.Format(time.RFC3339)
+	}
+	if a.ResolvedAt != nil {
+		ret.ResolvedAt = a.ResolvedAt.Format(time.RFC3339)
+	}
+	return ret
+}
+
+// alertsQuery selects the user's alerts with their product names.
+func alertsQuery(userID uint) *gorm.DB {
+	return db.Table("stock_alerts a").
+		Select("a.*, COALESCE(p.prod_name, '') AS product_name").
+		Joins("LEFT JOIN products p ON p.id = a.product_id").
+		Where("a.user_id = ?", userID)
+}
+
+// GetStockAlerts lists the current user's stock alerts.
+//
+// @Summary      List stock alerts
+// @Description  Lists the current user's low-stock and out-of-stock alerts, newest first. Without a status, the unresolved (open and acknowledged) alerts are listed.
+// @Tags         stock
+// @Produce      json
+// @Param        status query string false "Only alerts with this status" Enums(open, acknowledged, resolved)
+// @Success      200  {array}   StockAlertReturn "List of stock alerts"
+// @Failure      400  {string}  string "Invalid status"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/get_stock_alerts [get]
+func GetStockAlerts(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("GetStockAlerts: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	query := alertsQuery(user.ID)
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		query = query.Where("a.status <> ?", StatusResolved)
+	case StatusOpen, StatusAcknowledged, StatusResolved:
+		query = query.Where("a.status = ?", status)
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	var rows []alertRow
+	if err := query.Order("a.created_at DESC, a.id DESC").Scan(&rows).Error; err != nil {
+		log.Printf("Error fetching stock alerts for user %d: %v", user.ID, err)
+		http.Error(w, "Internal server error while fetching stock alerts", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]StockAlertReturn, 0, len(rows))
+	for i := range rows {
+		ret = append(ret, rows[i].toReturn())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
+
+var (
+	errAlertNotFound = errors.New("stock alert not found")
+	errAlertResolved = errors.New("stock alert is already resolved")
+)
+
+// AcknowledgeStockAlert marks one of the current user's alerts as seen. The alert stays listed
+// until the product is restocked; acknowledging it again has no effect.
+//
+// @Summary      Acknowledge a stock alert
+// @Description  Marks an open stock alert as acknowledged. Acknowledged alerts are resolved like open ones once the product is restocked.
+// @Tags         stock
+// @Produce      json
+// @Param        id   query integer true "Stock alert ID"
+// @Success      200  {object}  StockAlertReturn "The acknowledged alert"
+// @Failure      400  {string}  string "Invalid alert ID"
+// @Failure      401  {string}  string "User not authenticated"
+// @Failure      404  {string}  string "Stock alert not found"
+// @Failure      409  {string}  string "Stock alert is already resolved"
+// @Failure      500  {string}  string "Internal server error"
+// @Security     ApiKeyAuth
+// @Router       /api/acknowledge_stock_alert [post]
+func AcknowledgeStockAlert(w http.ResponseWriter, r *http.Request) {
+	user, err := oauth.GetCurrentUser(r)
+	if err != nil {
+		log.Printf("AcknowledgeStockAlert: Error getting current user: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	alertID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid alert ID format", http.StatusBadRequest)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var alert StockAlert
+		if err := tx.Where("id = ? AND user_id = ?", alertID, user.ID).First(&alert).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errAlertNotFound
+			}
+			return err
+		}
+		// Resolving and acknowledging only ever move an alert forward, so a conditional update
+		// is enough to keep a concurrent evaluation from being overwritten
+		res := tx.Model(&StockAlert{}).Where("id = ? AND status = ?", alert.ID, StatusOpen).
+			Updates(map[string]interface{}{"status": StatusAcknowledged, "acknowledged_at": time.Now()})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 && alert.Status == StatusResolved {
+			return errAlertResolved
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errAlertNotFound):
+			http.Error(w, "Stock alert not found", http.StatusNotFound)
+		case errors.Is(err, errAlertResolved):
+			http.Error(w, "Stock alert is already resolved", http.StatusConflict)
+		default:
+			log.Printf("Error acknowledging stock alert %d: %v", alertID, err)
+			http.Error(w, "Internal server error while acknowledging stock alert", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var row alertRow
+	if err := alertsQuery(user.ID).Where("a.id = ?", alertID).Scan(&row).Error; err != nil {
+		log.Printf("Error fetching stock alert %d: %v", alertID, err)
+		http.Error(w, "Internal server error while fetching stock alert", http.StatusInternalServerError)
+		return
+	}
+	if row.Status == StatusResolved {
+		// Resolved by an evaluation since it was read
+		http.Error(w, "Stock alert is already resolved", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(row.toReturn())
+}
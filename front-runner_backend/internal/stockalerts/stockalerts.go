@@ -0,0 +1,208 @@
+// Package stockalerts raises alerts when products with a reorder threshold run low on or out of
+// stock, notifies their sellers, and resolves the alerts once the products are restocked.
+package stockalerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"front-runner/internal/coredbutils"
+	"front-runner/internal/notify"
+
+	"gorm.io/gorm"
+)
+
+var (
+	db        *gorm.DB
+	setupOnce sync.Once
+	// notifier tells sellers about new alerts; replaced in Setup by the one configured in the environment
+	notifier notify.Notifier = notify.LogNotifier{}
+)
+
+// Alert kinds. A product has at most one unresolved alert, of the kind matching its stock.
+const (
+	KindLowStock   = "low_stock"    // Stock is below the reorder threshold
+	KindOutOfStock = "out_of_stock" // No stock left
+)
+
+// Alert statuses.
+const (
+	StatusOpen         = "open"
+	StatusAcknowledged = "acknowledged" // Seen by the seller; stays unresolved until restocked
+	StatusResolved     = "resolved"     // Stock recovered, changed kind, or the product was deleted
+)
+
+const evaluateInterval = time.Minute
+
+// StockAlert records a product running low on or out of stock.
+type StockAlert struct {
+	ID             uint       `gorm:"primaryKey"`
+	UserID         uint       `gorm:"not null;index"` // Seller of the product
+	ProductID      uint       `gorm:"not null;index"`
+	Kind           string     `gorm:"not null"`
+	Stock          uint       `gorm:"not null"` // Stock when the alert was raised
+	Threshold      uint       `gorm:"not null"` // Reorder threshold when the alert was raised
+	Status         string     `gorm:"not null;index"`
+	CreatedAt      time.Time  `gorm:"not null"`
+	NotifiedAt     *time.Time // When the seller was notified; nil until then
+	AcknowledgedAt *time.Time
+	ResolvedAt     *time.Time
+}
+
+// Setup initializes the database connection and notifier for the stockalerts package.
+func Setup() {
+	setupOnce.Do(func() {
+		coredbutils.LoadEnv() // Ensure env vars are loaded if needed by GetDB
+		var err error
+		db, err = coredbutils.GetDB()
+		if err != nil {
+			log.Fatalf("stockalerts Setup: Failed to get database connection: %v", err)
+		}
+		if db == nil {
+			log.Fatal("stockalerts Setup: Database connection is nil after GetDB.")
+		}
+		notifier, err = notify.FromEnv()
+		if err != nil {
+			log.Fatalf("stockalerts Setup: Failed to configure notifications: %v", err)
+		}
+		log.Println("stockalerts package setup complete (DB connection obtained).")
+	})
+}
+
+// MigrateStockAlertsDB runs GORM auto-migration for the stock alerts table. Products must be migrated first.
+func MigrateStockAlertsDB() {
+	if db == nil {
+		log.Fatal("Database connection is not initialized for stock alerts migration")
+	}
+	log.Println("Running stock alerts database migrations...")
+	if err := db.AutoMigrate(&StockAlert{}); err != nil {
+		log.Fatalf("Stock alerts migration failed: %v", err)
+	}
+	for _, stmt := range []string{
+		// At most one unresolved alert per product, even with several evaluators running
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_alerts_unresolved ON stock_alerts (product_id) WHERE status <> 'resolved'`,
+		// Products currently due an alert
+		`CREATE INDEX IF NOT EXISTS idx_products_below_threshold ON products (id) WHERE reorder_threshold > 0 AND prod_count < reorder_threshold`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Fatalf("Stock alerts migration failed creating index: %v", err)
+		}
+	}
+	log.Println("Stock alerts database migration complete")
+}
+
+// ClearStockAlertsTable removes all stock alerts. USE WITH CAUTION.
+func ClearStockAlertsTable(db *gorm.DB) error {
+	return db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&StockAlert{}).Error
+}
+
+// alertKindSQL is the kind of alert a product p is due, if p.reorder_threshold > 0 AND p.prod_count < p.reorder_threshold.
+const alertKindSQL = `CASE WHEN p.prod_count = 0 THEN '` + KindOutOfStock + `' ELSE '` + KindLowStock + `' END`
+
+// Evaluate resolves alerts that no longer match their product's stock and raises alerts for
+// products below their reorder threshold, then notifies sellers of new alerts.
+// It reports how many alerts were raised and resolved.
+func Evaluate(ctx context.Context, now time.Time) (raised, resolved int64, err error) {
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec(`
+			UPDATE stock_alerts a SET status = ?, resolved_at = ?
+			WHERE a.status <> ?
+			  AND NOT EXISTS (
+				SELECT 1 FROM products p
+				WHERE p.id = a.product_id AND p.reorder_threshold > 0 AND p.prod_count < p.reorder_threshold
+				  AND `+alertKindSQL+` = a.kind)`, StatusResolved, now, StatusResolved)
+		if res.Error != nil {
+			return fmt.Errorf("resolving alerts: %w", res.Error)
+		}
+		resolved = res.RowsAffected
+
+		res = tx.Exec(`
+			INSERT INTO stock_alerts (user_id, product_id, kind, stock, threshold, status, created_at)
+			SELECT p.user_id, p.id, `+alertKindSQL+`, p.prod_count, p.reorder_threshold, ?, ?
+			FROM products p
+			WHERE p.reorder_threshold > 0 AND p.prod_count < p.reorder_threshold
+			  AND NOT EXISTS (SELECT 1 FROM stock_alerts a WHERE a.product_id = p.id AND a.status <> ?)
+			ON CONFLICT DO NOTHING`, StatusOpen, now, StatusResolved)
+		if res.Error != nil {
+			return fmt.Errorf("raising alerts: %w", res.Error)
+		}
+		raised = res.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return raised, resolved, notifySellers(ctx, now)
+}
+
+// notifySellers notifies sellers of their open alerts not yet notified. Alerts that fail to be
+// delivered are retried on the next evaluation.
+func notifySellers(ctx context.Context, now time.Time) error {
+	var pending []struct {
+		StockAlert
+		Email       string
+		ProductName string
+	}
+	if err := db.WithContext(ctx).Table("stock_alerts a").
+		Select("a.*, u.email, p.prod_name AS product_name").
+		Joins("JOIN users u ON u.id = a.user_id").
+		Joins("JOIN products p ON p.id = a.product_id").
+		Where("a.status = ? AND a.notified_at IS NULL", StatusOpen).
+		Order("a.id").Scan(&pending).Error; err != nil {
+		return fmt.Errorf("finding alerts to notify: %w", err)
+	}
+
+	for _, alert := range pending {
+		// Claim the alert so another evaluator does not notify it too
+		res := db.WithContext(ctx).Model(&StockAlert{}).Where("id = ? AND notified_at IS NULL", alert.ID).Update("notified_at", now)
+		if res.Error != nil {
+			return fmt.Errorf("claiming alert %d: %w", alert.ID, res.Error)
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		if err := notifier.Notify(ctx, alertMessage(alert.Email, alert.ProductName, alert.StockAlert)); err != nil {
+			log.Printf("Stock alerts: failed to notify user %d of alert %d: %v", alert.UserID, alert.ID, err)
+			if err := db.Model(&StockAlert{}).Where("id = ?", alert.ID).Update("notified_at", nil).Error; err != nil {
+				log.Printf("Stock alerts: failed to release alert %d for retry: %v", alert.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// alertMessage describes an alert to its seller.
+func alertMessage(email, productName string, alert StockAlert) notify.Message {
+	if alert.Kind == KindOutOfStock {
+		return notify.Message{
+			To:      email,
+			Subject: fmt.Sprintf("Out of stock: %s", productName),
+			Body:    fmt.Sprintf("%s has sold out. Restock it to keep selling it.", productName),
+		}
+	}
+	return notify.Message{
+		To:      email,
+		Subject: fmt.Sprintf("Low stock: %s", productName),
+		Body:    fmt.Sprintf("%s is down to %d in stock, below its reorder threshold of %d.", productName, alert.Stock, alert.Threshold),
+	}
+}
+
+// StartEvaluator evaluates stock alerts in the background every minute.
+func StartEvaluator() {
+	go func() {
+		ticker := time.NewTicker(evaluateInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			raised, resolved, err := Evaluate(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("Stock alerts evaluator: %v", err)
+			}
+			if raised > 0 || resolved > 0 {
+				log.Printf("Stock alerts evaluator: raised %d and resolved %d alerts", raised, resolved)
+			}
+		}
+	}()
+}
@@ -0,0 +1,264 @@
+// internal/stockalerts/stockalerts_test.go
+package stockalerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"front-runner/internal/coredbutils"
+	"front-runner/internal/notify"
+	"front-runner/internal/oauth"
+	"front-runner/internal/prodtable"
+	"front-runner/internal/usertable"
+)
+
+const projectDirName = "front-runner_backend"
+
+// Global test variables
+var (
+	testDB           *gorm.DB
+	testSessionStore *sessions.CookieStore
+	setupEnvOnce     sync.Once
+)
+
+// setupTestEnvironment loads environment variables, initializes DB and session store for tests.
+// It also clears relevant tables before each test run.
+func setupTestEnvironment(t *testing.T) {
+	t.Helper()
+
+	setupEnvOnce.Do(func() {
+		// Find project root
+		re := regexp.MustCompile(`^(.*` + projectDirName + `)`)
+		cwd, _ := os.Getwd()
+		rootPath := re.Find([]byte(cwd))
+		if rootPath == nil {
+			t.Fatalf("Could not find project root directory '%s' from '%s'", projectDirName, cwd)
+		}
+
+		// Load .env file
+		envPath := string(rootPath) + `/.env`
+		err := godotenv.Load(envPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Problem loading .env file from %s: %v", envPath, err)
+		}
+
+		// Initialize DB connection
+		coredbutils.ResetDBStateForTests()
+		require.NoError(t, coredbutils.LoadEnv(), "Failed to load core DB environment")
+		var dbErr error
+		testDB, dbErr = coredbutils.GetDB()
+		require.NoError(t, dbErr, "Failed to get DB connection for tests")
+
+		// Initialize Session Store for tests
+		testSessionStore = sessions.NewCookieStore([]byte("test-auth-key-32-bytes-long-000"), []byte("test-enc-key-needs-to-be-32-byte"))
+		testSessionStore.Options = &sessions.Options{Path: "/", MaxAge: 86400, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+
+		// Setup dependent packages
+		usertable.Setup()
+		prodtable.Setup()
+		oauth.Setup(testSessionStore)
+		Setup()
+
+		// Run migrations once after setup
+		usertable.MigrateUserDB()
+		prodtable.MigrateProdDB()
+		MigrateStockAlertsDB()
+	})
+
+	require.NoError(t, ClearStockAlertsTable(testDB), "Failed to clear stock_alerts table")
+	require.NoError(t, testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.StockMovement{}).Error, "Failed to clear stock_movements table")
+	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.Product{}).Error, "Failed to clear product table")
+	require.NoError(t, testDB.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&prodtable.Image{}).Error, "Failed to clear image table")
+	require.NoError(t, usertable.ClearUserTable(testDB), "Failed to clear user table")
+}
+
+// Helper to create a test user directly in the DB
+func createTestUser(t *testing.T, email string) *usertable.User {
+	t.Helper()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	require.NoError(t, usertable.CreateUser(&usertable.User{Email: email, PasswordHash: string(hashedPassword), Name: "Test User " + email, Provider: "local"}))
+	user, err := usertable.GetUserByEmail(email)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	return user
+}
+
+// Helper to create an authenticated request
+func createAuthenticatedRequest(t *testing.T, user *usertable.User, method, url string, body io.Reader) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(method, url, body)
+	session, err := testSessionStore.New(req, "front-runner-session")
+	require.NoError(t, err)
+	session.Values["userID"] = user.ID
+	rr := httptest.NewRecorder()
+	require.NoError(t, testSessionStore.Save(req, rr, session))
+	req.Header.Set("Cookie", rr.Header().Get("Set-Cookie"))
+	return req
+}
+
+// Helper to create a test product directly in the DB
+func createTestProduct(t *testing.T, owner *usertable.User, name string, count, threshold uint) *prodtable.Product {
+	t.Helper()
+	image := prodtable.Image{URL: fmt.Sprintf("dummy_%s.jpg", uuid.NewString()), UserID: owner.ID}
+	require.NoError(t, testDB.Create(&image).Error)
+	product := &prodtable.Product{UserID: owner.ID, ProdName: name, ImgID: image.ID, PriceMinor: 500, ProdCount: count, ReorderThreshold: threshold}
+	require.NoError(t, testDB.Create(product).Error)
+	return product
+}
+
+// recordingNotifier records the messages it is asked to send, failing while fail is set.
+type recordingNotifier struct {
+	sent []notify.Message
+	fail bool
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, msg notify.Message) error {
+	if n.fail {
+		return errors.New("mail server unavailable")
+	}
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+// TestStockAlerts tests raising, escalating, notifying, acknowledging and resolving stock alerts.
+func TestStockAlerts(t *testing.T) {
+	setupTestEnvironment(t)
+	seller := createTestUser(t, "alertseller@example.com")
+	other := createTestUser(t, "alertother@example.com")
+	mug := createTestProduct(t, seller, "Mug", 3, 5)
+	plate := createTestProduct(t, seller, "Plate", 10, 5)
+	createTestProduct(t, seller, "Bowl", 0, 0) // No threshold, so no alerts
+
+	recorder := &recordingNotifier{}
+	previous := notifier
+	notifier = recorder
+	defer func() { notifier = previous }()
+
+	setStock := func(t *testing.T, product *prodtable.Product, count uint) {
+		t.Helper()
+		require.NoError(t, testDB.Model(&prodtable.Product{}).Where("id = ?", product.ID).Update("prod_count", count).Error)
+	}
+	evaluate := func(t *testing.T) (int64, int64) {
+		t.Helper()
+		raised, resolved, err := Evaluate(context.Background(), time.Now())
+		require.NoError(t, err)
+		return raised, resolved
+	}
+	list := func(t *testing.T, user *usertable.User, query string) []StockAlertReturn {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetStockAlerts(rr, createAuthenticatedRequest(t, user, "GET", "/api/get_stock_alerts"+query, nil))
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var alerts []StockAlertReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &alerts))
+		return alerts
+	}
+	acknowledge := func(t *testing.T, user *usertable.User, id uint) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		AcknowledgeStockAlert(rr, createAuthenticatedRequest(t, user, "POST", fmt.Sprintf("/api/acknowledge_stock_alert?id=%d", id), nil))
+		return rr
+	}
+
+	t.Run("RaiseAndNotify", func(t *testing.T) {
+		raised, _ := evaluate(t)
+		assert.Equal(t, int64(1), raised, "Only the mug is below its threshold")
+		require.Len(t, recorder.sent, 1)
+		assert.Equal(t, "alertseller@example.com", recorder.sent[0].To)
+		assert.Equal(t, "Low stock: Mug", recorder.sent[0].Subject)
+
+		raised, _ = evaluate(t)
+		assert.Zero(t, raised, "A product has one alert at a time")
+		assert.Len(t, recorder.sent, 1, "Sellers are notified once per alert")
+
+		alerts := list(t, seller, "")
+		require.Len(t, alerts, 1)
+		assert.Equal(t, mug.ID, alerts[0].ProductID)
+		assert.Equal(t, "Mug", alerts[0].ProductName)
+		assert.Equal(t, KindLowStock, alerts[0].Kind)
+		assert.Equal(t, uint(3), alerts[0].Stock)
+		assert.Empty(t, list(t, other, ""))
+	})
+
+	t.Run("Acknowledge", func(t *testing.T) {
+		alert := list(t, seller, "")[0]
+		assert.Equal(t, http.StatusNotFound, acknowledge(t, other, alert.ID).Code)
+
+		rr := acknowledge(t, seller, alert.ID)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var acked StockAlertReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &acked))
+		assert.Equal(t, StatusAcknowledged, acked.Status)
+		assert.NotEmpty(t, acked.AcknowledgedAt)
+		assert.Equal(t, http.StatusOK, acknowledge(t, seller, alert.ID).Code, "Acknowledging again has no effect")
+
+		assert.Empty(t, list(t, seller, "?status=open"))
+		assert.Len(t, list(t, seller, ""), 1, "Acknowledged alerts stay listed until resolved")
+	})
+
+	t.Run("EscalateAndResolve", func(t *testing.T) {
+		setStock(t, mug, 0)
+		raised, resolved := evaluate(t)
+		assert.Equal(t, int64(1), raised)
+		assert.Equal(t, int64(1), resolved, "The low-stock alert makes way for an out-of-stock one")
+		require.Len(t, recorder.sent, 2)
+		assert.Equal(t, "Out of stock: Mug", recorder.sent[1].Subject)
+
+		alerts := list(t, seller, "")
+		require.Len(t, alerts, 1)
+		assert.Equal(t, KindOutOfStock, alerts[0].Kind)
+
+		setStock(t, mug, 20)
+		_, resolved = evaluate(t)
+		assert.Equal(t, int64(1), resolved)
+		assert.Empty(t, list(t, seller, ""))
+		resolvedAlerts := list(t, seller, "?status=resolved")
+		assert.Len(t, resolvedAlerts, 2)
+		assert.Equal(t, http.StatusConflict, acknowledge(t, seller, resolvedAlerts[0].ID).Code)
+	})
+
+	t.Run("FailedNotificationsAreRetried", func(t *testing.T) {
+		setStock(t, plate, 1)
+		recorder.fail = true
+		raised, _ := evaluate(t)
+		assert.Equal(t, int64(1), raised)
+
+		recorder.fail = false
+		evaluate(t)
+		require.Len(t, recorder.sent, 3)
+		assert.Equal(t, "Low stock: Plate", recorder.sent[2].Subject)
+	})
+
+	t.Run("DeletedProductsResolve", func(t *testing.T) {
+		require.NoError(t, testDB.Unscoped().Delete(&prodtable.Product{}, plate.ID).Error)
+		_, resolved := evaluate(t)
+		assert.Equal(t, int64(1), resolved)
+		assert.Empty(t, list(t, seller, ""))
+	})
+
+	t.Run("InvalidStatus", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		GetStockAlerts(rr, createAuthenticatedRequest(t, seller, "GET", "/api/get_stock_alerts?status=snoozed", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
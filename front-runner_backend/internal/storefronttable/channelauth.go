@@ -0,0 +1,158 @@
+// front-runner/internal/storefronttable/channelauth.go
+package storefronttable
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Headers of a request signed by a linked storefront (a sales channel).
+const (
+	ChannelIDHeader        = "X-Channel-ID"        // ID of the StorefrontLink
+	ChannelTimestampHeader = "X-Channel-Timestamp" // Unix time in seconds when the request was signed
+	ChannelSignatureHeader = "X-Channel-Signature" // Hex HMAC-SHA256 of the signing payload, see SignRequest
+)
+
+// maxSignatureAge is how far a signed request's timestamp may be from the server's clock.
+const maxSignatureAge = 5 * time.Minute
+
+// ErrInvalidSignature is returned by VerifySignedRequest for unknown channels and bad or stale signatures.
+var ErrInvalidSignature = errors.New("invalid channel signature")
+
+// SigningSecretReturn is returned once when a storefront's signing secret is created or rotated.
+type SigningSecretReturn struct {
+	ChannelID     uint   `json:"channelID"`     // Send as the X-Channel-ID header
+	SigningSecret string `json:"signingSecret"` // Key for the X-Channel-Signature HMAC; it cannot be retrieved again
+}
+
+// IsSignedRequest reports whether the request claims to come from a linked storefront.
+func IsSignedRequest(r *http.Request) bool {
+	return r.Header.Get(ChannelIDHeader) != ""
+}
+
+// SignRequest computes the X-Channel-Signature of a request: the hex HMAC-SHA256, keyed by the
+// channel's signing secret, of "<timestamp>.<method>.<request URI>." followed by the raw body.
+// The request URI is the path followed by the query string, if any, e.g. "/api/release_reservation?id=7".
+func SignRequest(secret string, timestamp int64, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s.%s.", timestamp, method, requestURI)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignedRequest authenticates a request signed with a storefront's signing secret
+// and returns the storefront. body is the raw request body.
+func VerifySignedRequest(r *http.Request, body []byte) (*StorefrontLink, error) {
+	linkID, err := strconv.ParseUint(r.Header.Get(ChannelIDHeader), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad %s", ErrInvalidSignature, ChannelIDHeader)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(ChannelTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad %s", ErrInvalidSignature, ChannelTimestampHeader)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > maxSignatureAge || age < -maxSignatureAge {
+		return nil, fmt.Errorf("%w: timestamp outside the allowed window", ErrInvalidSignature)
+	}
+	signature, err := hex.DecodeString(r.Header.Get(ChannelSignatureHeader))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad %s", ErrInvalidSignature, ChannelSignatureHeader)
+	}
+
+	var link StorefrontLink
+	if err := db.First(&link, linkID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSignature
+		}
+		return nil, err
+	}
+	if link.SigningSecret == "" {
+		return nil, fmt.Errorf("%w: channel has no signing secret", ErrInvalidSignature)
+	}
+	secret, err := decryptCredentials(link.SigningSecret)
+	if err != nil {
+		return nil, err
+	}
+	expected, _ := hex.DecodeString(SignRequest(secret, timestamp, r.Method, r.URL.RequestURI(), body))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSignature
+	}
+	return &link, nil
+}
+
+// RotateSigningSecret creates a new signing secret for a storefront link, replacing any previous one.
+// @Summary      Rotate a storefront's signing secret
+// @Description  Generates a new secret with which the storefront signs requests (such as creating orders) on the seller's behalf. The secret is only returned by this call; the previous secret stops working immediately.
+// @Tags         Storefronts
+// @Param        id query integer true "ID of the Storefront Link" Format(uint) example(123)
+// @Success      200 {object} SigningSecretReturn "The new signing secret"
+// @Failure      400 {string} string "Bad Request - Invalid or missing 'id' query parameter"
+// @Failure      401 {string} string "Unauthorized - User session invalid or expired"
+// @Failure      403 {string} string "Forbidden - User does not own this storefront link"
+// @Failure      404 {string} string "Not Found - Storefront link with the specified ID not found"
+// @Failure      500 {string} string "Internal Server Error - Failed to generate or store the secret"
+// @Security     ApiKeyAuth
+// @Router       /api/rotate_storefront_secret [post]
+func RotateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkAuth(w, r)
+	if !ok {
+		return
+	}
+
+	linkID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid ID format: must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	linkID := uint(linkID64)
+
+	var link StorefrontLink
+	if err := db.First(&link, linkID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("Storefront link with ID %d not found", linkID), http.StatusNotFound)
+		} else {
+			log.Printf("Error finding storefront link ID %d for secret rotation: %v", linkID, err)
+			http.Error(w, "Internal server error while searching for link", http.StatusInternalServerError)
+		}
+		return
+	}
+	if link.UserID != userID {
+		log.Printf("Security violation: User %d attempted to rotate the secret of storefront link ID %d owned by user %d", userID, linkID, link.UserID)
+		http.Error(w, "Forbidden: You do not have permission to modify this storefront link", http.StatusForbidden)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Error generating signing secret for storefront link ID %d: %v", linkID, err)
+		http.Error(w, "Failed to generate signing secret", http.StatusInternalServerError)
+		return
+	}
+	secret := hex.EncodeToString(raw)
+	encrypted, err := encryptCredentials(secret)
+	if err != nil {
+		log.Printf("Error encrypting signing secret for storefront link ID %d: %v", linkID, err)
+		http.Error(w, "Failed to secure signing secret", http.StatusInternalServerError)
+		return
+	}
+	if err := db.Model(&link).Update("signing_secret", encrypted).Error; err != nil {
+		log.Printf("Error saving signing secret for storefront link ID %d: %v", linkID, err)
+		http.Error(w, "Failed to save signing secret due to a database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SigningSecretReturn{ChannelID: link.ID, SigningSecret: secret})
+}
@@ -0,0 +1,146 @@
+// front-runner/internal/storefronttable/connector.go
+package storefronttable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"front-runner/internal/connectors"
+
+	"gorm.io/gorm"
+)
+
+// credentials decrypts the link's stored credentials, such as "apiKey" and "accessToken".
+func (link *StorefrontLink) credentials() (map[string]string, error) {
+	creds := map[string]string{}
+	if link.Credentials == "" {
+		return creds, nil
+	}
+	plaintext, err := decryptCredentials(link.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(plaintext), &creds); err != nil {
+		return nil, fmt.Errorf("invalid stored credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// webhookSecretKey is the credential the marketplace signs webhook deliveries with.
+const webhookSecretKey = "webhookSecret"
+
+// setCredential sets or, when value is empty, removes one of the link's stored credentials.
+// The change is saved with the link.
+func (link *StorefrontLink) setCredential(key, value string) error {
+	creds, err := link.credentials()
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(creds, key)
+	} else {
+		creds[key] = value
+	}
+	if len(creds) == 0 {
+		link.Credentials = ""
+		return nil
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	link.Credentials, err = encryptCredentials(string(plaintext))
+	return err
+}
+
+// WebhookSecret returns the secret the link's marketplace signs webhook deliveries with, or "" if none was set.
+func (link *StorefrontLink) WebhookSecret() (string, error) {
+	creds, err := link.credentials()
+	if err != nil {
+		return "", err
+	}
+	return creds[webhookSecretKey], nil
+}
+
+// hasWebhookSecret reports whether the link has a webhook secret, treating unreadable credentials as none.
+func (link *StorefrontLink) hasWebhookSecret() bool {
+	secret, err := link.WebhookSecret()
+	return err == nil && secret != ""
+}
+
+// Connector returns the marketplace connector of the link, authenticated with its credentials.
+// It fails with connectors.ErrUnsupported when the link's StoreType has no connector.
+func (link *StorefrontLink) Connector() (connectors.Connector, error) {
+	if !connectors.Supported(link.StoreType) {
+		return nil, fmt.Errorf("%w: %q", connectors.ErrUnsupported, link.StoreType)
+	}
+	creds, err := link.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return connectors.New(link.StoreType, connectors.Config{StoreURL: link.StoreURL, StoreID: link.StoreID, Credentials: creds})
+}
+
+// GetStorefrontListings lists the listings on a linked storefront's marketplace.
+// @Summary      Get a storefront's listings
+// @Description  Fetches every listing from the marketplace behind a storefront link, using the link's stored credentials. Only store types with a connector (such as "shopify") are supported.
+// @Tags         Storefronts
+// @Param        id query integer true "ID of the Storefront Link" Format(uint) example(123)
+// @Success      200 {array} connectors.Listing "The storefront's listings"
+// @Failure      400 {string} string "Bad Request - Invalid ID, or the store type has no connector or the link is misconfigured"
+// @Failure      401 {string} string "Unauthorized - User session invalid or expired"
+// @Failure      403 {string} string "Forbidden - User does not own this storefront link"
+// @Failure      404 {string} string "Not Found - Storefront link with the specified ID not found"
+// @Failure      502 {string} string "Bad Gateway - The marketplace rejected the request or could not be reached"
+// @Security     ApiKeyAuth
+// @Router       /api/get_storefront_listings [get]
+func GetStorefrontListings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkAuth(w, r)
+	if !ok {
+		return
+	}
+
+	linkID64, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid ID format: must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	linkID := uint(linkID64)
+
+	var link StorefrontLink
+	if err := db.First(&link, linkID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("Storefront link with ID %d not found", linkID), http.StatusNotFound)
+		} else {
+			log.Printf("Error finding storefront link ID %d for listings: %v", linkID, err)
+			http.Error(w, "Internal server error while searching for link", http.StatusInternalServerError)
+		}
+		return
+	}
+	if link.UserID != userID {
+		log.Printf("Security violation: User %d attempted to read listings of storefront link ID %d owned by user %d", userID, linkID, link.UserID)
+		http.Error(w, "Forbidden: You do not have permission to access this storefront link", http.StatusForbidden)
+		return
+	}
+
+	conn, err := link.Connector()
+	if err != nil {
+		log.Printf("Cannot connect storefront link ID %d: %v", linkID, err)
+		http.Error(w, "Storefront link cannot connect to its marketplace: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	listings, err := conn.ListListings(r.Context())
+	if err != nil {
+		log.Printf("Error listing marketplace listings of storefront link ID %d: %v", linkID, err)
+		http.Error(w, "The marketplace request failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(listings)
+}
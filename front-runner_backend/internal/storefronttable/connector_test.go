@@ -0,0 +1,113 @@
+// front-runner/internal/storefronttable/connector_test.go
+package storefronttable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"front-runner/internal/connectors"
+	"front-runner/internal/usertable"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConnector serves fixed listings to links of the "fakemarket" store type.
+type fakeConnector struct {
+	cfg connectors.Config
+}
+
+func (f *fakeConnector) ListListings(context.Context) ([]connectors.Listing, error) {
+	if f.cfg.Credentials["accessToken"] == "revoked" {
+		return nil, &connectors.APIError{StatusCode: http.StatusUnauthorized, Message: "invalid token"}
+	}
+	return []connectors.Listing{{ExternalID: "1", Title: "Mug from " + f.cfg.StoreURL, Variants: []connectors.ListingVariant{{SKU: "MUG", PriceMinor: 950}}}}, nil
+}
+
+func (f *fakeConnector) PushListing(_ context.Context, l connectors.Listing) (connectors.Listing, error) {
+	return l, nil
+}
+
+func (f *fakeConnector) PullOrders(context.Context, time.Time) ([]connectors.Order, error) {
+	return nil, nil
+}
+
+func (f *fakeConnector) UpdateInventory(context.Context, connectors.InventoryUpdate) error {
+	return nil
+}
+
+func init() {
+	connectors.Register("fakemarket", func(cfg connectors.Config) (connectors.Connector, error) {
+		if cfg.Credentials["accessToken"] == "" {
+			return nil, errors.New("fakemarket links need an accessToken")
+		}
+		return &fakeConnector{cfg: cfg}, nil
+	})
+}
+
+// TestStorefrontConnector tests validating connectable links and listing their marketplace listings.
+func TestStorefrontConnector(t *testing.T) {
+	setupTestEnvironment(t)
+	user := createTestUser(t, "connector@example.com", "password123")
+	other := createTestUser(t, "connectorother@example.com", "password123")
+
+	addLink := func(t *testing.T, payload StorefrontLinkAddPayload) *httptest.ResponseRecorder {
+		t.Helper()
+		bodyBytes, err := json.Marshal(payload)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		AddStorefront(rr, createAuthenticatedRequest(t, user, "POST", "/api/add_storefront", bytes.NewReader(bodyBytes)))
+		return rr
+	}
+	listings := func(t *testing.T, owner *usertable.User, id uint) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetStorefrontListings(rr, createAuthenticatedRequest(t, owner, "GET", fmt.Sprintf("/api/get_storefront_listings?id=%d", id), nil))
+		return rr
+	}
+
+	assert.Equal(t, http.StatusBadRequest, addLink(t, StorefrontLinkAddPayload{StoreType: "fakemarket", StoreUrl: "https://shop.test"}).Code, "Connectable links are validated")
+	assert.Equal(t, http.StatusBadRequest, addLink(t, StorefrontLinkAddPayload{StoreType: "shopify", StoreUrl: "https://example.com", AccessToken: "token"}).Code)
+
+	rr := addLink(t, StorefrontLinkAddPayload{StoreType: "fakemarket", StoreName: "Fake", StoreUrl: "https://shop.test", AccessToken: "token"})
+	require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+	var link StorefrontLinkReturn
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	assert.True(t, link.HasConnector)
+
+	t.Run("Listings", func(t *testing.T) {
+		rr := listings(t, user, link.ID)
+		require.Equal(t, http.StatusOK, rr.Code, "body: %s", rr.Body.String())
+		var resp []connectors.Listing
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp, 1)
+		assert.Equal(t, "Mug from https://shop.test", resp[0].Title, "The connector gets the link's details")
+
+		assert.Equal(t, http.StatusForbidden, listings(t, other, link.ID).Code)
+		assert.Equal(t, http.StatusNotFound, listings(t, user, 999999).Code)
+	})
+
+	t.Run("MarketplaceErrors", func(t *testing.T) {
+		rr := addLink(t, StorefrontLinkAddPayload{StoreType: "fakemarket", StoreName: "Revoked", StoreUrl: "https://shop.test", AccessToken: "revoked"})
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var revoked StorefrontLinkReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revoked))
+		assert.Equal(t, http.StatusBadGateway, listings(t, user, revoked.ID).Code)
+	})
+
+	t.Run("NoConnector", func(t *testing.T) {
+		rr := addLink(t, StorefrontLinkAddPayload{StoreType: "pinterest", ApiKey: "key"})
+		require.Equal(t, http.StatusCreated, rr.Code, "body: %s", rr.Body.String())
+		var plain StorefrontLinkReturn
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &plain))
+		assert.False(t, plain.HasConnector)
+		assert.Equal(t, http.StatusBadRequest, listings(t, user, plain.ID).Code)
+	})
+}
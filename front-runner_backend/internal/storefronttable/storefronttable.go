@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"front-runner/internal/connectors"
 	"front-runner/internal/coredbutils" // Use coredbutils for DB access
 	"front-runner/internal/oauth"       // Import oauth
 
@@ -22,36 +23,43 @@ import (
 
 // StorefrontLink represents a linked external storefront in the database.
 type StorefrontLink struct {
-	ID          uint   `gorm:"primaryKey"`
-	UserID      uint   `gorm:"not null;index:idx_user_store_unique,unique,priority:1"` // Composite unique index
-	StoreType   string `gorm:"not null;index:idx_user_store_unique,unique,priority:2"` // Composite unique index
-	StoreName   string `gorm:"index:idx_user_store_unique,unique,priority:3"`          // Composite unique index
-	Credentials string `gorm:"not null;type:text"`                                     // Store encrypted data (use text type for potentially longer strings)
-	StoreID     string `gorm:"index"`                                                  // Index for potential lookups by StoreID
-	StoreURL    string
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	ID            uint   `gorm:"primaryKey"`
+	UserID        uint   `gorm:"not null;index:idx_user_store_unique,unique,priority:1"` // Composite unique index
+	StoreType     string `gorm:"not null;index:idx_user_store_unique,unique,priority:2"` // Composite unique index
+	StoreName     string `gorm:"index:idx_user_store_unique,unique,priority:3"`          // Composite unique index
+	Credentials   string `gorm:"not null;type:text"`                                     // Store encrypted data (use text type for potentially longer strings)
+	StoreID       string `gorm:"index"`                                                  // Index for potential lookups by StoreID
+	StoreURL      string
+	SigningSecret string    `gorm:"not null;default:'';type:text"` // Encrypted key the storefront signs requests with; see channelauth.go
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
 }
 
 // StorefrontLinkAddPayload is used to decode the JSON body when adding a link.
 type StorefrontLinkAddPayload struct {
-	StoreType string `json:"storeType"`
-	StoreName string `json:"storeName"` // User-defined nickname
-	ApiKey    string `json:"apiKey"`    // Example credential field
-	ApiSecret string `json:"apiSecret"` // Example credential field
-	StoreId   string `json:"storeId"`   // Platform-specific ID
-	StoreUrl  string `json:"storeUrl"`  // Storefront URL
+	StoreType   string `json:"storeType"`
+	StoreName   string `json:"storeName"`   // User-defined nickname
+	ApiKey      string `json:"apiKey"`      // Example credential field
+	ApiSecret   string `json:"apiSecret"`   // Example credential field
+	AccessToken string `json:"accessToken"` // Admin API access token, e.g. for Shopify
+	// Secret the marketplace signs webhook deliveries with, e.g. the Shopify app's client secret
+	WebhookSecret string `json:"webhookSecret"`
+	StoreId       string `json:"storeId"`  // Platform-specific ID
+	StoreUrl      string `json:"storeUrl"` // Storefront URL
 	// Add other potential credential fields as needed per platform
 }
 
 // StorefrontLinkReturn is the struct returned to the frontend.
 // IMPORTANT: It omits the sensitive Credentials field.
 type StorefrontLinkReturn struct {
-	ID        uint   `json:"id"`
-	StoreType string `json:"storeType"`
-	StoreName string `json:"storeName"`
-	StoreID   string `json:"storeId"` // Match frontend JSON keys
-	StoreURL  string `json:"storeUrl"`
+	ID               uint   `json:"id"`
+	StoreType        string `json:"storeType"`
+	StoreName        string `json:"storeName"`
+	StoreID          string `json:"storeId"` // Match frontend JSON keys
+	StoreURL         string `json:"storeUrl"`
+	HasSigningSecret bool   `json:"hasSigningSecret"` // Whether the storefront can sign requests; the secret itself is never returned
+	HasConnector     bool   `json:"hasConnector"`     // Whether the backend can talk to the store's marketplace
+	HasWebhookSecret bool   `json:"hasWebhookSecret"` // Whether webhook deliveries from the marketplace can be verified
 }
 
 // StorefrontLinkUpdatePayload defines the fields allowed for updating a storefront link.
@@ -59,6 +67,8 @@ type StorefrontLinkUpdatePayload struct {
 	StoreName string `json:"storeName"` // User-defined nickname
 	StoreId   string `json:"storeId"`   // Platform-specific ID
 	StoreUrl  string `json:"storeUrl"`  // Storefront URL
+	// Replaces the webhook secret when present; "" removes it
+	WebhookSecret *string `json:"webhookSecret"`
 }
 
 // --- Package Variables ---
@@ -130,7 +140,7 @@ func ClearStorefrontTable(db *gorm.DB) error {
 // @Description  Links a new external storefront (e.g., Amazon, Pinterest) to the user's account, storing credentials securely. Requires authentication.
 // @Tags         Storefronts
 // @Accept       json
-// @Param        storefrontLink body StorefrontLinkAddPayload true "Storefront Link Details (including credentials like apiKey, apiSecret)"
+// @Param        storefrontLink body StorefrontLinkAddPayload true "Storefront Link Details (including credentials like apiKey, apiSecret, accessToken)"
 // @Success      201 {object} StorefrontLinkReturn "Successfully linked storefront (credentials omitted)"
 // @Failure      400 {string} string "Bad Request - Invalid input, missing fields, or JSON parsing error"
 // @Failure      401 {string} string "Unauthorized - User session invalid or expired"
@@ -175,6 +185,19 @@ func AddStorefront(w http.ResponseWriter, r *http.Request) {
 	if payload.ApiSecret != "" {
 		credentialsMap["apiSecret"] = payload.ApiSecret
 	}
+	if payload.AccessToken != "" {
+		credentialsMap["accessToken"] = payload.AccessToken
+	}
+	if payload.WebhookSecret != "" {
+		credentialsMap[webhookSecretKey] = payload.WebhookSecret
+	}
+	// Links to marketplaces we have a connector for must be able to connect
+	if connectors.Supported(payload.StoreType) {
+		if _, err := connectors.New(payload.StoreType, connectors.Config{StoreURL: payload.StoreUrl, StoreID: payload.StoreId, Credentials: credentialsMap}); err != nil {
+			http.Error(w, "Invalid storefront details: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	// Add other fields from payload if they are considered sensitive
 
 	// Only encrypt if there are actual credentials to store
@@ -238,11 +261,13 @@ func AddStorefront(w http.ResponseWriter, r *http.Request) {
 	// --- Return Success Response (Safe Data Only) ---
 	// Create the return object *without* credentials
 	returnData := StorefrontLinkReturn{
-		ID:        newLink.ID,
-		StoreType: newLink.StoreType,
-		StoreName: newLink.StoreName,
-		StoreID:   newLink.StoreID,
-		StoreURL:  newLink.StoreURL,
+		ID:               newLink.ID,
+		StoreType:        newLink.StoreType,
+		StoreName:        newLink.StoreName,
+		StoreID:          newLink.StoreID,
+		StoreURL:         newLink.StoreURL,
+		HasConnector:     connectors.Supported(newLink.StoreType),
+		HasWebhookSecret: payload.WebhookSecret != "",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -278,11 +303,14 @@ func GetStorefronts(w http.ResponseWriter, r *http.Request) {
 	returnData := make([]StorefrontLinkReturn, len(links)) // Pre-allocate slice
 	for i, link := range links {
 		returnData[i] = StorefrontLinkReturn{
-			ID:        link.ID,
-			StoreType: link.StoreType,
-			StoreName: link.StoreName,
-			StoreID:   link.StoreID,
-			StoreURL:  link.StoreURL,
+			ID:               link.ID,
+			StoreType:        link.StoreType,
+			StoreName:        link.StoreName,
+			StoreID:          link.StoreID,
+			StoreURL:         link.StoreURL,
+			HasSigningSecret: link.SigningSecret != "",
+			HasConnector:     connectors.Supported(link.StoreType),
+			HasWebhookSecret: link.hasWebhookSecret(),
 		}
 	}
 
@@ -294,11 +322,11 @@ func GetStorefronts(w http.ResponseWriter, r *http.Request) {
 
 // UpdateStorefront handles updating non-sensitive details of an existing storefront link.
 // @Summary      Update a storefront link
-// @Description  Updates the name, store ID, or store URL of an existing storefront link belonging to the authenticated user. Store type and credentials cannot be updated via this endpoint.
+// @Description  Updates the name, store ID, store URL or webhook secret of an existing storefront link belonging to the authenticated user. Store type and other credentials cannot be updated via this endpoint.
 // @Tags         Storefronts
 // @Accept       json
 // @Param        id query integer true "ID of the Storefront Link to update" Format(uint) example(123)
-// @Param        storefrontUpdate body StorefrontLinkUpdatePayload true "Fields to update (storeName, storeId, storeUrl)"
+// @Param        storefrontUpdate body StorefrontLinkUpdatePayload true "Fields to update (storeName, storeId, storeUrl, webhookSecret)"
 // @Success      200 {object} StorefrontLinkReturn "Successfully updated storefront link details"
 // @Failure      400 {string} string "Bad Request - Invalid input, missing ID, or JSON parsing error"
 // @Failure      401 {string} string "Unauthorized - User session invalid or expired"
@@ -364,8 +392,15 @@ func UpdateStorefront(w http.ResponseWriter, r *http.Request) {
 	}
 	link.StoreID = payload.StoreId   // Allow empty StoreId if desired
 	link.StoreURL = payload.StoreUrl // Allow empty StoreUrl if desired
+	if payload.WebhookSecret != nil {
+		if err := link.setCredential(webhookSecretKey, *payload.WebhookSecret); err != nil {
+			log.Printf("Error updating the webhook secret of storefront link ID %d: %v", linkID, err)
+			http.Error(w, "Failed to secure credentials", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// Note: StoreType and Credentials are NOT updated here.
+	// Note: StoreType and the other credentials are NOT updated here.
 
 	// --- Save Changes to Database ---
 	saveResult := db.Save(&link)
@@ -382,11 +417,14 @@ func UpdateStorefront(w http.ResponseWriter, r *http.Request) {
 
 	// --- Return Success Response (Updated, Safe Data) ---
 	returnData := StorefrontLinkReturn{
-		ID:        link.ID,        // ID doesn't change
-		StoreType: link.StoreType, // Type doesn't change
-		StoreName: link.StoreName, // Updated name
-		StoreID:   link.StoreID,   // Updated Store ID
-		StoreURL:  link.StoreURL,  // Updated URL
+		ID:               link.ID,        // ID doesn't change
+		StoreType:        link.StoreType, // Type doesn't change
+		StoreName:        link.StoreName, // Updated name
+		StoreID:          link.StoreID,   // Updated Store ID
+		StoreURL:         link.StoreURL,  // Updated URL
+		HasSigningSecret: link.SigningSecret != "",
+		HasConnector:     connectors.Supported(link.StoreType),
+		HasWebhookSecret: link.hasWebhookSecret(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -21,12 +21,15 @@ import (
 
 	_ "front-runner/docs" // This is important for swagger to find your docs!
 	"front-runner/internal/coredbutils"
+	"front-runner/internal/inventorysync"
 	"front-runner/internal/login"
 
 	"front-runner/internal/oauth" // Import oauth
 	"front-runner/internal/orderstable"
+	"front-runner/internal/pricing"
 	"front-runner/internal/prodtable"
 	"front-runner/internal/routes"
+	"front-runner/internal/stockalerts"
 	"front-runner/internal/storefronttable"
 	"front-runner/internal/usertable"
 
@@ -146,12 +149,28 @@ func setupModules() {
 	prodtable.Setup() // Assumes prodtable.Setup only needs coredbutils.GetDB() internally now
 	prodtable.MigrateProdDB()
 
+	// Low-stock alerts (needs products; notifier configured from env vars)
+	stockalerts.Setup()
+	stockalerts.MigrateStockAlertsDB()
+	stockalerts.StartEvaluator() // Raises and resolves alerts as stock changes
+
 	// Storefront Table (only needs DB, encryption key loaded internally)
 	storefronttable.Setup() // Assumes storefronttable.Setup uses coredbutils.GetDB() and loads key internally
 	storefronttable.MigrateStorefrontDB()
 
+	// Pushes stock changes to linked marketplaces (needs products and storefront links)
+	inventorysync.Setup()
+	inventorysync.MigrateInventorySyncDB()
+	inventorysync.StartInventorySync()
+
+	// Tax, shipping and discount settings (needed by orders)
+	pricing.Setup()
+	pricing.MigratePricingDB()
+
 	orderstable.Setup()
 	orderstable.MigrateOrdersDB()
+	orderstable.StartReservationSweeper() // Returns the stock of expired reservations
+	orderstable.StartChannelSync()        // Imports orders placed on linked marketplaces
 
 	log.Println("All modules set up.")
 